@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/id"
+)
+
+// RoomSettings is the effective configuration of the bot in a room.
+type RoomSettings struct {
+	Plugins           map[string]bool
+	Personas          []string
+	AnswerUnaddressed bool
+	Rules             int
+}
+
+// Conversations returns the conversations the bot has in memory, most
+// recently used first.
+func (m *Bot) Conversations() []*Conversation {
+	return m.conversations.All()
+}
+
+// ExpireConversation forgets the conversation that started with the given
+// event. Replies to it will no longer be answered in context.
+func (m *Bot) ExpireConversation(root id.EventID) bool {
+	found, err := m.conversations.Remove(root)
+	if err != nil {
+		m.logger.Error("failed to remove stored conversation", slog.String("err", err.Error()), slog.String("root", root.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+
+	return found
+}
+
+// Usage returns the tokens used by the bot since the start.
+func (m *Bot) Usage() openai.Usage {
+	return m.gptClient.Usage()
+}
+
+func (m *Bot) RoomSettings(roomID id.RoomID) (RoomSettings, error) {
+	rs := RoomSettings{
+		Plugins:           make(map[string]bool),
+		AnswerUnaddressed: m.answerUnaddressed(roomID),
+	}
+	for _, name := range m.pluginNames() {
+		enabled, err := m.store.PluginEnabled(roomID, name)
+		if err != nil {
+			return RoomSettings{}, err
+		}
+		rs.Plugins[name] = enabled
+	}
+	for _, p := range m.listPersonas() {
+		if p.inRoom(roomID) {
+			rs.Personas = append(rs.Personas, p.Name)
+		}
+	}
+	for _, r := range m.rules {
+		if r.config.Room == "" || r.config.Room == roomID.String() {
+			rs.Rules++
+		}
+	}
+
+	return rs, nil
+}
@@ -0,0 +1,150 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+)
+
+const HookFormatAlertmanager = "alertmanager"
+
+// AlertmanagerMessage is the body of an Alertmanager webhook notification.
+type AlertmanagerMessage struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// alertColors are the colors of firing alerts by severity.
+var alertColors = map[string]string{
+	"critical": "#dc3545",
+	"error":    "#dc3545",
+	"warning":  "#fd7e14",
+	"info":     "#0d6efd",
+}
+
+const (
+	alertColorDefault  = "#dc3545"
+	alertColorResolved = "#198754"
+)
+
+// RenderAlertmanager formats a notification as HTML: a line for the group,
+// followed by the firing and then the resolved alerts, each in the color of
+// its severity.
+func RenderAlertmanager(body []byte) (string, error) {
+	var msg AlertmanagerMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return "", fmt.Errorf("invalid json: %w", err)
+	}
+	if msg.Status == "" || len(msg.Alerts) == 0 {
+		return "", fmt.Errorf("not an alertmanager notification")
+	}
+
+	var firing, resolved []Alert
+	for _, a := range msg.Alerts {
+		if a.Status == "resolved" {
+			resolved = append(resolved, a)
+		} else {
+			firing = append(firing, a)
+		}
+	}
+
+	var b strings.Builder
+	status := strings.ToUpper(msg.Status)
+	if len(firing) > 0 {
+		status = fmt.Sprintf("FIRING:%d", len(firing))
+	}
+	color := alertColorResolved
+	if len(firing) > 0 {
+		color = alertColor(firing[0])
+	}
+	fmt.Fprintf(&b, `<font color="%s"><b>[%s]</b></font> %s`, color, status, html.EscapeString(formatLabels(msg.GroupLabels)))
+	if msg.ExternalURL != "" {
+		fmt.Fprintf(&b, ` (<a href="%s">alertmanager</a>)`, html.EscapeString(msg.ExternalURL))
+	}
+	b.WriteString("<ul>")
+	for _, a := range append(firing, resolved...) {
+		renderAlert(&b, a)
+	}
+	b.WriteString("</ul>")
+	if msg.TruncatedAlerts > 0 {
+		fmt.Fprintf(&b, "<i>and %d more</i>", msg.TruncatedAlerts)
+	}
+
+	return b.String(), nil
+}
+
+func renderAlert(b *strings.Builder, a Alert) {
+	status, color := "FIRING", alertColor(a)
+	if a.Status == "resolved" {
+		status, color = "RESOLVED", alertColorResolved
+	}
+	name := a.Labels["alertname"]
+	fmt.Fprintf(b, `<li><font color="%s"><b>%s</b></font> <b>%s</b>`, color, status, html.EscapeString(name))
+	if sev := a.Labels["severity"]; sev != "" {
+		fmt.Fprintf(b, " (%s)", html.EscapeString(sev))
+	}
+	text := a.Annotations["summary"]
+	if text == "" {
+		text = a.Annotations["description"]
+	}
+	if text != "" {
+		fmt.Fprintf(b, ": %s", html.EscapeString(text))
+	}
+	labels := make(map[string]string)
+	for k, v := range a.Labels {
+		if k != "alertname" && k != "severity" {
+			labels[k] = v
+		}
+	}
+	if len(labels) > 0 {
+		fmt.Fprintf(b, "<br><code>%s</code>", html.EscapeString(formatLabels(labels)))
+	}
+	if a.GeneratorURL != "" {
+		fmt.Fprintf(b, ` <a href="%s">source</a>`, html.EscapeString(a.GeneratorURL))
+	}
+	b.WriteString("</li>")
+}
+
+func alertColor(a Alert) string {
+	if color, ok := alertColors[a.Labels["severity"]]; ok {
+		return color
+	}
+
+	return alertColorDefault
+}
+
+// formatLabels renders labels sorted by name, like alertname=Down instance=db1.
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%s", k, labels[k]))
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,54 @@
+package bot_test
+
+import (
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestRenderAlertmanager(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		body   string
+		exp    string
+		expErr bool
+	}{
+		{
+			name: "firing and resolved",
+			body: `{"version":"4","status":"firing","groupLabels":{"alertname":"Down"},"externalURL":"http://am","alerts":[
+				{"status":"resolved","labels":{"alertname":"Down","instance":"web1"},"annotations":{"summary":"web1 is back"}},
+				{"status":"firing","labels":{"alertname":"Down","instance":"db<1>","severity":"warning"},"annotations":{"description":"db1 is down"},"generatorURL":"http://prom/graph"}
+			]}`,
+			exp: `<font color="#fd7e14"><b>[FIRING:1]</b></font> alertname=Down (<a href="http://am">alertmanager</a>)<ul>` +
+				`<li><font color="#fd7e14"><b>FIRING</b></font> <b>Down</b> (warning): db1 is down<br><code>instance=db&lt;1&gt;</code> <a href="http://prom/graph">source</a></li>` +
+				`<li><font color="#198754"><b>RESOLVED</b></font> <b>Down</b>: web1 is back<br><code>instance=web1</code></li></ul>`,
+		},
+		{
+			name: "resolved",
+			body: `{"status":"resolved","groupLabels":{},"alerts":[{"status":"resolved","labels":{"alertname":"Load"}}],"truncatedAlerts":2}`,
+			exp:  `<font color="#198754"><b>[RESOLVED]</b></font> <ul><li><font color="#198754"><b>RESOLVED</b></font> <b>Load</b></li></ul><i>and 2 more</i>`,
+		},
+		{
+			name:   "no alerts",
+			body:   `{"status":"firing","alerts":[]}`,
+			expErr: true,
+		},
+		{
+			name:   "invalid json",
+			body:   `{`,
+			expErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := bot.RenderAlertmanager([]byte(tc.body))
+			if (err != nil) != tc.expErr {
+				t.Fatalf("exp error %v, got %v", tc.expErr, err)
+			}
+			if act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
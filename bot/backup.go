@@ -0,0 +1,390 @@
+package bot
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/olm"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	KeyBackupAlgorithm = "m.megolm_backup.v1.curve25519-aes-sha2"
+	keyBackupInterval  = 10 * time.Minute
+)
+
+// AccountDataMegolmBackup holds the private key of the key backup, encrypted
+// in secret storage.
+var AccountDataMegolmBackup = event.Type{Type: "m.megolm_backup.v1", Class: event.AccountDataEventType}
+
+var ErrBackupMAC = fmt.Errorf("%w: backup session data has an invalid mac", ErrDecryptFailed)
+
+// BackupKey encrypts and decrypts sessions for the server-side key backup,
+// as described for m.megolm_backup.v1.curve25519-aes-sha2 in the Matrix spec.
+type BackupKey struct {
+	key *ecdh.PrivateKey
+}
+
+// BackupSessionData is the encrypted form of a session in the backup.
+type BackupSessionData struct {
+	Ephemeral  string `json:"ephemeral"`
+	Ciphertext string `json:"ciphertext"`
+	MAC        string `json:"mac"`
+}
+
+func NewBackupKey(privateKey []byte) (*BackupKey, error) {
+	key, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BackupKey{key: key}, nil
+}
+
+// PublicKey returns the unpadded base64 public key, as found in the auth data
+// of the backup version.
+func (k *BackupKey) PublicKey() string {
+	return base64.RawStdEncoding.EncodeToString(k.key.PublicKey().Bytes())
+}
+
+func (k *BackupKey) Encrypt(plaintext []byte) (BackupSessionData, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return BackupSessionData{}, err
+	}
+	shared, err := ephemeral.ECDH(k.key.PublicKey())
+	if err != nil {
+		return BackupSessionData{}, err
+	}
+	aesKey, macKey, iv, err := backupKeys(shared)
+	if err != nil {
+		return BackupSessionData{}, err
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return BackupSessionData{}, err
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	return BackupSessionData{
+		Ephemeral:  base64.RawStdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+		Ciphertext: base64.RawStdEncoding.EncodeToString(ciphertext),
+		MAC:        base64.RawStdEncoding.EncodeToString(backupMAC(macKey)),
+	}, nil
+}
+
+func (k *BackupKey) Decrypt(data BackupSessionData) ([]byte, error) {
+	ephemeralBytes, err := decodeUnpadded(data.Ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralBytes)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := k.key.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	aesKey, macKey, iv, err := backupKeys(shared)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := decodeUnpadded(data.MAC)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, backupMAC(macKey)) {
+		return nil, ErrBackupMAC
+	}
+	ciphertext, err := decodeUnpadded(data.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("backup ciphertext has invalid length %d", len(ciphertext))
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, fmt.Errorf("backup plaintext has invalid padding")
+	}
+
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// backupKeys derives the AES key, MAC key and IV from the shared secret.
+func backupKeys(shared []byte) ([]byte, []byte, []byte, error) {
+	out := make([]byte, 80)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, make([]byte, 32), nil), out); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return out[:32], out[32:64], out[64:], nil
+}
+
+// backupMAC is the first 8 bytes of the HMAC of an empty string. The spec
+// keeps this for compatibility with the original libolm implementation.
+func backupMAC(macKey []byte) []byte {
+	return hmac.New(sha256.New, macKey).Sum(nil)[:8]
+}
+
+func decodeUnpadded(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+type backupVersion struct {
+	Algorithm string `json:"algorithm"`
+	AuthData  struct {
+		PublicKey string `json:"public_key"`
+	} `json:"auth_data"`
+	Version string `json:"version"`
+}
+
+type backupRoomKeys struct {
+	Rooms map[id.RoomID]backupRoom `json:"rooms"`
+}
+
+type backupRoom struct {
+	Sessions map[id.SessionID]backupSession `json:"sessions"`
+}
+
+type backupSession struct {
+	FirstMessageIndex uint32            `json:"first_message_index"`
+	ForwardedCount    int               `json:"forwarded_count"`
+	IsVerified        bool              `json:"is_verified"`
+	SessionData       BackupSessionData `json:"session_data"`
+}
+
+type backupSessionPlain struct {
+	Algorithm         id.Algorithm             `json:"algorithm"`
+	ForwardingChains  []string                 `json:"forwarding_curve25519_key_chain"`
+	SenderClaimedKeys crypto.SenderClaimedKeys `json:"sender_claimed_keys"`
+	SenderKey         id.SenderKey             `json:"sender_key"`
+	SessionKey        string                   `json:"session_key"`
+}
+
+// connectKeyBackup loads the key of the current backup version on the server
+// from secret storage, restores the sessions in it and then keeps backing up
+// new sessions.
+func (m *Bot) connectKeyBackup() error {
+	key, err := m.ssssKey()
+	if err != nil {
+		return err
+	}
+	encoded, err := m.cryptoHelper.Machine().SSSS.GetDecryptedAccountData(AccountDataMegolmBackup, key)
+	if err != nil {
+		return fmt.Errorf("failed to get backup key from secret storage: %w", err)
+	}
+	private, err := decodeUnpadded(string(encoded))
+	if err != nil {
+		return err
+	}
+	backupKey, err := NewBackupKey(private)
+	if err != nil {
+		return err
+	}
+	var version backupVersion
+	if _, err := m.client.MakeRequest(http.MethodGet, m.client.BuildClientURL("v3", "room_keys", "version"), nil, &version); err != nil {
+		return fmt.Errorf("failed to get backup version: %w", err)
+	}
+	if version.Algorithm != KeyBackupAlgorithm {
+		return fmt.Errorf("unsupported backup algorithm %s", version.Algorithm)
+	}
+	if strings.TrimRight(version.AuthData.PublicKey, "=") != backupKey.PublicKey() {
+		return fmt.Errorf("backup key in secret storage does not belong to backup version %s", version.Version)
+	}
+
+	if err := m.restoreKeys(backupKey, version.Version); err != nil {
+		return err
+	}
+	m.goLoop(func() {
+		// back up once more when stopping, for the keys of the last messages
+		for running := true; ; running = m.wait(keyBackupInterval) {
+			if err := m.backupKeys(backupKey, version.Version); err != nil {
+				m.logger.Error("failed to back up keys", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+			}
+			if !running {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+func (m *Bot) restoreKeys(backupKey *BackupKey, version string) error {
+	mach := m.cryptoHelper.Machine()
+	var keys backupRoomKeys
+	u := m.client.BuildURLWithQuery(mautrix.ClientURLPath{"v3", "room_keys", "keys"}, map[string]string{"version": version})
+	if _, err := m.client.MakeRequest(http.MethodGet, u, nil, &keys); err != nil {
+		return fmt.Errorf("failed to get backed up keys: %w", err)
+	}
+
+	var count int
+	for roomID, room := range keys.Rooms {
+		for sessionID, session := range room.Sessions {
+			imported, err := m.restoreSession(mach, backupKey, roomID, sessionID, session)
+			if err != nil {
+				m.logger.Error("failed to restore session", slog.String("err", err.Error()), slog.String("session_id", sessionID.String()), slog.String("bot", m.config.UserDisplayName))
+				continue
+			}
+			if err := m.store.SetKeyBackedUp(sessionID); err != nil {
+				return err
+			}
+			if imported {
+				count++
+			}
+		}
+	}
+	m.logger.Info("restored keys from backup", slog.Int("sessions", count), slog.String("version", version), slog.String("bot", m.config.UserDisplayName))
+
+	return nil
+}
+
+func (m *Bot) restoreSession(mach *crypto.OlmMachine, backupKey *BackupKey, roomID id.RoomID, sessionID id.SessionID, session backupSession) (bool, error) {
+	plaintext, err := backupKey.Decrypt(session.SessionData)
+	if err != nil {
+		return false, err
+	}
+	var plain backupSessionPlain
+	if err := json.Unmarshal(plaintext, &plain); err != nil {
+		return false, err
+	}
+	if plain.Algorithm != id.AlgorithmMegolmV1 {
+		return false, fmt.Errorf("unsupported session algorithm %s", plain.Algorithm)
+	}
+	internal, err := olm.InboundGroupSessionImport([]byte(plain.SessionKey))
+	if err != nil {
+		return false, err
+	}
+	if internal.ID() != sessionID {
+		return false, fmt.Errorf("session id does not match")
+	}
+	existing, _ := mach.CryptoStore.GetGroupSession(roomID, plain.SenderKey, sessionID)
+	if existing != nil && existing.Internal.FirstKnownIndex() <= internal.FirstKnownIndex() {
+		return false, nil
+	}
+	igs := &crypto.InboundGroupSession{
+		Internal:         *internal,
+		SigningKey:       plain.SenderClaimedKeys.Ed25519,
+		SenderKey:        plain.SenderKey,
+		RoomID:           roomID,
+		ForwardingChains: plain.ForwardingChains,
+		ReceivedAt:       time.Now().UTC(),
+	}
+	if err := mach.CryptoStore.PutGroupSession(roomID, plain.SenderKey, sessionID, igs); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// backupKeys uploads the sessions that are not in the backup yet.
+func (m *Bot) backupKeys(backupKey *BackupKey, version string) error {
+	sessions, err := m.cryptoHelper.Machine().CryptoStore.GetAllGroupSessions()
+	if err != nil {
+		return err
+	}
+
+	req := backupRoomKeys{Rooms: make(map[id.RoomID]backupRoom)}
+	var backedUp []id.SessionID
+	for _, s := range sessions {
+		sessionID := s.ID()
+		done, err := m.store.KeyBackedUp(sessionID)
+		if err != nil {
+			return err
+		}
+		if done {
+			continue
+		}
+		firstIndex := s.Internal.FirstKnownIndex()
+		exported, err := s.Internal.Export(firstIndex)
+		if err != nil {
+			m.logger.Error("failed to export session", slog.String("err", err.Error()), slog.String("session_id", sessionID.String()), slog.String("bot", m.config.UserDisplayName))
+			continue
+		}
+		plaintext, err := json.Marshal(backupSessionPlain{
+			Algorithm:         id.AlgorithmMegolmV1,
+			ForwardingChains:  s.ForwardingChains,
+			SenderClaimedKeys: crypto.SenderClaimedKeys{Ed25519: s.SigningKey},
+			SenderKey:         s.SenderKey,
+			SessionKey:        string(exported),
+		})
+		if err != nil {
+			return err
+		}
+		data, err := backupKey.Encrypt(plaintext)
+		if err != nil {
+			return err
+		}
+		room, ok := req.Rooms[s.RoomID]
+		if !ok {
+			room = backupRoom{Sessions: make(map[id.SessionID]backupSession)}
+			req.Rooms[s.RoomID] = room
+		}
+		room.Sessions[sessionID] = backupSession{
+			FirstMessageIndex: firstIndex,
+			ForwardedCount:    len(s.ForwardingChains),
+			SessionData:       data,
+		}
+		backedUp = append(backedUp, sessionID)
+	}
+	if len(backedUp) == 0 {
+		return nil
+	}
+
+	u := m.client.BuildURLWithQuery(mautrix.ClientURLPath{"v3", "room_keys", "keys"}, map[string]string{"version": version})
+	if _, err := m.client.MakeRequest(http.MethodPut, u, &req, nil); err != nil {
+		return err
+	}
+	for _, sessionID := range backedUp {
+		if err := m.store.SetKeyBackedUp(sessionID); err != nil {
+			return err
+		}
+	}
+	m.logger.Info("backed up keys", slog.Int("sessions", len(backedUp)), slog.String("bot", m.config.UserDisplayName))
+
+	return nil
+}
+
+// KeyBackedUp reports whether the session is known to be in the server-side
+// key backup.
+func (s *Store) KeyBackedUp(sessionID id.SessionID) (bool, error) {
+	var n int
+	if err := s.db.QueryRowContext(s.context(), `SELECT COUNT(*) FROM bot_key_backup WHERE session_id = $1`, sessionID).Scan(&n); err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (s *Store) SetKeyBackedUp(sessionID id.SessionID) error {
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_key_backup (session_id) VALUES ($1) ON CONFLICT (session_id) DO NOTHING`, sessionID)
+	return err
+}
@@ -0,0 +1,56 @@
+package bot_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestBackupKey(t *testing.T) {
+	t.Parallel()
+
+	private := make([]byte, 32)
+	if _, err := rand.Read(private); err != nil {
+		t.Fatal(err)
+	}
+	key, err := bot.NewBackupKey(private)
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	plaintext := []byte(`{"algorithm":"m.megolm.v1.aes-sha2","session_key":"key"}`)
+	data, err := key.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+
+	t.Run("roundtrip", func(t *testing.T) {
+		act, err := key.Decrypt(data)
+		if err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+		if !bytes.Equal(plaintext, act) {
+			t.Errorf("exp %s, got %s", plaintext, act)
+		}
+	})
+
+	t.Run("other key", func(t *testing.T) {
+		other := make([]byte, 32)
+		if _, err := rand.Read(other); err != nil {
+			t.Fatal(err)
+		}
+		otherKey, err := bot.NewBackupKey(other)
+		if err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+		_, err = otherKey.Decrypt(data)
+		if !errors.Is(err, bot.ErrBackupMAC) {
+			t.Errorf("exp %v, got %v", bot.ErrBackupMAC, err)
+		}
+		if !errors.Is(err, bot.ErrDecryptFailed) {
+			t.Errorf("exp %v, got %v", bot.ErrDecryptFailed, err)
+		}
+	})
+}
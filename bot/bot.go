@@ -1,9 +1,16 @@
 package bot
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/sashabaranov/go-openai"
 	"golang.org/x/exp/slog"
 	"maunium.net/go/mautrix"
@@ -11,72 +18,204 @@ import (
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/format"
 	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/util/dbutil"
 )
 
-var (
-	botNames = []string{}
-	mu       = &sync.Mutex{}
-)
-
-func BotNameAppend(name string) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	botNames = append(botNames, name)
-}
-
 type ConfigOpenAI struct {
 	APIKey string
 }
 
 type ConfigBot struct {
-	DBPath            string
-	Pickle            string
-	Homeserver        string
-	UserID            string
-	UserAccessKey     string
-	UserPassword      string
-	UserDisplayName   string
-	SystemPrompt      string
-	AnswerUnaddressed bool
+	DBPath             string
+	Pickle             string
+	Homeserver         string
+	UserID             string
+	UserAccessKey      string
+	UserPassword       string
+	RecoveryKey        string
+	CrossSigning       bool
+	KeyBackup          bool
+	TrustPolicy        string
+	EncryptedOnly      bool
+	ReplyUndecryptable bool
+	UserDisplayName    string
+	Profile            ConfigProfile
+	Greeting           ConfigGreeting
+	Timezone           string
+	Language           string
+	Catalog            map[string]map[string]string
+	Translate          ConfigTranslate
+	Correct            ConfigCorrect
+	CodeReview         ConfigCodeReview
+	Quiz               ConfigQuiz
+	Karma              ConfigKarma
+	SystemPrompt       string
+	Examples           []Example
+	Model              string
+	RoomModels         []string
+	Timeouts           ConfigTimeouts
+	Tools              []string
+	ToolTrail          bool
+	Search             ConfigSearch
+	Sandbox            ConfigSandbox
+	Forges             []ConfigForge
+	HomeAssistant      ConfigHomeAssistant
+	AnswerUnaddressed  bool
+	DryRun             bool
+	AutoJoinRooms      []string
+	ApproveInvites     bool
+	AdminRoom          string
+	MaxConversations   int
+	Scripts            []string
+	Admins             []string
+	VerifyFrom         []string
+	Rules              []ConfigRule
+	Routes             []ConfigRoute
+	Webhooks           []ConfigWebhook
+	Schedules          []ConfigSchedule
+	Hooks              []ConfigHook
+	Digests            []ConfigDigest
+	Personas           []Persona
+	PersonaDir         string
+	TopicPersona       bool
+	RoomPrompts        bool
+	Injection          ConfigInjection
+	Prices             []ConfigPrice
+	Budget             ConfigBudget
+	Cache              ConfigCache
+	Quota              ConfigQuota
+	UsageReports       []ConfigUsageReport
+	Standups           []ConfigStandup
 }
 
+// Config is the configuration file. SystemPrompt is used by the bots that
+// have none of their own, and defaults to DefaultSystemPrompt.
 type Config struct {
-	OpenAI ConfigOpenAI `toml:"openai"`
-	Bots   []ConfigBot  `toml:"bot"`
+	SystemPrompt string        `toml:"systemprompt"`
+	OpenAI       ConfigOpenAI  `toml:"openai"`
+	Inbound      ConfigInbound `toml:"inbound"`
+	Bots         []ConfigBot   `toml:"bot"`
+}
+
+// ApplyDefaults fills in the settings that the bots leave empty.
+func (c *Config) ApplyDefaults() {
+	prompt := c.SystemPrompt
+	if prompt == "" {
+		prompt = DefaultSystemPrompt
+	}
+	for i := range c.Bots {
+		if c.Bots[i].SystemPrompt == "" {
+			c.Bots[i].SystemPrompt = prompt
+		}
+	}
 }
 
 type Bot struct {
-	openaiKey     string
-	config        ConfigBot
-	client        *mautrix.Client
-	cryptoHelper  *cryptohelper.CryptoHelper
-	characters    []Character
-	conversations Conversations
-	gptClient     *GPT
-	logger        *slog.Logger
+	openaiKey         string
+	config            ConfigBot
+	client            *mautrix.Client
+	matrix            Matrix
+	cryptoHelper      *cryptohelper.CryptoHelper
+	characters        []Character
+	conversations     *ConversationCache
+	gptClient         Provider
+	fallback          Provider
+	scripts           []*Script
+	rules             []*Rule
+	routes            []*route
+	injectionPatterns []*regexp.Regexp
+	forwarders        []*Forwarder
+	personas          map[string]Persona
+	personaMu         sync.RWMutex
+	personaDir        personaDir
+	tools             map[string]Tool
+	dispatcher        *Dispatcher
+	commands          map[string]Command
+	store             *Store
+	scheduler         *Scheduler
+	calendars         calendarCache
+	standups          []standup
+	quizzes           quizGames
+	loc               *time.Location
+	logger            *slog.Logger
+	clientLog         *zerolog.Logger
+	httpClient        *http.Client
+	verifier          Verifier
+	verifyMu          sync.Mutex
+	dmMu              sync.Mutex
+	acceptInvites     bool
+	ctx               context.Context
+	cancel            context.CancelFunc
+	stop              chan struct{}
+	stopOnce          sync.Once
+	running           sync.WaitGroup
+	syncHealth        syncHealth
 }
 
-func New(openaiKey string, cfg ConfigBot, logger *slog.Logger) *Bot {
-	return &Bot{
-		openaiKey: openaiKey,
-		config:    cfg,
-		logger:    logger,
+// New creates a bot for the config. It does not connect yet, that is done by
+// Init.
+func New(cfg ConfigBot, opts ...Option) *Bot {
+	m := &Bot{
+		config: cfg,
+		logger: slog.Default(),
+		stop:   make(chan struct{}),
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(m)
 	}
+
+	return m
 }
 
 func (m *Bot) Init(acceptInvites bool) error {
+	if err := m.config.Timeouts.validate(); err != nil {
+		return err
+	}
+	if err := m.validateGreeting(); err != nil {
+		return err
+	}
+	if m.matrix != nil {
+		// given with WithMatrix, only the handling of messages is set up
+		if m.store == nil {
+			return fmt.Errorf("a bot with its own Matrix needs a store")
+		}
+		m.acceptInvites = acceptInvites
+		return m.setup()
+	}
 	client, err := mautrix.NewClient(m.config.Homeserver, id.UserID(m.config.UserID), m.config.UserAccessKey)
 	if err != nil {
 		return err
 	}
+	if m.clientLog != nil {
+		client.Log = *m.clientLog
+	}
+	if m.httpClient != nil {
+		hc := *m.httpClient
+		client.Client = &hc
+	}
+	if m.config.Timeouts.Matrix != 0 {
+		client.Client.Timeout = m.config.Timeouts.Matrix
+	}
+	syncer := &supervisedSyncer{DefaultSyncer: client.Syncer.(*mautrix.DefaultSyncer), health: &m.syncHealth}
+	syncer.OnSync(func(_ *mautrix.RespSync, _ string) bool {
+		m.syncHealth.succeeded(time.Now())
+		return true
+	})
+	client.Syncer = syncer
 	var oei mautrix.OldEventIgnorer
-	oei.Register(client.Syncer.(mautrix.ExtensibleSyncer))
+	oei.Register(syncer)
 	m.client = client
-	m.cryptoHelper, err = cryptohelper.NewCryptoHelper(client, []byte(m.config.Pickle), m.config.DBPath)
+	m.matrix = &clientMatrix{client: client}
+	db, err := dbutil.NewWithDialect(m.config.DBPath, DBDialect(m.config.DBPath))
+	if err != nil {
+		return err
+	}
+	m.cryptoHelper, err = cryptohelper.NewCryptoHelper(client, []byte(m.config.Pickle), db)
 	if err != nil {
 		return err
 	}
+	m.cryptoHelper.DecryptErrorCallback = m.decryptError
 	m.cryptoHelper.LoginAs = &mautrix.ReqLogin{
 		Type:       mautrix.AuthTypePassword,
 		Identifier: mautrix.UserIdentifier{Type: mautrix.IdentifierTypeUser, User: m.config.UserID},
@@ -86,53 +225,359 @@ func (m *Bot) Init(acceptInvites bool) error {
 		return err
 	}
 	m.client.Crypto = m.cryptoHelper
-	m.gptClient = NewGPT(m.openaiKey)
-	m.conversations = make(Conversations, 0)
-	if acceptInvites {
-		m.AddEventHandler(m.InviteHandler())
+	m.cryptoHelper.Machine().AcceptVerificationFrom = m.acceptVerification
+	if err := m.applyTrustPolicy(); err != nil {
+		return err
+	}
+	if m.config.CrossSigning {
+		if err := m.bootstrapCrossSigning(); err != nil {
+			return err
+		}
+	}
+	if m.store == nil {
+		m.store, err = NewStore(db)
+		if err != nil {
+			return err
+		}
+	}
+	if m.config.KeyBackup {
+		if err := m.connectKeyBackup(); err != nil {
+			return err
+		}
+	}
+	if !m.config.DryRun {
+		if err := m.applyProfile(); err != nil {
+			m.logger.Error("failed to apply profile", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		}
+	}
+	if err := m.setup(); err != nil {
+		return err
 	}
+	m.acceptInvites = acceptInvites
+	m.AddEventHandler(m.InviteHandler())
 	m.AddEventHandler(m.ResponseHandler())
-
-	m.config.UserDisplayName = strings.ToLower(m.config.UserDisplayName)
-	BotNameAppend(m.config.UserDisplayName)
+	m.AddEventHandler(m.PluginStateHandler())
+	m.AddEventHandler(m.RoomConfigHandler())
+	for _, t := range []event.Type{event.StateRoomName, event.StateCanonicalAlias, event.StateTopic, event.StateEncryption, event.StateMember} {
+		m.AddEventHandler(t, m.RoomInfoHandler())
+	}
+	m.AddEventHandler(m.MembershipHandler())
+	m.AddEventHandler(m.ReminderReactionHandler())
+	m.AddEventHandler(m.KarmaReactionHandler())
+	for _, t := range []event.Type{EventPollResponse, EventPollEnd} {
+		m.AddEventHandler(t, m.PollHandler())
+	}
+	for _, t := range []event.Type{event.EventMessage, event.InRoomVerificationStart, event.InRoomVerificationReady, event.InRoomVerificationAccept, event.InRoomVerificationKey, event.InRoomVerificationMAC, event.InRoomVerificationCancel} {
+		m.AddEventHandler(t, m.InRoomVerificationHandler())
+	}
 
 	return nil
 }
 
-func (m *Bot) Run() error {
-	if err := m.client.Sync(); err != nil {
+// SetProvider replaces the provider that answers, for example with a
+// FakeProvider in tests.
+func (m *Bot) SetProvider(p Provider) {
+	m.gptClient = p
+}
+
+// setup creates everything that handles messages: commands, handlers, tools
+// and personas. It does not need a connection to the homeserver.
+func (m *Bot) setup() error {
+	if m.config.Timezone != "" {
+		loc, err := time.LoadLocation(m.config.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid time zone %q: %w", m.config.Timezone, err)
+		}
+		m.loc = loc
+	}
+	if err := m.config.Budget.validate(); err != nil {
+		return err
+	}
+	if m.config.SystemPrompt == "" {
+		m.config.SystemPrompt = DefaultSystemPrompt
+	}
+	if err := validatePrompt(m.config.SystemPrompt); err != nil {
+		return err
+	}
+	if err := m.validateCatalog(); err != nil {
+		return err
+	}
+	patterns, err := m.config.Injection.compile()
+	if err != nil {
+		return err
+	}
+	m.injectionPatterns = patterns
+	m.store = m.store.WithContext(m.ctx)
+	if m.config.DryRun {
+		m.matrix = &dryRunMatrix{Matrix: m.matrix, logger: m.logger, bot: m.config.UserDisplayName}
+		m.logger.Warn("dry run, messages are logged instead of sent", slog.String("bot", m.config.UserDisplayName))
+	}
+	if m.gptClient == nil {
+		config := openai.DefaultConfig(m.openaiKey)
+		config.HTTPClient = m.httpClient
+		gpt := NewGPTWithConfig(config)
+		gpt.SetToolTimeout(m.config.Timeouts.tool())
+		m.gptClient = gpt
+	}
+	m.conversations = NewConversationCache(m.config.MaxConversations, m.store)
+	for _, path := range m.config.Scripts {
+		script, err := LoadScript(path)
+		if err != nil {
+			return err
+		}
+		m.scripts = append(m.scripts, script)
+	}
+	for _, rc := range m.config.Rules {
+		r, err := NewRule(rc)
+		if err != nil {
+			return err
+		}
+		m.rules = append(m.rules, r)
+	}
+	for _, rc := range m.config.Routes {
+		r, err := newRoute(rc)
+		if err != nil {
+			return err
+		}
+		m.routes = append(m.routes, r)
+	}
+	for _, wc := range m.config.Webhooks {
+		m.forwarders = append(m.forwarders, NewForwarder(wc))
+	}
+	m.commands = make(map[string]Command)
+	m.RegisterCommand(m.helpCommand())
+	m.RegisterCommand(m.pluginCommand())
+	m.RegisterCommand(m.remindCommand())
+	m.RegisterCommand(m.snoozeCommand())
+	m.RegisterCommand(m.cancelCommand())
+	m.RegisterCommand(m.tzCommand())
+	m.RegisterCommand(m.langCommand())
+	m.RegisterCommand(m.translateCommand())
+	m.RegisterCommand(m.correctCommand())
+	m.RegisterCommand(m.weatherCommand())
+	m.RegisterCommand(m.scheduleCommand())
+	m.RegisterCommand(m.feedCommand())
+	m.RegisterCommand(m.calendarCommand())
+	m.RegisterCommand(m.toolCommand())
+	m.RegisterCommand(m.leaveCommand())
+	m.RegisterCommand(m.directoryCommand())
+	m.RegisterCommand(m.aliasCommand())
+	m.RegisterCommand(m.dmCommand())
+	m.RegisterCommand(m.knockCommand())
+	m.RegisterCommand(m.roomCommand())
+	m.RegisterCommand(m.approveCommand())
+	m.RegisterCommand(m.denyCommand())
+	m.RegisterCommand(m.usageCommand())
+	m.RegisterCommand(m.personaCommand())
+	m.RegisterCommand(m.quizCommand())
+	m.RegisterCommand(m.karmaCommand())
+	m.RegisterCommand(m.pollCommand())
+	m.scheduler = NewScheduler(m.location())
+	if err := m.loadSchedules(); err != nil {
 		return err
 	}
+	if err := m.loadDigests(); err != nil {
+		return err
+	}
+	if err := m.loadUsageReports(); err != nil {
+		return err
+	}
+	if err := m.loadStandups(); err != nil {
+		return err
+	}
+	m.dispatcher = NewDispatcher()
+	m.dispatcher.SetEnabledFunc(m.pluginEnabled)
+	m.AddMessageHandler(m.RuleHandler())
+	m.AddMessageHandler(m.ForwardHandler())
+	m.AddMessageHandler(m.ScriptHandler())
+	m.AddMessageHandler(m.TranslateHandler())
+	m.AddMessageHandler(m.CorrectHandler())
+	m.AddMessageHandler(m.KarmaHandler())
+	m.AddMessageHandler(m.QuizHandler())
+	m.AddMessageHandler(m.StandupHandler())
+	m.AddMessageHandler(m.CommandHandler())
+	m.AddMessageHandler(m.ChatHandler())
+	m.personas = make(map[string]Persona)
+	m.tools = make(map[string]Tool)
+	m.registerTool(NewFetchTool())
+	weather := NewWeatherTool("", "")
+	weather.home = m.homePlace
+	m.registerTool(weather)
+	m.registerTool(NewWikipediaTool(""))
+	if m.config.Search.Backend != "" {
+		search, err := NewSearchTool(m.config.Search)
+		if err != nil {
+			return err
+		}
+		m.registerTool(search)
+	}
+	if m.config.Sandbox.Mode != "" {
+		sandbox, err := NewSandboxTool(m.config.Sandbox)
+		if err != nil {
+			return err
+		}
+		m.registerTool(sandbox)
+	}
+	if len(m.config.Forges) > 0 {
+		forge, err := NewForgeTool(m.config.Forges)
+		if err != nil {
+			return err
+		}
+		m.registerTool(forge)
+		m.RegisterCommand(m.ghCommand())
+	}
+	if m.config.HomeAssistant.URL != "" {
+		ha, err := NewHomeAssistantTool(m.config.HomeAssistant)
+		if err != nil {
+			return err
+		}
+		m.registerTool(ha)
+	}
+	if m.config.CodeReview.Enabled {
+		if err := m.RegisterPersona(m.config.CodeReview.persona()); err != nil {
+			return err
+		}
+	}
+	for _, p := range m.config.Personas {
+		if err := m.RegisterPersona(p); err != nil {
+			return err
+		}
+	}
+	if m.config.PersonaDir != "" {
+		if err := m.ReloadPersonas(); err != nil {
+			return err
+		}
+	}
+	m.config.UserDisplayName = strings.ToLower(m.config.UserDisplayName)
 
 	return nil
 }
 
+// DBDialect returns the database driver for DBPath, which is either a SQLite
+// file or a Postgres connection string.
+func DBDialect(dbPath string) string {
+	if strings.HasPrefix(dbPath, "postgres://") || strings.HasPrefix(dbPath, "postgresql://") {
+		return "postgres"
+	}
+
+	return "sqlite3"
+}
+
+// Run syncs with the homeserver until Shutdown is called. Syncing is
+// restarted when it fails, unless the homeserver does not accept the bot.
+func (m *Bot) Run() error {
+	return m.RunContext(context.Background())
+}
+
+// RunContext is Run, but the bot also shuts down, like with Close, when ctx
+// is done.
+func (m *Bot) RunContext(ctx context.Context) error {
+	m.running.Add(1)
+	defer m.running.Done()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := m.Close(); err != nil {
+				m.logger.Error("failed to shut down", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+			}
+		case <-m.stop:
+		}
+	}()
+
+	m.goLoop(m.runReminders)
+	m.goLoop(m.runFeeds)
+	m.goLoop(m.runCalendars)
+	m.goLoop(m.runOutbox)
+	m.goLoop(m.runPrune)
+	m.goLoop(m.runPersonaDir)
+	m.goLoop(m.runStandups)
+	m.scheduler.Start()
+	m.goLoop(m.refreshRooms)
+	m.autoJoin()
+
+	return m.superviseSync(ctx)
+}
+
+// Close shuts the bot down, waiting at most shutdownTimeout for running work.
 func (m *Bot) Close() error {
-	if err := m.client.Sync(); err != nil {
-		return err
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	return m.Shutdown(ctx)
+}
+
+func (m *Bot) Name() string {
+	return m.config.UserDisplayName
+}
+
+// SendText sends a plain text message to the room, encrypted if the room is.
+func (m *Bot) SendText(roomID id.RoomID, text string) error {
+	if !m.allowedRoom(roomID) {
+		return ErrUnencryptedRoom
 	}
-	if err := m.cryptoHelper.Close(); err != nil {
-		return err
+	_, err := m.sendMessage(roomID, &event.MessageEventContent{MsgType: event.MsgText, Body: text})
+	return err
+}
+
+// SendMarkdown sends text, rendered as markdown, as a notice to the room.
+func (m *Bot) SendMarkdown(roomID id.RoomID, text string) error {
+	if !m.allowedRoom(roomID) {
+		return ErrUnencryptedRoom
 	}
+	content := format.RenderMarkdown(text, true, false)
+	content.MsgType = event.MsgNotice
+	_, err := m.sendMessage(roomID, &content)
 
-	return nil
+	return err
+}
+
+// SendHTML sends a notice with the given HTML to the room.
+func (m *Bot) SendHTML(roomID id.RoomID, html string) error {
+	if !m.allowedRoom(roomID) {
+		return ErrUnencryptedRoom
+	}
+	content := format.HTMLToContent(html)
+	content.MsgType = event.MsgNotice
+	_, err := m.sendMessage(roomID, &content)
+
+	return err
 }
 
 func (m *Bot) AddEventHandler(eventType event.Type, handler mautrix.EventHandler) {
-	syncer := m.client.Syncer.(*mautrix.DefaultSyncer)
+	syncer := m.client.Syncer.(mautrix.ExtensibleSyncer)
 	syncer.OnEventType(eventType, handler)
 }
 
+// AddMessageHandler registers a handler for incoming messages, see
+// MessageHandler for the order in which they are run.
+func (m *Bot) AddMessageHandler(h MessageHandler) {
+	m.dispatcher.Register(h)
+}
+
+// InviteHandler joins rooms the bot is invited to, if it accepts invites, or
+// if the invite is the answer to a knock. With ApproveInvites, invites from
+// others than admins wait for approval.
 func (m *Bot) InviteHandler() (event.Type, mautrix.EventHandler) {
 	return event.StateMember, func(source mautrix.EventSource, evt *event.Event) {
-		if evt.GetStateKey() == m.client.UserID.String() && evt.Content.AsMember().Membership == event.MembershipInvite {
-			_, err := m.client.JoinRoomByID(evt.RoomID)
-			if err != nil {
-				m.logger.Error("failed to join room after invite", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("inviter", evt.Sender.String()), slog.String("bot", m.config.UserDisplayName))
+		if evt.GetStateKey() == m.matrix.UserID().String() && evt.Content.AsMember().Membership == event.MembershipInvite {
+			switch {
+			case m.config.DryRun:
+				m.logger.Info("dry run, ignoring invite", slog.String("room_id", evt.RoomID.String()), slog.String("inviter", evt.Sender.String()), slog.String("bot", m.config.UserDisplayName))
+				return
+			case m.knocked(evt.RoomID):
+				if err := m.store.DeleteKnock(evt.RoomID); err != nil {
+					m.logger.Error("failed to remove knock", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+				}
+			case m.config.ApproveInvites && !m.isAdmin(evt.Sender):
+				m.queueInvite(evt.RoomID, evt.Sender)
+				return
+			case !m.acceptInvites && !m.config.ApproveInvites:
 				return
 			}
-
-			m.logger.Info("joined room after invite", slog.String("room_id", evt.RoomID.String()), slog.String("inviter", evt.Sender.String()), slog.String("bot", m.config.UserDisplayName))
+			if err := m.acceptInvite(evt.RoomID, evt.Sender); err != nil {
+				m.logger.Error("failed to join room after invite", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("inviter", evt.Sender.String()), slog.String("bot", m.config.UserDisplayName))
+			}
 		}
 	}
 }
@@ -141,10 +586,17 @@ func (m *Bot) ResponseHandler() (event.Type, mautrix.EventHandler) {
 	return event.EventMessage, func(source mautrix.EventSource, evt *event.Event) {
 		content := evt.Content.AsMessage()
 		eventID := evt.ID
+		if content.MsgType == event.MsgVerificationRequest {
+			return
+		}
 		m.logger.Info("received message", slog.String("content", content.Body))
+		if m.stopping() {
+			m.logger.Info("shutting down, ignoring", slog.String("event_id", eventID.String()), slog.String("bot", m.config.UserDisplayName))
+			return
+		}
 
 		// ignore if the message is already recorded
-		if conv := m.conversations.FindByEventID(eventID); conv != nil {
+		if conv := m.findConversation(eventID); conv != nil {
 			m.logger.Info("known message, ignoring", slog.String("event_id", eventID.String()), slog.String("bot", m.config.UserDisplayName))
 			return
 		}
@@ -155,80 +607,154 @@ func (m *Bot) ResponseHandler() (event.Type, mautrix.EventHandler) {
 			return
 		}
 
-		var conv *Conversation
-		// find out if it is a reply to a known conversation
-		parentID := id.EventID("")
-		var hasParent bool
-		if relatesTo := content.GetRelatesTo(); relatesTo != nil {
-			if parentID = relatesTo.GetReplyTo(); parentID != "" {
-				hasParent = true
-				m.logger.Info("message is a reply", slog.String("parent_id", parentID.String()))
-				if c := m.conversations.FindByEventID(parentID); c != nil {
-					m.logger.Info("found parent, appending message to conversation", slog.String("event_id", eventID.String()), slog.String("bot", m.config.UserDisplayName))
-					c.Add(Message{
-						EventID:  eventID,
-						ParentID: parentID,
-						Role:     openai.ChatMessageRoleUser,
-						Content:  content.Body,
-					})
-					conv = c
-				}
-			}
+		if !m.firstTime(eventID) {
+			m.logger.Info("message already processed, ignoring", slog.String("event_id", eventID.String()), slog.String("bot", m.config.UserDisplayName))
+			return
 		}
 
-		m.logger.Info(content.Body)
-		addressedTo, _, isAddressed := strings.Cut(content.Body, ": ")
-		addressedTo = strings.TrimSpace(strings.ToLower(addressedTo))
-		if strings.Contains(addressedTo, " ") {
-			isAddressed = false // only display names without spaces, otherwise no way to know if it's a name or not
+		if m.config.EncryptedOnly && !evt.Mautrix.WasEncrypted {
+			m.logger.Info("message in unencrypted room, ignoring", slog.String("event_id", eventID.String()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+			m.refuseUnencrypted(evt)
+			return
 		}
 
-		// find out if message is a new question addressed to the bot
-		if conv == nil && isAddressed && addressedTo == m.config.UserDisplayName {
-			m.logger.Info("message is addressed to bot", slog.String("event_id", eventID.String()), slog.String("bot", m.config.UserDisplayName))
-			conv = NewConversation(eventID, m.config.SystemPrompt, content.Body)
-			m.conversations = append(m.conversations, conv)
-		}
-		// find out if the message is addressed to no-one and this bot answers those
-		if conv == nil && !isAddressed && !hasParent && m.config.AnswerUnaddressed {
-			m.logger.Info("message is addressed to no-one", slog.String("event_id", eventID.String()), slog.String("bot", m.config.UserDisplayName))
-			conv = NewConversation(eventID, m.config.SystemPrompt, content.Body)
-			m.conversations = append(m.conversations, conv)
+		if name, ok := m.dispatcher.Dispatch(evt); ok {
+			m.logger.Info("message handled", slog.String("event_id", eventID.String()), slog.String("handler", name), slog.String("bot", m.config.UserDisplayName))
 		}
+	}
+}
 
-		if conv == nil {
-			m.logger.Info("apparently not for us, ignoring", slog.String("event_id", eventID.String()), slog.String("bot", m.config.UserDisplayName))
-			return
+// ScriptHandler runs the configured scripts. A script that drops the message
+// consumes it.
+func (m *Bot) ScriptHandler() MessageHandler {
+	return NewMessageHandler("script", PriorityScript, func(evt *event.Event) bool {
+		content := evt.Content.AsMessage()
+		for _, script := range m.scripts {
+			body, keep, err := script.OnMessage(m, evt, content.Body)
+			if err != nil {
+				m.logger.Error("script failed", slog.String("err", err.Error()), slog.String("script", script.path), slog.String("bot", m.config.UserDisplayName))
+				continue
+			}
+			if !keep {
+				m.logger.Info("message dropped by script", slog.String("event_id", evt.ID.String()), slog.String("script", script.path), slog.String("bot", m.config.UserDisplayName))
+				return true
+			}
+			content.Body = body
 		}
 
-		// get reply from GPT
-		reply, err := m.gptClient.Complete(conv)
-		if err != nil {
-			m.logger.Error("failed to get reply from openai", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
-			return
-		}
+		return false
+	})
+}
 
-		formattedReply := format.RenderMarkdown(reply, true, false)
-		formattedReply.RelatesTo = &event.RelatesTo{
-			InReplyTo: &event.InReplyTo{
-				EventID: eventID,
-			},
-		}
-		res, err := m.client.SendMessageEvent(evt.RoomID, event.EventMessage, &formattedReply)
-		if err != nil {
-			m.logger.Error("failed to send message", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
-			return
+// ChatHandler answers messages with a completion from GPT, using the system
+// prompt and model of the bot itself.
+func (m *Bot) ChatHandler() MessageHandler {
+	return NewMessageHandler("chat", PriorityChat, func(evt *event.Event) bool {
+		return m.personaHandler(m.chatPersona(evt.RoomID), PriorityChat, true).HandleMessage(evt)
+	})
+}
+
+// respond gets a completion for the conversation and sends it as a reply to
+// the event.
+func (m *Bot) respond(evt *event.Event, p Persona, conv *Conversation) bool {
+	eventID := evt.ID
+
+	lang := m.messageLanguage(evt)
+	if text, exceeded := m.quotaExceeded(evt.Sender, lang); exceeded {
+		m.sendNotice(evt.RoomID, eventID, text)
+		return true
+	}
+	provider, model, err := m.provider(m.routeModel(evt, p.Model, conv))
+	if err != nil {
+		m.sendNotice(evt.RoomID, eventID, m.text(lang, msgBudget))
+		return true
+	}
+
+	// show that an answer is coming while waiting for GPT
+	if err := m.matrix.Typing(evt.RoomID, true, m.config.Timeouts.completion()); err != nil {
+		m.logger.Error("failed to set typing", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+	defer func() {
+		if err := m.matrix.Typing(evt.RoomID, false, 0); err != nil {
+			m.logger.Error("failed to unset typing", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
 		}
-		conv.Add(Message{
-			EventID:  res.EventID,
-			ParentID: eventID,
-			Role:     openai.ChatMessageRoleAssistant,
-			Content:  reply,
-		})
+	}()
 
-		if len(reply) > 30 {
-			reply = reply[:30] + "..."
+	// get reply from GPT
+	trail := &ToolTrail{}
+	meter := &UsageMeter{}
+	var failed bool
+	defer func() {
+		m.saveUsage(evt, model, conv, meter, failed)
+		m.checkBudget(time.Now())
+	}()
+	ctx, cancel := m.completionContext(m.ctx)
+	defer cancel()
+	ctx = WithCaller(ctx, Caller{UserID: evt.Sender, RoomID: evt.RoomID})
+	ctx = WithToolTrail(ctx, trail)
+	ctx = WithUsageMeter(ctx, meter)
+	ctx = WithCompletionParams(ctx, p.params())
+	tools := m.guardTools(evt, m.roomTools(evt.RoomID, m.personaTools(p)))
+	key := m.cacheKey(model, conv, tools)
+	if promptUsesTime(p.SystemPrompt) {
+		key = ""
+	}
+	reply, cached := m.cachedAnswer(key)
+	if cached {
+		m.logger.Info("answered from cache", slog.String("event_id", eventID.String()), slog.String("bot", m.config.UserDisplayName))
+	} else {
+		reply, err = provider.CompleteContext(ctx, model, conv, tools...)
+	}
+	if err != nil {
+		failed = true
+		m.logger.Error("failed to get reply from openai", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		key := msgError
+		switch {
+		case errors.Is(err, ErrUnavailable):
+			key = msgUnavailable
+		case errors.Is(err, ErrRateLimited):
+			key = msgRateLimited
+		case errors.Is(err, ErrContextTooLong):
+			key = msgTooLong
 		}
-		m.logger.Info("sent reply", slog.String("parent_id", eventID.String()), slog.String("content", reply), slog.String("bot", m.config.UserDisplayName))
+		m.sendNotice(evt.RoomID, eventID, m.text(lang, key))
+		return true
+	}
+	if !cached && len(trail.Uses()) == 0 {
+		m.cacheAnswer(key, reply)
+	}
+
+	formattedReply := format.RenderMarkdown(reply, true, false)
+	formattedReply.RelatesTo = replyRelation(evt, p.Thread)
+	var content any = &formattedReply
+	if p.Name != "chat" {
+		content = personaReply(p, &formattedReply)
+	}
+	replyID, err := m.sendMessage(evt.RoomID, content)
+	if err != nil && !errors.Is(err, ErrQueued) {
+		m.logger.Error("failed to send message", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		return true
 	}
+	// a queued reply has no event ID yet, but it will be sent, so the next
+	// question in the conversation should see it
+	conv.Add(Message{
+		EventID:  replyID,
+		ParentID: eventID,
+		Role:     openai.ChatMessageRoleAssistant,
+		Content:  reply,
+	})
+	if errors.Is(err, ErrQueued) {
+		m.logger.Warn("reply queued", slog.String("err", err.Error()), slog.String("parent_id", eventID.String()), slog.String("bot", m.config.UserDisplayName))
+		return true
+	}
+	if m.config.ToolTrail {
+		m.sendToolTrail(evt.RoomID, replyID, trail)
+	}
+
+	if len(reply) > 30 {
+		reply = reply[:30] + "..."
+	}
+	m.logger.Info("sent reply", slog.String("parent_id", eventID.String()), slog.String("content", reply), slog.String("persona", p.Name), slog.String("bot", m.config.UserDisplayName))
+
+	return true
 }
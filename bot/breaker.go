@@ -0,0 +1,82 @@
+package bot
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	breakerThreshold = 5
+	breakerCooldown  = time.Minute
+)
+
+// ErrUnavailable means the provider failed too often recently and is left
+// alone for a while.
+var ErrUnavailable = errors.New("provider temporarily unavailable")
+
+// Breaker is a circuit breaker. After Threshold failures in a row it opens
+// and refuses calls. When Cooldown passed, it lets one call through as a
+// probe: if that succeeds it closes again, otherwise it waits another
+// Cooldown.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	failures int
+	openedAt time.Time
+	probing  bool
+	mu       sync.Mutex
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+	}
+}
+
+// Allow reports whether a call may be made at now.
+func (b *Breaker) Allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.Threshold {
+		return true
+	}
+	if b.probing || now.Sub(b.openedAt) < b.Cooldown {
+		return false
+	}
+	b.probing = true
+
+	return true
+}
+
+// Success records a call that worked, which closes the breaker.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure records a call that failed at now.
+func (b *Breaker) Failure(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.failures >= b.Threshold {
+		b.openedAt = now
+	}
+}
+
+// Open reports whether calls are refused at the moment.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.failures >= b.Threshold
+}
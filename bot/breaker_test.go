@@ -0,0 +1,63 @@
+package bot_test
+
+import (
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestBreaker(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 6, 7, 12, 0, 0, 0, time.UTC)
+	b := bot.NewBreaker(2, time.Minute)
+	for _, tc := range []struct {
+		name     string
+		at       time.Duration
+		expAllow bool
+		success  bool
+	}{
+		{name: "first failure", at: 0, expAllow: true},
+		{name: "second failure opens", at: time.Second, expAllow: true},
+		{name: "open", at: 30 * time.Second, expAllow: false},
+		{name: "failing probe", at: 62 * time.Second, expAllow: true},
+		{name: "open again", at: 90 * time.Second, expAllow: false},
+		{name: "succeeding probe", at: 123 * time.Second, expAllow: true, success: true},
+		{name: "closed", at: 124 * time.Second, expAllow: true, success: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			at := now.Add(tc.at)
+			act := b.Allow(at)
+			if act != tc.expAllow {
+				t.Fatalf("exp %v, got %v", tc.expAllow, act)
+			}
+			if !act {
+				return
+			}
+			if tc.success {
+				b.Success()
+				return
+			}
+			b.Failure(at)
+		})
+	}
+	if b.Open() {
+		t.Errorf("exp closed, got open")
+	}
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 6, 7, 12, 0, 0, 0, time.UTC)
+	b := bot.NewBreaker(1, time.Minute)
+	b.Failure(now)
+	later := now.Add(2 * time.Minute)
+	if !b.Allow(later) {
+		t.Errorf("exp probe, got refused")
+	}
+	if b.Allow(later) {
+		t.Errorf("exp one probe at a time, got another")
+	}
+}
@@ -0,0 +1,233 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// ErrBudgetExhausted is returned instead of calling the provider when the
+// monthly budget is used up and there is no fallback.
+var ErrBudgetExhausted = errors.New("monthly budget exhausted")
+
+// ConfigBudget caps what the bot spends on the model per calendar month, in
+// dollars, as estimated with the prices of !usage. Once the cap is reached,
+// the bot answers with Fallback, a cheaper or local model, or not at all.
+// Without Monthly there is no cap.
+//
+// Before that, the admins are warned when the spending passes one of the
+// Thresholds, in percent of the cap. Without Thresholds, that is at 50, 80
+// and 95 percent. The warnings, and the cutoff, are also posted to the
+// Webhooks.
+type ConfigBudget struct {
+	Monthly    float64
+	Fallback   string
+	Thresholds []int
+	Webhooks   []ConfigBudgetWebhook
+}
+
+// ConfigBudgetWebhook is an HTTP endpoint that receives the budget alerts
+// as JSON. With a Secret, the body is signed like that of ConfigWebhook.
+type ConfigBudgetWebhook struct {
+	URL    string
+	Secret string
+}
+
+// BudgetAlert is posted to the budget webhooks when the spending passes a
+// threshold. A threshold of 100 means the budget is used up.
+type BudgetAlert struct {
+	Bot       string  `json:"bot"`
+	Month     string  `json:"month"`
+	Threshold int     `json:"threshold"`
+	Budget    float64 `json:"budget"`
+	Spent     float64 `json:"spent"`
+}
+
+var defaultBudgetThresholds = []int{50, 80, 95}
+
+func (c ConfigBudget) validate() error {
+	for _, t := range c.Thresholds {
+		if t <= 0 || t >= 100 {
+			return fmt.Errorf("budget threshold %d is not between 0 and 100", t)
+		}
+	}
+
+	return nil
+}
+
+// thresholds returns the thresholds from low to high.
+func (c ConfigBudget) thresholds() []int {
+	if len(c.Thresholds) == 0 {
+		return defaultBudgetThresholds
+	}
+	thresholds := append([]int(nil), c.Thresholds...)
+	sort.Ints(thresholds)
+
+	return thresholds
+}
+
+// monthSpent estimates what was spent since the start of the month, in the
+// time zone of the bot.
+func (m *Bot) monthSpent(now time.Time) (float64, error) {
+	now = now.In(m.location())
+	perModel, err := m.store.ModelUsage(UsageFilter{Since: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())})
+	if err != nil {
+		return 0, err
+	}
+	dollars, _ := m.cost(perModel)
+
+	return dollars, nil
+}
+
+// provider returns the provider and the model to use instead of the given
+// one. When the budget is used up, that is the fallback provider or model,
+// and the admins are told. Without a fallback, the error is
+// ErrBudgetExhausted. If the spending can not be read, the budget does not
+// apply.
+func (m *Bot) provider(model string) (Provider, string, error) {
+	budget := m.config.Budget
+	if budget.Monthly <= 0 {
+		return m.gptClient, model, nil
+	}
+	now := time.Now()
+	spent, err := m.monthSpent(now)
+	if err != nil {
+		m.logger.Error("failed to get spending", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		return m.gptClient, model, nil
+	}
+	if spent < budget.Monthly {
+		return m.gptClient, model, nil
+	}
+	m.notifyBudgetExhausted(now, spent)
+
+	switch {
+	case m.fallback != nil:
+		return m.fallback, budget.Fallback, nil
+	case budget.Fallback != "":
+		return m.gptClient, budget.Fallback, nil
+	default:
+		return nil, "", ErrBudgetExhausted
+	}
+}
+
+// notifyBudgetExhausted tells the admins and the budget webhooks that the
+// budget is used up, once a month.
+func (m *Bot) notifyBudgetExhausted(now time.Time, spent float64) {
+	month := now.In(m.location()).Format("2006-01")
+	if !m.firstBudgetAlert(month, 100) {
+		return
+	}
+
+	text := fmt.Sprintf("The budget of $%.2f for %s is used up, about $%.2f was spent.", m.config.Budget.Monthly, month, spent)
+	switch {
+	case m.fallback != nil || m.config.Budget.Fallback != "":
+		text += " I answer with the fallback until the next month."
+	default:
+		text += " I do not answer questions until the next month."
+	}
+	m.logger.Warn("budget exhausted", slog.String("month", month), slog.Float64("spent", spent), slog.String("bot", m.config.UserDisplayName))
+	m.sendBudgetAlert(text, BudgetAlert{Month: month, Threshold: 100, Budget: m.config.Budget.Monthly, Spent: spent})
+}
+
+// checkBudget warns when the spending of this month passed one of the
+// thresholds of the budget. Only the highest threshold that was passed is
+// reported, once.
+func (m *Bot) checkBudget(now time.Time) {
+	budget := m.config.Budget
+	if budget.Monthly <= 0 {
+		return
+	}
+	spent, err := m.monthSpent(now)
+	if err != nil {
+		m.logger.Error("failed to get spending", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		return
+	}
+	month := now.In(m.location()).Format("2006-01")
+	var passed []int
+	for _, t := range budget.thresholds() {
+		if spent >= budget.Monthly*float64(t)/100 {
+			passed = append(passed, t)
+		}
+	}
+	if len(passed) == 0 {
+		return
+	}
+	highest := passed[len(passed)-1]
+	if !m.firstBudgetAlert(month, passed...) {
+		return
+	}
+
+	text := fmt.Sprintf("%d%% of the budget of $%.2f for %s is used, about $%.2f was spent.", highest, budget.Monthly, month, spent)
+	m.logger.Warn("budget threshold passed", slog.String("month", month), slog.Int("threshold", highest), slog.Float64("spent", spent), slog.String("bot", m.config.UserDisplayName))
+	m.sendBudgetAlert(text, BudgetAlert{Month: month, Threshold: highest, Budget: budget.Monthly, Spent: spent})
+}
+
+// firstBudgetAlert records that the alerts for the thresholds are sent, and
+// reports whether the last one was not sent before.
+func (m *Bot) firstBudgetAlert(month string, thresholds ...int) bool {
+	sent, err := m.store.BudgetAlertSent(month, thresholds[len(thresholds)-1])
+	if err != nil {
+		m.logger.Error("failed to check budget alert", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		return false
+	}
+	if sent {
+		return false
+	}
+	if err := m.store.SetBudgetAlertSent(month, thresholds...); err != nil {
+		m.logger.Error("failed to store budget alert", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		return false
+	}
+
+	return true
+}
+
+// sendBudgetAlert posts the text in the admin room, or else sends it to each
+// admin directly, and posts the alert to the budget webhooks.
+func (m *Bot) sendBudgetAlert(text string, alert BudgetAlert) {
+	alert.Bot = m.config.UserID
+	for _, wh := range m.config.Budget.Webhooks {
+		go func(wh ConfigBudgetWebhook) {
+			if err := postBudgetAlert(m.ctx, wh, alert); err != nil {
+				m.logger.Error("failed to post budget alert", slog.String("err", err.Error()), slog.String("url", wh.URL), slog.String("bot", m.config.UserDisplayName))
+			}
+		}(wh)
+	}
+	m.notifyAdmins(text)
+}
+
+func postBudgetAlert(ctx context.Context, wh ConfigBudgetWebhook, alert BudgetAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: forwardTimeout}
+
+	return postWebhook(ctx, client, wh.URL, wh.Secret, body)
+}
+
+// BudgetAlertSent tells whether the alert for the threshold, in percent of
+// the budget of the month, was sent.
+func (s *Store) BudgetAlertSent(month string, threshold int) (bool, error) {
+	var n int
+	if err := s.db.QueryRowContext(s.context(), `SELECT COUNT(*) FROM bot_budget_alert WHERE month = $1 AND threshold = $2`, month, threshold).Scan(&n); err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (s *Store) SetBudgetAlertSent(month string, thresholds ...int) error {
+	for _, t := range thresholds {
+		if _, err := s.db.ExecContext(s.context(), `INSERT INTO bot_budget_alert (month, threshold) VALUES ($1, $2) ON CONFLICT (month, threshold) DO NOTHING`, month, t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
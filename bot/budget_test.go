@@ -0,0 +1,202 @@
+package bot_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestBudget(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name          string
+		budget        bot.ConfigBudget
+		withFallback  bool
+		expAnswer     string
+		expModel      string
+		expRequests   int
+		expFallbacks  int
+		expAdminCount int
+	}{
+		{
+			name:        "no cap",
+			expAnswer:   "OK.",
+			expRequests: 3,
+		},
+		{
+			name:          "cut off",
+			budget:        bot.ConfigBudget{Monthly: 0.01},
+			expAnswer:     "Sorry, the budget for this month is used up.",
+			expRequests:   1,
+			expAdminCount: 2,
+		},
+		{
+			name:          "fallback model",
+			budget:        bot.ConfigBudget{Monthly: 0.01, Fallback: "local"},
+			expAnswer:     "OK.",
+			expModel:      "local",
+			expRequests:   3,
+			expAdminCount: 2,
+		},
+		{
+			name:          "fallback provider",
+			budget:        bot.ConfigBudget{Monthly: 0.01, Fallback: "llama3"},
+			withFallback:  true,
+			expAnswer:     "Local.",
+			expModel:      "llama3",
+			expRequests:   1,
+			expFallbacks:  2,
+			expAdminCount: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fm := bot.NewFakeMatrix()
+			fp := bot.NewFakeProvider()
+			local := bot.NewFakeProvider()
+			local.Default("Local.")
+			opts := []bot.Option{bot.WithMatrix(fm), bot.WithProvider(fp)}
+			if tc.withFallback {
+				opts = append(opts, bot.WithFallbackProvider(local))
+			}
+			b := newTestBot(t, bot.ConfigBot{
+				UserID:            "@bot:ewintr.nl",
+				AnswerUnaddressed: true,
+				AdminRoom:         "!admin:ewintr.nl",
+				Prices:            []bot.ConfigPrice{{Model: "gpt-4", Prompt: 10000, Completion: 10000}},
+				Budget:            tc.budget,
+			}, opts...)
+			_, h := b.ResponseHandler()
+
+			// the first answer uses up the budget
+			for i, body := range []string{"Hello", "Are you there?", "Hello?"} {
+				h(mautrix.EventSourceTimeline, testMessage(id.EventID(fmt.Sprintf("$q%d", i)), body, ""))
+			}
+
+			var answers []string
+			var adminCount int
+			for _, msg := range fm.Messages() {
+				body := msg.Content.(*event.MessageEventContent).Body
+				if msg.RoomID == "!admin:ewintr.nl" {
+					adminCount++
+					continue
+				}
+				answers = append(answers, body)
+			}
+			if len(answers) != 3 {
+				t.Fatalf("exp 3, got %v", len(answers))
+			}
+			if !strings.HasPrefix(answers[2], tc.expAnswer) {
+				t.Errorf("exp %v, got %v", tc.expAnswer, answers[2])
+			}
+			if adminCount != tc.expAdminCount {
+				t.Errorf("exp %v, got %v", tc.expAdminCount, adminCount)
+			}
+			reqs := fp.Requests()
+			if len(reqs) != tc.expRequests {
+				t.Errorf("exp %v, got %v", tc.expRequests, len(reqs))
+			}
+			if act := len(local.Requests()); act != tc.expFallbacks {
+				t.Errorf("exp %v, got %v", tc.expFallbacks, act)
+			}
+			if tc.expModel == "" {
+				return
+			}
+			last := reqs[len(reqs)-1]
+			if tc.withFallback {
+				last = local.Requests()[tc.expFallbacks-1]
+			}
+			if last.Model != tc.expModel {
+				t.Errorf("exp %v, got %v", tc.expModel, last.Model)
+			}
+		})
+	}
+}
+
+func TestBudgetAlerts(t *testing.T) {
+	t.Parallel()
+
+	alerts := make(chan bot.BudgetAlert, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if exp, act := "sha256="+bot.Sign("secret", body), r.Header.Get(bot.ForwardSignatureHeader); exp != act {
+			t.Errorf("exp %v, got %v", exp, act)
+		}
+		var alert bot.BudgetAlert
+		if err := json.Unmarshal(body, &alert); err != nil {
+			t.Errorf("exp nil, got %v", err)
+		}
+		alerts <- alert
+	}))
+	defer srv.Close()
+
+	fm := bot.NewFakeMatrix()
+	b := newTestBot(t, bot.ConfigBot{
+		UserID:            "@bot:ewintr.nl",
+		SystemPrompt:      "Help.",
+		AnswerUnaddressed: true,
+		AdminRoom:         "!admin:ewintr.nl",
+		// every answer is three words, that cost $0.30
+		Prices: []bot.ConfigPrice{{Model: "gpt-4", Prompt: 100000, Completion: 100000}},
+		Budget: bot.ConfigBudget{
+			Monthly:    1,
+			Thresholds: []int{80, 50},
+			Webhooks:   []bot.ConfigBudgetWebhook{{URL: srv.URL, Secret: "secret"}},
+		},
+	}, bot.WithMatrix(fm))
+	_, h := b.ResponseHandler()
+	for i := 0; i < 5; i++ {
+		h(mautrix.EventSourceTimeline, testMessage(id.EventID(fmt.Sprintf("$q%d", i)), "Hello", ""))
+	}
+
+	var notices []string
+	for _, msg := range fm.Messages() {
+		if msg.RoomID == "!admin:ewintr.nl" {
+			notices = append(notices, msg.Content.(*event.MessageEventContent).Body)
+		}
+	}
+	expNotices := []string{"50% of the budget", "80% of the budget", "The budget of $1.00"}
+	if len(notices) != len(expNotices) {
+		t.Fatalf("exp %v, got %v", expNotices, notices)
+	}
+	for i, exp := range expNotices {
+		if !strings.HasPrefix(notices[i], exp) {
+			t.Errorf("exp %v, got %v", exp, notices[i])
+		}
+	}
+
+	thresholds := map[int]bool{}
+	for i := 0; i < 3; i++ {
+		select {
+		case alert := <-alerts:
+			thresholds[alert.Threshold] = true
+			if alert.Bot != "@bot:ewintr.nl" {
+				t.Errorf("exp @bot:ewintr.nl, got %v", alert.Bot)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("exp alert, got none")
+		}
+	}
+	for _, exp := range []int{50, 80, 100} {
+		if !thresholds[exp] {
+			t.Errorf("exp alert for %v, got %v", exp, thresholds)
+		}
+	}
+
+	if _, err := initTestBot(t, bot.ConfigBot{
+		UserID: "@bot:ewintr.nl",
+		Budget: bot.ConfigBudget{Monthly: 1, Thresholds: []int{120}},
+	}); err == nil {
+		t.Errorf("exp error, got nil")
+	}
+}
@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// ConfigCache keeps answers for TTL, like "24h", and gives the same answer
+// to the same conversation with the same model without asking it again.
+// Conversations are the same when they only differ in case and whitespace.
+// Answers for which the model used tools, or with a prompt that shows the
+// time of day, are not kept, they depend on the moment. Without TTL nothing
+// is kept.
+type ConfigCache struct {
+	TTL time.Duration
+}
+
+// cacheKey identifies the conversation, or is empty when there is no
+// cache.
+func (m *Bot) cacheKey(model string, conv *Conversation, tools []Tool) string {
+	if m.config.Cache.TTL <= 0 {
+		return ""
+	}
+	h := sha256.New()
+	h.Write([]byte(modelOrDefault(model)))
+	for _, t := range tools {
+		h.Write([]byte("\x00tool\x00" + t.Name()))
+	}
+	for _, msg := range conv.History() {
+		h.Write([]byte("\x00" + msg.Role + "\x00" + strings.Join(strings.Fields(strings.ToLower(msg.Content)), " ")))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedAnswer returns the answer that was kept for the key, if it is not
+// too old.
+func (m *Bot) cachedAnswer(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	answer, ok, err := m.store.CachedAnswer(key, time.Now().Add(-m.config.Cache.TTL))
+	if err != nil {
+		m.logger.Error("failed to read cached answer", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		return "", false
+	}
+
+	return answer, ok
+}
+
+func (m *Bot) cacheAnswer(key, answer string) {
+	if key == "" {
+		return
+	}
+	if err := m.store.CacheAnswer(key, answer, time.Now()); err != nil {
+		m.logger.Error("failed to cache answer", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+	}
+}
+
+// CachedAnswer returns the answer kept for the key since the given time.
+func (s *Store) CachedAnswer(key string, since time.Time) (string, bool, error) {
+	var answer string
+	err := s.db.QueryRowContext(s.context(), `SELECT answer FROM bot_answer_cache WHERE key = $1 AND created_at >= $2`, key, since.Unix()).Scan(&answer)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return answer, true, nil
+}
+
+func (s *Store) CacheAnswer(key, answer string, now time.Time) error {
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_answer_cache (key, answer, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET answer = excluded.answer, created_at = excluded.created_at`, key, answer, now.Unix())
+
+	return err
+}
+
+// PruneAnswerCache removes the answers that were kept before the given time.
+func (s *Store) PruneAnswerCache(before time.Time) error {
+	_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_answer_cache WHERE created_at < $1`, before.Unix())
+	return err
+}
@@ -0,0 +1,107 @@
+package bot_test
+
+import (
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestAnswerCache(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name        string
+		cache       bot.ConfigCache
+		prompt      string
+		questions   []string
+		expRequests int
+	}{
+		{
+			name:        "no cache",
+			questions:   []string{"What are the opening hours?", "What are the opening hours?"},
+			expRequests: 2,
+		},
+		{
+			name:        "same question",
+			cache:       bot.ConfigCache{TTL: time.Hour},
+			questions:   []string{"What are the opening hours?", "what are  the Opening hours?"},
+			expRequests: 1,
+		},
+		{
+			name:        "other question",
+			cache:       bot.ConfigCache{TTL: time.Hour},
+			questions:   []string{"What are the opening hours?", "Where are you?"},
+			expRequests: 2,
+		},
+		{
+			name:        "prompt with time",
+			cache:       bot.ConfigCache{TTL: time.Hour},
+			prompt:      "It is {{.Time}}.",
+			questions:   []string{"What are the opening hours?", "What are the opening hours?"},
+			expRequests: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fm := bot.NewFakeMatrix()
+			fp := bot.NewFakeProvider()
+			fp.Script("From nine to five.", "In Amsterdam.")
+			b := newTestBot(t, bot.ConfigBot{
+				UserID:            "@bot:ewintr.nl",
+				AnswerUnaddressed: true,
+				Cache:             tc.cache,
+				SystemPrompt:      tc.prompt,
+			}, bot.WithMatrix(fm), bot.WithProvider(fp))
+			_, h := b.ResponseHandler()
+
+			h(mautrix.EventSourceTimeline, testMessage("$q1", tc.questions[0], ""))
+			h(mautrix.EventSourceTimeline, testMessage("$q2", tc.questions[1], ""))
+
+			if act := len(fp.Requests()); act != tc.expRequests {
+				t.Errorf("exp %v, got %v", tc.expRequests, act)
+			}
+			msgs := fm.Messages()
+			if len(msgs) != 2 {
+				t.Fatalf("exp 2, got %v", len(msgs))
+			}
+			first := msgs[0].Content.(*event.MessageEventContent).Body
+			second := msgs[1].Content.(*event.MessageEventContent).Body
+			if exp := tc.expRequests == 1; (first == second) != exp {
+				t.Errorf("exp same answer %v, got %q and %q", exp, first, second)
+			}
+		})
+	}
+}
+
+func TestStore_AnswerCache(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	now := time.Now()
+	if err := store.CacheAnswer("key", "answer", now); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	for _, tc := range []struct {
+		name  string
+		since time.Time
+		exp   bool
+	}{
+		{name: "fresh", since: now.Add(-time.Minute), exp: true},
+		{name: "expired", since: now.Add(time.Minute), exp: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			answer, ok, err := store.CachedAnswer("key", tc.since)
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			if ok != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, ok)
+			}
+			if ok && answer != "answer" {
+				t.Errorf("exp answer, got %v", answer)
+			}
+		})
+	}
+}
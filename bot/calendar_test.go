@@ -0,0 +1,105 @@
+package bot_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestParseICal(t *testing.T) {
+	t.Parallel()
+
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skip("no time zone data")
+	}
+	for _, tc := range []struct {
+		name   string
+		data   string
+		exp    []bot.CalendarEvent
+		expErr bool
+	}{
+		{
+			name: "events",
+			data: strings.Join([]string{
+				"BEGIN:VCALENDAR",
+				"VERSION:2.0",
+				"BEGIN:VEVENT",
+				"UID:2",
+				"SUMMARY:Planning\\, again",
+				"DTSTART;TZID=Europe/Amsterdam:20230607T140000",
+				"DTEND;TZID=Europe/Amsterdam:20230607T150000",
+				"LOCATION:Room",
+				"  1",
+				"END:VEVENT",
+				"BEGIN:VEVENT",
+				"UID:1",
+				"SUMMARY:Standup",
+				"DTSTART:20230607T070000Z",
+				"DTEND:20230607T071500Z",
+				"END:VEVENT",
+				"BEGIN:VEVENT",
+				"UID:3",
+				"SUMMARY:Holiday",
+				"DTSTART;VALUE=DATE:20230608",
+				"END:VEVENT",
+				"END:VCALENDAR",
+			}, "\r\n"),
+			exp: []bot.CalendarEvent{
+				{UID: "1", Summary: "Standup", Start: time.Date(2023, 6, 7, 7, 0, 0, 0, time.UTC), End: time.Date(2023, 6, 7, 7, 15, 0, 0, time.UTC)},
+				{UID: "2", Summary: "Planning, again", Location: "Room 1", Start: time.Date(2023, 6, 7, 14, 0, 0, 0, amsterdam), End: time.Date(2023, 6, 7, 15, 0, 0, 0, amsterdam)},
+				{UID: "3", Summary: "Holiday", Start: time.Date(2023, 6, 8, 0, 0, 0, 0, time.UTC), End: time.Date(2023, 6, 8, 0, 0, 0, 0, time.UTC), AllDay: true},
+			},
+		},
+		{
+			name:   "not a calendar",
+			data:   "<html></html>",
+			expErr: true,
+		},
+		{
+			name:   "bad time",
+			data:   "BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART:tomorrow\nEND:VEVENT\nEND:VCALENDAR",
+			expErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := bot.ParseICal([]byte(tc.data), time.UTC)
+			if (err != nil) != tc.expErr {
+				t.Fatalf("exp error %v, got %v", tc.expErr, err)
+			}
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestFormatCalendarEvent(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name  string
+		event bot.CalendarEvent
+		exp   string
+	}{
+		{
+			name:  "timed",
+			event: bot.CalendarEvent{Summary: "Standup", Start: time.Date(2023, 6, 7, 7, 0, 0, 0, time.UTC), End: time.Date(2023, 6, 7, 7, 15, 0, 0, time.UTC)},
+			exp:   "Wed 7 Jun 07:00-07:15: Standup",
+		},
+		{
+			name:  "all day",
+			event: bot.CalendarEvent{Summary: "Holiday", Location: "Beach", Start: time.Date(2023, 6, 8, 0, 0, 0, 0, time.UTC), AllDay: true},
+			exp:   "Thu 8 Jun, all day: Holiday (Beach)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if act := bot.FormatCalendarEvent(tc.event, time.UTC); act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
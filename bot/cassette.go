@@ -0,0 +1,159 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// ErrNotRecorded is returned when a replayed request is not on the cassette.
+var ErrNotRecorded = errors.New("no recorded interaction")
+
+// CassetteMode tells whether a Cassette records real traffic or replays it.
+type CassetteMode int
+
+const (
+	CassetteReplay CassetteMode = iota
+	CassetteRecord
+)
+
+// Interaction is a request and its response, as stored on a cassette.
+type Interaction struct {
+	Method       string `json:"method"`
+	Path         string `json:"path"`
+	RequestBody  string `json:"request_body"`
+	Status       int    `json:"status"`
+	ContentType  string `json:"content_type"`
+	ResponseBody string `json:"response_body"`
+}
+
+// Cassette is an http.RoundTripper that records the traffic to the provider
+// in a file, or replays it from there without network or API key. A
+// replayed request must have the same method, path, query and body as a
+// recorded one; each recording is used once, in order. Request headers are
+// not recorded, so the API key stays out of the file.
+//
+// Use it as the transport of the HTTP client in NewGPTWithConfig, and call
+// Save after recording.
+type Cassette struct {
+	path         string
+	mode         CassetteMode
+	base         http.RoundTripper
+	interactions []Interaction
+	used         []bool
+	mu           sync.Mutex
+}
+
+// NewCassette creates a cassette for the file. When replaying, the file must
+// exist. When recording, requests are sent with base, or with the default
+// transport if that is nil.
+func NewCassette(path string, mode CassetteMode, base http.RoundTripper) (*Cassette, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c := &Cassette{path: path, mode: mode, base: base}
+	if mode == CassetteRecord {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.interactions); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %w", path, err)
+	}
+	c.used = make([]bool, len(c.interactions))
+
+	return c, nil
+}
+
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	path := req.URL.Path
+	if req.URL.RawQuery != "" {
+		path += "?" + req.URL.RawQuery
+	}
+
+	if c.mode == CassetteRecord {
+		return c.record(req, path, body)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, in := range c.interactions {
+		if c.used[i] || in.Method != req.Method || in.Path != path || in.RequestBody != string(body) {
+			continue
+		}
+		c.used[i] = true
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Status, http.StatusText(in.Status)),
+			StatusCode:    in.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": []string{in.ContentType}},
+			Body:          io.NopCloser(bytes.NewReader([]byte(in.ResponseBody))),
+			ContentLength: int64(len(in.ResponseBody)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w for %s %s on cassette %s", ErrNotRecorded, req.Method, path, c.path)
+}
+
+func (c *Cassette) record(req *http.Request, path string, body []byte) (*http.Response, error) {
+	resp, err := c.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interactions = append(c.interactions, Interaction{
+		Method:       req.Method,
+		Path:         path,
+		RequestBody:  string(body),
+		Status:       resp.StatusCode,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ResponseBody: string(respBody),
+	})
+
+	return resp, nil
+}
+
+// Save writes what was recorded to the file. It does nothing when replaying.
+func (c *Cassette) Save() error {
+	if c.mode != CassetteRecord {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(c.path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,71 @@
+package bot_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestCassette(t *testing.T) {
+	t.Parallel()
+
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":"answer %d"}}]}`, calls)
+	}))
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	complete := func(c *bot.Cassette, baseURL, question string) (string, error) {
+		cfg := openai.DefaultConfig("key")
+		cfg.BaseURL = baseURL
+		cfg.HTTPClient = &http.Client{Transport: c}
+		return bot.NewGPTWithConfig(cfg).Complete("gpt-4", bot.NewConversation("", "prompt", question))
+	}
+
+	// record two answers from the server
+	rec, err := bot.NewCassette(path, bot.CassetteRecord, nil)
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	for _, q := range []string{"first", "second"} {
+		if _, err := complete(rec, srv.URL, q); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	srv.Close()
+
+	for _, tc := range []struct {
+		name     string
+		question string
+		exp      string
+		expErr   error
+	}{
+		{name: "second", question: "second", exp: "answer 2"},
+		{name: "first", question: "first", exp: "answer 1"},
+		{name: "not recorded", question: "third", expErr: bot.ErrNotRecorded},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			play, err := bot.NewCassette(path, bot.CassetteReplay, nil)
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			act, err := complete(play, "http://replay.invalid", tc.question)
+			if !errors.Is(err, tc.expErr) {
+				t.Errorf("exp %v, got %v", tc.expErr, err)
+			}
+			if act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
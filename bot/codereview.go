@@ -0,0 +1,83 @@
+package bot
+
+import (
+	"regexp"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// DefaultReviewPrompt is the system prompt of the code review persona when
+// the config has none.
+const DefaultReviewPrompt = "You are an experienced code reviewer. Review the code in the message for bugs, security problems and style, most important first. Refer to the lines you comment on, and give every suggested change as a fenced code block with the name of the language, so it is highlighted. If the code looks fine, say so. Keep it short."
+
+// reviewPersona is the name of the code review persona.
+const reviewPersona = "review"
+
+// ConfigCodeReview adds a persona that reviews the code in messages that
+// mention the bot, or that start with "review: ". It answers in a thread on
+// the message, where follow-up questions continue the review.
+type ConfigCodeReview struct {
+	Enabled bool
+	Model   string
+	Prompt  string
+}
+
+func (c ConfigCodeReview) persona() Persona {
+	prompt := c.Prompt
+	if prompt == "" {
+		prompt = DefaultReviewPrompt
+	}
+
+	return Persona{
+		Name:         reviewPersona,
+		DisplayName:  "Code review",
+		Description:  "Reviews the code blocks the bot is mentioned with",
+		SystemPrompt: prompt,
+		Model:        c.Model,
+		Trigger:      reviewPersona,
+		CodeBlocks:   true,
+		Thread:       true,
+	}
+}
+
+// codeFence is the start or end of a fenced code block in Markdown.
+var codeFence = regexp.MustCompile("(?m)^\\s*```")
+
+// hasCodeBlock tells whether the text has a fenced code block.
+func hasCodeBlock(text string) bool {
+	return len(codeFence.FindAllStringIndex(event.TrimReplyFallbackText(text), -1)) >= 2
+}
+
+// mentioned tells whether the message mentions the bot, in m.mentions or
+// with a pill or its user ID in the text.
+func (m *Bot) mentioned(content *event.MessageEventContent) bool {
+	userID := id.UserID(m.config.UserID)
+	if content.Mentions != nil {
+		for _, u := range content.Mentions.UserIDs {
+			if u == userID {
+				return true
+			}
+		}
+	}
+
+	return strings.Contains(content.Body, userID.String()) || strings.Contains(content.FormattedBody, userID.String())
+}
+
+// replyRelation makes the answer a reply to the event. When the event is in
+// a thread, or the persona answers in threads, the answer goes in the
+// thread.
+func replyRelation(evt *event.Event, thread bool) *event.RelatesTo {
+	root := evt.Content.AsMessage().RelatesTo.GetThreadParent()
+	if root == "" && thread {
+		root = evt.ID
+	}
+	rel := &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: evt.ID}}
+	if root != "" {
+		rel.Type = event.RelThread
+		rel.EventID = root
+	}
+
+	return rel
+}
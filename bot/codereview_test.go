@@ -0,0 +1,96 @@
+package bot_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestCodeReview(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	fp := bot.NewFakeProvider()
+	b := newTestBot(t, bot.ConfigBot{
+		UserID:          "@bot:ewintr.nl",
+		UserDisplayName: "bot",
+		SystemPrompt:    "You are the bot.",
+		CodeReview:      bot.ConfigCodeReview{Enabled: true, Model: "gpt-4o"},
+	}, bot.WithMatrix(fm), bot.WithProvider(fp))
+	_, h := b.ResponseHandler()
+
+	code := "```go\nfunc main() {\n\tpanic(nil)\n}\n```"
+	for _, tc := range []struct {
+		name      string
+		body      string
+		mention   bool
+		followUp  bool
+		otherUser bool
+		expAnswer bool
+		expPrompt string
+		expThread id.EventID
+	}{
+		{name: "not mentioned", body: "Look at this:\n" + code},
+		{name: "addressed", body: "bot: is this right?\n" + code, expAnswer: true, expPrompt: bot.DefaultReviewPrompt, expThread: "$addressed"},
+		{name: "follow-up", body: "Why is that a problem?", followUp: true, expAnswer: true, expPrompt: bot.DefaultReviewPrompt, expThread: "$addressed"},
+		// the last message in the thread is of someone else
+		{name: "follow-up after other", body: "How do I fix it?", followUp: true, otherUser: true, expAnswer: true, expPrompt: bot.DefaultReviewPrompt, expThread: "$addressed"},
+		{name: "mentioned", body: "Can Bot have a look?\n" + code, mention: true, expAnswer: true, expPrompt: bot.DefaultReviewPrompt, expThread: "$mentioned"},
+		{name: "no code", body: "bot: what is a panic?", expAnswer: true, expPrompt: "You are the bot."},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := len(fm.Messages())
+			evt := testMessage(id.EventID("$"+tc.name), tc.body, "")
+			content := evt.Content.AsMessage()
+			if tc.mention {
+				content.Mentions = &event.Mentions{UserIDs: []id.UserID{"@bot:ewintr.nl"}}
+			}
+			if tc.followUp {
+				msgs := fm.Messages()
+				last := msgs[len(msgs)-1].EventID
+				if tc.otherUser {
+					last = "$other"
+				}
+				content.RelatesTo = &event.RelatesTo{Type: event.RelThread, EventID: "$addressed", IsFallingBack: true, InReplyTo: &event.InReplyTo{EventID: last}}
+			}
+			h(mautrix.EventSourceTimeline, evt)
+
+			msgs := fm.Messages()
+			if !tc.expAnswer {
+				if len(msgs) != before {
+					t.Errorf("exp %v, got %v", before, len(msgs))
+				}
+				return
+			}
+			if len(msgs) != before+1 {
+				t.Fatalf("exp %v, got %v", before+1, len(msgs))
+			}
+			reqs := fp.Requests()
+			if act := reqs[len(reqs)-1].Messages[0].Content; act != tc.expPrompt {
+				t.Errorf("exp %q, got %q", tc.expPrompt, act)
+			}
+			raw, err := json.Marshal(msgs[len(msgs)-1].Content)
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			var reply event.MessageEventContent
+			if err := json.Unmarshal(raw, &reply); err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			if act := reply.RelatesTo.GetThreadParent(); act != tc.expThread {
+				t.Errorf("exp %v, got %v", tc.expThread, act)
+			}
+			if act := reply.RelatesTo.GetReplyTo(); act != evt.ID {
+				t.Errorf("exp %v, got %v", evt.ID, act)
+			}
+			if tc.expThread != "" && !strings.HasPrefix(reply.Body, "Code review: ") {
+				t.Errorf("exp Code review label, got %q", reply.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+)
+
+const commandPrefix = "!"
+
+// Command is a message starting with an exclamation mark, like "!help". The
+// output of Run is sent back to the room as a notice.
+type Command struct {
+	Name      string
+	Usage     string
+	Help      string
+	AdminOnly bool
+	// Writes marks a command that changes rooms, aliases or the directory on
+	// the homeserver. It does not run in a dry run.
+	Writes bool
+	Run    func(evt *event.Event, args []string) (string, error)
+}
+
+// RegisterCommand makes a command available in all rooms. Registering a
+// command with an existing name replaces it.
+func (m *Bot) RegisterCommand(cmd Command) {
+	m.commands[cmd.Name] = cmd
+}
+
+func (m *Bot) isAdmin(userID id.UserID) bool {
+	return contains(m.config.Admins, userID.String())
+}
+
+// notifyAdmins tells the admins something in the admin room, or, without
+// one, in a direct chat with each of them.
+func (m *Bot) notifyAdmins(text string) {
+	if m.config.AdminRoom != "" {
+		adminRoom, err := m.ResolveRoom(m.config.AdminRoom)
+		if err != nil {
+			m.logger.Error("failed to resolve admin room", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+			return
+		}
+		m.sendNotice(adminRoom, "", text)
+		return
+	}
+	for _, admin := range m.config.Admins {
+		if err := m.SendDM(id.UserID(admin), text); err != nil {
+			m.logger.Error("failed to notify admin", slog.String("err", err.Error()), slog.String("user_id", admin), slog.String("bot", m.config.UserDisplayName))
+		}
+	}
+}
+
+// CommandHandler runs registered commands. Messages that look like a command,
+// but do not match one, are passed on to the other handlers.
+func (m *Bot) CommandHandler() MessageHandler {
+	return NewMessageHandler("command", PriorityCommand, func(evt *event.Event) bool {
+		content := evt.Content.AsMessage()
+		body := content.Body
+		if content.RelatesTo.GetReplyTo() != "" {
+			body = event.TrimReplyFallbackText(body)
+		}
+		body = strings.TrimSpace(body)
+		if !strings.HasPrefix(body, commandPrefix) {
+			return false
+		}
+		fields := strings.Fields(strings.TrimPrefix(body, commandPrefix))
+		if len(fields) == 0 {
+			return false
+		}
+		cmd, ok := m.commands[strings.ToLower(fields[0])]
+		if !ok {
+			return false
+		}
+
+		if cmd.AdminOnly && !m.isAdmin(evt.Sender) {
+			m.logger.Info("command not allowed", slog.String("command", cmd.Name), slog.String("sender", evt.Sender.String()), slog.String("bot", m.config.UserDisplayName))
+			m.sendNotice(evt.RoomID, evt.ID, m.text(m.messageLanguage(evt), msgAdminOnly, cmd.Name))
+			return true
+		}
+
+		if cmd.Writes && m.config.DryRun {
+			m.logger.Info("dry run, not running command", slog.String("command", cmd.Name), slog.String("sender", evt.Sender.String()), slog.String("bot", m.config.UserDisplayName))
+			m.sendNotice(evt.RoomID, evt.ID, m.text(m.messageLanguage(evt), msgCommandError, fmt.Sprintf("!%s does not run in a dry run", cmd.Name)))
+			return true
+		}
+
+		out, err := cmd.Run(evt, fields[1:])
+		if err != nil {
+			m.logger.Error("command failed", slog.String("err", err.Error()), slog.String("command", cmd.Name), slog.String("bot", m.config.UserDisplayName))
+			out = m.text(m.messageLanguage(evt), msgCommandError, err.Error())
+		}
+		if out != "" {
+			m.sendNotice(evt.RoomID, evt.ID, out)
+		}
+
+		return true
+	})
+}
+
+func (m *Bot) helpCommand() Command {
+	return Command{
+		Name: "help",
+		Help: "show the available commands",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			names := make([]string, 0, len(m.commands))
+			for name := range m.commands {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			lang := m.messageLanguage(evt)
+			var lines []string
+			for _, name := range names {
+				cmd := m.commands[name]
+				usage := commandPrefix + cmd.Name
+				if cmd.Usage != "" {
+					usage += " " + cmd.Usage
+				}
+				help, ok := m.translation(lang, msgHelpPrefix+cmd.Name)
+				if !ok {
+					help = cmd.Help
+				}
+				lines = append(lines, fmt.Sprintf("- `%s`: %s", usage, help))
+			}
+
+			return strings.Join(lines, "\n"), nil
+		},
+	}
+}
+
+// sendNotice sends text, rendered as markdown, as a notice in reply to the
+// given event. It returns the ID of the notice, or nothing if sending failed.
+func (m *Bot) sendNotice(roomID id.RoomID, replyTo id.EventID, text string) id.EventID {
+	content := format.RenderMarkdown(text, true, false)
+	content.MsgType = event.MsgNotice
+	if replyTo != "" {
+		content.RelatesTo = &event.RelatesTo{
+			InReplyTo: &event.InReplyTo{
+				EventID: replyTo,
+			},
+		}
+	}
+	eventID, err := m.sendMessage(roomID, &content)
+	if err != nil {
+		m.logger.Error("failed to send notice", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+		return ""
+	}
+
+	return eventID
+}
@@ -1,11 +1,17 @@
 package bot
 
 import (
+	"sync"
+
 	"github.com/sashabaranov/go-openai"
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/id"
 )
 
+// DefaultSystemPrompt is the system prompt of bots that are not configured
+// with one.
+const DefaultSystemPrompt = "You are a chatbot that helps people by responding to their questions with short messages."
+
 type Character struct {
 	UserID    string
 	Password  string
@@ -21,8 +27,14 @@ type Message struct {
 	ParentID id.EventID
 }
 
+// Conversation is a thread of messages with the model. It can be used from
+// several goroutines at once, as long as Messages is only touched directly
+// before the conversation is shared; after that, use Add and History.
 type Conversation struct {
+	RoomID   id.RoomID
+	Persona  string
 	Messages []Message
+	mu       sync.Mutex
 }
 
 func NewConversation(id id.EventID, systemPrompt, question string) *Conversation {
@@ -41,7 +53,38 @@ func NewConversation(id id.EventID, systemPrompt, question string) *Conversation
 	}
 }
 
+// SetSystemPrompt makes the prompt the first message of the conversation,
+// replacing the system prompt it started with.
+func (c *Conversation) SetSystemPrompt(prompt string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg := Message{Role: openai.ChatMessageRoleSystem, Content: prompt}
+	if len(c.Messages) > 0 && c.Messages[0].Role == openai.ChatMessageRoleSystem && c.Messages[0].EventID == "" {
+		c.Messages[0] = msg
+		return
+	}
+	c.Messages = append([]Message{msg}, c.Messages...)
+}
+
+// InsertBefore adds messages right before the one of the event, for context
+// that only arrives after the conversation is shared.
+func (c *Conversation) InsertBefore(eventID id.EventID, msgs ...Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, m := range c.Messages {
+		if m.EventID == eventID {
+			c.Messages = append(c.Messages[:i], append(msgs, c.Messages[i:]...)...)
+			return
+		}
+	}
+}
+
 func (c *Conversation) Contains(EventID id.EventID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	for _, m := range c.Messages {
 		if m.EventID.String() == EventID.String() {
 			return true
@@ -51,10 +94,35 @@ func (c *Conversation) Contains(EventID id.EventID) bool {
 	return false
 }
 
+// Root returns the event that started the conversation.
+func (c *Conversation) Root() id.EventID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, m := range c.Messages {
+		if m.EventID != "" {
+			return m.EventID
+		}
+	}
+
+	return ""
+}
+
 func (c *Conversation) Add(msg Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.Messages = append(c.Messages, msg)
 }
 
+// History returns a copy of the messages.
+func (c *Conversation) History() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Message(nil), c.Messages...)
+}
+
 type Conversations []*Conversation
 
 func (cs Conversations) FindByEventID(EventID id.EventID) *Conversation {
@@ -1,15 +1,18 @@
 package bot_test
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 
 	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix/id"
 )
 
 func TestNewConversation(t *testing.T) {
 	t.Parallel()
 
-	conv := bot.NewConversation("test", "question")
+	conv := bot.NewConversation("test", "system", "question")
 	if conv == nil {
 		t.Error("NewConversation returned nil")
 	}
@@ -75,3 +78,105 @@ func TestConversation_Add(t *testing.T) {
 		t.Error("Add did not add message")
 	}
 }
+
+func TestConversationConcurrent(t *testing.T) {
+	t.Parallel()
+
+	workers := 10
+	conv := bot.NewConversation("$root", "system", "question")
+	// room for conv and the one every worker adds, so conv is never evicted
+	cache := bot.NewConversationCache(workers+1, nil)
+	if err := cache.Add(conv); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			eventID := id.EventID(fmt.Sprintf("$event%d", i))
+			conv.Add(bot.Message{EventID: eventID, Content: "answer"})
+			_ = conv.History()
+			if c, _ := cache.Find(eventID); c != conv {
+				t.Errorf("exp conversation, got %v", c)
+			}
+			_ = cache.Add(bot.NewConversation(id.EventID(fmt.Sprintf("$other%d", i)), "system", "question"))
+		}(i)
+	}
+	wg.Wait()
+
+	if exp, act := workers+2, len(conv.History()); act != exp {
+		t.Errorf("exp %v, got %v", exp, act)
+	}
+}
+
+func TestConversation_SetSystemPrompt(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		conv    *bot.Conversation
+		expLen  int
+		expNext string
+	}{
+		{
+			name:    "replace",
+			conv:    bot.NewConversation("$root", "old", "question"),
+			expLen:  2,
+			expNext: "question",
+		},
+		{
+			name:    "prepend",
+			conv:    &bot.Conversation{Messages: []bot.Message{{EventID: "$root", Role: "user", Content: "question"}}},
+			expLen:  2,
+			expNext: "question",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.conv.SetSystemPrompt("new")
+			if len(tc.conv.Messages) != tc.expLen {
+				t.Fatalf("exp %v, got %v", tc.expLen, len(tc.conv.Messages))
+			}
+			if act := tc.conv.Messages[0]; act.Role != "system" || act.Content != "new" {
+				t.Errorf("exp system new, got %v %v", act.Role, act.Content)
+			}
+			if act := tc.conv.Messages[1].Content; act != tc.expNext {
+				t.Errorf("exp %v, got %v", tc.expNext, act)
+			}
+		})
+	}
+}
+
+func TestConfig_ApplyDefaults(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		config bot.Config
+		exp    string
+	}{
+		{
+			name:   "built-in",
+			config: bot.Config{Bots: []bot.ConfigBot{{}}},
+			exp:    bot.DefaultSystemPrompt,
+		},
+		{
+			name:   "config",
+			config: bot.Config{SystemPrompt: "Be nice.", Bots: []bot.ConfigBot{{}}},
+			exp:    "Be nice.",
+		},
+		{
+			name:   "bot",
+			config: bot.Config{SystemPrompt: "Be nice.", Bots: []bot.ConfigBot{{SystemPrompt: "Be brief."}}},
+			exp:    "Be brief.",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.config.ApplyDefaults()
+			if act := tc.config.Bots[0].SystemPrompt; act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package bot
+
+import (
+	"container/list"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	defaultMaxConversations = 1000
+	conversationKeep        = 30 * 24 * time.Hour
+)
+
+// ConversationStore keeps the conversations that do not fit in memory.
+type ConversationStore interface {
+	SaveConversation(c *Conversation, now time.Time) error
+	ConversationByEvent(eventID id.EventID) (*Conversation, bool, error)
+	DeleteConversation(root id.EventID) error
+	DeleteRoomConversations(roomID id.RoomID) error
+}
+
+// ConversationCache holds the most recently used conversations in memory.
+// When there are more than fit, the least recently used one is moved to the
+// store, where it is found again when someone replies to it.
+type ConversationCache struct {
+	size  int
+	store ConversationStore
+	order *list.List
+	mu    sync.Mutex
+}
+
+func NewConversationCache(size int, store ConversationStore) *ConversationCache {
+	if size <= 0 {
+		size = defaultMaxConversations
+	}
+
+	return &ConversationCache{
+		size:  size,
+		store: store,
+		order: list.New(),
+	}
+}
+
+// Add puts a new conversation in front. It returns an error if moving the
+// oldest one to the store failed; that one is then lost.
+func (cc *ConversationCache) Add(c *Conversation) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.order.PushFront(c)
+
+	return cc.evict()
+}
+
+// Find returns the conversation that contains the event, from memory or
+// from the store, and marks it as used.
+func (cc *ConversationCache) Find(eventID id.EventID) (*Conversation, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	for e := cc.order.Front(); e != nil; e = e.Next() {
+		c := e.Value.(*Conversation)
+		if c.Contains(eventID) {
+			cc.order.MoveToFront(e)
+			return c, nil
+		}
+	}
+	if cc.store == nil {
+		return nil, nil
+	}
+	c, ok, err := cc.store.ConversationByEvent(eventID)
+	if err != nil || !ok {
+		return nil, err
+	}
+	cc.order.PushFront(c)
+
+	return c, cc.evict()
+}
+
+// All returns the conversations in memory, most recently used first.
+func (cc *ConversationCache) All() []*Conversation {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	all := make([]*Conversation, 0, cc.order.Len())
+	for e := cc.order.Front(); e != nil; e = e.Next() {
+		all = append(all, e.Value.(*Conversation))
+	}
+
+	return all
+}
+
+// Remove forgets the conversation that started with root, in memory and in
+// the store.
+func (cc *ConversationCache) Remove(root id.EventID) (bool, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	var found bool
+	for e := cc.order.Front(); e != nil; e = e.Next() {
+		if e.Value.(*Conversation).Root() == root {
+			cc.order.Remove(e)
+			found = true
+			break
+		}
+	}
+	if cc.store == nil {
+		return found, nil
+	}
+
+	return found, cc.store.DeleteConversation(root)
+}
+
+// RemoveRoom forgets all conversations in a room, in memory and in the store.
+func (cc *ConversationCache) RemoveRoom(roomID id.RoomID) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	for e := cc.order.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*Conversation).RoomID == roomID {
+			cc.order.Remove(e)
+		}
+		e = next
+	}
+	if cc.store == nil {
+		return nil
+	}
+
+	return cc.store.DeleteRoomConversations(roomID)
+}
+
+func (cc *ConversationCache) evict() error {
+	var errs []error
+	for cc.order.Len() > cc.size {
+		c := cc.order.Remove(cc.order.Back()).(*Conversation)
+		if cc.store == nil {
+			continue
+		}
+		if err := cc.store.SaveConversation(c, time.Now()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SaveConversation stores the conversation, replacing an older version.
+func (s *Store) SaveConversation(c *Conversation, now time.Time) error {
+	root := c.Root()
+	if root == "" {
+		return errors.New("conversation without events")
+	}
+	history := c.History()
+	messages, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(s.context(), `INSERT INTO bot_conversation (root_id, room_id, persona, messages, updated_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (root_id) DO UPDATE SET messages = excluded.messages, updated_at = excluded.updated_at`,
+		root, c.RoomID, c.Persona, string(messages), now.Unix()); err != nil {
+		return err
+	}
+	for _, msg := range history {
+		if msg.EventID == "" {
+			continue
+		}
+		if _, err := s.db.ExecContext(s.context(), `INSERT INTO bot_conversation_event (event_id, root_id) VALUES ($1, $2)
+			ON CONFLICT (event_id) DO NOTHING`, msg.EventID, root); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ConversationByEvent finds the stored conversation that contains the event.
+func (s *Store) ConversationByEvent(eventID id.EventID) (*Conversation, bool, error) {
+	var messages string
+	c := &Conversation{}
+	err := s.db.QueryRowContext(s.context(), `SELECT c.room_id, c.persona, c.messages FROM bot_conversation c
+		JOIN bot_conversation_event e ON e.root_id = c.root_id WHERE e.event_id = $1`, eventID).Scan(&c.RoomID, &c.Persona, &messages)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, false, nil
+	case err != nil:
+		return nil, false, err
+	}
+	if err := json.Unmarshal([]byte(messages), &c.Messages); err != nil {
+		return nil, false, err
+	}
+
+	return c, true, nil
+}
+
+func (s *Store) DeleteConversation(root id.EventID) error {
+	if _, err := s.db.ExecContext(s.context(), `DELETE FROM bot_conversation_event WHERE root_id = $1`, root); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_conversation WHERE root_id = $1`, root)
+	return err
+}
+
+func (s *Store) DeleteRoomConversations(roomID id.RoomID) error {
+	if _, err := s.db.ExecContext(s.context(), `DELETE FROM bot_conversation_event WHERE root_id IN
+		(SELECT root_id FROM bot_conversation WHERE room_id = $1)`, roomID); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_conversation WHERE room_id = $1`, roomID)
+	return err
+}
+
+// PruneConversations removes the stored conversations that were last used
+// before the given time.
+func (s *Store) PruneConversations(before time.Time) error {
+	if _, err := s.db.ExecContext(s.context(), `DELETE FROM bot_conversation_event WHERE root_id IN
+		(SELECT root_id FROM bot_conversation WHERE updated_at < $1)`, before.Unix()); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_conversation WHERE updated_at < $1`, before.Unix())
+	return err
+}
@@ -0,0 +1,76 @@
+package bot_test
+
+import (
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestConversationCache(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	cache := bot.NewConversationCache(2, store)
+	for _, root := range []id.EventID{"$one", "$two", "$three"} {
+		c := bot.NewConversation(root, "system", "question "+root.String())
+		c.RoomID = "!room:server"
+		c.Persona = "chat"
+		if err := cache.Add(c); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+	}
+	if act := roots(cache.All()); act != "$three $two" {
+		t.Errorf("exp $three $two, got %v", act)
+	}
+
+	// the oldest one was moved to the store
+	one, err := cache.Find("$one")
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if one == nil || one.Persona != "chat" || one.RoomID != "!room:server" || len(one.Messages) != 2 || one.Messages[1].Content != "question $one" {
+		t.Fatalf("exp conversation $one, got %v", one)
+	}
+	if act := roots(cache.All()); act != "$one $three" {
+		t.Errorf("exp $one $three, got %v", act)
+	}
+
+	// a reply to a message in a stored conversation finds it too
+	one.Add(bot.Message{EventID: "$reply", ParentID: "$one", Content: "answer"})
+	if _, err := cache.Find("$two"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if _, err := cache.Find("$three"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	reply, err := cache.Find("$reply")
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if reply == nil || reply.Root() != "$one" || len(reply.Messages) != 3 {
+		t.Errorf("exp conversation $one with reply, got %v", reply)
+	}
+
+	if found, err := cache.Remove("$one"); err != nil || !found {
+		t.Errorf("exp found, got %v %v", found, err)
+	}
+	if c, _ := cache.Find("$reply"); c != nil {
+		t.Errorf("exp nil, got %v", c)
+	}
+	if c, _ := cache.Find("$unknown"); c != nil {
+		t.Errorf("exp nil, got %v", c)
+	}
+}
+
+func roots(convs []*bot.Conversation) string {
+	var s string
+	for i, c := range convs {
+		if i > 0 {
+			s += " "
+		}
+		s += c.Root().String()
+	}
+
+	return s
+}
@@ -0,0 +1,105 @@
+package bot_test
+
+import (
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestCorrect(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	fp := bot.NewFakeProvider()
+	fp.On("Ik heb gisteren naar de winkel gelopen geweest", "Ik ben gisteren naar de winkel gelopen.")
+	fp.On("Ik ben thuis", "[ok]")
+	b := newTestBot(t, bot.ConfigBot{
+		UserID: "@bot:ewintr.nl",
+	}, bot.WithMatrix(fm), bot.WithProvider(fp))
+	_, h := b.ResponseHandler()
+
+	for _, tc := range []struct {
+		name      string
+		body      string
+		expBody   string
+		expThread bool
+	}{
+		{name: "not asked", body: "Ik heb gisteren naar de winkel gelopen geweest"},
+		{name: "ask", body: "!correct nl thread", expBody: "From now on I suggest corrections for your messages in Nederlands, in a thread on the message."},
+		{name: "corrected", body: "Ik heb gisteren naar de winkel gelopen geweest", expBody: "Ik ben gisteren naar de winkel gelopen.", expThread: true},
+		{name: "fine", body: "Ik ben thuis en het is mooi weer"},
+		{name: "other language", body: "What is the weather like in Amsterdam?"},
+		{name: "unknown place", body: "!correct nl email", expBody: `Error: unknown place "email", use dm or thread`},
+		{name: "off", body: "!correct off", expBody: "Your messages are no longer corrected."},
+		{name: "stopped", body: "Ik heb gisteren naar de winkel gelopen geweest"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := len(fm.Messages())
+			evt := testMessage(id.EventID("$"+tc.name), tc.body, "")
+			h(mautrix.EventSourceTimeline, evt)
+
+			msgs := fm.Messages()
+			if tc.expBody == "" {
+				if len(msgs) != before {
+					t.Errorf("exp %v, got %v", before, len(msgs))
+				}
+				return
+			}
+			if len(msgs) != before+1 {
+				t.Fatalf("exp %v, got %v", before+1, len(msgs))
+			}
+			content := msgs[len(msgs)-1].Content.(*event.MessageEventContent)
+			if act := content.Body; act != tc.expBody {
+				t.Errorf("exp %q, got %q", tc.expBody, act)
+			}
+			if act := content.RelatesTo.GetThreadParent() == evt.ID; act != tc.expThread {
+				t.Errorf("exp %v, got %v", tc.expThread, act)
+			}
+		})
+	}
+
+	reqs := fp.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("exp 2, got %v", len(reqs))
+	}
+	if reqs[0].Model != "gpt-3.5-turbo" {
+		t.Errorf("exp gpt-3.5-turbo, got %v", reqs[0].Model)
+	}
+}
+
+func TestCorrectDM(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	fm.SetUserID("@bot:ewintr.nl")
+	fp := bot.NewFakeProvider()
+	fp.On("Ik heb gisteren naar de winkel gelopen geweest", "Ik ben gisteren naar de winkel gelopen.")
+	b := newTestBot(t, bot.ConfigBot{
+		UserID: "@bot:ewintr.nl",
+	}, bot.WithMatrix(fm), bot.WithProvider(fp))
+	_, h := b.ResponseHandler()
+
+	h(mautrix.EventSourceTimeline, testMessage("$ask", "!correct nl", ""))
+	for i, eventID := range []id.EventID{"$first", "$second"} {
+		h(mautrix.EventSourceTimeline, testMessage(eventID, "Ik heb gisteren naar de winkel gelopen geweest", ""))
+		msgs := fm.Messages()
+		if len(msgs) != i+2 {
+			t.Fatalf("exp %v, got %v", i+2, len(msgs))
+		}
+		// the second correction goes to the direct chat that was created
+		// for the first
+		if act := msgs[len(msgs)-1].RoomID; act != "!dm1:fake" {
+			t.Errorf("exp !dm1:fake, got %v", act)
+		}
+	}
+	direct, err := fm.DirectChats()
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if act := direct["@someone:ewintr.nl"]; len(act) != 1 || act[0] != "!dm1:fake" {
+		t.Errorf("exp [!dm1:fake], got %v", act)
+	}
+}
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ConfigPrice is what a model costs, in dollars per million tokens of the
+// prompt and of the completion. A price also applies to the versions of a
+// model, so "gpt-4o" covers "gpt-4o-2024-05-13". Prices in the config come
+// before the built-in ones.
+type ConfigPrice struct {
+	Model      string
+	Prompt     float64
+	Completion float64
+}
+
+var defaultPrices = []ConfigPrice{
+	{Model: openai.GPT4, Prompt: 30, Completion: 60},
+	{Model: openai.GPT432K, Prompt: 60, Completion: 120},
+	{Model: openai.GPT4Turbo, Prompt: 10, Completion: 30},
+	{Model: openai.GPT4o, Prompt: 5, Completion: 15},
+	{Model: openai.GPT3Dot5Turbo, Prompt: 0.5, Completion: 1.5},
+}
+
+// price finds the price of the model, the one with the longest matching
+// name first.
+func (m *Bot) price(model string) (ConfigPrice, bool) {
+	for _, prices := range [][]ConfigPrice{m.config.Prices, defaultPrices} {
+		var best ConfigPrice
+		var found bool
+		for _, p := range prices {
+			if model != p.Model && !strings.HasPrefix(model, p.Model+"-") {
+				continue
+			}
+			if !found || len(p.Model) > len(best.Model) {
+				best, found = p, true
+			}
+		}
+		if found {
+			return best, true
+		}
+	}
+
+	return ConfigPrice{}, false
+}
+
+// estimate returns what the tokens of the model cost in dollars, or nothing
+// when the model has no price.
+func (m *Bot) estimate(model string, promptTokens, completionTokens int) float64 {
+	p, ok := m.price(model)
+	if !ok {
+		return 0
+	}
+
+	return (float64(promptTokens)*p.Prompt + float64(completionTokens)*p.Completion) / 1e6
+}
+
+// cost adds up the cost of the usage per model in dollars, as it was
+// estimated when the tokens were used. The models that have no price are
+// returned.
+func (m *Bot) cost(perModel []UsageTotal) (float64, []string) {
+	var dollars float64
+	var unknown []string
+	for _, ut := range perModel {
+		dollars += ut.Cost
+		if _, ok := m.price(ut.Key); !ok {
+			unknown = append(unknown, ut.Key)
+		}
+	}
+	sort.Strings(unknown)
+
+	return dollars, unknown
+}
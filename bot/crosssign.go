@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/crypto/ssss"
+	"maunium.net/go/mautrix/id"
+)
+
+// ssssKey returns the key of the secret storage of the account, unlocked with
+// the recovery key.
+func (m *Bot) ssssKey() (*ssss.Key, error) {
+	if m.config.RecoveryKey == "" {
+		return nil, fmt.Errorf("no recovery key configured")
+	}
+	_, keyData, err := m.cryptoHelper.Machine().SSSS.GetDefaultKeyData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret storage key: %w", err)
+	}
+	key, err := keyData.VerifyRecoveryKey(m.config.RecoveryKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recovery key: %w", err)
+	}
+
+	return key, nil
+}
+
+// bootstrapCrossSigning makes sure the device of the bot is signed with the
+// self-signing key of the account, so other users see it as trusted. With a
+// recovery key, the cross-signing keys are restored from secret storage on
+// the server. Without one, new keys are generated and uploaded, but only if
+// the account has none yet, as that would invalidate the existing ones.
+func (m *Bot) bootstrapCrossSigning() error {
+	mach := m.cryptoHelper.Machine()
+
+	switch {
+	case m.config.RecoveryKey != "":
+		key, err := m.ssssKey()
+		if err != nil {
+			return err
+		}
+		if err := mach.FetchCrossSigningKeysFromSSSS(key); err != nil {
+			return fmt.Errorf("failed to restore cross-signing keys: %w", err)
+		}
+		m.logger.Info("restored cross-signing keys", slog.String("bot", m.config.UserDisplayName))
+	default:
+		// only when the server says there are no keys, generating them after
+		// a failed query would replace the identity of the account
+		keys, err := mach.GetCrossSigningPublicKeys(id.UserID(m.config.UserID))
+		if err != nil {
+			return fmt.Errorf("failed to get cross-signing keys: %w", err)
+		}
+		if keys != nil {
+			m.logger.Warn("account already has cross-signing keys, set a recovery key to sign this device", slog.String("bot", m.config.UserDisplayName))
+			return nil
+		}
+		recoveryKey, err := mach.GenerateAndUploadCrossSigningKeys(m.config.UserPassword, "")
+		if err != nil {
+			return fmt.Errorf("failed to set up cross-signing: %w", err)
+		}
+		// the only time the key is available, the operator must store it
+		path, err := m.saveRecoveryKey(recoveryKey)
+		if err != nil {
+			m.logger.Error("failed to save recovery key, it is printed on stderr", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+			fmt.Fprintf(os.Stderr, "recovery key of %s: %s\n", m.config.UserID, recoveryKey)
+		} else {
+			m.logger.Warn("created cross-signing keys, store the recovery key to restore them on a new deployment", slog.String("file", path), slog.String("bot", m.config.UserDisplayName))
+		}
+	}
+
+	device := mach.OwnIdentity()
+	signed, err := mach.CryptoStore.IsKeySignedBy(device.UserID, device.SigningKey, device.UserID, mach.CrossSigningKeys.SelfSigningKey.PublicKey)
+	if err != nil {
+		return err
+	}
+	if signed {
+		return nil
+	}
+	if err := mach.SignOwnDevice(device); err != nil {
+		return fmt.Errorf("failed to sign device: %w", err)
+	}
+	if err := mach.SignOwnMasterKey(); err != nil {
+		return fmt.Errorf("failed to sign master key: %w", err)
+	}
+	m.logger.Info("signed device with cross-signing keys", slog.String("device_id", device.DeviceID.String()), slog.String("bot", m.config.UserDisplayName))
+
+	return nil
+}
+
+// saveRecoveryKey writes a new recovery key to a file that only the owner can
+// read, as it unlocks the secret storage of the account. The key never goes
+// to the log.
+func (m *Bot) saveRecoveryKey(key string) (string, error) {
+	path := fmt.Sprintf("%s.recovery-key", id.UserID(m.config.UserID).Localpart())
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fmt.Fprintln(f, key); err != nil {
+		f.Close()
+		return "", err
+	}
+
+	return path, f.Close()
+}
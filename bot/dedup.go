@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	processedKeep = 7 * 24 * time.Hour
+	pruneInterval = time.Hour
+)
+
+// firstTime records that the event is handled and reports whether that did
+// not happen before, so a message that is synced again, after a gap or a
+// restart, is not answered twice. If the store fails, the event is handled.
+func (m *Bot) firstTime(eventID id.EventID) bool {
+	first, err := m.store.MarkProcessed(eventID, time.Now())
+	if err != nil {
+		m.logger.Error("failed to mark event as processed", slog.String("err", err.Error()), slog.String("event_id", eventID.String()), slog.String("bot", m.config.UserDisplayName))
+		return true
+	}
+
+	return first
+}
+
+// runPrune forgets processed events that are too old to be synced again,
+// stored conversations that nobody replied to for a long time, and cached
+// answers that expired.
+func (m *Bot) runPrune() {
+	for {
+		now := time.Now()
+		if err := m.store.PruneProcessed(now.Add(-processedKeep)); err != nil {
+			m.logger.Error("failed to prune processed events", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		}
+		if err := m.store.PruneConversations(now.Add(-conversationKeep)); err != nil {
+			m.logger.Error("failed to prune conversations", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		}
+		if err := m.store.PruneAnswerCache(now.Add(-m.config.Cache.TTL)); err != nil {
+			m.logger.Error("failed to prune answer cache", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		}
+		if !m.wait(pruneInterval) {
+			return
+		}
+	}
+}
+
+// MarkProcessed records the event and reports whether it was new.
+func (s *Store) MarkProcessed(eventID id.EventID, now time.Time) (bool, error) {
+	res, err := s.db.ExecContext(s.context(), `INSERT INTO bot_processed_event (event_id, processed_at) VALUES ($1, $2)
+		ON CONFLICT (event_id) DO NOTHING`, eventID, now.Unix())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// PruneProcessed removes the events that were processed before the given
+// time.
+func (s *Store) PruneProcessed(before time.Time) error {
+	_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_processed_event WHERE processed_at < $1`, before.Unix())
+	return err
+}
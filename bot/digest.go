@@ -0,0 +1,156 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	digestPeriod   = 24 * time.Hour
+	digestPageSize = 100
+	digestMaxChars = 40000
+	digestPrompt   = "You write the daily digest of a chat room. Summarize the conversation below in a few short paragraphs or bullet points: the main topics, decisions, questions that are still open and anything people were asked to do. Mention who said what where it matters. Do not add anything that is not in the conversation."
+)
+
+// ConfigDigest posts a summary of the last 24 hours of Room at the times in
+// Cron, like "0 18 * * *" for every evening.
+type ConfigDigest struct {
+	Room string
+	Cron string
+}
+
+// TranscriptLine is one message in the history of a room.
+type TranscriptLine struct {
+	Time   time.Time
+	Sender id.UserID
+	Body   string
+}
+
+// FormatTranscript renders the lines, oldest first, as text for the model.
+// When the text would be longer than maxChars, the oldest lines are left out.
+func FormatTranscript(lines []TranscriptLine, maxChars int) string {
+	var (
+		out   []string
+		total int
+	)
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := fmt.Sprintf("[%s] %s: %s", lines[i].Time.Format("15:04"), lines[i].Sender, lines[i].Body)
+		if total+len(l)+1 > maxChars {
+			break
+		}
+		total += len(l) + 1
+		out = append(out, l)
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	return strings.Join(out, "\n")
+}
+
+func (m *Bot) loadDigests() error {
+	for _, dc := range m.config.Digests {
+		roomID := id.RoomID(dc.Room)
+		if err := m.scheduler.Add(0, dc.Cron, func() {
+			if err := m.postDigest(roomID, time.Now()); err != nil {
+				m.logger.Error("failed to post digest", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+			}
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Bot) postDigest(roomID id.RoomID, now time.Time) error {
+	lines, err := m.roomHistory(roomID, now.Add(-digestPeriod))
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	digest, err := m.summarize(roomID, digestPrompt, FormatTranscript(lines, digestMaxChars))
+	if err != nil {
+		return err
+	}
+
+	return m.SendMarkdown(roomID, fmt.Sprintf("**Digest of the last 24 hours**\n\n%s", digest))
+}
+
+// roomHistory pages back through the timeline of the room and returns the
+// text messages since the given time, oldest first. Messages of the bot
+// itself are skipped.
+func (m *Bot) roomHistory(roomID id.RoomID, since time.Time) ([]TranscriptLine, error) {
+	from := m.client.Store.LoadNextBatch(m.client.UserID)
+	if from == "" {
+		return nil, fmt.Errorf("no sync token yet")
+	}
+
+	var lines []TranscriptLine
+	for {
+		res, err := m.client.Messages(roomID, from, "", mautrix.DirectionBackward, nil, digestPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, evt := range res.Chunk {
+			evt.RoomID = roomID
+			if time.UnixMilli(evt.Timestamp).Before(since) {
+				return reverseLines(lines), nil
+			}
+			if evt.Sender == m.matrix.UserID() {
+				continue
+			}
+			if line, ok := m.transcriptLine(evt); ok {
+				lines = append(lines, line)
+			}
+		}
+		if res.End == "" || len(res.Chunk) == 0 {
+			return reverseLines(lines), nil
+		}
+		from = res.End
+	}
+}
+
+func (m *Bot) transcriptLine(evt *event.Event) (TranscriptLine, bool) {
+	if err := evt.Content.ParseRaw(evt.Type); err != nil {
+		return TranscriptLine{}, false
+	}
+	if evt.Type == event.EventEncrypted {
+		decrypted, err := m.cryptoHelper.Decrypt(evt)
+		if err != nil {
+			return TranscriptLine{}, false
+		}
+		evt = decrypted
+	}
+	if evt.Type != event.EventMessage {
+		return TranscriptLine{}, false
+	}
+	content := evt.Content.AsMessage()
+	switch content.MsgType {
+	case event.MsgText, event.MsgEmote, event.MsgNotice:
+	default:
+		return TranscriptLine{}, false
+	}
+
+	return TranscriptLine{
+		Time:   time.UnixMilli(evt.Timestamp).In(m.location()),
+		Sender: evt.Sender,
+		Body:   content.Body,
+	}, true
+}
+
+func reverseLines(lines []TranscriptLine) []TranscriptLine {
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+
+	return lines
+}
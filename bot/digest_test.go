@@ -0,0 +1,44 @@
+package bot_test
+
+import (
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestFormatTranscript(t *testing.T) {
+	t.Parallel()
+
+	lines := []bot.TranscriptLine{
+		{Time: time.Date(2023, 6, 7, 9, 0, 0, 0, time.UTC), Sender: "@a:example.com", Body: "morning"},
+		{Time: time.Date(2023, 6, 7, 9, 5, 0, 0, time.UTC), Sender: "@b:example.com", Body: "hi"},
+	}
+	for _, tc := range []struct {
+		name     string
+		maxChars int
+		exp      string
+	}{
+		{
+			name:     "all",
+			maxChars: 1000,
+			exp:      "[09:00] @a:example.com: morning\n[09:05] @b:example.com: hi",
+		},
+		{
+			name:     "latest only",
+			maxChars: 40,
+			exp:      "[09:05] @b:example.com: hi",
+		},
+		{
+			name:     "nothing fits",
+			maxChars: 5,
+			exp:      "",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if act := bot.FormatTranscript(lines, tc.maxChars); act != tc.exp {
+				t.Errorf("exp %q, got %q", tc.exp, act)
+			}
+		})
+	}
+}
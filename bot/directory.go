@@ -0,0 +1,156 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	visibilityPublic  = "public"
+	visibilityPrivate = "private"
+)
+
+type roomVisibility struct {
+	Visibility string `json:"visibility"`
+}
+
+// RoomVisibility tells whether the room is published in the room directory
+// of the homeserver.
+func (m *Bot) RoomVisibility(roomID id.RoomID) (string, error) {
+	var resp roomVisibility
+	_, err := m.client.MakeRequest("GET", m.client.BuildClientURL("v3", "directory", "list", "room", roomID), nil, &resp)
+
+	return resp.Visibility, err
+}
+
+// SetRoomVisibility publishes the room in the room directory, or removes it.
+// The bot needs to be allowed to do so by the homeserver, usually by having
+// enough power in the room.
+func (m *Bot) SetRoomVisibility(roomID id.RoomID, visibility string) error {
+	_, err := m.client.MakeRequest("PUT", m.client.BuildClientURL("v3", "directory", "list", "room", roomID), &roomVisibility{Visibility: visibility}, nil)
+
+	return err
+}
+
+// fullAlias turns a bare name into an alias on the homeserver of the bot.
+func (m *Bot) fullAlias(name string) id.RoomAlias {
+	if strings.HasPrefix(name, "#") {
+		return id.RoomAlias(name)
+	}
+
+	return id.NewRoomAlias(name, m.matrix.UserID().Homeserver())
+}
+
+// AddAlias creates an alias for the room. If the room has no canonical alias
+// yet, it becomes the canonical one.
+func (m *Bot) AddAlias(roomID id.RoomID, alias id.RoomAlias) error {
+	if _, err := m.client.CreateAlias(alias, roomID); err != nil {
+		return err
+	}
+	var canonical event.CanonicalAliasEventContent
+	err := m.matrix.StateEvent(roomID, event.StateCanonicalAlias, "", &canonical)
+	if err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return err
+	}
+	if canonical.Alias != "" {
+		return nil
+	}
+	canonical.Alias = alias
+	if _, err := m.client.SendStateEvent(roomID, event.StateCanonicalAlias, "", &canonical); err != nil {
+		return fmt.Errorf("alias created, but could not make it canonical: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Bot) directoryCommand() Command {
+	return Command{
+		Name:      "directory",
+		Usage:     "status|publish|unpublish",
+		Help:      "show or change whether this room is listed in the room directory",
+		AdminOnly: true,
+		Writes:    true,
+		Run: func(evt *event.Event, args []string) (string, error) {
+			if len(args) == 0 || args[0] == "status" {
+				visibility, err := m.RoomVisibility(evt.RoomID)
+				if err != nil {
+					return "", err
+				}
+				if visibility == visibilityPublic {
+					return "This room is listed in the room directory.", nil
+				}
+				return "This room is not listed in the room directory.", nil
+			}
+			switch {
+			case len(args) == 1 && args[0] == "publish":
+				if err := m.SetRoomVisibility(evt.RoomID, visibilityPublic); err != nil {
+					return "", err
+				}
+				m.logger.Info("published room", slog.String("room_id", evt.RoomID.String()), slog.String("sender", evt.Sender.String()), slog.String("bot", m.config.UserDisplayName))
+				return "This room is now listed in the room directory.", nil
+			case len(args) == 1 && args[0] == "unpublish":
+				if err := m.SetRoomVisibility(evt.RoomID, visibilityPrivate); err != nil {
+					return "", err
+				}
+				m.logger.Info("unpublished room", slog.String("room_id", evt.RoomID.String()), slog.String("sender", evt.Sender.String()), slog.String("bot", m.config.UserDisplayName))
+				return "This room is no longer listed in the room directory.", nil
+			default:
+				return "", fmt.Errorf("usage: !directory status|publish|unpublish")
+			}
+		},
+	}
+}
+
+func (m *Bot) aliasCommand() Command {
+	return Command{
+		Name:      "alias",
+		Usage:     "list|add <alias>|remove <alias>",
+		Help:      "show, create or remove the aliases of this room, like `!alias add #community:ewintr.nl`",
+		AdminOnly: true,
+		Writes:    true,
+		Run: func(evt *event.Event, args []string) (string, error) {
+			if len(args) == 0 || args[0] == "list" {
+				resp, err := m.client.GetAliases(evt.RoomID)
+				if err != nil {
+					return "", err
+				}
+				if len(resp.Aliases) == 0 {
+					return "This room has no aliases.", nil
+				}
+				lines := make([]string, 0, len(resp.Aliases))
+				for _, alias := range resp.Aliases {
+					lines = append(lines, fmt.Sprintf("- %s", alias))
+				}
+				return strings.Join(lines, "\n"), nil
+			}
+			if len(args) != 2 || (args[0] != "add" && args[0] != "remove") {
+				return "", fmt.Errorf("usage: !alias list|add <alias>|remove <alias>")
+			}
+
+			alias := m.fullAlias(args[1])
+			if args[0] == "add" {
+				if err := m.AddAlias(evt.RoomID, alias); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Added alias %s.", alias), nil
+			}
+			resp, err := m.client.ResolveAlias(alias)
+			if err != nil {
+				return "", err
+			}
+			if resp.RoomID != evt.RoomID {
+				return "", fmt.Errorf("%s is not an alias of this room", alias)
+			}
+			if _, err := m.client.DeleteAlias(alias); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Removed alias %s.", alias), nil
+		},
+	}
+}
@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// SendDM sends a message, rendered as markdown, in the direct chat with the
+// user. If there is none yet, an encrypted one is created.
+func (m *Bot) SendDM(userID id.UserID, message string) error {
+	roomID, err := m.directRoom(userID)
+	if err != nil {
+		return err
+	}
+
+	return m.SendMarkdown(roomID, message)
+}
+
+// directRoom finds a direct chat with the user in the m.direct account data
+// that both are still in, or creates a new one and records it there.
+func (m *Bot) directRoom(userID id.UserID) (id.RoomID, error) {
+	m.dmMu.Lock()
+	defer m.dmMu.Unlock()
+
+	direct, err := m.matrix.DirectChats()
+	if err != nil {
+		return "", err
+	}
+	for _, roomID := range direct[userID] {
+		if m.matrix.IsMember(roomID, m.matrix.UserID(), event.MembershipJoin) &&
+			m.matrix.IsMember(roomID, userID, event.MembershipJoin, event.MembershipInvite) {
+			return roomID, nil
+		}
+	}
+
+	roomID, err := m.matrix.CreateDirectRoom(userID)
+	if err != nil {
+		return "", err
+	}
+	direct[userID] = append(direct[userID], roomID)
+	if err := m.matrix.SetDirectChats(direct); err != nil {
+		m.logger.Error("failed to record direct chat", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+	m.logger.Info("created direct chat", slog.String("room_id", roomID.String()), slog.String("user_id", userID.String()), slog.String("bot", m.config.UserDisplayName))
+
+	return roomID, nil
+}
+
+func (m *Bot) dmCommand() Command {
+	return Command{
+		Name:      "dm",
+		Usage:     "<user id> <message>",
+		Help:      "send a message to a user in a direct chat, like `!dm @someone:ewintr.nl The backup failed`",
+		AdminOnly: true,
+		Run: func(evt *event.Event, args []string) (string, error) {
+			if len(args) < 2 || !strings.HasPrefix(args[0], "@") {
+				return "", fmt.Errorf("usage: !dm <user id> <message>")
+			}
+			userID := id.UserID(args[0])
+			if err := m.SendDM(userID, strings.Join(args[1:], " ")); err != nil {
+				return "", err
+			}
+
+			return fmt.Sprintf("Sent a direct message to %s.", userID), nil
+		},
+	}
+}
@@ -0,0 +1,25 @@
+// Package bot is a Matrix bot that answers with a language model, and the
+// library to embed it in other programs.
+//
+// A bot is created with New, connected with Init and started with Run, which
+// syncs until Shutdown or Close is called:
+//
+//	b := bot.New(cfg, bot.WithOpenAIKey(apiKey), bot.WithLogger(logger))
+//	if err := b.Init(false); err != nil {
+//		return err
+//	}
+//	b.RegisterCommand(bot.Command{Name: "ping", Run: ping})
+//	go b.Run()
+//	defer b.Close()
+//
+// Dependencies are injected with options, like WithProvider, WithStore and
+// WithHTTPClient. Between Init and Run, the bot can be extended with
+// RegisterCommand, RegisterTool, RegisterPersona and AddMessageHandler. The
+// parts that can be swapped are interfaces: Provider for the model, Matrix
+// for what the bot does in rooms, Tool for what the model can call,
+// MessageHandler for handling messages, and Verifier for device
+// verification.
+//
+// WithMatrix gives a bot without a homeserver, for testing handlers with a
+// FakeMatrix and a FakeProvider.
+package bot
@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"errors"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const unencryptedNotice = "I only talk in encrypted rooms. Enable encryption in the room settings to talk to me."
+
+var ErrUnencryptedRoom = errors.New("room is not encrypted")
+
+// refuseUnencrypted explains, once per room, why the bot does not answer.
+func (m *Bot) refuseUnencrypted(evt *event.Event) {
+	sent, err := m.store.UnencryptedNoticeSent(evt.RoomID)
+	if err != nil {
+		m.logger.Error("failed to check unencrypted notice", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+		return
+	}
+	if sent {
+		return
+	}
+	m.sendNotice(evt.RoomID, "", unencryptedNotice)
+	if err := m.store.SetUnencryptedNoticeSent(evt.RoomID); err != nil {
+		m.logger.Error("failed to store unencrypted notice", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+}
+
+// allowedRoom reports whether the bot may send content to the room.
+func (m *Bot) allowedRoom(roomID id.RoomID) bool {
+	return !m.config.EncryptedOnly || m.matrix.IsEncrypted(roomID)
+}
+
+func (s *Store) UnencryptedNoticeSent(roomID id.RoomID) (bool, error) {
+	var n int
+	if err := s.db.QueryRowContext(s.context(), `SELECT COUNT(*) FROM bot_unencrypted_notice WHERE room_id = $1`, roomID).Scan(&n); err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (s *Store) SetUnencryptedNoticeSent(roomID id.RoomID) error {
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_unencrypted_notice (room_id) VALUES ($1) ON CONFLICT (room_id) DO NOTHING`, roomID)
+	return err
+}
@@ -0,0 +1,63 @@
+package bot_test
+
+import (
+	"fmt"
+	"io"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/util/dbutil"
+)
+
+// Example embeds a bot with a command of its own, and lets it answer in a
+// room that only exists in memory.
+func Example() {
+	db, err := dbutil.NewWithDialect(":memory:", "sqlite3")
+	if err != nil {
+		panic(err)
+	}
+	db.RawDB.SetMaxOpenConns(1)
+	store, err := bot.NewStore(db)
+	if err != nil {
+		panic(err)
+	}
+	fm := bot.NewFakeMatrix()
+	b := bot.New(bot.ConfigBot{
+		UserID:            "@bot:example.org",
+		AnswerUnaddressed: true,
+	}, bot.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))), bot.WithStore(store), bot.WithMatrix(fm))
+	if err := b.Init(false); err != nil {
+		panic(err)
+	}
+
+	b.RegisterCommand(bot.Command{
+		Name: "ping",
+		Help: "check that the bot is there",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			return "pong", nil
+		},
+	})
+	fp := bot.NewFakeProvider()
+	fp.On(`(?i)hello`, "Hi there!")
+	b.SetProvider(fp)
+
+	_, h := b.ResponseHandler()
+	for i, body := range []string{"!ping", "Hello bot"} {
+		h(mautrix.EventSourceTimeline, &event.Event{
+			ID:      id.EventID(fmt.Sprintf("$event%d", i)),
+			RoomID:  "!room:example.org",
+			Sender:  "@someone:example.org",
+			Type:    event.EventMessage,
+			Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: body}},
+		})
+	}
+	for _, msg := range fm.Messages() {
+		fmt.Println(msg.Content.(*event.MessageEventContent).Body)
+	}
+	// Output:
+	// pong
+	// Hi there!
+}
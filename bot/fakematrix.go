@@ -0,0 +1,413 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// FakeMessage is a message or other event sent through a FakeMatrix.
+type FakeMessage struct {
+	RoomID  id.RoomID
+	EventID id.EventID
+	TxnID   string
+	Type    event.Type
+	Content any
+}
+
+// FakeReaction is a reaction sent through a FakeMatrix.
+type FakeReaction struct {
+	RoomID  id.RoomID
+	EventID id.EventID
+	Key     string
+}
+
+type fakeStateKey struct {
+	roomID    id.RoomID
+	eventType event.Type
+	stateKey  string
+}
+
+// FakeMatrix is an in-memory Matrix for tests. It records what the bot does
+// in rooms. Messages sent again with the same transaction ID are recorded
+// once, like a homeserver would. What the bot reads about rooms is set up
+// with SetState, SetMembership and AddEvent.
+type FakeMatrix struct {
+	userID     id.UserID
+	messages   []FakeMessage
+	reactions  []FakeReaction
+	joined     []id.RoomID
+	left       []id.RoomID
+	typing     map[id.RoomID]bool
+	media      map[id.ContentURI][]byte
+	state      map[fakeStateKey][]byte
+	members    map[id.RoomID]map[id.UserID]event.Membership
+	events     map[id.EventID]*event.Event
+	direct     event.DirectChatsEventContent
+	createdDMs int
+	err        error
+	mu         sync.Mutex
+}
+
+func NewFakeMatrix() *FakeMatrix {
+	return &FakeMatrix{
+		typing:  make(map[id.RoomID]bool),
+		media:   make(map[id.ContentURI][]byte),
+		state:   make(map[fakeStateKey][]byte),
+		members: make(map[id.RoomID]map[id.UserID]event.Membership),
+		events:  make(map[id.EventID]*event.Event),
+		direct:  make(event.DirectChatsEventContent),
+	}
+}
+
+// SetUserID sets the user of the bot.
+func (fm *FakeMatrix) SetUserID(userID id.UserID) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	fm.userID = userID
+}
+
+// SetState sets the content of a state event in the room.
+func (fm *FakeMatrix) SetState(roomID id.RoomID, eventType event.Type, stateKey string, content any) error {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	fm.state[fakeStateKey{roomID: roomID, eventType: eventType, stateKey: stateKey}] = data
+
+	return nil
+}
+
+// SetMembership sets the membership of the user in the room.
+func (fm *FakeMatrix) SetMembership(roomID id.RoomID, userID id.UserID, membership event.Membership) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	fm.setMembership(roomID, userID, membership)
+}
+
+func (fm *FakeMatrix) setMembership(roomID id.RoomID, userID id.UserID, membership event.Membership) {
+	if fm.members[roomID] == nil {
+		fm.members[roomID] = make(map[id.UserID]event.Membership)
+	}
+	fm.members[roomID][userID] = membership
+}
+
+// AddEvent makes an event available for GetEvent.
+func (fm *FakeMatrix) AddEvent(evt *event.Event) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	fm.events[evt.ID] = evt
+}
+
+// FailWith makes all following calls return err, until it is called with nil.
+func (fm *FakeMatrix) FailWith(err error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	fm.err = err
+}
+
+// AddMedia makes data available for Download.
+func (fm *FakeMatrix) AddMedia(uri id.ContentURI, data []byte) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	fm.media[uri] = data
+}
+
+func (fm *FakeMatrix) SendMessage(roomID id.RoomID, content any, txnID string) (id.EventID, error) {
+	return fm.SendEvent(roomID, event.EventMessage, content, txnID)
+}
+
+func (fm *FakeMatrix) SendEvent(roomID id.RoomID, eventType event.Type, content any, txnID string) (id.EventID, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.err != nil {
+		return "", fm.err
+	}
+	for _, msg := range fm.messages {
+		if txnID != "" && msg.TxnID == txnID {
+			return msg.EventID, nil
+		}
+	}
+	eventID := fm.nextEventID()
+	fm.messages = append(fm.messages, FakeMessage{RoomID: roomID, EventID: eventID, TxnID: txnID, Type: eventType, Content: content})
+
+	return eventID, nil
+}
+
+func (fm *FakeMatrix) JoinRoom(roomIDOrAlias string) (id.RoomID, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.err != nil {
+		return "", fm.err
+	}
+	roomID := id.RoomID(roomIDOrAlias)
+	fm.joined = append(fm.joined, roomID)
+
+	return roomID, nil
+}
+
+func (fm *FakeMatrix) LeaveRoom(roomID id.RoomID) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.err != nil {
+		return fm.err
+	}
+	fm.left = append(fm.left, roomID)
+
+	return nil
+}
+
+func (fm *FakeMatrix) ForgetRoom(roomID id.RoomID) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	return fm.err
+}
+
+func (fm *FakeMatrix) React(roomID id.RoomID, eventID id.EventID, key string) (id.EventID, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.err != nil {
+		return "", fm.err
+	}
+	fm.reactions = append(fm.reactions, FakeReaction{RoomID: roomID, EventID: eventID, Key: key})
+
+	return fm.nextEventID(), nil
+}
+
+func (fm *FakeMatrix) Typing(roomID id.RoomID, typing bool, timeout time.Duration) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.err != nil {
+		return fm.err
+	}
+	fm.typing[roomID] = typing
+
+	return nil
+}
+
+func (fm *FakeMatrix) Download(uri id.ContentURI) ([]byte, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.err != nil {
+		return nil, fm.err
+	}
+	data, ok := fm.media[uri]
+	if !ok {
+		return nil, fmt.Errorf("no media %s", uri)
+	}
+
+	return data, nil
+}
+
+func (fm *FakeMatrix) UserID() id.UserID {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	return fm.userID
+}
+
+func (fm *FakeMatrix) IsEncrypted(roomID id.RoomID) bool {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	_, ok := fm.state[fakeStateKey{roomID: roomID, eventType: event.StateEncryption}]
+
+	return ok
+}
+
+// GetEvent returns an event added with AddEvent, or one that was sent.
+func (fm *FakeMatrix) GetEvent(roomID id.RoomID, eventID id.EventID) (*event.Event, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.err != nil {
+		return nil, fm.err
+	}
+	if evt, ok := fm.events[eventID]; ok && evt.RoomID == roomID {
+		return evt, nil
+	}
+	for _, msg := range fm.messages {
+		if msg.EventID == eventID && msg.RoomID == roomID {
+			return &event.Event{ID: eventID, RoomID: roomID, Sender: fm.userID, Type: msg.Type, Content: event.Content{Parsed: msg.Content}}, nil
+		}
+	}
+
+	return nil, mautrix.MNotFound
+}
+
+func (fm *FakeMatrix) StateEvent(roomID id.RoomID, eventType event.Type, stateKey string, content any) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.err != nil {
+		return fm.err
+	}
+	data, ok := fm.state[fakeStateKey{roomID: roomID, eventType: eventType, stateKey: stateKey}]
+	if !ok {
+		return mautrix.MNotFound
+	}
+
+	return json.Unmarshal(data, content)
+}
+
+func (fm *FakeMatrix) JoinedMembers(roomID id.RoomID) ([]id.UserID, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.err != nil {
+		return nil, fm.err
+	}
+	var members []id.UserID
+	for userID, membership := range fm.members[roomID] {
+		if membership == event.MembershipJoin {
+			members = append(members, userID)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i] < members[j] })
+
+	return members, nil
+}
+
+// Member returns the member event set with SetState.
+func (fm *FakeMatrix) Member(roomID id.RoomID, userID id.UserID) (*event.MemberEventContent, bool) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	data, ok := fm.state[fakeStateKey{roomID: roomID, eventType: event.StateMember, stateKey: userID.String()}]
+	if !ok {
+		return nil, false
+	}
+	var member event.MemberEventContent
+	if err := json.Unmarshal(data, &member); err != nil {
+		return nil, false
+	}
+
+	return &member, true
+}
+
+func (fm *FakeMatrix) DirectChats() (event.DirectChatsEventContent, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.err != nil {
+		return nil, fm.err
+	}
+	direct := make(event.DirectChatsEventContent, len(fm.direct))
+	for userID, rooms := range fm.direct {
+		direct[userID] = append([]id.RoomID(nil), rooms...)
+	}
+
+	return direct, nil
+}
+
+func (fm *FakeMatrix) SetDirectChats(direct event.DirectChatsEventContent) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.err != nil {
+		return fm.err
+	}
+	fm.direct = direct
+
+	return nil
+}
+
+func (fm *FakeMatrix) IsMember(roomID id.RoomID, userID id.UserID, memberships ...event.Membership) bool {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	current, ok := fm.members[roomID][userID]
+	if !ok {
+		return false
+	}
+	for _, membership := range memberships {
+		if current == membership {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreateDirectRoom creates a room !dm<n>:fake with the bot joined and the
+// user invited.
+func (fm *FakeMatrix) CreateDirectRoom(userID id.UserID) (id.RoomID, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	if fm.err != nil {
+		return "", fm.err
+	}
+	fm.createdDMs++
+	roomID := id.RoomID(fmt.Sprintf("!dm%d:fake", fm.createdDMs))
+	fm.setMembership(roomID, fm.userID, event.MembershipJoin)
+	fm.setMembership(roomID, userID, event.MembershipInvite)
+	fm.state[fakeStateKey{roomID: roomID, eventType: event.StateEncryption}] = []byte(`{"algorithm":"m.megolm.v1.aes-sha2"}`)
+
+	return roomID, nil
+}
+
+// Messages returns the messages that were sent, oldest first.
+func (fm *FakeMatrix) Messages() []FakeMessage {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	return append([]FakeMessage(nil), fm.messages...)
+}
+
+// Reactions returns the reactions that were sent, oldest first.
+func (fm *FakeMatrix) Reactions() []FakeReaction {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	return append([]FakeReaction(nil), fm.reactions...)
+}
+
+// Joined returns the rooms that were joined.
+func (fm *FakeMatrix) Joined() []id.RoomID {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	return append([]id.RoomID(nil), fm.joined...)
+}
+
+// Left returns the rooms that were left.
+func (fm *FakeMatrix) Left() []id.RoomID {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	return append([]id.RoomID(nil), fm.left...)
+}
+
+// IsTyping tells whether the bot shows that it is typing in the room.
+func (fm *FakeMatrix) IsTyping(roomID id.RoomID) bool {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	return fm.typing[roomID]
+}
+
+func (fm *FakeMatrix) nextEventID() id.EventID {
+	return id.EventID(fmt.Sprintf("$fake%d", len(fm.messages)+len(fm.reactions)+1))
+}
@@ -0,0 +1,146 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// FakeRequest is a request made to a FakeProvider.
+type FakeRequest struct {
+	Model    string
+	Messages []Message
+	Params   CompletionParams
+	// Tools are the names of the tools that were offered
+	Tools []string
+}
+
+type fakeRule struct {
+	pattern *regexp.Regexp
+	answer  string
+}
+
+// FakeProvider is a Provider for tests that gives canned answers. Scripted
+// answers are given first, in order. After that, the answer of the first
+// rule whose pattern matches the last message is given, or the default
+// answer. Tools are never called. Usage counts words instead of tokens.
+type FakeProvider struct {
+	script   []string
+	rules    []fakeRule
+	fallback string
+	err      error
+	requests []FakeRequest
+	usage    openai.Usage
+	mu       sync.Mutex
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{fallback: "OK."}
+}
+
+// Script adds answers that are given in order, one per request, before the
+// rules apply.
+func (fp *FakeProvider) Script(answers ...string) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	fp.script = append(fp.script, answers...)
+}
+
+// On gives the answer when the last message matches the regular expression.
+func (fp *FakeProvider) On(pattern, answer string) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	fp.rules = append(fp.rules, fakeRule{pattern: regexp.MustCompile(pattern), answer: answer})
+}
+
+// Default sets the answer for when nothing else applies.
+func (fp *FakeProvider) Default(answer string) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	fp.fallback = answer
+}
+
+// FailWith makes all following requests return err, until it is called with
+// nil.
+func (fp *FakeProvider) FailWith(err error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	fp.err = err
+}
+
+func (fp *FakeProvider) CompleteContext(ctx context.Context, model string, conv *Conversation, tools ...Tool) (string, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	history := conv.History()
+	var names []string
+	for _, t := range tools {
+		names = append(names, t.Name())
+	}
+	fp.requests = append(fp.requests, FakeRequest{Model: model, Messages: history, Params: completionParams(ctx), Tools: names})
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if fp.err != nil {
+		return "", fp.err
+	}
+	if len(history) == 0 {
+		return "", fmt.Errorf("empty conversation")
+	}
+
+	answer := fp.fallback
+	last := history[len(history)-1].Content
+	switch {
+	case len(fp.script) > 0:
+		answer, fp.script = fp.script[0], fp.script[1:]
+	default:
+		for _, r := range fp.rules {
+			if r.pattern.MatchString(last) {
+				answer = r.answer
+				break
+			}
+		}
+	}
+	var u openai.Usage
+	for _, m := range history {
+		u.PromptTokens += len(strings.Fields(m.Content))
+	}
+	u.CompletionTokens = len(strings.Fields(answer))
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	fp.usage.PromptTokens += u.PromptTokens
+	fp.usage.CompletionTokens += u.CompletionTokens
+	fp.usage.TotalTokens += u.TotalTokens
+	recordUsage(ctx, u)
+
+	return answer, nil
+}
+
+func (fp *FakeProvider) Usage() openai.Usage {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	return fp.usage
+}
+
+func (fp *FakeProvider) Available() bool {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	return fp.err == nil
+}
+
+// Requests returns the requests that were made, oldest first.
+func (fp *FakeProvider) Requests() []FakeRequest {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	return append([]FakeRequest(nil), fp.requests...)
+}
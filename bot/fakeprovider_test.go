@@ -0,0 +1,119 @@
+package bot_test
+
+import (
+	"fmt"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestFakeProvider_Conversation(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name        string
+		setup       func(fp *bot.FakeProvider)
+		expAnswers  []string
+		expMessages int
+	}{
+		{
+			name:        "default",
+			setup:       func(fp *bot.FakeProvider) {},
+			expAnswers:  []string{"OK.", "OK."},
+			expMessages: 4,
+		},
+		{
+			name: "pattern",
+			setup: func(fp *bot.FakeProvider) {
+				fp.On(`(?i)weather`, "Sunny.")
+				fp.On(`(?i)tomorrow`, "Rain.")
+			},
+			expAnswers:  []string{"Sunny.", "Rain."},
+			expMessages: 4,
+		},
+		{
+			name: "script before patterns",
+			setup: func(fp *bot.FakeProvider) {
+				fp.On(`(?i)weather`, "Sunny.")
+				fp.Script("Let me check.")
+			},
+			expAnswers:  []string{"Let me check.", "OK."},
+			expMessages: 4,
+		},
+		{
+			name: "failure",
+			setup: func(fp *bot.FakeProvider) {
+				fp.FailWith(fmt.Errorf("down"))
+			},
+			expAnswers: []string{"Sorry, I could not get an answer. Please try again later."},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fm := bot.NewFakeMatrix()
+			fp := bot.NewFakeProvider()
+			tc.setup(fp)
+			b := newTestBot(t, bot.ConfigBot{
+				UserID:            "@bot:ewintr.nl",
+				AnswerUnaddressed: true,
+			}, bot.WithMatrix(fm), bot.WithProvider(fp))
+			_, h := b.ResponseHandler()
+
+			// a question, and a reply to the answer of the bot
+			h(mautrix.EventSourceTimeline, testMessage("$q1", "What is the weather?", ""))
+			if msgs := fm.Messages(); len(msgs) == 1 {
+				h(mautrix.EventSourceTimeline, testMessage("$q2", "And tomorrow?", msgs[0].EventID))
+			}
+
+			msgs := fm.Messages()
+			if len(msgs) != len(tc.expAnswers) {
+				t.Fatalf("exp %v, got %v", len(tc.expAnswers), len(msgs))
+			}
+			for i, exp := range tc.expAnswers {
+				if act := msgs[i].Content.(*event.MessageEventContent).Body; act != exp {
+					t.Errorf("exp %v, got %v", exp, act)
+				}
+			}
+			if tc.expMessages == 0 {
+				return
+			}
+			reqs := fp.Requests()
+			if act := len(reqs[len(reqs)-1].Messages); act != tc.expMessages {
+				t.Errorf("exp %v, got %v", tc.expMessages, act)
+			}
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	fp := bot.NewFakeProvider()
+	b := newTestBot(t, bot.ConfigBot{
+		UserID:            "@bot:ewintr.nl",
+		AnswerUnaddressed: true,
+		DryRun:            true,
+		Admins:            []string{"@someone:ewintr.nl"},
+	}, bot.WithMatrix(fm), bot.WithProvider(fp))
+	_, h := b.ResponseHandler()
+	h(mautrix.EventSourceTimeline, testMessage("$q1", "What is the weather?", ""))
+
+	if act := len(fp.Requests()); act != 1 {
+		t.Errorf("exp 1, got %v", act)
+	}
+	if act := len(fm.Messages()); act != 0 {
+		t.Errorf("exp 0, got %v", act)
+	}
+
+	// commands that change the homeserver do not run
+	h(mautrix.EventSourceTimeline, testMessage("$leave", "!leave", ""))
+	h(mautrix.EventSourceTimeline, testMessage("$knock", "!knock #community:ewintr.nl", ""))
+	if act := fm.Left(); len(act) != 0 {
+		t.Errorf("exp none, got %v", act)
+	}
+	if act := len(fp.Requests()); act != 1 {
+		t.Errorf("exp 1, got %v", act)
+	}
+}
@@ -0,0 +1,337 @@
+package bot
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	feedInterval   = 15 * time.Minute
+	feedTimeout    = 30 * time.Second
+	feedMaxBody    = 5 << 20
+	feedMaxSummary = 500
+	feedPrompt     = "Summarize the following article in at most two sentences."
+)
+
+// Feed is an RSS or Atom feed that a room is subscribed to.
+type Feed struct {
+	ID        int64
+	RoomID    id.RoomID
+	URL       string
+	Summarize bool
+}
+
+type FeedItem struct {
+	ID      string
+	Title   string
+	Link    string
+	Summary string
+}
+
+type rssDoc struct {
+	Items []struct {
+		GUID        string `xml:"guid"`
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		Description string `xml:"description"`
+	} `xml:"channel>item"`
+}
+
+type atomDoc struct {
+	Entries []struct {
+		ID    string `xml:"id"`
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Summary string `xml:"summary"`
+		Content string `xml:"content"`
+	} `xml:"entry"`
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// ParseFeed reads the items of an RSS 2.0 or Atom feed, in the order of the
+// document, which is usually newest first.
+func ParseFeed(data []byte) ([]FeedItem, error) {
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("not a feed: %w", err)
+	}
+
+	var items []FeedItem
+	switch root.XMLName.Local {
+	case "rss":
+		var doc rssDoc
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		for _, i := range doc.Items {
+			items = append(items, FeedItem{ID: i.GUID, Title: i.Title, Link: i.Link, Summary: i.Description})
+		}
+	case "feed":
+		var doc atomDoc
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		for _, e := range doc.Entries {
+			item := FeedItem{ID: e.ID, Title: e.Title, Summary: e.Summary}
+			if item.Summary == "" {
+				item.Summary = e.Content
+			}
+			for _, l := range e.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					item.Link = l.Href
+					break
+				}
+			}
+			items = append(items, item)
+		}
+	default:
+		return nil, fmt.Errorf("not a feed: unknown root element %s", root.XMLName.Local)
+	}
+
+	for i := range items {
+		items[i].Title = strings.TrimSpace(items[i].Title)
+		items[i].Summary = strings.TrimSpace(htmlTag.ReplaceAllString(items[i].Summary, ""))
+		if items[i].ID == "" {
+			items[i].ID = items[i].Link
+		}
+		if items[i].ID == "" {
+			items[i].ID = items[i].Title
+		}
+	}
+
+	return items, nil
+}
+
+func fetchFeed(ctx context.Context, url string) ([]FeedItem, error) {
+	client := publicClient(feedTimeout)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed %s returned status %d", url, res.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, feedMaxBody))
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseFeed(data)
+}
+
+func (m *Bot) feedCommand() Command {
+	return Command{
+		Name:  "feed",
+		Usage: "list|add <url> [summarize]|remove <id>",
+		Help:  "post new items of an RSS or Atom feed in this room, optionally summarized",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			if len(args) == 0 || args[0] == "list" {
+				feeds, err := m.store.Feeds(evt.RoomID)
+				if err != nil {
+					return "", err
+				}
+				if len(feeds) == 0 {
+					return "No feeds in this room.", nil
+				}
+				var lines []string
+				for _, f := range feeds {
+					line := fmt.Sprintf("- %d: %s", f.ID, f.URL)
+					if f.Summarize {
+						line += " (summarized)"
+					}
+					lines = append(lines, line)
+				}
+				return strings.Join(lines, "\n"), nil
+			}
+			if !m.isAdmin(evt.Sender) {
+				return "", fmt.Errorf("only admins can change the feeds of this room")
+			}
+
+			switch {
+			case args[0] == "add" && (len(args) == 2 || (len(args) == 3 && args[2] == "summarize")):
+				items, err := fetchFeed(m.ctx, args[1])
+				if err != nil {
+					return "", err
+				}
+				feedID, err := m.store.AddFeed(Feed{RoomID: evt.RoomID, URL: args[1], Summarize: len(args) == 3})
+				if err != nil {
+					return "", err
+				}
+				// only what is new from now on
+				for _, item := range items {
+					if err := m.store.SetFeedItemSeen(feedID, item.ID); err != nil {
+						return "", err
+					}
+				}
+				return fmt.Sprintf("Subscribed to feed %d, new items will be posted here.", feedID), nil
+			case args[0] == "remove" && len(args) == 2:
+				feedID, err := strconv.ParseInt(args[1], 10, 64)
+				if err != nil {
+					return "", fmt.Errorf("not a feed id: %s", args[1])
+				}
+				if err := m.store.DeleteFeed(evt.RoomID, feedID); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Removed feed %d.", feedID), nil
+			default:
+				return "", fmt.Errorf("usage: !feed list|add <url> [summarize]|remove <id>")
+			}
+		},
+	}
+}
+
+// runFeeds checks the subscribed feeds for new items.
+func (m *Bot) runFeeds() {
+	for {
+		feeds, err := m.store.Feeds("")
+		if err != nil {
+			m.logger.Error("failed to get feeds", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		}
+		for _, f := range feeds {
+			if err := m.pollFeed(f); err != nil {
+				m.logger.Error("failed to poll feed", slog.String("err", err.Error()), slog.String("url", f.URL), slog.String("bot", m.config.UserDisplayName))
+			}
+		}
+		if !m.wait(feedInterval) {
+			return
+		}
+	}
+}
+
+func (m *Bot) pollFeed(f Feed) error {
+	items, err := fetchFeed(m.ctx, f.URL)
+	if err != nil {
+		return err
+	}
+	// oldest first
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		seen, err := m.store.FeedItemSeen(f.ID, item.ID)
+		if err != nil {
+			return err
+		}
+		if seen {
+			continue
+		}
+		summary := item.Summary
+		if f.Summarize && summary != "" {
+			if s, err := m.summarize(f.RoomID, feedPrompt, summary); err == nil {
+				summary = s
+			} else {
+				m.logger.Error("failed to summarize feed item", slog.String("err", err.Error()), slog.String("url", item.Link), slog.String("bot", m.config.UserDisplayName))
+			}
+		}
+		if r := []rune(summary); len(r) > feedMaxSummary {
+			summary = string(r[:feedMaxSummary]) + "…"
+		}
+		text := fmt.Sprintf("**[%s](%s)**", item.Title, item.Link)
+		if summary != "" {
+			text += "\n\n" + summary
+		}
+		if err := m.SendMarkdown(f.RoomID, text); err != nil {
+			return err
+		}
+		if err := m.store.SetFeedItemSeen(f.ID, item.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// summarize asks the model to condense text according to the prompt, for a
+// post in the room. Nobody asked for it, so the usage is recorded for the
+// room and the bot.
+func (m *Bot) summarize(roomID id.RoomID, prompt, text string) (string, error) {
+	provider, model, err := m.provider(m.config.Model)
+	if err != nil {
+		return "", err
+	}
+	meter := &UsageMeter{}
+	conv := NewConversation("", prompt, text)
+	ctx, cancel := m.completionContext(m.ctx)
+	defer cancel()
+	ctx = WithUsageMeter(ctx, meter)
+	summary, err := provider.CompleteContext(ctx, model, conv)
+	m.saveUsage(m.botEvent(roomID), model, conv, meter, err != nil)
+
+	return summary, err
+}
+
+func (s *Store) AddFeed(f Feed) (int64, error) {
+	var feedID int64
+	err := s.db.QueryRowContext(s.context(), `INSERT INTO bot_feed (room_id, url, summarize) VALUES ($1, $2, $3) RETURNING id`,
+		f.RoomID, f.URL, f.Summarize).Scan(&feedID)
+
+	return feedID, err
+}
+
+// Feeds returns the feeds of a room, or of all rooms if roomID is empty.
+func (s *Store) Feeds(roomID id.RoomID) ([]Feed, error) {
+	rows, err := s.db.QueryContext(s.context(), `SELECT id, room_id, url, summarize FROM bot_feed WHERE $1 = '' OR room_id = $1 ORDER BY id`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feeds []Feed
+	for rows.Next() {
+		var f Feed
+		if err := rows.Scan(&f.ID, &f.RoomID, &f.URL, &f.Summarize); err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, f)
+	}
+
+	return feeds, rows.Err()
+}
+
+// DeleteFeed removes a feed, which must belong to the room, and its items.
+func (s *Store) DeleteFeed(roomID id.RoomID, feedID int64) error {
+	res, err := s.db.ExecContext(s.context(), `DELETE FROM bot_feed WHERE id = $1 AND room_id = $2`, feedID, roomID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("no feed %d in this room", feedID)
+	}
+	_, err = s.db.ExecContext(s.context(), `DELETE FROM bot_feed_item WHERE feed_id = $1`, feedID)
+
+	return err
+}
+
+func (s *Store) FeedItemSeen(feedID int64, itemID string) (bool, error) {
+	var n int
+	if err := s.db.QueryRowContext(s.context(), `SELECT COUNT(*) FROM bot_feed_item WHERE feed_id = $1 AND item_id = $2`, feedID, itemID).Scan(&n); err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (s *Store) SetFeedItemSeen(feedID int64, itemID string) error {
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_feed_item (feed_id, item_id) VALUES ($1, $2) ON CONFLICT (feed_id, item_id) DO NOTHING`, feedID, itemID)
+	return err
+}
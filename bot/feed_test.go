@@ -0,0 +1,62 @@
+package bot_test
+
+import (
+	"reflect"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestParseFeed(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		data   string
+		exp    []bot.FeedItem
+		expErr bool
+	}{
+		{
+			name: "rss",
+			data: `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Blog</title>
+<item><guid>post-2</guid><title> Second </title><link>https://example.com/2</link><description>&lt;p&gt;More &lt;b&gt;news&lt;/b&gt;&lt;/p&gt;</description></item>
+<item><title>First</title><link>https://example.com/1</link></item>
+</channel></rss>`,
+			exp: []bot.FeedItem{
+				{ID: "post-2", Title: "Second", Link: "https://example.com/2", Summary: "More news"},
+				{ID: "https://example.com/1", Title: "First", Link: "https://example.com/1"},
+			},
+		},
+		{
+			name: "atom",
+			data: `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>Blog</title>
+<entry><id>urn:1</id><title>Post</title><link rel="self" href="https://example.com/self"/><link href="https://example.com/post"/><content type="html">Body</content></entry>
+</feed>`,
+			exp: []bot.FeedItem{
+				{ID: "urn:1", Title: "Post", Link: "https://example.com/post", Summary: "Body"},
+			},
+		},
+		{
+			name:   "html",
+			data:   `<html><body>not a feed</body></html>`,
+			expErr: true,
+		},
+		{
+			name:   "garbage",
+			data:   `{"json": true}`,
+			expErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := bot.ParseFeed([]byte(tc.data))
+			if (err != nil) != tc.expErr {
+				t.Fatalf("exp error %v, got %v", tc.expErr, err)
+			}
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
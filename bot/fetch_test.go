@@ -0,0 +1,122 @@
+package bot_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		ip  string
+		exp bool
+	}{
+		{ip: "93.184.216.34", exp: true},
+		{ip: "2606:2800:220:1:248:1893:25c8:1946", exp: true},
+		{ip: "127.0.0.1"},
+		{ip: "10.1.2.3"},
+		{ip: "192.168.1.1"},
+		{ip: "172.16.0.1"},
+		{ip: "169.254.169.254"},
+		{ip: "100.64.0.1"},
+		{ip: "0.0.0.0"},
+		{ip: "::1"},
+		{ip: "fd00::1"},
+		{ip: "::ffff:127.0.0.1"},
+	} {
+		t.Run(tc.ip, func(t *testing.T) {
+			if act := bot.IsPublicIP(net.ParseIP(tc.ip)); act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestReadableText(t *testing.T) {
+	t.Parallel()
+
+	page := `<html><head><title>T</title><style>p{}</style></head><body>
+<nav><a href="/">Home</a></nav>
+<article><h1>Title</h1><p>First   paragraph with <b>bold</b> text.</p><script>alert(1)</script><p>Second &amp; last.</p></article>
+<footer>Copyright</footer></body></html>`
+	exp := "Title\nFirst paragraph with bold text.\nSecond & last."
+	act, err := bot.ReadableText(strings.NewReader(page))
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if act != exp {
+		t.Errorf("exp %q, got %q", exp, act)
+	}
+}
+
+func TestFetchToolRefusesLocal(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("secret"))
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name string
+		url  string
+	}{
+		{name: "loopback", url: srv.URL},
+		{name: "scheme", url: "file:///etc/passwd"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := bot.NewFetchTool().Fetch(context.Background(), tc.url)
+			if err == nil {
+				t.Fatalf("exp error, got %v", act)
+			}
+			if tc.name == "loopback" && !errors.Is(err, bot.ErrForbiddenAddress) {
+				t.Errorf("exp %v, got %v", bot.ErrForbiddenAddress, err)
+			}
+		})
+	}
+}
+
+func TestLinkedPages(t *testing.T) {
+	t.Parallel()
+
+	fp := bot.NewFakeProvider()
+	b := newTestBot(t, bot.ConfigBot{
+		UserID:            "@bot:ewintr.nl",
+		AnswerUnaddressed: true,
+		Tools:             []string{"fetch_url"},
+	}, bot.WithProvider(fp))
+	_, h := b.ResponseHandler()
+	h(mautrix.EventSourceTimeline, testMessage("$question", "What is on http://127.0.0.1/admin?", ""))
+	// the page is fetched in the background
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+
+	reqs := fp.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("exp 1, got %v", len(reqs))
+	}
+	msgs := reqs[0].Messages
+	if len(msgs) != 3 {
+		t.Fatalf("exp 3, got %v", len(msgs))
+	}
+	page := msgs[1]
+	if page.Role != "user" {
+		t.Errorf("exp user, got %v", page.Role)
+	}
+	if !strings.Contains(page.Content, "<<<page http://127.0.0.1/admin\nThe page could not be fetched") || !strings.HasSuffix(page.Content, "page>>>") {
+		t.Errorf("exp page between delimiters, got %q", page.Content)
+	}
+	if act := msgs[2].Content; act != "What is on http://127.0.0.1/admin?" {
+		t.Errorf("exp question, got %q", act)
+	}
+}
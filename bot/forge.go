@@ -0,0 +1,388 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	ForgeGitHub = "github"
+	ForgeGitea  = "gitea"
+
+	forgeTimeout  = 15 * time.Second
+	forgeMaxBody  = 2 << 20
+	forgeListSize = 10
+	forgeMaxText  = 1500
+)
+
+// ConfigForge gives access to Repos, like "ewintr/matrix-bots", on GitHub
+// or a Gitea instance. URL is the address of the Gitea instance, or of the
+// GitHub API when it is not api.github.com. Token is optional for public
+// repositories.
+type ConfigForge struct {
+	Kind  string
+	URL   string
+	Token string
+	Repos []string
+}
+
+// ForgeClient reads issues, pull requests and commit statuses. GitHub and
+// Gitea share most of their API.
+type ForgeClient struct {
+	config ConfigForge
+	api    string
+	client *http.Client
+}
+
+func NewForgeClient(cfg ConfigForge) (*ForgeClient, error) {
+	var api string
+	switch cfg.Kind {
+	case ForgeGitHub:
+		api = "https://api.github.com"
+		if cfg.URL != "" {
+			api = strings.TrimSuffix(cfg.URL, "/")
+		}
+	case ForgeGitea:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("gitea needs a url")
+		}
+		api = strings.TrimSuffix(cfg.URL, "/") + "/api/v1"
+	default:
+		return nil, fmt.Errorf("unknown forge %q", cfg.Kind)
+	}
+
+	return &ForgeClient{
+		config: cfg,
+		api:    api,
+		client: &http.Client{Timeout: forgeTimeout},
+	}, nil
+}
+
+type forgeUser struct {
+	Login string `json:"login"`
+}
+
+type forgeIssue struct {
+	Number      int       `json:"number"`
+	Title       string    `json:"title"`
+	State       string    `json:"state"`
+	User        forgeUser `json:"user"`
+	HTMLURL     string    `json:"html_url"`
+	Body        string    `json:"body"`
+	Comments    int       `json:"comments"`
+	PullRequest any       `json:"pull_request"`
+	Labels      []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+}
+
+type forgePull struct {
+	forgeIssue
+	Merged bool `json:"merged"`
+	Draft  bool `json:"draft"`
+	Head   struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	} `json:"head"`
+}
+
+type forgeStatus struct {
+	State    string `json:"state"`
+	Statuses []struct {
+		Context   string `json:"context"`
+		State     string `json:"state"`
+		Status    string `json:"status"`
+		TargetURL string `json:"target_url"`
+	} `json:"statuses"`
+}
+
+func (f *ForgeClient) get(ctx context.Context, path string, query url.Values, v any) error {
+	u := f.api + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if f.config.Token != "" {
+		if f.config.Kind == ForgeGitea {
+			req.Header.Set("Authorization", "token "+f.config.Token)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+f.config.Token)
+		}
+	}
+	res, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("not found")
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", f.config.Kind, res.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, forgeMaxBody)).Decode(v)
+}
+
+// Issue describes an issue with the start of its description.
+func (f *ForgeClient) Issue(ctx context.Context, repo string, number int) (string, error) {
+	var issue forgeIssue
+	if err := f.get(ctx, fmt.Sprintf("/repos/%s/issues/%d", repo, number), nil, &issue); err != nil {
+		return "", err
+	}
+	var labels []string
+	for _, l := range issue.Labels {
+		labels = append(labels, l.Name)
+	}
+	text := fmt.Sprintf("#%d [%s](%s), %s, by %s, %d comments", issue.Number, issue.Title, issue.HTMLURL, issue.State, issue.User.Login, issue.Comments)
+	if len(labels) > 0 {
+		text += fmt.Sprintf(", labels: %s", strings.Join(labels, ", "))
+	}
+	if body := strings.TrimSpace(issue.Body); body != "" {
+		if len(body) > forgeMaxText {
+			body = strings.ToValidUTF8(body[:forgeMaxText], "") + "..."
+		}
+		text += "\n\n" + body
+	}
+
+	return text, nil
+}
+
+// Pull describes a pull request and the status of its last commit.
+func (f *ForgeClient) Pull(ctx context.Context, repo string, number int) (string, error) {
+	var pull forgePull
+	if err := f.get(ctx, fmt.Sprintf("/repos/%s/pulls/%d", repo, number), nil, &pull); err != nil {
+		return "", err
+	}
+	state := pull.State
+	switch {
+	case pull.Merged:
+		state = "merged"
+	case pull.Draft:
+		state = "draft"
+	}
+	text := fmt.Sprintf("#%d [%s](%s), %s, by %s, from `%s`", pull.Number, pull.Title, pull.HTMLURL, state, pull.User.Login, pull.Head.Ref)
+	if pull.Head.SHA != "" {
+		if status, err := f.Status(ctx, repo, pull.Head.SHA); err == nil {
+			text += "\nCI: " + status
+		}
+	}
+
+	return text, nil
+}
+
+// List returns the latest open issues or pull requests.
+func (f *ForgeClient) List(ctx context.Context, repo string, pulls bool) (string, error) {
+	path, kind := fmt.Sprintf("/repos/%s/issues", repo), "issues"
+	query := url.Values{"state": {"open"}, "per_page": {strconv.Itoa(forgeListSize)}, "limit": {strconv.Itoa(forgeListSize)}}
+	if pulls {
+		path, kind = fmt.Sprintf("/repos/%s/pulls", repo), "pull requests"
+	} else if f.config.Kind == ForgeGitea {
+		query.Set("type", "issues")
+	}
+	var issues []forgeIssue
+	if err := f.get(ctx, path, query, &issues); err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for _, issue := range issues {
+		// github lists pull requests as issues too
+		if !pulls && issue.PullRequest != nil {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("- #%d [%s](%s) by %s", issue.Number, issue.Title, issue.HTMLURL, issue.User.Login))
+	}
+	if len(lines) == 0 {
+		return fmt.Sprintf("No open %s in %s.", kind, repo), nil
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// Status returns the combined commit status of ref, a branch or commit. An
+// empty ref means the default branch.
+func (f *ForgeClient) Status(ctx context.Context, repo, ref string) (string, error) {
+	if ref == "" {
+		var r struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		if err := f.get(ctx, "/repos/"+repo, nil, &r); err != nil {
+			return "", err
+		}
+		ref = r.DefaultBranch
+	}
+	var status forgeStatus
+	if err := f.get(ctx, fmt.Sprintf("/repos/%s/commits/%s/status", repo, url.PathEscape(ref)), nil, &status); err != nil {
+		return "", err
+	}
+	if len(status.Statuses) == 0 {
+		return "no checks", nil
+	}
+	parts := []string{status.State}
+	for _, s := range status.Statuses {
+		state := s.State
+		if state == "" {
+			state = s.Status
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s", s.Context, state))
+	}
+
+	return strings.Join(parts, ", "), nil
+}
+
+// ForgeTool lets the model look up issues, pull requests and CI status in the
+// configured repositories.
+type ForgeTool struct {
+	forges map[string]*ForgeClient
+}
+
+// NewForgeTool creates the tool for the repositories of the forges.
+func NewForgeTool(cfgs []ConfigForge) (*ForgeTool, error) {
+	t := &ForgeTool{forges: make(map[string]*ForgeClient)}
+	for _, cfg := range cfgs {
+		client, err := NewForgeClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range cfg.Repos {
+			t.forges[strings.ToLower(repo)] = client
+		}
+	}
+
+	return t, nil
+}
+
+func (t *ForgeTool) Name() string { return "repository" }
+
+func (t *ForgeTool) Description() string {
+	repos := make([]string, 0, len(t.forges))
+	for repo := range t.forges {
+		repos = append(repos, repo)
+	}
+
+	return fmt.Sprintf("Look up issues, pull requests and CI status in the repositories %s.", strings.Join(repos, ", "))
+}
+
+func (t *ForgeTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{` +
+		`"repo":{"type":"string","description":"owner/name of the repository"},` +
+		`"what":{"type":"string","enum":["issue","pull","issues","pulls","ci"],"description":"one issue or pull request, the open ones, or the CI status"},` +
+		`"number":{"type":"integer","description":"the number of the issue or pull request"},` +
+		`"ref":{"type":"string","description":"branch or commit for ci, the default branch if empty"}},` +
+		`"required":["repo","what"]}`)
+}
+
+func (t *ForgeTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	var a struct {
+		Repo   string `json:"repo"`
+		What   string `json:"what"`
+		Number int    `json:"number"`
+		Ref    string `json:"ref"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return "", err
+	}
+
+	return t.Lookup(ctx, a.Repo, a.What, a.Number, a.Ref)
+}
+
+// Lookup answers a question about a configured repository. what is issue,
+// pull, issues, pulls or ci.
+func (t *ForgeTool) Lookup(ctx context.Context, repo, what string, number int, ref string) (string, error) {
+	forge, ok := t.forges[strings.ToLower(repo)]
+	if !ok {
+		return "", fmt.Errorf("unknown repository %s", repo)
+	}
+	switch what {
+	case "issue":
+		return forge.Issue(ctx, repo, number)
+	case "pull":
+		return forge.Pull(ctx, repo, number)
+	case "issues", "pulls":
+		return forge.List(ctx, repo, what == "pulls")
+	case "ci":
+		status, err := forge.Status(ctx, repo, ref)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("CI of %s: %s", repo, status), nil
+	default:
+		return "", fmt.Errorf("unknown lookup %q", what)
+	}
+}
+
+// repo returns the only configured repository, if there is one.
+func (t *ForgeTool) repo() (string, bool) {
+	if len(t.forges) != 1 {
+		return "", false
+	}
+	for repo := range t.forges {
+		return repo, true
+	}
+
+	return "", false
+}
+
+func (m *Bot) ghCommand() Command {
+	usage := "usage: !gh [owner/repo] issues|pulls|issue <n>|pull <n>|ci [ref]"
+	return Command{
+		Name:  "gh",
+		Usage: "[owner/repo] issues|pulls|issue <n>|pull <n>|ci [ref]",
+		Help:  "look up issues, pull requests and CI status of the configured repositories",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			forge, ok := m.tools["repository"].(*ForgeTool)
+			if !ok {
+				return "", fmt.Errorf("no repositories configured")
+			}
+			var repo string
+			if len(args) > 0 && strings.Contains(args[0], "/") {
+				repo, args = args[0], args[1:]
+			} else if repo, ok = forge.repo(); !ok {
+				return "", errors.New(usage)
+			}
+			if len(args) == 0 {
+				return "", errors.New(usage)
+			}
+
+			var (
+				number int
+				ref    string
+			)
+			switch {
+			case (args[0] == "issue" || args[0] == "pull") && len(args) == 2:
+				n, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+				if err != nil {
+					return "", fmt.Errorf("not a number: %s", args[1])
+				}
+				number = n
+			case args[0] == "ci" && len(args) <= 2:
+				if len(args) == 2 {
+					ref = args[1]
+				}
+			case (args[0] == "issues" || args[0] == "pulls") && len(args) == 1:
+			default:
+				return "", errors.New(usage)
+			}
+
+			ctx, cancel := m.toolContext()
+			defer cancel()
+
+			return forge.Lookup(ctx, repo, args[0], number, ref)
+		},
+	}
+}
@@ -0,0 +1,146 @@
+package bot_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestForgeToolLookup(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token secret" {
+			t.Errorf("exp token secret, got %v", r.Header.Get("Authorization"))
+		}
+		switch r.URL.Path {
+		case "/api/v1/repos/ewintr/bots/issues/7":
+			_, _ = w.Write([]byte(`{"number":7,"title":"Crash","state":"open","user":{"login":"erik"},"html_url":"https://git/7","comments":2,"labels":[{"name":"bug"}],"body":"It crashes."}`))
+		case "/api/v1/repos/ewintr/bots/pulls":
+			_, _ = w.Write([]byte(`[{"number":8,"title":"Fix crash","user":{"login":"erik"},"html_url":"https://git/8"}]`))
+		case "/api/v1/repos/ewintr/bots":
+			_, _ = w.Write([]byte(`{"default_branch":"main"}`))
+		case "/api/v1/repos/ewintr/bots/commits/main/status":
+			_, _ = w.Write([]byte(`{"state":"failure","statuses":[{"context":"test","status":"failure"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	forge, err := bot.NewForgeTool([]bot.ConfigForge{{Kind: bot.ForgeGitea, URL: srv.URL, Token: "secret", Repos: []string{"ewintr/bots"}}})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	for _, tc := range []struct {
+		name   string
+		repo   string
+		what   string
+		number int
+		exp    string
+		expErr bool
+	}{
+		{name: "issue", repo: "ewintr/bots", what: "issue", number: 7, exp: "#7 [Crash](https://git/7), open, by erik, 2 comments, labels: bug\n\nIt crashes."},
+		{name: "pulls", repo: "ewintr/bots", what: "pulls", exp: "- #8 [Fix crash](https://git/8) by erik"},
+		{name: "ci", repo: "ewintr/bots", what: "ci", exp: "CI of ewintr/bots: failure, test: failure"},
+		{name: "missing issue", repo: "ewintr/bots", what: "issue", number: 9, expErr: true},
+		{name: "other repo", repo: "ewintr/other", what: "issues", expErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := forge.Lookup(context.Background(), tc.repo, tc.what, tc.number, "")
+			if (err != nil) != tc.expErr {
+				t.Fatalf("exp error %v, got %v", tc.expErr, err)
+			}
+			if act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestRenderForgeEvent(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name  string
+		event string
+		body  string
+		exp   string
+	}{
+		{
+			name:  "push",
+			event: "push",
+			body:  `{"ref":"refs/heads/main","compare":"https://c","sender":{"login":"erik"},"repository":{"full_name":"ewintr/bots"},"commits":[{"id":"0123456789","message":"Fix it\n\nLong story","url":"https://c/1"}]}`,
+			exp:   "**erik** pushed [1 commits](https://c) to `main` of ewintr/bots\n- [`0123456`](https://c/1) Fix it",
+		},
+		{
+			name:  "merged",
+			event: "pull_request",
+			body:  `{"action":"closed","sender":{"login":"erik"},"repository":{"full_name":"ewintr/bots"},"pull_request":{"number":3,"title":"Tools","html_url":"https://p/3","merged":true}}`,
+			exp:   "**erik** merged pull request [#3 Tools](https://p/3) in ewintr/bots",
+		},
+		{
+			name:  "labeled is ignored",
+			event: "issues",
+			body:  `{"action":"labeled","issue":{"number":1}}`,
+		},
+		{
+			name:  "ping",
+			event: "ping",
+			body:  `{"zen":"Keep it simple."}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := bot.RenderForgeEvent(tc.event, []byte(tc.body))
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			if act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestInboundServerGitHubSignature(t *testing.T) {
+	t.Parallel()
+
+	var text string
+	s := bot.NewInboundServer(slog.Default())
+	if err := s.AddHook(bot.ConfigHook{Name: "gh", Token: "secret", Room: "!room:server", Format: bot.ForgeGitHub}, func(_ id.RoomID, t string) error {
+		text = t
+		return nil
+	}); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	body := `{"action":"opened","sender":{"login":"erik"},"repository":{"full_name":"ewintr/bots"},"issue":{"number":1,"title":"Bug","html_url":"https://i/1"}}`
+	for _, tc := range []struct {
+		name      string
+		signature string
+		expCode   int
+	}{
+		{name: "signed", signature: "sha256=" + bot.Sign("secret", []byte(body)), expCode: http.StatusNoContent},
+		{name: "wrong secret", signature: "sha256=" + bot.Sign("guess", []byte(body)), expCode: http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			text = ""
+			req := httptest.NewRequest(http.MethodPost, "/hook/gh", strings.NewReader(body))
+			req.Header.Set("X-GitHub-Event", "issues")
+			req.Header.Set("X-Hub-Signature-256", tc.signature)
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			if rec.Code != tc.expCode {
+				t.Errorf("exp %v, got %v", tc.expCode, rec.Code)
+			}
+			if tc.expCode == http.StatusNoContent && text != "**erik** opened issue [#1 Bug](https://i/1) in ewintr/bots" {
+				t.Errorf("exp issue text, got %v", text)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const forgeMaxCommits = 5
+
+type forgeEvent struct {
+	Action     string    `json:"action"`
+	Sender     forgeUser `json:"sender"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	// push
+	Ref        string `json:"ref"`
+	Compare    string `json:"compare"`
+	CompareURL string `json:"compare_url"`
+	Commits    []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+	} `json:"commits"`
+	Issue       *forgeIssue `json:"issue"`
+	PullRequest *forgePull  `json:"pull_request"`
+	Comment     *struct {
+		Body    string `json:"body"`
+		HTMLURL string `json:"html_url"`
+	} `json:"comment"`
+	Release *struct {
+		TagName string `json:"tag_name"`
+		Name    string `json:"name"`
+		HTMLURL string `json:"html_url"`
+	} `json:"release"`
+	WorkflowRun *struct {
+		Name       string `json:"name"`
+		HeadBranch string `json:"head_branch"`
+		Conclusion string `json:"conclusion"`
+		HTMLURL    string `json:"html_url"`
+	} `json:"workflow_run"`
+}
+
+// RenderForgeEvent formats a GitHub or Gitea webhook event, named in the
+// X-GitHub-Event or X-Gitea-Event header, as markdown. Events and actions
+// that are not interesting in a chat, like ping or labeled, give an empty
+// text.
+func RenderForgeEvent(name string, body []byte) (string, error) {
+	var e forgeEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		return "", fmt.Errorf("invalid json: %w", err)
+	}
+	repo, who := e.Repository.FullName, e.Sender.Login
+
+	switch {
+	case name == "push" && len(e.Commits) > 0:
+		branch := strings.TrimPrefix(e.Ref, "refs/heads/")
+		compare := e.Compare
+		if compare == "" {
+			compare = e.CompareURL
+		}
+		lines := []string{fmt.Sprintf("**%s** pushed [%d commits](%s) to `%s` of %s", who, len(e.Commits), compare, branch, repo)}
+		for i, c := range e.Commits {
+			if i == forgeMaxCommits {
+				lines = append(lines, fmt.Sprintf("- and %d more", len(e.Commits)-forgeMaxCommits))
+				break
+			}
+			msg, _, _ := strings.Cut(c.Message, "\n")
+			lines = append(lines, fmt.Sprintf("- [`%.7s`](%s) %s", c.ID, c.URL, msg))
+		}
+		return strings.Join(lines, "\n"), nil
+	case name == "issues" && e.Issue != nil && forgeAction(e.Action):
+		return fmt.Sprintf("**%s** %s issue [#%d %s](%s) in %s", who, e.Action, e.Issue.Number, e.Issue.Title, e.Issue.HTMLURL, repo), nil
+	case name == "pull_request" && e.PullRequest != nil && forgeAction(e.Action):
+		action := e.Action
+		if action == "closed" && e.PullRequest.Merged {
+			action = "merged"
+		}
+		return fmt.Sprintf("**%s** %s pull request [#%d %s](%s) in %s", who, action, e.PullRequest.Number, e.PullRequest.Title, e.PullRequest.HTMLURL, repo), nil
+	case name == "issue_comment" && e.Issue != nil && e.Comment != nil && e.Action == "created":
+		text := e.Comment.Body
+		if len(text) > 200 {
+			text = strings.ToValidUTF8(text[:200], "") + "..."
+		}
+		return fmt.Sprintf("**%s** [commented](%s) on #%d %s in %s:\n> %s", who, e.Comment.HTMLURL, e.Issue.Number, e.Issue.Title, repo, strings.ReplaceAll(text, "\n", "\n> ")), nil
+	case name == "release" && e.Release != nil && e.Action == "published":
+		title := e.Release.Name
+		if title == "" {
+			title = e.Release.TagName
+		}
+		return fmt.Sprintf("**%s** released [%s](%s) of %s", who, title, e.Release.HTMLURL, repo), nil
+	case name == "workflow_run" && e.WorkflowRun != nil && e.Action == "completed":
+		return fmt.Sprintf("CI [%s](%s) on `%s` of %s: **%s**", e.WorkflowRun.Name, e.WorkflowRun.HTMLURL, e.WorkflowRun.HeadBranch, repo, e.WorkflowRun.Conclusion), nil
+	default:
+		return "", nil
+	}
+}
+
+func forgeAction(action string) bool {
+	switch action {
+	case "opened", "closed", "reopened":
+		return true
+	}
+
+	return false
+}
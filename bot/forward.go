@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	forwardTimeout         = 10 * time.Second
+	ForwardSignatureHeader = "X-Signature-256"
+)
+
+// ConfigWebhook is an HTTP endpoint that receives a copy of the messages in
+// the listed rooms. Empty Rooms or MsgTypes match everything. When a Secret is
+// set, the body is signed with HMAC-SHA256 and the signature is put in the
+// X-Signature-256 header as "sha256=<hex>".
+type ConfigWebhook struct {
+	URL      string
+	Secret   string
+	Rooms    []string
+	MsgTypes []string
+}
+
+type ForwardPayload struct {
+	EventID   string          `json:"event_id"`
+	RoomID    string          `json:"room_id"`
+	Sender    string          `json:"sender"`
+	Type      string          `json:"type"`
+	Timestamp int64           `json:"timestamp"`
+	Content   json.RawMessage `json:"content"`
+}
+
+type Forwarder struct {
+	config ConfigWebhook
+	client *http.Client
+}
+
+func NewForwarder(cfg ConfigWebhook) *Forwarder {
+	return &Forwarder{
+		config: cfg,
+		client: &http.Client{Timeout: forwardTimeout},
+	}
+}
+
+func (f *Forwarder) Match(evt *event.Event) bool {
+	if len(f.config.Rooms) > 0 && !contains(f.config.Rooms, evt.RoomID.String()) {
+		return false
+	}
+	if len(f.config.MsgTypes) > 0 && !contains(f.config.MsgTypes, string(evt.Content.AsMessage().MsgType)) {
+		return false
+	}
+
+	return true
+}
+
+// Forward posts the event to the webhook. The request is given up when ctx
+// is done.
+func (f *Forwarder) Forward(ctx context.Context, evt *event.Event) error {
+	content := evt.Content.VeryRaw
+	if len(content) == 0 {
+		var err error
+		if content, err = json.Marshal(evt.Content.Parsed); err != nil {
+			return err
+		}
+	}
+	body, err := json.Marshal(ForwardPayload{
+		EventID:   evt.ID.String(),
+		RoomID:    evt.RoomID.String(),
+		Sender:    evt.Sender.String(),
+		Type:      evt.Type.Type,
+		Timestamp: evt.Timestamp,
+		Content:   content,
+	})
+	if err != nil {
+		return err
+	}
+
+	return postWebhook(ctx, f.client, f.config.URL, f.config.Secret, body)
+}
+
+// postWebhook posts the JSON body to the URL, signed when there is a secret.
+func postWebhook(ctx context.Context, client *http.Client, url, secret string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(ForwardSignatureHeader, "sha256="+Sign(secret, body))
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned status %d", url, res.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ForwardHandler posts matching messages to the configured webhooks. It never
+// consumes the message and does not wait for the webhooks to answer.
+func (m *Bot) ForwardHandler() MessageHandler {
+	return NewMessageHandler("forward", PriorityForward, func(evt *event.Event) bool {
+		for _, f := range m.forwarders {
+			if !f.Match(evt) {
+				continue
+			}
+			go func(f *Forwarder) {
+				if err := f.Forward(m.ctx, evt); err != nil {
+					m.logger.Error("failed to forward event", slog.String("err", err.Error()), slog.String("event_id", evt.ID.String()), slog.String("bot", m.config.UserDisplayName))
+				}
+			}(f)
+		}
+
+		return false
+	})
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
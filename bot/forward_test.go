@@ -0,0 +1,56 @@
+package bot_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestForwarder_Forward(t *testing.T) {
+	t.Parallel()
+
+	var (
+		body      []byte
+		signature string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(bot.ForwardSignatureHeader)
+	}))
+	defer srv.Close()
+
+	f := bot.NewForwarder(bot.ConfigWebhook{URL: srv.URL, Secret: "secret"})
+	evt := &event.Event{
+		ID:     "$event",
+		RoomID: "!room:server",
+		Sender: "@user:server",
+		Type:   event.EventMessage,
+		Content: event.Content{
+			VeryRaw: json.RawMessage(`{"msgtype":"m.text","body":"hi"}`),
+			Parsed:  &event.MessageEventContent{MsgType: event.MsgText, Body: "hi"},
+		},
+	}
+	if !f.Match(evt) {
+		t.Fatal("exp event to match")
+	}
+	if err := f.Forward(context.Background(), evt); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+
+	if exp := "sha256=" + bot.Sign("secret", body); signature != exp {
+		t.Errorf("exp %s, got %s", exp, signature)
+	}
+	var payload bot.ForwardPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if payload.EventID != "$event" || payload.RoomID != "!room:server" {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}
@@ -0,0 +1,98 @@
+package bot_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+type conversationFixture struct {
+	Config  bot.ConfigBot
+	Answers []string
+	Events  []struct {
+		ID      id.EventID
+		Body    string
+		ReplyTo id.EventID `json:"reply_to"`
+	}
+}
+
+type goldenMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type goldenRequest struct {
+	Model    string          `json:"model"`
+	Messages []goldenMessage `json:"messages"`
+}
+
+// TestConversationGolden feeds the events of each fixture in
+// testdata/conversations to a bot and compares every request made to the
+// provider with the golden file next to it. Run with -update after an
+// intended change in how conversations are built.
+func TestConversationGolden(t *testing.T) {
+	t.Parallel()
+
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "conversations", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range fixtures {
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".json"), func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fixture conversationFixture
+			if err := json.Unmarshal(data, &fixture); err != nil {
+				t.Fatal(err)
+			}
+
+			fp := bot.NewFakeProvider()
+			fp.Script(fixture.Answers...)
+			b := newTestBot(t, fixture.Config, bot.WithProvider(fp))
+			_, h := b.ResponseHandler()
+			for _, e := range fixture.Events {
+				h(mautrix.EventSourceTimeline, testMessage(e.ID, e.Body, e.ReplyTo))
+			}
+
+			requests := []goldenRequest{}
+			for _, req := range fp.Requests() {
+				gr := goldenRequest{Model: req.Model}
+				for _, msg := range req.Messages {
+					gr.Messages = append(gr.Messages, goldenMessage{Role: msg.Role, Content: msg.Content})
+				}
+				requests = append(requests, gr)
+			}
+			act, err := json.MarshalIndent(requests, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			act = append(act, '\n')
+
+			golden := strings.TrimSuffix(path, ".json") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, act, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			exp, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(exp, act) {
+				t.Errorf("exp %s, got %s", exp, act)
+			}
+		})
+	}
+}
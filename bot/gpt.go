@@ -2,38 +2,217 @@ package bot
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
 )
 
+var (
+	// ErrRateLimited means the provider refused the request because too
+	// many were made, even after retrying.
+	ErrRateLimited = errors.New("rate limited by provider")
+	// ErrContextTooLong means the conversation does not fit in the context
+	// window of the model.
+	ErrContextTooLong = errors.New("conversation too long for the model")
+	// ErrNotAuthorized means the provider did not accept the API key, or
+	// the key may not use the model.
+	ErrNotAuthorized = errors.New("not authorized by provider")
+)
+
+// Provider gives the answers of a language model. GPT is the one that is
+// used normally, FakeProvider gives scripted answers in tests.
+type Provider interface {
+	CompleteContext(ctx context.Context, model string, conv *Conversation, tools ...Tool) (string, error)
+	Usage() openai.Usage
+	Available() bool
+}
+
+// CompletionParams tune the answer of the model. Zero values leave the
+// default of the provider.
+type CompletionParams struct {
+	Temperature float32
+	MaxTokens   int
+}
+
+type completionParamsKey struct{}
+
+// WithCompletionParams adds the params to the context, providers use them
+// for the requests made with it.
+func WithCompletionParams(ctx context.Context, p CompletionParams) context.Context {
+	return context.WithValue(ctx, completionParamsKey{}, p)
+}
+
+func completionParams(ctx context.Context) CompletionParams {
+	p, _ := ctx.Value(completionParamsKey{}).(CompletionParams)
+	return p
+}
+
 type GPT struct {
-	client *openai.Client
+	client      *openai.Client
+	breaker     *Breaker
+	toolTimeout time.Duration
+	usage       openai.Usage
+	mu          sync.Mutex
 }
 
 func NewGPT(apiKey string) *GPT {
+	return NewGPTWithConfig(openai.DefaultConfig(apiKey))
+}
+
+// NewGPTWithConfig creates a client for another endpoint that speaks the
+// OpenAI API. Rate limited and failed requests are retried with backoff.
+func NewGPTWithConfig(config openai.ClientConfig) *GPT {
+	client := http.Client{}
+	if config.HTTPClient != nil {
+		client = *config.HTTPClient
+	}
+	client.Transport = &RetryTransport{Base: client.Transport, Backoff: providerBackoff}
+	config.HTTPClient = &client
+
 	return &GPT{
-		client: openai.NewClient(apiKey),
+		client:  openai.NewClientWithConfig(config),
+		breaker: NewBreaker(breakerThreshold, breakerCooldown),
 	}
 }
 
-func (g GPT) Complete(conv *Conversation) (string, error) {
-	ctx := context.Background()
+// SetToolTimeout limits the time a single tool call may take. Without it,
+// only the context of the completion applies.
+func (g *GPT) SetToolTimeout(d time.Duration) {
+	g.toolTimeout = d
+}
+
+// Complete returns the next message in the conversation. Without a model,
+// GPT-4 is used. The model can call the given tools before it answers; the
+// calls and their results are not added to the conversation.
+func (g *GPT) Complete(model string, conv *Conversation, tools ...Tool) (string, error) {
+	return g.CompleteContext(context.Background(), model, conv, tools...)
+}
+
+// CompleteContext is Complete with a context that is passed to the tools.
+func (g *GPT) CompleteContext(ctx context.Context, model string, conv *Conversation, tools ...Tool) (string, error) {
+	model = modelOrDefault(model)
 	msg := []openai.ChatCompletionMessage{}
-	for _, m := range conv.Messages {
+	for _, m := range conv.History() {
 		msg = append(msg, openai.ChatCompletionMessage{
 			Role:    m.Role,
 			Content: m.Content,
 		})
 	}
+	params := completionParams(ctx)
 	req := openai.ChatCompletionRequest{
-		Model:    openai.GPT4,
-		Messages: msg,
+		Model:       model,
+		Messages:    msg,
+		Temperature: params.Temperature,
+		MaxTokens:   params.MaxTokens,
 	}
 
-	resp, err := g.client.CreateChatCompletion(ctx, req)
-	if err != nil {
-		return "", err
+	for round := 0; ; round++ {
+		if len(tools) > 0 {
+			req.Tools = toolDefinitions(tools)
+			if round == maxToolRounds {
+				req.ToolChoice = "none"
+			}
+		}
+		if !g.breaker.Allow(time.Now()) {
+			return "", ErrUnavailable
+		}
+		resp, err := g.client.CreateChatCompletion(ctx, req)
+		if providerFailed(err) {
+			g.breaker.Failure(time.Now())
+		} else {
+			g.breaker.Success()
+		}
+		if err != nil {
+			return "", providerError(err)
+		}
+		g.mu.Lock()
+		g.usage.PromptTokens += resp.Usage.PromptTokens
+		g.usage.CompletionTokens += resp.Usage.CompletionTokens
+		g.usage.TotalTokens += resp.Usage.TotalTokens
+		g.mu.Unlock()
+		recordUsage(ctx, resp.Usage)
+		if len(resp.Choices) == 0 {
+			return "", fmt.Errorf("no choices in response")
+		}
+
+		answer := resp.Choices[len(resp.Choices)-1].Message
+		if len(answer.ToolCalls) == 0 || round == maxToolRounds {
+			return answer.Content, nil
+		}
+		req.Messages = append(req.Messages, answer)
+		for _, call := range answer.ToolCalls {
+			req.Messages = append(req.Messages, executeToolCall(ctx, tools, call, g.toolTimeout))
+		}
+	}
+}
+
+// modelOrDefault returns the model that is used when the given one is
+// empty.
+func modelOrDefault(model string) string {
+	if model == "" {
+		return openai.GPT4
+	}
+	return model
+}
+
+// providerError wraps the error of the provider in ErrRateLimited,
+// ErrContextTooLong or ErrNotAuthorized when it is one of those.
+func providerError(err error) error {
+	var status int
+	var code any
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	switch {
+	case errors.As(err, &apiErr):
+		status, code = apiErr.HTTPStatusCode, apiErr.Code
+	case errors.As(err, &reqErr):
+		status = reqErr.HTTPStatusCode
 	}
 
-	return resp.Choices[len(resp.Choices)-1].Message.Content, nil
+	switch {
+	case status == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %w", ErrRateLimited, err)
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return fmt.Errorf("%w: %w", ErrNotAuthorized, err)
+	case code == "context_length_exceeded":
+		return fmt.Errorf("%w: %w", ErrContextTooLong, err)
+	default:
+		return err
+	}
+}
+
+// providerFailed reports whether the error means the provider has problems,
+// as opposed to a request that was wrong or given up on.
+func providerFailed(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode == http.StatusTooManyRequests || apiErr.HTTPStatusCode >= 500
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode == http.StatusTooManyRequests || reqErr.HTTPStatusCode >= 500
+	}
+
+	return true
+}
+
+// Usage returns the number of tokens used since the start.
+func (g *GPT) Usage() openai.Usage {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.usage
+}
+
+// Available tells whether requests are let through, or the provider had too
+// many failures lately.
+func (g *GPT) Available() bool {
+	return !g.breaker.Open()
 }
@@ -0,0 +1,194 @@
+package bot_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+type echoTool struct{}
+
+func (echoTool) Name() string        { return "echo" }
+func (echoTool) Description() string { return "repeats the text" }
+func (echoTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"text":{"type":"string"}},"required":["text"]}`)
+}
+
+func (echoTool) Execute(_ context.Context, args json.RawMessage) (string, error) {
+	var a struct{ Text string }
+	if err := json.Unmarshal(args, &a); err != nil {
+		return "", err
+	}
+	if a.Text == "" {
+		return "", fmt.Errorf("no text")
+	}
+	return "echo: " + a.Text, nil
+}
+
+func TestGPTCompleteTools(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name      string
+		arguments string
+		expResult string
+		expFailed bool
+	}{
+		{name: "result", arguments: `{"text":"hi"}`, expResult: "echo: hi"},
+		{name: "error", arguments: `{}`, expResult: "error: no text", expFailed: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests []openai.ChatCompletionRequest
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req openai.ChatCompletionRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Errorf("exp nil, got %v", err)
+				}
+				requests = append(requests, req)
+				msg := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "done"}
+				if len(requests) == 1 {
+					msg = openai.ChatCompletionMessage{
+						Role: openai.ChatMessageRoleAssistant,
+						ToolCalls: []openai.ToolCall{{
+							ID:       "call-1",
+							Type:     openai.ToolTypeFunction,
+							Function: openai.FunctionCall{Name: "echo", Arguments: tc.arguments},
+						}},
+					}
+				}
+				_ = json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: msg}}})
+			}))
+			defer srv.Close()
+
+			cfg := openai.DefaultConfig("key")
+			cfg.BaseURL = srv.URL
+			trail := &bot.ToolTrail{}
+			ctx := bot.WithToolTrail(context.Background(), trail)
+			act, err := bot.NewGPTWithConfig(cfg).CompleteContext(ctx, "model", bot.NewConversation("", "system", "question"), echoTool{})
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			if act != "done" {
+				t.Errorf("exp done, got %v", act)
+			}
+			if len(requests) != 2 {
+				t.Fatalf("exp 2, got %v", len(requests))
+			}
+			if len(requests[0].Tools) != 1 || requests[0].Tools[0].Function.Name != "echo" {
+				t.Errorf("exp echo tool, got %v", requests[0].Tools)
+			}
+			last := requests[1].Messages[len(requests[1].Messages)-1]
+			if last.Role != openai.ChatMessageRoleTool || last.ToolCallID != "call-1" || last.Content != tc.expResult {
+				t.Errorf("exp %v, got %v", tc.expResult, last)
+			}
+			exp := []bot.ToolUse{{Name: "echo", Arguments: tc.arguments, Failed: tc.expFailed}}
+			if uses := trail.Uses(); !reflect.DeepEqual(exp, uses) {
+				t.Errorf("exp %v, got %v", exp, uses)
+			}
+		})
+	}
+}
+
+type slowTool struct{ echoTool }
+
+func (slowTool) Execute(ctx context.Context, _ json.RawMessage) (string, error) {
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-time.After(time.Second):
+		return "too late", nil
+	}
+}
+
+func TestGPTToolTimeout(t *testing.T) {
+	t.Parallel()
+
+	var result string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("exp nil, got %v", err)
+		}
+		msg := openai.ChatCompletionMessage{
+			Role: openai.ChatMessageRoleAssistant,
+			ToolCalls: []openai.ToolCall{{
+				ID:       "call-1",
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: "echo", Arguments: `{"text":"hi"}`},
+			}},
+		}
+		if last := req.Messages[len(req.Messages)-1]; last.Role == openai.ChatMessageRoleTool {
+			result = last.Content
+			msg = openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "done"}
+		}
+		_ = json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: msg}}})
+	}))
+	defer srv.Close()
+
+	cfg := openai.DefaultConfig("key")
+	cfg.BaseURL = srv.URL
+	gpt := bot.NewGPTWithConfig(cfg)
+	gpt.SetToolTimeout(10 * time.Millisecond)
+	if _, err := gpt.Complete("model", bot.NewConversation("", "system", "question"), slowTool{}); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if exp := "error: context deadline exceeded"; result != exp {
+		t.Errorf("exp %v, got %v", exp, result)
+	}
+}
+
+func TestGPTErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		status int
+		body   string
+		exp    error
+	}{
+		{
+			name:   "rate limited",
+			status: http.StatusTooManyRequests,
+			body:   `{"error":{"message":"slow down","type":"requests"}}`,
+			exp:    bot.ErrRateLimited,
+		},
+		{
+			name:   "not authorized",
+			status: http.StatusUnauthorized,
+			body:   `{"error":{"message":"invalid api key","type":"invalid_request_error","code":"invalid_api_key"}}`,
+			exp:    bot.ErrNotAuthorized,
+		},
+		{
+			name:   "context too long",
+			status: http.StatusBadRequest,
+			body:   `{"error":{"message":"too long","type":"invalid_request_error","code":"context_length_exceeded"}}`,
+			exp:    bot.ErrContextTooLong,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			}))
+			defer srv.Close()
+
+			cfg := openai.DefaultConfig("key")
+			cfg.BaseURL = srv.URL
+			_, err := bot.NewGPTWithConfig(cfg).Complete("model", bot.NewConversation("", "system", "question"))
+			if !errors.Is(err, tc.exp) {
+				t.Errorf("exp %v, got %v", tc.exp, err)
+			}
+		})
+	}
+}
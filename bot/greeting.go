@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"text/template"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/id"
+)
+
+// DefaultGreeting is posted when the config has no greeting of its own.
+const DefaultGreeting = `Hi, I am {{.Name}}. Ask me something by starting a message with "{{.Name}}: "{{if .AnswerUnaddressed}}, or just ask without addressing anyone{{end}}. Reply to one of my answers to continue the conversation.
+{{if .Personas}}
+You can also talk to {{range $i, $p := .Personas}}{{if $i}}, {{end}}{{$p}}{{end}}.
+{{end}}
+Commands: {{range $i, $c := .Commands}}{{if $i}}, {{end}}` + "`!{{$c}}`" + `{{end}}.
+
+Please note that the messages I answer, and the conversations they are part of, are sent to OpenAI to generate a reply.`
+
+// ConfigGreeting is the message the bot posts after joining a room. Template
+// is a Go template that can use the fields of GreetingData. Without one, the
+// default greeting is used, in the language of the room.
+type ConfigGreeting struct {
+	Disabled bool
+	Template string
+}
+
+func (cg ConfigGreeting) template() string {
+	if cg.Template == "" {
+		return DefaultGreeting
+	}
+
+	return cg.Template
+}
+
+// GreetingData is what a greeting template can use.
+type GreetingData struct {
+	Name              string
+	RoomID            id.RoomID
+	AnswerUnaddressed bool
+	Personas          []string
+	Commands          []string
+}
+
+// RenderGreeting executes a greeting template.
+func RenderGreeting(greeting string, data GreetingData) (string, error) {
+	tmpl, err := template.New("greeting").Parse(greeting)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func (m *Bot) validateGreeting() error {
+	if _, err := template.New("greeting").Parse(m.config.Greeting.template()); err != nil {
+		return fmt.Errorf("invalid greeting template: %w", err)
+	}
+
+	return nil
+}
+
+// greet posts the greeting in a room the bot just joined.
+func (m *Bot) greet(roomID id.RoomID) {
+	if m.config.Greeting.Disabled {
+		return
+	}
+	data := GreetingData{
+		Name:              m.config.Profile.DisplayName,
+		RoomID:            roomID,
+		AnswerUnaddressed: m.answerUnaddressed(roomID),
+	}
+	if data.Name == "" {
+		data.Name = m.config.UserDisplayName
+	}
+	for _, p := range m.listPersonas() {
+		if p.Trigger != "" {
+			data.Personas = append(data.Personas, p.Trigger)
+		}
+	}
+	sort.Strings(data.Personas)
+	for name := range m.commands {
+		data.Commands = append(data.Commands, name)
+	}
+	sort.Strings(data.Commands)
+
+	greeting := m.config.Greeting.Template
+	if greeting == "" {
+		greeting = m.text(m.roomLanguage(roomID), msgGreeting)
+	}
+	text, err := RenderGreeting(greeting, data)
+	if err != nil {
+		m.logger.Error("failed to render greeting", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+		return
+	}
+	m.sendNotice(roomID, "", text)
+}
@@ -0,0 +1,51 @@
+package bot_test
+
+import (
+	"strings"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestRenderGreeting(t *testing.T) {
+	t.Parallel()
+
+	data := bot.GreetingData{
+		Name:     "GoGPT",
+		RoomID:   "!room:server",
+		Personas: []string{"helpdesk", "poet"},
+		Commands: []string{"help", "remind"},
+	}
+	for _, tc := range []struct {
+		name     string
+		greeting string
+		exp      string
+		expErr   bool
+	}{
+		{name: "plain", greeting: "Hello {{.RoomID}}!", exp: "Hello !room:server!"},
+		{name: "invalid", greeting: "{{.Missing", expErr: true},
+		{name: "unknown field", greeting: "{{.Missing}}", expErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := bot.RenderGreeting(tc.greeting, data)
+			if (err != nil) != tc.expErr {
+				t.Fatalf("exp error %v, got %v", tc.expErr, err)
+			}
+			if act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+
+	t.Run("default", func(t *testing.T) {
+		act, err := bot.RenderGreeting(bot.DefaultGreeting, data)
+		if err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+		for _, exp := range []string{`"GoGPT: "`, "helpdesk, poet", "`!help`, `!remind`", "OpenAI"} {
+			if !strings.Contains(act, exp) {
+				t.Errorf("exp %v in %v", exp, act)
+			}
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package bot
+
+import (
+	"sort"
+	"sync"
+
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	PriorityRule      = 200
+	PriorityForward   = 150
+	PriorityScript    = 100
+	PriorityTranslate = 75
+	PriorityCorrect   = 70
+	PriorityKarma     = 60
+	PriorityCommand   = 50
+	PriorityQuiz      = 45
+	PriorityStandup   = 40
+	PriorityPersona   = 10
+	PriorityChat      = 0
+)
+
+// MessageHandler processes incoming messages. Handlers are run in order of
+// descending priority. A handler that returns true has consumed the message
+// and handlers with a lower priority will not see it.
+type MessageHandler interface {
+	Name() string
+	Priority() int
+	HandleMessage(evt *event.Event) bool
+}
+
+type messageHandlerFunc struct {
+	name     string
+	priority int
+	fn       func(evt *event.Event) bool
+}
+
+func NewMessageHandler(name string, priority int, fn func(evt *event.Event) bool) MessageHandler {
+	return &messageHandlerFunc{
+		name:     name,
+		priority: priority,
+		fn:       fn,
+	}
+}
+
+func (h *messageHandlerFunc) Name() string                        { return h.name }
+func (h *messageHandlerFunc) Priority() int                       { return h.priority }
+func (h *messageHandlerFunc) HandleMessage(evt *event.Event) bool { return h.fn(evt) }
+
+type Dispatcher struct {
+	handlers []MessageHandler
+	enabled  func(evt *event.Event, name string) bool
+	mu       sync.RWMutex
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		handlers: make([]MessageHandler, 0),
+	}
+}
+
+// Register adds a handler. Handlers with equal priority run in the order
+// they were registered.
+func (d *Dispatcher) Register(h MessageHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.handlers = append(d.handlers, h)
+	sort.SliceStable(d.handlers, func(i, j int) bool {
+		return d.handlers[i].Priority() > d.handlers[j].Priority()
+	})
+}
+
+// SetEnabledFunc sets a check that decides per event whether a handler may
+// run. Without it, all handlers run.
+func (d *Dispatcher) SetEnabledFunc(fn func(evt *event.Event, name string) bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.enabled = fn
+}
+
+func (d *Dispatcher) Handlers() []MessageHandler {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return append([]MessageHandler{}, d.handlers...)
+}
+
+func (d *Dispatcher) Handler(name string) (MessageHandler, bool) {
+	for _, h := range d.Handlers() {
+		if h.Name() == name {
+			return h, true
+		}
+	}
+
+	return nil, false
+}
+
+// Dispatch passes the event to the handlers and returns the name of the
+// handler that consumed it, if any.
+func (d *Dispatcher) Dispatch(evt *event.Event) (string, bool) {
+	d.mu.RLock()
+	enabled := d.enabled
+	d.mu.RUnlock()
+
+	for _, h := range d.Handlers() {
+		if enabled != nil && !enabled(evt, h.Name()) {
+			continue
+		}
+		if h.HandleMessage(evt) {
+			return h.Name(), true
+		}
+	}
+
+	return "", false
+}
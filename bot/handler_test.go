@@ -0,0 +1,36 @@
+package bot_test
+
+import (
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestDispatcher_Dispatch(t *testing.T) {
+	t.Parallel()
+
+	var called []string
+	handler := func(name string, priority int, consume bool) bot.MessageHandler {
+		return bot.NewMessageHandler(name, priority, func(evt *event.Event) bool {
+			called = append(called, name)
+			return consume
+		})
+	}
+
+	d := bot.NewDispatcher()
+	d.Register(handler("low", 0, true))
+	d.Register(handler("high", 100, false))
+	d.Register(handler("mid", 50, true))
+
+	name, ok := d.Dispatch(&event.Event{})
+	if !ok {
+		t.Fatal("exp message to be consumed")
+	}
+	if name != "mid" {
+		t.Errorf("exp mid, got %s", name)
+	}
+	if len(called) != 2 || called[0] != "high" || called[1] != "mid" {
+		t.Errorf("exp [high mid], got %v", called)
+	}
+}
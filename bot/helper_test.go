@@ -0,0 +1,73 @@
+package bot_test
+
+import (
+	"io"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/util/dbutil"
+)
+
+func newTestStore(t *testing.T) *bot.Store {
+	t.Helper()
+
+	db, err := dbutil.NewWithDialect(":memory:", "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.RawDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.RawDB.Close() })
+	store, err := bot.NewStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+// initTestBot creates a bot that handles messages without a homeserver, with
+// a store in memory, a FakeMatrix and a FakeProvider. The options come after
+// those, so they can replace them, like WithMatrix with a FakeMatrix the test
+// looks at.
+func initTestBot(t *testing.T, cfg bot.ConfigBot, opts ...bot.Option) (*bot.Bot, error) {
+	t.Helper()
+
+	b := bot.New(cfg, append([]bot.Option{
+		bot.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		bot.WithStore(newTestStore(t)),
+		bot.WithMatrix(bot.NewFakeMatrix()),
+		bot.WithProvider(bot.NewFakeProvider()),
+	}, opts...)...)
+
+	return b, b.Init(false)
+}
+
+// newTestBot is initTestBot for a config that is valid.
+func newTestBot(t *testing.T, cfg bot.ConfigBot, opts ...bot.Option) *bot.Bot {
+	t.Helper()
+
+	b, err := initTestBot(t, cfg, opts...)
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+
+	return b
+}
+
+func testMessage(eventID id.EventID, body string, replyTo id.EventID) *event.Event {
+	content := &event.MessageEventContent{MsgType: event.MsgText, Body: body}
+	if replyTo != "" {
+		content.RelatesTo = &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: replyTo}}
+	}
+
+	return &event.Event{
+		ID:      eventID,
+		RoomID:  "!room:ewintr.nl",
+		Sender:  "@someone:ewintr.nl",
+		Type:    event.EventMessage,
+		Content: event.Content{Parsed: content},
+	}
+}
@@ -0,0 +1,212 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	homeAssistantTimeout = 15 * time.Second
+	homeAssistantMaxBody = 4 << 20
+)
+
+// ConfigHomeAssistant connects the home_assistant tool to an instance, with a
+// long-lived access token. Only the Entities that are listed can be used.
+type ConfigHomeAssistant struct {
+	URL      string
+	Token    string
+	Entities []ConfigEntity
+}
+
+// ConfigEntity gives access to an entity, or to all entities that match a
+// pattern like "light.*". Users lists who may use it, everyone when empty.
+// With Control they can also switch it, lock it and so on, otherwise they can
+// only see its state.
+type ConfigEntity struct {
+	ID      string
+	Users   []string
+	Control bool
+}
+
+type haState struct {
+	EntityID   string         `json:"entity_id"`
+	State      string         `json:"state"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// HomeAssistantTool lets users ask about and control their home.
+type HomeAssistantTool struct {
+	config ConfigHomeAssistant
+	client *http.Client
+}
+
+func NewHomeAssistantTool(cfg ConfigHomeAssistant) (*HomeAssistantTool, error) {
+	if cfg.URL == "" || cfg.Token == "" {
+		return nil, fmt.Errorf("home assistant needs a url and a token")
+	}
+	for _, e := range cfg.Entities {
+		if _, err := path.Match(e.ID, ""); err != nil {
+			return nil, fmt.Errorf("invalid entity pattern %q: %w", e.ID, err)
+		}
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+
+	return &HomeAssistantTool{
+		config: cfg,
+		client: &http.Client{Timeout: homeAssistantTimeout},
+	}, nil
+}
+
+func (h *HomeAssistantTool) Name() string { return "home_assistant" }
+
+func (h *HomeAssistantTool) Description() string {
+	return "See and control the devices in the home of the user with Home Assistant. Use list to find the entity ids and their state, state for one entity and call to run a service like turn_on, turn_off, toggle, lock or unlock on an entity."
+}
+
+func (h *HomeAssistantTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{` +
+		`"action":{"type":"string","enum":["list","state","call"]},` +
+		`"entity_id":{"type":"string","description":"like light.office, for state and call"},` +
+		`"service":{"type":"string","description":"the service to call, like turn_off"}},` +
+		`"required":["action"]}`)
+}
+
+func (h *HomeAssistantTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	var a struct {
+		Action   string `json:"action"`
+		EntityID string `json:"entity_id"`
+		Service  string `json:"service"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return "", err
+	}
+	caller, _ := CallerFromContext(ctx)
+	user := caller.UserID.String()
+
+	switch a.Action {
+	case "list":
+		return h.List(ctx, user)
+	case "state":
+		if _, ok := h.allowed(a.EntityID, user); !ok {
+			return "", fmt.Errorf("%s is not available to %s", a.EntityID, user)
+		}
+		var s haState
+		if err := h.do(ctx, http.MethodGet, "/api/states/"+a.EntityID, nil, &s); err != nil {
+			return "", err
+		}
+		return formatHAState(s), nil
+	case "call":
+		return h.Call(ctx, user, a.EntityID, a.Service)
+	default:
+		return "", fmt.Errorf("unknown action %q", a.Action)
+	}
+}
+
+// List returns the state of the entities the user may see.
+func (h *HomeAssistantTool) List(ctx context.Context, user string) (string, error) {
+	var states []haState
+	if err := h.do(ctx, http.MethodGet, "/api/states", nil, &states); err != nil {
+		return "", err
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].EntityID < states[j].EntityID })
+	var lines []string
+	for _, s := range states {
+		if _, ok := h.allowed(s.EntityID, user); ok {
+			lines = append(lines, formatHAState(s))
+		}
+	}
+	if len(lines) == 0 {
+		return "No entities available.", nil
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// Call runs a service of the domain of the entity, like light.turn_off, if
+// the user may control it.
+func (h *HomeAssistantTool) Call(ctx context.Context, user, entityID, service string) (string, error) {
+	control, ok := h.allowed(entityID, user)
+	if !ok || !control {
+		return "", fmt.Errorf("%s may not control %s", user, entityID)
+	}
+	domain, _, _ := strings.Cut(entityID, ".")
+	if service == "" || strings.ContainsAny(service, "/.") {
+		return "", fmt.Errorf("invalid service %q", service)
+	}
+	body, err := json.Marshal(map[string]string{"entity_id": entityID})
+	if err != nil {
+		return "", err
+	}
+	var changed []haState
+	if err := h.do(ctx, http.MethodPost, fmt.Sprintf("/api/services/%s/%s", domain, service), body, &changed); err != nil {
+		return "", err
+	}
+	for _, s := range changed {
+		if s.EntityID == entityID {
+			return fmt.Sprintf("Called %s.%s, now %s", domain, service, formatHAState(s)), nil
+		}
+	}
+
+	return fmt.Sprintf("Called %s.%s on %s.", domain, service, entityID), nil
+}
+
+// allowed reports whether the user may control the entity, and, second,
+// whether they may use it at all. The first matching entry counts.
+func (h *HomeAssistantTool) allowed(entityID, user string) (bool, bool) {
+	if entityID == "" || strings.Contains(entityID, "/") {
+		return false, false
+	}
+	for _, e := range h.config.Entities {
+		if ok, _ := path.Match(e.ID, entityID); !ok {
+			continue
+		}
+		if len(e.Users) > 0 && !contains(e.Users, user) {
+			return false, false
+		}
+		return e.Control, true
+	}
+
+	return false, false
+}
+
+func (h *HomeAssistantTool) do(ctx context.Context, method, p string, body []byte, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, h.config.URL+p, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+h.config.Token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("not found")
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return fmt.Errorf("home assistant returned status %d", res.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, homeAssistantMaxBody)).Decode(v)
+}
+
+func formatHAState(s haState) string {
+	text := fmt.Sprintf("%s: %s", s.EntityID, s.State)
+	if name, ok := s.Attributes["friendly_name"].(string); ok && name != "" {
+		text = fmt.Sprintf("%s (%s): %s", s.EntityID, name, s.State)
+	}
+	if unit, ok := s.Attributes["unit_of_measurement"].(string); ok {
+		text += " " + unit
+	}
+
+	return text
+}
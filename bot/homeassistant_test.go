@@ -0,0 +1,129 @@
+package bot_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestHomeAssistantTool(t *testing.T) {
+	t.Parallel()
+
+	var called []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/states":
+			_, _ = w.Write([]byte(`[
+				{"entity_id":"lock.front_door","state":"locked","attributes":{"friendly_name":"Front door"}},
+				{"entity_id":"light.office","state":"on","attributes":{}},
+				{"entity_id":"switch.heater","state":"off","attributes":{}},
+				{"entity_id":"sensor.temperature","state":"21.5","attributes":{"unit_of_measurement":"°C"}}
+			]`))
+		case "/api/states/lock.front_door":
+			_, _ = w.Write([]byte(`{"entity_id":"lock.front_door","state":"locked","attributes":{"friendly_name":"Front door"}}`))
+		case "/api/services/light/turn_off":
+			called = append(called, r.URL.Path)
+			_, _ = w.Write([]byte(`[{"entity_id":"light.office","state":"off","attributes":{}}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	ha, err := bot.NewHomeAssistantTool(bot.ConfigHomeAssistant{
+		URL:   srv.URL,
+		Token: "secret",
+		Entities: []bot.ConfigEntity{
+			{ID: "lock.front_door", Users: []string{"@erik:ewintr.nl"}, Control: true},
+			{ID: "light.*", Control: true},
+			{ID: "sensor.*"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		user   id.UserID
+		args   map[string]string
+		exp    string
+		expErr bool
+	}{
+		{
+			name: "list for owner",
+			user: "@erik:ewintr.nl",
+			args: map[string]string{"action": "list"},
+			exp:  "light.office: on\nlock.front_door (Front door): locked\nsensor.temperature: 21.5 °C",
+		},
+		{
+			name: "list for guest",
+			user: "@guest:ewintr.nl",
+			args: map[string]string{"action": "list"},
+			exp:  "light.office: on\nsensor.temperature: 21.5 °C",
+		},
+		{
+			name: "state",
+			user: "@erik:ewintr.nl",
+			args: map[string]string{"action": "state", "entity_id": "lock.front_door"},
+			exp:  "lock.front_door (Front door): locked",
+		},
+		{
+			name:   "state not allowed",
+			user:   "@guest:ewintr.nl",
+			args:   map[string]string{"action": "state", "entity_id": "lock.front_door"},
+			expErr: true,
+		},
+		{
+			name:   "state not listed",
+			user:   "@erik:ewintr.nl",
+			args:   map[string]string{"action": "state", "entity_id": "switch.heater"},
+			expErr: true,
+		},
+		{
+			name: "call",
+			user: "@guest:ewintr.nl",
+			args: map[string]string{"action": "call", "entity_id": "light.office", "service": "turn_off"},
+			exp:  "Called light.turn_off, now light.office: off",
+		},
+		{
+			name:   "call without control",
+			user:   "@erik:ewintr.nl",
+			args:   map[string]string{"action": "call", "entity_id": "sensor.temperature", "service": "turn_off"},
+			expErr: true,
+		},
+		{
+			name:   "call other domain",
+			user:   "@erik:ewintr.nl",
+			args:   map[string]string{"action": "call", "entity_id": "light.office", "service": "../../states"},
+			expErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args, err := json.Marshal(tc.args)
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			ctx := bot.WithCaller(context.Background(), bot.Caller{UserID: tc.user})
+			act, err := ha.Execute(ctx, args)
+			if (err != nil) != tc.expErr {
+				t.Fatalf("exp error %v, got %v", tc.expErr, err)
+			}
+			if act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+	if len(called) != 1 {
+		t.Errorf("exp 1, got %v", len(called))
+	}
+}
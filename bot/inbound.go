@@ -0,0 +1,237 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	inboundMaxBody     = 1 << 20
+	inboundPathPrefix  = "/hook/"
+	inboundReadTimeout = 10 * time.Second
+)
+
+// ConfigHook lets external systems post to a room. A POST with a JSON body to
+// /hook/<Name> on the inbound server is rendered with Template, a Go template
+// that gets the decoded JSON as data, and sent to Room as markdown. Without a
+// template, the JSON itself is posted. The Token must be passed in the
+// Authorization header as "Bearer <token>". With Format "alertmanager" the
+// body is read as an Alertmanager notification instead and posted as HTML.
+// With Format "github" or "gitea" the body is a webhook event of that forge,
+// signed with Token as secret.
+type ConfigHook struct {
+	Name     string
+	Token    string
+	Room     string
+	Template string
+	Format   string
+}
+
+// ConfigInbound is the address of the inbound server. With a UsageToken, it
+// also exports the usage of all bots as CSV, see EnableUsageExport.
+type ConfigInbound struct {
+	Addr       string
+	UsageToken string
+}
+
+type inboundHook struct {
+	config ConfigHook
+	tmpl   *template.Template
+	send   func(roomID id.RoomID, text string) error
+}
+
+// InboundServer receives the hooks of all bots.
+type InboundServer struct {
+	hooks      map[string]*inboundHook
+	bots       []*Bot
+	usageToken string
+	srv        *http.Server
+	logger     *slog.Logger
+	mu         sync.RWMutex
+}
+
+func NewInboundServer(logger *slog.Logger) *InboundServer {
+	return &InboundServer{
+		hooks:  make(map[string]*inboundHook),
+		logger: logger,
+	}
+}
+
+// AddHook makes the hook available. send posts the rendered text, which is
+// markdown, or HTML for the alertmanager format.
+func (s *InboundServer) AddHook(cfg ConfigHook, send func(roomID id.RoomID, text string) error) error {
+	if cfg.Name == "" || cfg.Token == "" || cfg.Room == "" {
+		return fmt.Errorf("hook needs a name, token and room")
+	}
+	switch cfg.Format {
+	case "", HookFormatAlertmanager, ForgeGitHub, ForgeGitea:
+	default:
+		return fmt.Errorf("unknown format %q for hook %s", cfg.Format, cfg.Name)
+	}
+	h := &inboundHook{config: cfg, send: send}
+	if cfg.Template != "" {
+		tmpl, err := template.New(cfg.Name).Parse(cfg.Template)
+		if err != nil {
+			return fmt.Errorf("invalid template for hook %s: %w", cfg.Name, err)
+		}
+		h.tmpl = tmpl
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.hooks[cfg.Name]; ok {
+		return fmt.Errorf("duplicate hook %s", cfg.Name)
+	}
+	s.hooks[cfg.Name] = h
+
+	return nil
+}
+
+// Register adds the hooks of the bot, and its usage to the export.
+func (s *InboundServer) Register(b *Bot) error {
+	for _, cfg := range b.config.Hooks {
+		send := b.SendMarkdown
+		if cfg.Format == HookFormatAlertmanager {
+			send = b.SendHTML
+		}
+		if err := s.AddHook(cfg, send); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.bots = append(s.bots, b)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// ListenAndServe accepts hooks on addr until Shutdown is called, then it
+// returns http.ErrServerClosed.
+func (s *InboundServer) ListenAndServe(addr string) error {
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     s,
+		ReadTimeout: inboundReadTimeout,
+	}
+	s.mu.Lock()
+	s.srv = srv
+	s.mu.Unlock()
+
+	return srv.ListenAndServe()
+}
+
+// Shutdown stops accepting hooks and waits for the ones that are being
+// delivered.
+func (s *InboundServer) Shutdown(ctx context.Context) error {
+	s.mu.RLock()
+	srv := s.srv
+	s.mu.RUnlock()
+	if srv == nil {
+		return nil
+	}
+
+	return srv.Shutdown(ctx)
+}
+
+func (s *InboundServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == inboundUsagePath {
+		s.serveUsage(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, inboundPathPrefix)
+	s.mu.RLock()
+	h, ok := s.hooks[name]
+	s.mu.RUnlock()
+	if !ok || !strings.HasPrefix(r.URL.Path, inboundPathPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, inboundMaxBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.authorized(r, body) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	text, err := h.render(r, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if text == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := h.send(id.RoomID(h.config.Room), text); err != nil {
+		s.logger.Error("failed to post hook", slog.String("err", err.Error()), slog.String("hook", name))
+		http.Error(w, "failed to post message", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorized checks the bearer token or, for forges, the signature of the
+// body.
+func (h *inboundHook) authorized(r *http.Request, body []byte) bool {
+	var got string
+	exp := h.config.Token
+	switch h.config.Format {
+	case ForgeGitHub:
+		got, exp = r.Header.Get("X-Hub-Signature-256"), "sha256="+Sign(h.config.Token, body)
+	case ForgeGitea:
+		got, exp = r.Header.Get("X-Gitea-Signature"), Sign(h.config.Token, body)
+	default:
+		got = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+
+	return subtle.ConstantTimeCompare([]byte(got), []byte(exp)) == 1
+}
+
+// render returns the text to post for the request. An empty text means there
+// is nothing to post.
+func (h *inboundHook) render(r *http.Request, body []byte) (string, error) {
+	switch h.config.Format {
+	case HookFormatAlertmanager:
+		return RenderAlertmanager(body)
+	case ForgeGitHub:
+		return RenderForgeEvent(r.Header.Get("X-GitHub-Event"), body)
+	case ForgeGitea:
+		return RenderForgeEvent(r.Header.Get("X-Gitea-Event"), body)
+	}
+	var data any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return "", fmt.Errorf("invalid json: %w", err)
+	}
+	if h.tmpl == nil {
+		pretty, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("```json\n%s\n```", pretty), nil
+	}
+	var buf bytes.Buffer
+	if err := h.tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,121 @@
+package bot_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestInboundServer(t *testing.T) {
+	t.Parallel()
+
+	var (
+		room id.RoomID
+		text string
+	)
+	send := func(roomID id.RoomID, t string) error {
+		room, text = roomID, t
+		return nil
+	}
+	s := bot.NewInboundServer(slog.Default())
+	if err := s.AddHook(bot.ConfigHook{Name: "ci", Token: "secret", Room: "!room:server", Template: "Build {{.status}} for {{.repo}}"}, send); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if err := s.AddHook(bot.ConfigHook{Name: "ci", Token: "other", Room: "!other:server"}, send); err == nil {
+		t.Error("exp error for duplicate hook")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		method  string
+		path    string
+		token   string
+		body    string
+		expCode int
+		expText string
+	}{
+		{name: "ok", method: http.MethodPost, path: "/hook/ci", token: "secret", body: `{"status":"failed","repo":"bots"}`, expCode: http.StatusNoContent, expText: "Build failed for bots"},
+		{name: "wrong token", method: http.MethodPost, path: "/hook/ci", token: "guess", body: `{}`, expCode: http.StatusUnauthorized},
+		{name: "unknown hook", method: http.MethodPost, path: "/hook/cd", token: "secret", body: `{}`, expCode: http.StatusNotFound},
+		{name: "get", method: http.MethodGet, path: "/hook/ci", token: "secret", expCode: http.StatusMethodNotAllowed},
+		{name: "invalid json", method: http.MethodPost, path: "/hook/ci", token: "secret", body: `{`, expCode: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			room, text = "", ""
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expCode {
+				t.Errorf("exp %v, got %v", tc.expCode, rec.Code)
+			}
+			if text != tc.expText {
+				t.Errorf("exp %v, got %v", tc.expText, text)
+			}
+			if tc.expText != "" && room != "!room:server" {
+				t.Errorf("exp !room:server, got %v", room)
+			}
+		})
+	}
+}
+
+func TestInboundServer_Usage(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	day := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	for _, r := range []bot.UsageRecord{
+		{EventID: "$1", Conversation: "$1", RoomID: "!a:ewintr.nl", UserID: "@one:ewintr.nl", Model: "gpt-4o", PromptTokens: 10, CompletionTokens: 5, Cost: 0.000125, CreatedAt: day},
+		{EventID: "$2", Conversation: "$1", RoomID: "!a:ewintr.nl", UserID: "@two:ewintr.nl", Model: "gpt-4o", Failed: true, CreatedAt: day.AddDate(0, 1, 0)},
+	} {
+		if err := store.AddUsage(r); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+	}
+	b := newTestBot(t, bot.ConfigBot{UserID: "@bot:ewintr.nl"}, bot.WithStore(store))
+	s := bot.NewInboundServer(slog.Default())
+	if err := s.Register(b); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	s.EnableUsageExport("secret")
+
+	for _, tc := range []struct {
+		name    string
+		query   string
+		token   string
+		expCode int
+		expBody string
+	}{
+		{name: "wrong token", query: "?from=2024-05-01&to=2024-05-31", token: "guess", expCode: http.StatusUnauthorized},
+		{name: "invalid day", query: "?from=may", token: "secret", expCode: http.StatusBadRequest},
+		{
+			name:    "may",
+			query:   "?from=2024-05-01&to=2024-05-31",
+			token:   "secret",
+			expCode: http.StatusOK,
+			expBody: "bot,day,created_at,room_id,user_id,conversation,event_id,model,prompt_tokens,completion_tokens,failed,cost\n" +
+				"@bot:ewintr.nl,2024-05-10,2024-05-10T12:00:00Z,!a:ewintr.nl,@one:ewintr.nl,$1,$1,gpt-4o,10,5,false,0.000125\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/usage"+tc.query, nil)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expCode {
+				t.Errorf("exp %v, got %v", tc.expCode, rec.Code)
+			}
+			if tc.expBody != "" && rec.Body.String() != tc.expBody {
+				t.Errorf("exp %v, got %v", tc.expBody, rec.Body.String())
+			}
+		})
+	}
+}
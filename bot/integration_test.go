@@ -0,0 +1,269 @@
+//go:build integration
+
+package bot_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/cryptohelper"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/util/dbutil"
+)
+
+const (
+	conduitImage    = "matrixconduit/matrix-conduit:v0.6.0"
+	testPassword    = "integration-test-password"
+	testWaitTimeout = 30 * time.Second
+)
+
+// TestIntegration runs a bot against a real homeserver and follows the whole
+// path: an invite, an encrypted question, the answer, and a reply to that
+// answer that continues the conversation. Run it with
+//
+//	go test -tags integration -run TestIntegration ./bot
+//
+// It starts Conduit in Docker, unless MATRIX_TEST_HOMESERVER points to a
+// homeserver that allows registration.
+func TestIntegration(t *testing.T) {
+	t.Parallel()
+
+	hs := testHomeserver(t)
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+	botID := registerUser(t, hs, "bot"+suffix)
+	aliceID := registerUser(t, hs, "alice"+suffix)
+
+	fp := bot.NewFakeProvider()
+	fp.Script("Sunny.", "Rain.")
+	startBot(t, bot.ConfigBot{
+		Homeserver:        hs,
+		UserID:            botID.String(),
+		UserPassword:      testPassword,
+		UserDisplayName:   "testbot",
+		DBPath:            filepath.Join(t.TempDir(), "bot.db"),
+		Pickle:            "integration",
+		AnswerUnaddressed: true,
+	}, fp)
+
+	alice, replies := cryptoClient(t, hs, aliceID, botID)
+
+	// invite the bot to an encrypted room
+	encryption := event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}
+	resp, err := alice.CreateRoom(&mautrix.ReqCreateRoom{
+		Preset: "private_chat",
+		Invite: []id.UserID{botID},
+		InitialState: []*event.Event{{
+			Type:    event.StateEncryption,
+			Content: event.Content{Parsed: &encryption},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	roomID := resp.RoomID
+	waitFor(t, "bot to join", func() bool {
+		return alice.StateStore.IsEncrypted(roomID) && alice.StateStore.IsMembership(roomID, botID, event.MembershipJoin)
+	})
+
+	// a question and the answer
+	question, err := alice.SendMessageEvent(roomID, event.EventMessage, &event.MessageEventContent{
+		MsgType: event.MsgText,
+		Body:    "What is the weather?",
+	})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	answer := waitForReply(t, replies)
+	if act := answer.Content.AsMessage().Body; act != "Sunny." {
+		t.Errorf("exp Sunny., got %v", act)
+	}
+	if !answer.Mautrix.WasEncrypted {
+		t.Errorf("exp encrypted answer, got plain text")
+	}
+	if act := answer.Content.AsMessage().GetRelatesTo().GetReplyTo(); act != question.EventID {
+		t.Errorf("exp %v, got %v", question.EventID, act)
+	}
+
+	// continue the conversation by replying to the answer
+	followUp := &event.MessageEventContent{MsgType: event.MsgText, Body: "And tomorrow?"}
+	followUp.RelatesTo = &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: answer.ID}}
+	if _, err := alice.SendMessageEvent(roomID, event.EventMessage, followUp); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	answer = waitForReply(t, replies)
+	if act := answer.Content.AsMessage().Body; act != "Rain." {
+		t.Errorf("exp Rain., got %v", act)
+	}
+	reqs := fp.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("exp 2, got %v", len(reqs))
+	}
+	// system prompt, question, answer and follow-up
+	if act := len(reqs[1].Messages); act != 4 {
+		t.Errorf("exp 4, got %v", act)
+	}
+}
+
+// testHomeserver returns the URL of the homeserver in MATRIX_TEST_HOMESERVER,
+// or starts Conduit in Docker and removes it when the test is done.
+func testHomeserver(t *testing.T) string {
+	t.Helper()
+
+	if hs := os.Getenv("MATRIX_TEST_HOMESERVER"); hs != "" {
+		return hs
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker not found and MATRIX_TEST_HOMESERVER not set")
+	}
+	out, err := exec.Command("docker", "run", "-d", "--rm",
+		"-p", "127.0.0.1::6167",
+		"-e", "CONDUIT_CONFIG=",
+		"-e", "CONDUIT_SERVER_NAME=localhost",
+		"-e", "CONDUIT_DATABASE_BACKEND=rocksdb",
+		"-e", "CONDUIT_DATABASE_PATH=/var/lib/matrix-conduit/",
+		"-e", "CONDUIT_ADDRESS=0.0.0.0",
+		"-e", "CONDUIT_PORT=6167",
+		"-e", "CONDUIT_ALLOW_REGISTRATION=true",
+		"-e", "CONDUIT_ALLOW_FEDERATION=false",
+		"-e", "CONDUIT_TRUSTED_SERVERS=[]",
+		conduitImage,
+	).Output()
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	container := strings.TrimSpace(string(out))
+	t.Cleanup(func() {
+		if err := exec.Command("docker", "stop", container).Run(); err != nil {
+			t.Errorf("exp nil, got %v", err)
+		}
+	})
+	out, err = exec.Command("docker", "port", container, "6167/tcp").Output()
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	addr, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	hs := "http://" + addr
+	waitFor(t, "homeserver to start", func() bool {
+		resp, err := http.Get(hs + "/_matrix/client/versions")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	})
+
+	return hs
+}
+
+func registerUser(t *testing.T, hs, name string) id.UserID {
+	t.Helper()
+
+	client, err := mautrix.NewClient(hs, "", "")
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	resp, err := client.RegisterDummy(&mautrix.ReqRegister{
+		Username:     name,
+		Password:     testPassword,
+		InhibitLogin: true,
+	})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+
+	return resp.UserID
+}
+
+func startBot(t *testing.T, cfg bot.ConfigBot, p bot.Provider) {
+	t.Helper()
+
+	b := bot.New(cfg, bot.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))), bot.WithProvider(p))
+	if err := b.Init(true); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	go b.Run()
+	t.Cleanup(func() {
+		if err := b.Close(); err != nil {
+			t.Errorf("exp nil, got %v", err)
+		}
+	})
+}
+
+// cryptoClient logs in as the user with encryption and starts syncing. The
+// messages of from arrive on the channel, decrypted.
+func cryptoClient(t *testing.T, hs string, userID, from id.UserID) (*mautrix.Client, chan *event.Event) {
+	t.Helper()
+
+	client, err := mautrix.NewClient(hs, userID, "")
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	db, err := dbutil.NewWithDialect(filepath.Join(t.TempDir(), "client.db"), "sqlite3")
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	helper, err := cryptohelper.NewCryptoHelper(client, []byte("integration"), db)
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	helper.LoginAs = &mautrix.ReqLogin{
+		Type:       mautrix.AuthTypePassword,
+		Identifier: mautrix.UserIdentifier{Type: mautrix.IdentifierTypeUser, User: userID.String()},
+		Password:   testPassword,
+	}
+	if err := helper.Init(); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	client.Crypto = helper
+
+	messages := make(chan *event.Event, 10)
+	client.Syncer.(*mautrix.DefaultSyncer).OnEventType(event.EventMessage, func(_ mautrix.EventSource, evt *event.Event) {
+		if evt.Sender == from {
+			messages <- evt
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go client.SyncWithContext(ctx)
+	t.Cleanup(func() {
+		cancel()
+		helper.Close()
+	})
+
+	return client, messages
+}
+
+func waitForReply(t *testing.T, replies chan *event.Event) *event.Event {
+	t.Helper()
+
+	select {
+	case evt := <-replies:
+		return evt
+	case <-time.After(testWaitTimeout):
+		t.Fatalf("exp reply, got none after %v", testWaitTimeout)
+		return nil
+	}
+}
+
+func waitFor(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testWaitTimeout)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("exp %s, got timeout after %v", what, testWaitTimeout)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
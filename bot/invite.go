@@ -0,0 +1,167 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Invite is an invite that waits for an admin to approve or deny it.
+type Invite struct {
+	RoomID    id.RoomID
+	Inviter   id.UserID
+	InvitedAt time.Time
+}
+
+// acceptInvite joins the room the bot was invited to.
+func (m *Bot) acceptInvite(roomID id.RoomID, inviter id.UserID) error {
+	if _, err := m.matrix.JoinRoom(roomID.String()); err != nil {
+		return err
+	}
+	m.logger.Info("joined room after invite", slog.String("room_id", roomID.String()), slog.String("inviter", inviter.String()), slog.String("bot", m.config.UserDisplayName))
+	m.joined(roomID)
+
+	return nil
+}
+
+// queueInvite keeps the invite until an admin approves or denies it, and
+// tells the admins about it, in the admin room or in a direct chat.
+func (m *Bot) queueInvite(roomID id.RoomID, inviter id.UserID) {
+	if err := m.store.AddInvite(Invite{RoomID: roomID, Inviter: inviter, InvitedAt: time.Now()}); err != nil {
+		m.logger.Error("failed to queue invite", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+		return
+	}
+	m.logger.Info("invite waits for approval", slog.String("room_id", roomID.String()), slog.String("inviter", inviter.String()), slog.String("bot", m.config.UserDisplayName))
+	m.notifyAdmins(fmt.Sprintf("%s invited me to %s. Use `!approve %s` to join or `!deny %s` to decline.", inviter, roomID, roomID, roomID))
+}
+
+func (m *Bot) approveCommand() Command {
+	return Command{
+		Name:      "approve",
+		Usage:     "[room id]",
+		Help:      "list the invites that wait for approval, or accept one",
+		AdminOnly: true,
+		Writes:    true,
+		Run: func(evt *event.Event, args []string) (string, error) {
+			if len(args) == 0 {
+				invites, err := m.store.Invites()
+				if err != nil {
+					return "", err
+				}
+				if len(invites) == 0 {
+					return "No invites wait for approval.", nil
+				}
+				lines := make([]string, 0, len(invites))
+				for _, inv := range invites {
+					lines = append(lines, fmt.Sprintf("- %s, invited by %s at %s", inv.RoomID, inv.Inviter, inv.InvitedAt.In(m.userLocation(evt.Sender)).Format(reminderTimeFormat)))
+				}
+				return strings.Join(lines, "\n"), nil
+			}
+			if len(args) != 1 {
+				return "", fmt.Errorf("usage: !approve [room id]")
+			}
+			inv, ok, err := m.store.Invite(id.RoomID(args[0]))
+			if err != nil {
+				return "", err
+			}
+			if !ok {
+				return "", fmt.Errorf("no invite for %s", args[0])
+			}
+			if err := m.acceptInvite(inv.RoomID, inv.Inviter); err != nil {
+				return "", err
+			}
+			if err := m.store.DeleteInvite(inv.RoomID); err != nil {
+				return "", err
+			}
+
+			return fmt.Sprintf("Joined %s.", inv.RoomID), nil
+		},
+	}
+}
+
+func (m *Bot) denyCommand() Command {
+	return Command{
+		Name:      "deny",
+		Usage:     "<room id>",
+		Help:      "decline an invite that waits for approval",
+		AdminOnly: true,
+		Writes:    true,
+		Run: func(evt *event.Event, args []string) (string, error) {
+			if len(args) != 1 {
+				return "", fmt.Errorf("usage: !deny <room id>")
+			}
+			roomID := id.RoomID(args[0])
+			_, ok, err := m.store.Invite(roomID)
+			if err != nil {
+				return "", err
+			}
+			if !ok {
+				return "", fmt.Errorf("no invite for %s", roomID)
+			}
+			// leaving a room one is invited to declines the invite
+			if err := m.matrix.LeaveRoom(roomID); err != nil {
+				return "", err
+			}
+			if err := m.store.DeleteInvite(roomID); err != nil {
+				return "", err
+			}
+			m.logger.Info("declined invite", slog.String("room_id", roomID.String()), slog.String("sender", evt.Sender.String()), slog.String("bot", m.config.UserDisplayName))
+
+			return fmt.Sprintf("Declined the invite for %s.", roomID), nil
+		},
+	}
+}
+
+func (s *Store) AddInvite(inv Invite) error {
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_invite (room_id, inviter, invited_at) VALUES ($1, $2, $3)
+		ON CONFLICT (room_id) DO UPDATE SET inviter = excluded.inviter, invited_at = excluded.invited_at`,
+		inv.RoomID, inv.Inviter, inv.InvitedAt.Unix())
+
+	return err
+}
+
+// Invite returns the invite for the room, if there is one.
+func (s *Store) Invite(roomID id.RoomID) (Invite, bool, error) {
+	invites, err := s.invites(`WHERE room_id = $1`, roomID)
+	if err != nil || len(invites) == 0 {
+		return Invite{}, false, err
+	}
+
+	return invites[0], true, nil
+}
+
+// Invites returns the invites that wait for approval, oldest first.
+func (s *Store) Invites() ([]Invite, error) {
+	return s.invites(``)
+}
+
+func (s *Store) invites(where string, args ...any) ([]Invite, error) {
+	rows, err := s.db.QueryContext(s.context(), `SELECT room_id, inviter, invited_at FROM bot_invite `+where+` ORDER BY invited_at`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []Invite
+	for rows.Next() {
+		var inv Invite
+		var invitedAt int64
+		if err := rows.Scan(&inv.RoomID, &inv.Inviter, &invitedAt); err != nil {
+			return nil, err
+		}
+		inv.InvitedAt = time.Unix(invitedAt, 0)
+		invites = append(invites, inv)
+	}
+
+	return invites, rows.Err()
+}
+
+func (s *Store) DeleteInvite(roomID id.RoomID) error {
+	_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_invite WHERE room_id = $1`, roomID)
+
+	return err
+}
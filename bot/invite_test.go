@@ -0,0 +1,60 @@
+package bot_test
+
+import (
+	"strings"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestInviteApproval(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name      string
+		adminRoom string
+		expRoom   id.RoomID
+	}{
+		{name: "admin room", adminRoom: "!admin:ewintr.nl", expRoom: "!admin:ewintr.nl"},
+		{name: "direct chat", expRoom: "!dm1:fake"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fm := bot.NewFakeMatrix()
+			fm.SetUserID("@bot:ewintr.nl")
+			b := newTestBot(t, bot.ConfigBot{
+				UserID:         "@bot:ewintr.nl",
+				Admins:         []string{"@admin:ewintr.nl"},
+				AdminRoom:      tc.adminRoom,
+				ApproveInvites: true,
+			}, bot.WithMatrix(fm))
+			_, h := b.InviteHandler()
+
+			stateKey := "@bot:ewintr.nl"
+			h(mautrix.EventSourceTimeline, &event.Event{
+				ID:       "$invite",
+				RoomID:   "!new:ewintr.nl",
+				Sender:   "@someone:ewintr.nl",
+				Type:     event.StateMember,
+				StateKey: &stateKey,
+				Content:  event.Content{Parsed: &event.MemberEventContent{Membership: event.MembershipInvite}},
+			})
+
+			if joined := fm.Joined(); len(joined) != 0 {
+				t.Errorf("exp no joins, got %v", joined)
+			}
+			msgs := fm.Messages()
+			if len(msgs) != 1 {
+				t.Fatalf("exp 1, got %v", len(msgs))
+			}
+			if msgs[0].RoomID != tc.expRoom {
+				t.Errorf("exp %v, got %v", tc.expRoom, msgs[0].RoomID)
+			}
+			if body := msgs[0].Content.(*event.MessageEventContent).Body; !strings.Contains(body, "!approve !new:ewintr.nl") {
+				t.Errorf("exp approve hint, got %v", body)
+			}
+		})
+	}
+}
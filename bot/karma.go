@@ -0,0 +1,257 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// karmaNamespace is where the karma is kept in the room values.
+	karmaNamespace  = "karma"
+	karmaLeaderSize = 10
+)
+
+var (
+	defaultKarmaReactions = []string{"👍", "❤"}
+	// karmaPlain is a name or user ID followed by ++ or --, like alice++
+	karmaPlain = regexp.MustCompile(`(?:^|\s)(@?[\p{L}\p{N}._=/-]+(?::[\w.-]+(?::\d+)?)?)(\+\+|--)`)
+	// karmaPill is a mention pill followed by ++ or --, as clients format it
+	karmaPill = regexp.MustCompile(`<a href="https://matrix\.to/#/(@[^"?]+)"[^>]*>[^<]*</a>:?\s?(\+\+|--)`)
+	// inlineCode is left out, i++ in code is not karma
+	inlineCode = regexp.MustCompile("`[^`]*`")
+)
+
+// ConfigKarma switches on karma: members give each other a point with
+// alice++ and take one with alice--, or give one by reacting to a message
+// with one of Reactions, 👍 and ❤️ when not set.
+type ConfigKarma struct {
+	Enabled   bool
+	Reactions []string
+}
+
+func (c ConfigKarma) reactions() []string {
+	if len(c.Reactions) == 0 {
+		return defaultKarmaReactions
+	}
+
+	return c.Reactions
+}
+
+// karmaName is the name karma is kept under. For a user that is the
+// localpart, so alice++ and a reaction to a message of @alice:ewintr.nl add
+// up.
+func karmaName(name string) string {
+	if strings.HasPrefix(name, "@") && strings.Contains(name, ":") {
+		return strings.ToLower(id.UserID(name).Localpart())
+	}
+
+	return strings.ToLower(strings.TrimPrefix(name, "@"))
+}
+
+// KarmaChanges finds the ++ and -- in a message, by name. Names that appear
+// more than once count once, the last one wins.
+func KarmaChanges(content *event.MessageEventContent) map[string]int64 {
+	changes := make(map[string]int64)
+	if content.Format == event.FormatHTML {
+		html := inlineCode.ReplaceAllString(content.FormattedBody, "")
+		for _, m := range karmaPill.FindAllStringSubmatch(html, -1) {
+			changes[karmaName(m[1])] = karmaDelta(m[2])
+		}
+		if len(changes) > 0 {
+			return changes
+		}
+	}
+	body := inlineCode.ReplaceAllString(event.TrimReplyFallbackText(content.Body), "")
+	for _, m := range karmaPlain.FindAllStringSubmatchIndex(body, -1) {
+		// alice++ must end there, c+++ or x--y are not karma
+		if m[1] < len(body) && !strings.ContainsRune(" \t\n.,!?;)", rune(body[m[1]])) {
+			continue
+		}
+		if name := karmaName(body[m[2]:m[3]]); name != "" {
+			changes[name] = karmaDelta(body[m[4]:m[5]])
+		}
+	}
+
+	return changes
+}
+
+func karmaDelta(op string) int64 {
+	if op == "--" {
+		return -1
+	}
+
+	return 1
+}
+
+// KarmaHandler counts the ++ and -- in messages and tells the new karma. It
+// never consumes the message.
+func (m *Bot) KarmaHandler() MessageHandler {
+	return NewMessageHandler("karma", PriorityKarma, func(evt *event.Event) bool {
+		content := evt.Content.AsMessage()
+		body := strings.TrimSpace(event.TrimReplyFallbackText(content.Body))
+		if !m.config.Karma.Enabled || content.MsgType != event.MsgText || strings.HasPrefix(body, commandPrefix) || hasCodeBlock(body) {
+			return false
+		}
+		changes := KarmaChanges(content)
+		names := make([]string, 0, len(changes))
+		for name := range changes {
+			// no karma for yourself
+			if name != karmaName(evt.Sender.String()) {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return false
+		}
+		sort.Strings(names)
+
+		var lines []string
+		for _, name := range names {
+			karma, err := m.store.AddRoomCounter(evt.RoomID, karmaNamespace, name, changes[name])
+			if err != nil {
+				m.logger.Error("failed to update karma", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+				return false
+			}
+			lines = append(lines, fmt.Sprintf("%s now has %d karma.", name, karma))
+		}
+		m.sendNotice(evt.RoomID, evt.ID, strings.Join(lines, "\n"))
+
+		return false
+	})
+}
+
+// KarmaReactionHandler gives a point to the sender of a message that gets
+// one of the karma reactions. Each member gives at most one point per
+// message, however many karma reactions they add. Taking the reaction back
+// does not take the point back.
+func (m *Bot) KarmaReactionHandler() (event.Type, mautrix.EventHandler) {
+	return event.EventReaction, func(source mautrix.EventSource, evt *event.Event) {
+		if !m.config.Karma.Enabled || evt.Sender == m.matrix.UserID() || !m.pluginEnabled(evt, "karma") {
+			return
+		}
+		rel := evt.Content.AsReaction().GetRelatesTo()
+		if rel.Type != event.RelAnnotation {
+			return
+		}
+		key := strings.TrimSuffix(rel.Key, "\ufe0f")
+		var karma bool
+		for _, r := range m.config.Karma.reactions() {
+			if key == strings.TrimSuffix(r, "\ufe0f") {
+				karma = true
+			}
+		}
+		if !karma {
+			return
+		}
+		// looking up the sender of the message is a request to the
+		// homeserver, keep it out of the sync loop
+		m.goLoop(func() {
+			m.karmaReaction(evt.RoomID, evt.Sender, rel.EventID)
+		})
+	}
+}
+
+// karmaReaction gives the point for a reaction of userID to the message
+// targetID, unless the user reacted to their own message or already gave a
+// point for it.
+func (m *Bot) karmaReaction(roomID id.RoomID, userID id.UserID, targetID id.EventID) {
+	target, err := m.matrix.GetEvent(roomID, targetID)
+	if err != nil {
+		m.logger.Error("failed to get event", slog.String("err", err.Error()), slog.String("event_id", targetID.String()), slog.String("bot", m.config.UserDisplayName))
+		return
+	}
+	if target.Sender == userID {
+		return
+	}
+	counted, err := m.store.AddKarmaReaction(roomID, targetID, userID)
+	if err != nil {
+		m.logger.Error("failed to store karma reaction", slog.String("err", err.Error()), slog.String("event_id", targetID.String()), slog.String("bot", m.config.UserDisplayName))
+		return
+	}
+	if !counted {
+		return
+	}
+	if _, err := m.store.AddRoomCounter(roomID, karmaNamespace, karmaName(target.Sender.String()), 1); err != nil {
+		m.logger.Error("failed to update karma", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+}
+
+// AddKarmaReaction records that the user gave a point for the message and
+// reports whether they had not done so before.
+func (s *Store) AddKarmaReaction(roomID id.RoomID, eventID id.EventID, userID id.UserID) (bool, error) {
+	res, err := s.db.ExecContext(s.context(), `INSERT INTO bot_karma_reaction (event_id, user_id, room_id) VALUES ($1, $2, $3)
+		ON CONFLICT (event_id, user_id) DO NOTHING`, eventID, userID, roomID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func (m *Bot) karmaCommand() Command {
+	return Command{
+		Name:  "karma",
+		Usage: "[<name>]",
+		Help:  "show who has the most karma in this room, or the karma of one name",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			if !m.config.Karma.Enabled {
+				return "", fmt.Errorf("karma is not enabled")
+			}
+			values, err := m.store.RoomValues(evt.RoomID, karmaNamespace)
+			if err != nil {
+				return "", err
+			}
+			if len(args) == 1 {
+				name := karmaName(args[0])
+				karma, _ := strconv.ParseInt(values[name], 10, 64)
+				return fmt.Sprintf("%s has %d karma.", name, karma), nil
+			}
+			if len(args) > 1 {
+				return "", fmt.Errorf("usage: !karma [<name>]")
+			}
+
+			type score struct {
+				name  string
+				karma int64
+			}
+			var scores []score
+			for name, value := range values {
+				karma, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return "", err
+				}
+				scores = append(scores, score{name: name, karma: karma})
+			}
+			if len(scores) == 0 {
+				return "Nobody has karma in this room yet.", nil
+			}
+			sort.Slice(scores, func(i, j int) bool {
+				if scores[i].karma != scores[j].karma {
+					return scores[i].karma > scores[j].karma
+				}
+				return scores[i].name < scores[j].name
+			})
+			if len(scores) > karmaLeaderSize {
+				scores = scores[:karmaLeaderSize]
+			}
+			lines := []string{"**Karma**", ""}
+			for i, s := range scores {
+				lines = append(lines, fmt.Sprintf("%d. %s: %d", i+1, s.name, s.karma))
+			}
+
+			return strings.Join(lines, "\n"), nil
+		},
+	}
+}
@@ -0,0 +1,135 @@
+package bot_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestKarmaChanges(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		content event.MessageEventContent
+		exp     map[string]int64
+	}{
+		{name: "plain", content: event.MessageEventContent{Body: "thanks alice++ and bob--!"}, exp: map[string]int64{"alice": 1, "bob": -1}},
+		{name: "user id", content: event.MessageEventContent{Body: "@Alice:ewintr.nl++"}, exp: map[string]int64{"alice": 1}},
+		{name: "pill", content: event.MessageEventContent{
+			Body:          "Alice Smith++",
+			Format:        event.FormatHTML,
+			FormattedBody: `<a href="https://matrix.to/#/@alice:ewintr.nl">Alice Smith</a>++`,
+		}, exp: map[string]int64{"alice": 1}},
+		{name: "inline code", content: event.MessageEventContent{Body: "use `i++` there"}, exp: map[string]int64{}},
+		{name: "no name", content: event.MessageEventContent{Body: "a -- b ++"}, exp: map[string]int64{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act := bot.KarmaChanges(&tc.content)
+			if len(act) != len(tc.exp) {
+				t.Fatalf("exp %v, got %v", tc.exp, act)
+			}
+			for name, delta := range tc.exp {
+				if act[name] != delta {
+					t.Errorf("exp %v, got %v", tc.exp, act)
+				}
+			}
+		})
+	}
+}
+
+func TestKarma(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	b := newTestBot(t, bot.ConfigBot{
+		UserID: "@bot:ewintr.nl",
+		Karma:  bot.ConfigKarma{Enabled: true},
+	}, bot.WithMatrix(fm))
+	_, h := b.ResponseHandler()
+
+	for _, tc := range []struct {
+		name    string
+		body    string
+		expBody string
+	}{
+		{name: "empty", body: "!karma", expBody: "Nobody has karma in this room yet."},
+		{name: "give", body: "alice++ bob++", expBody: "alice now has 1 karma.\nbob now has 1 karma."},
+		{name: "again", body: "great work alice++", expBody: "alice now has 2 karma."},
+		{name: "take", body: "bob--", expBody: "bob now has 0 karma."},
+		{name: "self", body: "someone++"},
+		{name: "leaderboard", body: "!karma", expBody: "**Karma**\n\n1. alice: 2\n2. bob: 0"},
+		{name: "one", body: "!karma @Alice:ewintr.nl", expBody: "alice has 2 karma."},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := len(fm.Messages())
+			h(mautrix.EventSourceTimeline, testMessage(id.EventID("$"+tc.name), tc.body, ""))
+
+			msgs := fm.Messages()
+			if tc.expBody == "" {
+				if len(msgs) != before {
+					t.Errorf("exp %v, got %v", before, len(msgs))
+				}
+				return
+			}
+			if len(msgs) <= before {
+				t.Fatalf("exp more than %v, got %v", before, len(msgs))
+			}
+			if act := msgs[before].Content.(*event.MessageEventContent).Body; act != tc.expBody {
+				t.Errorf("exp %q, got %q", tc.expBody, act)
+			}
+		})
+	}
+}
+
+func TestKarmaReaction(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	store := newTestStore(t)
+	b := newTestBot(t, bot.ConfigBot{
+		UserID: "@bot:ewintr.nl",
+		Karma:  bot.ConfigKarma{Enabled: true},
+	}, bot.WithStore(store), bot.WithMatrix(fm))
+	fm.AddEvent(&event.Event{ID: "$msg", RoomID: "!room:ewintr.nl", Sender: "@alice:ewintr.nl", Type: event.EventMessage})
+	_, h := b.KarmaReactionHandler()
+
+	for i, r := range []struct {
+		sender id.UserID
+		key    string
+	}{
+		{sender: "@someone:ewintr.nl", key: "👍"},
+		{sender: "@someone:ewintr.nl", key: "❤️"},
+		{sender: "@other:ewintr.nl", key: "👍"},
+		{sender: "@other:ewintr.nl", key: "🎉"},
+		{sender: "@alice:ewintr.nl", key: "👍"},
+	} {
+		h(mautrix.EventSourceTimeline, &event.Event{
+			ID:     id.EventID(fmt.Sprintf("$reaction%d", i)),
+			RoomID: "!room:ewintr.nl",
+			Sender: r.sender,
+			Type:   event.EventReaction,
+			Content: event.Content{Parsed: &event.ReactionEventContent{RelatesTo: event.RelatesTo{
+				Type:    event.RelAnnotation,
+				EventID: "$msg",
+				Key:     r.key,
+			}}},
+		})
+	}
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+
+	values, err := store.RoomValues("!room:ewintr.nl", "karma")
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if act := values["alice"]; act != "2" {
+		t.Errorf("exp 2, got %v", act)
+	}
+}
@@ -0,0 +1,31 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix/crypto"
+)
+
+// ExportKeys exports the megolm sessions of the bot in the passphrase
+// protected format of the Matrix spec, which Element can read as well.
+func (m *Bot) ExportKeys(passphrase string) ([]byte, error) {
+	sessions, err := m.cryptoHelper.Machine().CryptoStore.GetAllGroupSessions()
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.ExportKeys(passphrase, sessions)
+}
+
+// ImportKeys imports sessions exported with ExportKeys, or by another client.
+// It returns the number of sessions that were new and the total number in
+// the export. A wrong passphrase gives ErrDecryptFailed.
+func (m *Bot) ImportKeys(passphrase string, data []byte) (int, int, error) {
+	imported, total, err := m.cryptoHelper.Machine().ImportKeys(passphrase, data)
+	if errors.Is(err, crypto.ErrMismatchingExportHash) {
+		err = fmt.Errorf("%w: %w", ErrDecryptFailed, err)
+	}
+
+	return imported, total, err
+}
@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type reqKnock struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+type respKnock struct {
+	RoomID id.RoomID `json:"room_id"`
+}
+
+// KnockRoom asks to be let into a room that is only open for those who knock.
+// When the knock is approved, the bot gets an invite, which it accepts even
+// if it does not accept invites otherwise.
+func (m *Bot) KnockRoom(roomIDOrAlias, reason string) (id.RoomID, error) {
+	urlPath := m.client.BuildClientURL("v3", "knock", roomIDOrAlias)
+	// the homeserver may not know the room yet, the server in the room ID
+	// does
+	if _, server, ok := strings.Cut(roomIDOrAlias, ":"); strings.HasPrefix(roomIDOrAlias, "!") && ok {
+		urlPath = m.client.BuildURLWithQuery(mautrix.ClientURLPath{"v3", "knock", roomIDOrAlias}, map[string]string{
+			"server_name": server,
+		})
+	}
+	var resp respKnock
+	if _, err := m.client.MakeRequest("POST", urlPath, &reqKnock{Reason: reason}, &resp); err != nil {
+		return "", err
+	}
+	if err := m.store.AddKnock(resp.RoomID, time.Now()); err != nil {
+		return "", err
+	}
+	m.logger.Info("knocked on room", slog.String("room_id", resp.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+
+	return resp.RoomID, nil
+}
+
+// knocked tells whether the bot knocked on the room and is waiting for an
+// answer.
+func (m *Bot) knocked(roomID id.RoomID) bool {
+	knocked, err := m.store.Knocked(roomID)
+	if err != nil {
+		m.logger.Error("failed to get knock", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+
+	return knocked
+}
+
+func (m *Bot) knockCommand() Command {
+	return Command{
+		Name:      "knock",
+		Usage:     "<room id|alias> [reason]",
+		Help:      "ask to be let into a room that is open for those who knock, like `!knock #community:ewintr.nl`",
+		AdminOnly: true,
+		Writes:    true,
+		Run: func(evt *event.Event, args []string) (string, error) {
+			if len(args) == 0 {
+				return "", fmt.Errorf("usage: !knock <room id|alias> [reason]")
+			}
+			if _, err := m.KnockRoom(args[0], strings.Join(args[1:], " ")); err != nil {
+				return "", err
+			}
+
+			return fmt.Sprintf("Knocked on %s, I will join when I am let in.", args[0]), nil
+		},
+	}
+}
+
+func (s *Store) AddKnock(roomID id.RoomID, now time.Time) error {
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_knock (room_id, knocked_at) VALUES ($1, $2)
+		ON CONFLICT (room_id) DO UPDATE SET knocked_at = excluded.knocked_at`, roomID, now.Unix())
+
+	return err
+}
+
+func (s *Store) Knocked(roomID id.RoomID) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(s.context(), `SELECT COUNT(*) FROM bot_knock WHERE room_id = $1`, roomID).Scan(&n)
+
+	return n > 0, err
+}
+
+func (s *Store) DeleteKnock(roomID id.RoomID) error {
+	_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_knock WHERE room_id = $1`, roomID)
+
+	return err
+}
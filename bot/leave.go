@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// LeaveRoom leaves and forgets a room, and removes everything the bot kept
+// for it.
+func (m *Bot) LeaveRoom(roomID id.RoomID) error {
+	if err := m.matrix.LeaveRoom(roomID); err != nil {
+		return err
+	}
+
+	return m.forgetRoom(roomID)
+}
+
+// forgetRoom removes the conversations, schedules, feeds, reminders and
+// settings of a room the bot is no longer in, and asks the homeserver to
+// forget the room.
+func (m *Bot) forgetRoom(roomID id.RoomID) error {
+	var errs []error
+	schedules, err := m.store.Schedules(roomID)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, s := range schedules {
+		m.scheduler.Remove(s.ID)
+	}
+	m.endQuiz(roomID)
+	if err := m.conversations.RemoveRoom(roomID); err != nil {
+		errs = append(errs, err)
+	}
+	if err := m.store.DeleteRoom(roomID); err != nil {
+		errs = append(errs, err)
+	}
+	if err := m.matrix.ForgetRoom(roomID); err != nil {
+		errs = append(errs, err)
+	}
+	m.logger.Info("forgot room", slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+
+	return errors.Join(errs...)
+}
+
+// MembershipHandler cleans up after the bot was kicked or banned from a room,
+// or forgets its knock when that was rejected. Leaving on its own is handled
+// by LeaveRoom.
+func (m *Bot) MembershipHandler() (event.Type, mautrix.EventHandler) {
+	return event.StateMember, func(source mautrix.EventSource, evt *event.Event) {
+		if evt.GetStateKey() != m.matrix.UserID().String() || evt.Sender == m.matrix.UserID() {
+			return
+		}
+		membership := evt.Content.AsMember().Membership
+		if membership != event.MembershipLeave && membership != event.MembershipBan {
+			return
+		}
+		if m.knocked(evt.RoomID) {
+			m.logger.Info("knock rejected", slog.String("room_id", evt.RoomID.String()), slog.String("sender", evt.Sender.String()), slog.String("bot", m.config.UserDisplayName))
+			if err := m.store.DeleteKnock(evt.RoomID); err != nil {
+				m.logger.Error("failed to remove knock", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+			}
+			return
+		}
+		m.logger.Info("removed from room", slog.String("room_id", evt.RoomID.String()), slog.String("membership", string(membership)), slog.String("sender", evt.Sender.String()), slog.String("bot", m.config.UserDisplayName))
+		if err := m.forgetRoom(evt.RoomID); err != nil {
+			m.logger.Error("failed to forget room", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+		}
+	}
+}
+
+// mayRemoveBot tells whether the user is an admin of the bot, or is allowed
+// to kick it from the room.
+func (m *Bot) mayRemoveBot(roomID id.RoomID, userID id.UserID) bool {
+	if m.isAdmin(userID) {
+		return true
+	}
+	var pl event.PowerLevelsEventContent
+	if err := m.matrix.StateEvent(roomID, event.StatePowerLevels, "", &pl); err != nil {
+		m.logger.Error("failed to get power levels", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+		return false
+	}
+
+	return pl.GetUserLevel(userID) >= pl.Kick()
+}
+
+func (m *Bot) leaveCommand() Command {
+	return Command{
+		Name:   "leave",
+		Help:   "make the bot leave this room and forget everything about it, for admins and those who may kick",
+		Writes: true,
+		Run: func(evt *event.Event, args []string) (string, error) {
+			if !m.mayRemoveBot(evt.RoomID, evt.Sender) {
+				return "", fmt.Errorf("only admins and those who may kick can use !leave")
+			}
+			m.sendNotice(evt.RoomID, evt.ID, "Bye!")
+			if err := m.LeaveRoom(evt.RoomID); err != nil {
+				m.logger.Error("failed to leave room", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+			}
+
+			return "", nil
+		},
+	}
+}
+
+// DeleteRoom removes everything stored for a room, except the conversations,
+// which are removed through the conversation cache. It removes all of it or,
+// when something fails, nothing.
+func (s *Store) DeleteRoom(roomID id.RoomID) error {
+	tx, err := s.db.BeginTx(s.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, q := range []string{
+		`DELETE FROM bot_feed_item WHERE feed_id IN (SELECT id FROM bot_feed WHERE room_id = $1)`,
+		`DELETE FROM bot_feed WHERE room_id = $1`,
+		`DELETE FROM bot_calendar_announced WHERE calendar_id IN (SELECT id FROM bot_calendar WHERE room_id = $1)`,
+		`DELETE FROM bot_calendar WHERE room_id = $1`,
+		`DELETE FROM bot_schedule WHERE room_id = $1`,
+		`DELETE FROM bot_reminder WHERE room_id = $1`,
+		`DELETE FROM bot_outbox WHERE room_id = $1`,
+		`DELETE FROM bot_room_plugin WHERE room_id = $1`,
+		`DELETE FROM bot_room_tool WHERE room_id = $1`,
+		`DELETE FROM bot_unencrypted_notice WHERE room_id = $1`,
+		`DELETE FROM bot_room_config WHERE room_id = $1`,
+		`DELETE FROM bot_room_translate WHERE room_id = $1`,
+		`DELETE FROM bot_room_value WHERE room_id = $1`,
+		`DELETE FROM bot_karma_reaction WHERE room_id = $1`,
+		`DELETE FROM bot_poll_vote WHERE poll_event_id IN (SELECT event_id FROM bot_poll WHERE room_id = $1)`,
+		`DELETE FROM bot_poll WHERE room_id = $1`,
+		`DELETE FROM bot_knock WHERE room_id = $1`,
+		`DELETE FROM bot_room WHERE room_id = $1`,
+		`DELETE FROM bot_invite WHERE room_id = $1`,
+	} {
+		if _, err := tx.ExecContext(s.context(), q, roomID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,318 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// defaultLanguage is the language of the boilerplate when none is set or
+// detected, or when the catalog has no translation.
+const defaultLanguage = "en"
+
+// The keys of the texts in the catalog. Keys starting with "help." replace
+// the help of the command with that name.
+const (
+	msgBudget        = "budget"
+	msgError         = "error"
+	msgUnavailable   = "unavailable"
+	msgRateLimited   = "rate_limited"
+	msgTooLong       = "too_long"
+	msgAdminOnly     = "admin_only"
+	msgCommandError  = "command_error"
+	msgQuotaAnswers  = "quota_answers"
+	msgQuotaTokens   = "quota_tokens"
+	msgGreeting      = "greeting"
+	msgLanguage      = "language"
+	msgLanguageAuto  = "language_auto"
+	msgLanguageSet   = "language_set"
+	msgLanguageReset = "language_reset"
+	msgHelpPrefix    = "help."
+)
+
+// languageNames are the languages the bot can be asked to answer in, by
+// their ISO 639-1 code, with their own name.
+var languageNames = map[string]string{
+	"ca": "català",
+	"cs": "čeština",
+	"da": "dansk",
+	"de": "Deutsch",
+	"el": "ελληνικά",
+	"en": "English",
+	"es": "español",
+	"fi": "suomi",
+	"fr": "français",
+	"fy": "Frysk",
+	"hu": "magyar",
+	"it": "italiano",
+	"ja": "日本語",
+	"ko": "한국어",
+	"nl": "Nederlands",
+	"no": "norsk",
+	"pl": "polski",
+	"pt": "português",
+	"ro": "română",
+	"ru": "русский",
+	"sv": "svenska",
+	"tr": "Türkçe",
+	"uk": "українська",
+	"zh": "中文",
+}
+
+// catalog has the texts of the bot that are not written by the model, per
+// language. ConfigBot.Catalog can add languages and replace texts.
+var catalog = map[string]map[string]string{
+	"en": {
+		msgBudget:        "Sorry, the budget for this month is used up. I will be able to answer again next month.",
+		msgError:         "Sorry, I could not get an answer. Please try again later.",
+		msgUnavailable:   "Sorry, I'm temporarily unavailable. Please try again in a few minutes.",
+		msgRateLimited:   "Sorry, I'm getting too many questions right now. Please try again in a minute.",
+		msgTooLong:       "Sorry, this conversation has become too long for me. Please start a new one.",
+		msgAdminOnly:     "Sorry, only admins can use !%s.",
+		msgCommandError:  "Error: %s",
+		msgQuotaAnswers:  "Sorry, you have had your %d answers for today. You can ask me again tomorrow.",
+		msgQuotaTokens:   "Sorry, you have used your %d tokens for today. You can ask me again tomorrow.",
+		msgGreeting:      DefaultGreeting,
+		msgLanguage:      "I answer you in %s.",
+		msgLanguageAuto:  "I answer in the language you write in.",
+		msgLanguageSet:   "I will answer you in %s from now on.",
+		msgLanguageReset: "I no longer use a language of your own.",
+	},
+	"nl": {
+		msgBudget:       "Sorry, het budget voor deze maand is op. Volgende maand kan ik weer antwoorden.",
+		msgError:        "Sorry, ik kon geen antwoord krijgen. Probeer het later nog eens.",
+		msgUnavailable:  "Sorry, ik ben tijdelijk niet beschikbaar. Probeer het over een paar minuten nog eens.",
+		msgRateLimited:  "Sorry, ik krijg nu te veel vragen. Probeer het over een minuut nog eens.",
+		msgTooLong:      "Sorry, dit gesprek is te lang geworden voor mij. Begin alsjeblieft een nieuw gesprek.",
+		msgAdminOnly:    "Sorry, alleen beheerders kunnen !%s gebruiken.",
+		msgCommandError: "Fout: %s",
+		msgQuotaAnswers: "Sorry, je hebt je %d antwoorden voor vandaag gehad. Morgen kun je me weer iets vragen.",
+		msgQuotaTokens:  "Sorry, je hebt je %d tokens voor vandaag gebruikt. Morgen kun je me weer iets vragen.",
+		msgGreeting: `Hoi, ik ben {{.Name}}. Stel me een vraag door je bericht te beginnen met "{{.Name}}: "{{if .AnswerUnaddressed}}, of vraag het gewoon zonder iemand aan te spreken{{end}}. Antwoord op een van mijn antwoorden om het gesprek voort te zetten.
+{{if .Personas}}
+Je kunt ook praten met {{range $i, $p := .Personas}}{{if $i}}, {{end}}{{$p}}{{end}}.
+{{end}}
+Commando's: {{range $i, $c := .Commands}}{{if $i}}, {{end}}` + "`!{{$c}}`" + `{{end}}.
+
+Let op: de berichten die ik beantwoord, en de gesprekken waar ze bij horen, worden naar OpenAI gestuurd om een antwoord te maken.`,
+		msgLanguage:               "Ik antwoord je in het %s.",
+		msgLanguageAuto:           "Ik antwoord in de taal waarin je schrijft.",
+		msgLanguageSet:            "Ik antwoord je voortaan in het %s.",
+		msgLanguageReset:          "Ik gebruik geen eigen taal meer voor je.",
+		msgHelpPrefix + "cancel":  "verwijder een herinnering, in antwoord daarop",
+		msgHelpPrefix + "help":    "toon de beschikbare commando's",
+		msgHelpPrefix + "lang":    "toon of kies de taal waarin ik je antwoord, zoals `!lang en`",
+		msgHelpPrefix + "remind":  "word op het gegeven moment genoemd, zoals `!remind me in 2 hours to check the build`",
+		msgHelpPrefix + "room":    "toon wat de bot over deze kamer weet",
+		msgHelpPrefix + "snooze":  "stuur een herinnering later nog eens, in antwoord daarop, zoals `!snooze 30m` of `!snooze tomorrow`",
+		msgHelpPrefix + "tz":      "toon of kies je tijdzone voor herinneringen en tijden, zoals `!tz Europe/Amsterdam`",
+		msgHelpPrefix + "usage":   "toon de tokens die je gebruikt hebt en wat ze kostten, en voor beheerders die van deze kamer en alle kamers",
+		msgHelpPrefix + "weather": "toon het weer op een plek, of op je eigen plek die je instelt met `!weather home <place>`",
+	},
+}
+
+// languageWords are common words, used to recognize the languages of the
+// built in catalog.
+var languageWords = map[string][]string{
+	"en": {"the", "is", "are", "and", "what", "how", "you", "can", "i", "it", "of", "to", "do", "this", "with", "please", "why", "my"},
+	"nl": {"de", "het", "een", "is", "en", "wat", "hoe", "je", "jij", "kun", "kan", "ik", "van", "niet", "dit", "met", "alsjeblieft", "waarom", "mijn", "zijn"},
+}
+
+func validLanguage(lang string) error {
+	if _, ok := languageNames[lang]; !ok {
+		return fmt.Errorf("unknown language %q, use a code like en or nl", lang)
+	}
+
+	return nil
+}
+
+// validateCatalog checks the languages and the greeting templates of the
+// catalog in the config.
+func (m *Bot) validateCatalog() error {
+	if m.config.Language != "" {
+		if err := validLanguage(m.config.Language); err != nil {
+			return err
+		}
+	}
+	for lang, texts := range m.config.Catalog {
+		if err := validLanguage(lang); err != nil {
+			return err
+		}
+		if greeting, ok := texts[msgGreeting]; ok {
+			if _, err := template.New("greeting").Parse(greeting); err != nil {
+				return fmt.Errorf("invalid greeting template for %s: %w", lang, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// text returns the text for the key in the language, or in English when it
+// is not translated. With args, the text is a format for them.
+func (m *Bot) text(lang, key string, args ...any) string {
+	text, ok := m.translation(lang, key)
+	if !ok {
+		text, _ = m.translation(defaultLanguage, key)
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+
+	return text
+}
+
+// translation looks up the text for the key in the language, first in the
+// catalog of the config and then in the built in one.
+func (m *Bot) translation(lang, key string) (string, bool) {
+	if text, ok := m.config.Catalog[lang][key]; ok {
+		return text, true
+	}
+	text, ok := catalog[lang][key]
+
+	return text, ok
+}
+
+// replyLanguage is the language the bot was asked to answer the user in the
+// room: the one the user set with !lang, or else that of the room config or
+// the bot config. Without one, the model answers in the language of the
+// question.
+func (m *Bot) replyLanguage(roomID id.RoomID, userID id.UserID) string {
+	lang, err := m.store.UserLanguage(userID)
+	if err != nil {
+		m.logger.Error("failed to get language", slog.String("err", err.Error()), slog.String("user_id", userID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+	if lang != "" {
+		return lang
+	}
+	if rc := m.roomConfig(roomID); rc.Language != "" {
+		return rc.Language
+	}
+
+	return m.config.Language
+}
+
+// messageLanguage is the language of the boilerplate for a message: the
+// reply language, or else the language the message is written in.
+func (m *Bot) messageLanguage(evt *event.Event) string {
+	if lang := m.replyLanguage(evt.RoomID, evt.Sender); lang != "" {
+		return lang
+	}
+	if lang := detectLanguage(evt.Content.AsMessage().Body); lang != "" {
+		return lang
+	}
+
+	return defaultLanguage
+}
+
+// roomLanguage is the language for messages to the whole room.
+func (m *Bot) roomLanguage(roomID id.RoomID) string {
+	if rc := m.roomConfig(roomID); rc.Language != "" {
+		return rc.Language
+	}
+	if m.config.Language != "" {
+		return m.config.Language
+	}
+
+	return defaultLanguage
+}
+
+// languagePrompt tells the model which language to answer in.
+func languagePrompt(lang string) string {
+	if lang == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("Always answer in %s (%s), unless you are asked to translate.", languageNames[lang], lang)
+}
+
+// detectLanguage guesses the language of the text by counting common words.
+// It returns nothing when it is not sure.
+func detectLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(event.TrimReplyFallbackText(text)), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r == '\'' || r > 127)
+	})
+	best, bestScore, second := "", 0, 0
+	for lang, common := range languageWords {
+		score := 0
+		for _, w := range words {
+			if contains(common, w) {
+				score++
+			}
+		}
+		switch {
+		case score > bestScore:
+			best, bestScore, second = lang, score, bestScore
+		case score > second:
+			second = score
+		}
+	}
+	if bestScore < 2 || bestScore == second {
+		return ""
+	}
+
+	return best
+}
+
+func (m *Bot) langCommand() Command {
+	return Command{
+		Name:  "lang",
+		Usage: "[code|reset]",
+		Help:  "show or set the language I answer you in, like `!lang nl`",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			switch {
+			case len(args) == 0:
+				lang := m.replyLanguage(evt.RoomID, evt.Sender)
+				if lang == "" {
+					return m.text(m.messageLanguage(evt), msgLanguageAuto), nil
+				}
+				return m.text(lang, msgLanguage, languageNames[lang]), nil
+			case len(args) == 1 && strings.EqualFold(args[0], "reset"):
+				if err := m.store.SetUserLanguage(evt.Sender, ""); err != nil {
+					return "", err
+				}
+				return m.text(m.messageLanguage(evt), msgLanguageReset), nil
+			case len(args) == 1:
+				lang := strings.ToLower(args[0])
+				if err := validLanguage(lang); err != nil {
+					return "", err
+				}
+				if err := m.store.SetUserLanguage(evt.Sender, lang); err != nil {
+					return "", err
+				}
+				return m.text(lang, msgLanguageSet, languageNames[lang]), nil
+			default:
+				return "", fmt.Errorf("usage: !lang [code|reset]")
+			}
+		},
+	}
+}
+
+// UserLanguage returns the language a user set, or nothing.
+func (s *Store) UserLanguage(userID id.UserID) (string, error) {
+	var lang string
+	err := s.db.QueryRowContext(s.context(), `SELECT language FROM bot_user_language WHERE user_id = $1`, userID).Scan(&lang)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	return lang, err
+}
+
+// SetUserLanguage stores the language of a user. An empty one removes it.
+func (s *Store) SetUserLanguage(userID id.UserID, lang string) error {
+	if lang == "" {
+		_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_user_language WHERE user_id = $1`, userID)
+		return err
+	}
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_user_language (user_id, language) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET language = excluded.language`, userID, lang)
+
+	return err
+}
@@ -0,0 +1,95 @@
+package bot_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestLocale(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name      string
+		language  string
+		catalog   map[string]map[string]string
+		command   string
+		question  string
+		expErr    bool
+		expPrompt string
+		expNotice string
+	}{
+		{
+			name:      "english",
+			question:  "What is the time in Amsterdam?",
+			expPrompt: "Help.",
+			expNotice: "Sorry, I could not get an answer. Please try again later.",
+		},
+		{
+			name:      "detected",
+			question:  "Hoe laat is het in Amsterdam?",
+			expPrompt: "Help.",
+			expNotice: "Sorry, ik kon geen antwoord krijgen. Probeer het later nog eens.",
+		},
+		{
+			name:      "user",
+			command:   "!lang nl",
+			question:  "What is the time in Amsterdam?",
+			expPrompt: "Help.\n\nAlways answer in Nederlands (nl), unless you are asked to translate.",
+			expNotice: "Sorry, ik kon geen antwoord krijgen. Probeer het later nog eens.",
+		},
+		{
+			name:      "bot",
+			language:  "fr",
+			catalog:   map[string]map[string]string{"fr": {"error": "Désolé, pas de réponse."}},
+			question:  "What is the time in Amsterdam?",
+			expPrompt: "Help.\n\nAlways answer in français (fr), unless you are asked to translate.",
+			expNotice: "Désolé, pas de réponse.",
+		},
+		{
+			name:     "unknown language",
+			language: "xx",
+			expErr:   true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fm := bot.NewFakeMatrix()
+			fp := bot.NewFakeProvider()
+			b, err := initTestBot(t, bot.ConfigBot{
+				UserID:            "@bot:ewintr.nl",
+				SystemPrompt:      "Help.",
+				AnswerUnaddressed: true,
+				Language:          tc.language,
+				Catalog:           tc.catalog,
+			}, bot.WithMatrix(fm), bot.WithProvider(fp))
+			if tc.expErr != (err != nil) {
+				t.Fatalf("exp %v, got %v", tc.expErr, err)
+			}
+			if tc.expErr {
+				return
+			}
+			_, h := b.ResponseHandler()
+			if tc.command != "" {
+				h(mautrix.EventSourceTimeline, testMessage("$cmd", tc.command, ""))
+			}
+			fp.FailWith(fmt.Errorf("down"))
+			h(mautrix.EventSourceTimeline, testMessage("$question", tc.question, ""))
+
+			reqs := fp.Requests()
+			if len(reqs) != 1 {
+				t.Fatalf("exp 1, got %v", len(reqs))
+			}
+			if act := reqs[0].Messages[0].Content; act != tc.expPrompt {
+				t.Errorf("exp %q, got %q", tc.expPrompt, act)
+			}
+			msgs := fm.Messages()
+			if act := msgs[len(msgs)-1].Content.(*event.MessageEventContent).Body; !strings.Contains(act, tc.expNotice) {
+				t.Errorf("exp %q in %q", tc.expNotice, act)
+			}
+		})
+	}
+}
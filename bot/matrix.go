@@ -0,0 +1,267 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Matrix is what the handlers do in rooms, and what they read about them.
+// The bot uses a mautrix client for it, tests can use a FakeMatrix instead of
+// a homeserver.
+type Matrix interface {
+	SendMessage(roomID id.RoomID, content any, txnID string) (id.EventID, error)
+	SendEvent(roomID id.RoomID, eventType event.Type, content any, txnID string) (id.EventID, error)
+	JoinRoom(roomIDOrAlias string) (id.RoomID, error)
+	LeaveRoom(roomID id.RoomID) error
+	ForgetRoom(roomID id.RoomID) error
+	React(roomID id.RoomID, eventID id.EventID, key string) (id.EventID, error)
+	Typing(roomID id.RoomID, typing bool, timeout time.Duration) error
+	Download(uri id.ContentURI) ([]byte, error)
+
+	// UserID is the user of the bot.
+	UserID() id.UserID
+	// IsEncrypted tells whether the room is encrypted, as far as the sync
+	// has seen.
+	IsEncrypted(roomID id.RoomID) bool
+	GetEvent(roomID id.RoomID, eventID id.EventID) (*event.Event, error)
+	// StateEvent reads the content of a state event into content. It
+	// returns mautrix.MNotFound when there is no such event.
+	StateEvent(roomID id.RoomID, eventType event.Type, stateKey string, content any) error
+	JoinedMembers(roomID id.RoomID) ([]id.UserID, error)
+	// Member returns the member event of the user in the room, as far as
+	// the sync has seen, without asking the homeserver.
+	Member(roomID id.RoomID, userID id.UserID) (*event.MemberEventContent, bool)
+
+	// DirectChats returns the m.direct account data: the direct chats per
+	// user.
+	DirectChats() (event.DirectChatsEventContent, error)
+	SetDirectChats(direct event.DirectChatsEventContent) error
+	// IsMember tells whether the user has one of the memberships in the
+	// room, as far as the sync has seen.
+	IsMember(roomID id.RoomID, userID id.UserID, memberships ...event.Membership) bool
+	// CreateDirectRoom creates an encrypted direct chat with the user.
+	CreateDirectRoom(userID id.UserID) (id.RoomID, error)
+}
+
+// clientMatrix implements Matrix with a mautrix client. Encryption is done by
+// the crypto helper of the client.
+type clientMatrix struct {
+	client *mautrix.Client
+}
+
+func (cm *clientMatrix) SendMessage(roomID id.RoomID, content any, txnID string) (id.EventID, error) {
+	return cm.SendEvent(roomID, event.EventMessage, content, txnID)
+}
+
+func (cm *clientMatrix) SendEvent(roomID id.RoomID, eventType event.Type, content any, txnID string) (id.EventID, error) {
+	resp, err := cm.client.SendMessageEvent(roomID, eventType, content, mautrix.ReqSendEvent{TransactionID: txnID})
+	if err != nil {
+		return "", err
+	}
+
+	return resp.EventID, nil
+}
+
+func (cm *clientMatrix) JoinRoom(roomIDOrAlias string) (id.RoomID, error) {
+	resp, err := cm.client.JoinRoom(roomIDOrAlias, "", nil)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.RoomID, nil
+}
+
+func (cm *clientMatrix) React(roomID id.RoomID, eventID id.EventID, key string) (id.EventID, error) {
+	resp, err := cm.client.SendReaction(roomID, eventID, key)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.EventID, nil
+}
+
+func (cm *clientMatrix) Typing(roomID id.RoomID, typing bool, timeout time.Duration) error {
+	_, err := cm.client.UserTyping(roomID, typing, timeout)
+	return err
+}
+
+func (cm *clientMatrix) LeaveRoom(roomID id.RoomID) error {
+	_, err := cm.client.LeaveRoom(roomID)
+
+	return err
+}
+
+func (cm *clientMatrix) ForgetRoom(roomID id.RoomID) error {
+	_, err := cm.client.ForgetRoom(roomID)
+
+	return err
+}
+
+func (cm *clientMatrix) Download(uri id.ContentURI) ([]byte, error) {
+	return cm.client.DownloadBytes(uri)
+}
+
+func (cm *clientMatrix) UserID() id.UserID {
+	return cm.client.UserID
+}
+
+func (cm *clientMatrix) IsEncrypted(roomID id.RoomID) bool {
+	return cm.client.StateStore.IsEncrypted(roomID)
+}
+
+func (cm *clientMatrix) GetEvent(roomID id.RoomID, eventID id.EventID) (*event.Event, error) {
+	return cm.client.GetEvent(roomID, eventID)
+}
+
+func (cm *clientMatrix) StateEvent(roomID id.RoomID, eventType event.Type, stateKey string, content any) error {
+	return cm.client.StateEvent(roomID, eventType, stateKey, content)
+}
+
+func (cm *clientMatrix) JoinedMembers(roomID id.RoomID) ([]id.UserID, error) {
+	resp, err := cm.client.JoinedMembers(roomID)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]id.UserID, 0, len(resp.Joined))
+	for userID := range resp.Joined {
+		members = append(members, userID)
+	}
+
+	return members, nil
+}
+
+func (cm *clientMatrix) Member(roomID id.RoomID, userID id.UserID) (*event.MemberEventContent, bool) {
+	return cm.client.StateStore.TryGetMember(roomID, userID)
+}
+
+func (cm *clientMatrix) DirectChats() (event.DirectChatsEventContent, error) {
+	direct := event.DirectChatsEventContent{}
+	if err := cm.client.GetAccountData(event.AccountDataDirectChats.Type, &direct); err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return nil, err
+	}
+
+	return direct, nil
+}
+
+func (cm *clientMatrix) SetDirectChats(direct event.DirectChatsEventContent) error {
+	return cm.client.SetAccountData(event.AccountDataDirectChats.Type, &direct)
+}
+
+func (cm *clientMatrix) IsMember(roomID id.RoomID, userID id.UserID, memberships ...event.Membership) bool {
+	return cm.client.StateStore.IsMembership(roomID, userID, memberships...)
+}
+
+func (cm *clientMatrix) CreateDirectRoom(userID id.UserID) (id.RoomID, error) {
+	encryption := event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}
+	resp, err := cm.client.CreateRoom(&mautrix.ReqCreateRoom{
+		Invite:   []id.UserID{userID},
+		Preset:   "trusted_private_chat",
+		IsDirect: true,
+		InitialState: []*event.Event{{
+			Type:    event.StateEncryption,
+			Content: event.Content{Parsed: &encryption},
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+	// the sync has not seen the new room yet, but the first message must
+	// already be encrypted for the invited user
+	cm.client.StateStore.SetMembership(resp.RoomID, cm.client.UserID, event.MembershipJoin)
+	cm.client.StateStore.SetMembership(resp.RoomID, userID, event.MembershipInvite)
+	cm.client.StateStore.SetEncryptionEvent(resp.RoomID, &encryption)
+
+	return resp.RoomID, nil
+}
+
+// ErrDryRun is returned for what a bot in dry run can not pretend to do.
+var ErrDryRun = errors.New("dry run")
+
+// dryRunMatrix logs the messages and reactions the bot would send, instead of
+// sending them. Rooms are not joined or left, direct chats are not created and
+// typing is not shown. Media is still downloaded and rooms are still read, as
+// nobody in the room sees that.
+type dryRunMatrix struct {
+	Matrix
+	logger *slog.Logger
+	bot    string
+}
+
+func (dm *dryRunMatrix) SendMessage(roomID id.RoomID, content any, txnID string) (id.EventID, error) {
+	if txnID == "" {
+		txnID = newTxnID()
+	}
+	body := fmt.Sprintf("%v", content)
+	if mc, ok := content.(*event.MessageEventContent); ok {
+		body = mc.Body
+	}
+	dm.logger.Info("dry run, not sending message", slog.String("room_id", roomID.String()), slog.String("body", body), slog.String("bot", dm.bot))
+
+	return id.EventID("$dryrun." + txnID), nil
+}
+
+func (dm *dryRunMatrix) SendEvent(roomID id.RoomID, eventType event.Type, content any, txnID string) (id.EventID, error) {
+	if txnID == "" {
+		txnID = newTxnID()
+	}
+	dm.logger.Info("dry run, not sending event", slog.String("room_id", roomID.String()), slog.String("type", eventType.Type), slog.String("content", fmt.Sprintf("%v", content)), slog.String("bot", dm.bot))
+
+	return id.EventID("$dryrun." + txnID), nil
+}
+
+func (dm *dryRunMatrix) JoinRoom(roomIDOrAlias string) (id.RoomID, error) {
+	dm.logger.Info("dry run, not joining room", slog.String("room", roomIDOrAlias), slog.String("bot", dm.bot))
+
+	return "", ErrDryRun
+}
+
+func (dm *dryRunMatrix) LeaveRoom(roomID id.RoomID) error {
+	dm.logger.Info("dry run, not leaving room", slog.String("room_id", roomID.String()), slog.String("bot", dm.bot))
+
+	return ErrDryRun
+}
+
+// ForgetRoom only logs, the bot is already out of the room and what it kept
+// for the room locally can go.
+func (dm *dryRunMatrix) ForgetRoom(roomID id.RoomID) error {
+	dm.logger.Info("dry run, not forgetting room", slog.String("room_id", roomID.String()), slog.String("bot", dm.bot))
+
+	return nil
+}
+
+// CreateDirectRoom pretends to create the room, so that direct messages are
+// logged like the others.
+func (dm *dryRunMatrix) CreateDirectRoom(userID id.UserID) (id.RoomID, error) {
+	dm.logger.Info("dry run, not creating direct chat", slog.String("user_id", userID.String()), slog.String("bot", dm.bot))
+
+	return id.RoomID("!dryrun." + newTxnID()), nil
+}
+
+func (dm *dryRunMatrix) SetDirectChats(direct event.DirectChatsEventContent) error {
+	return nil
+}
+
+func (dm *dryRunMatrix) React(roomID id.RoomID, eventID id.EventID, key string) (id.EventID, error) {
+	dm.logger.Info("dry run, not reacting", slog.String("room_id", roomID.String()), slog.String("event_id", eventID.String()), slog.String("key", key), slog.String("bot", dm.bot))
+
+	return id.EventID("$dryrun." + newTxnID()), nil
+}
+
+func (dm *dryRunMatrix) Typing(roomID id.RoomID, typing bool, timeout time.Duration) error {
+	return nil
+}
+
+var txnCounter atomic.Int64
+
+// newTxnID returns a transaction ID that is unique for this process. The
+// homeserver uses it to recognize a message that is sent again.
+func newTxnID() string {
+	return fmt.Sprintf("mb%d.%d", time.Now().UnixNano(), txnCounter.Add(1))
+}
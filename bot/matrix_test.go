@@ -0,0 +1,108 @@
+package bot_test
+
+import (
+	"strings"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestFakeMatrix_Commands(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		sender id.UserID
+		body   string
+		exp    string
+	}{
+		{
+			name:   "help",
+			sender: "@someone:ewintr.nl",
+			body:   "!help",
+			exp:    "!help",
+		},
+		{
+			name:   "admin only",
+			sender: "@someone:ewintr.nl",
+			body:   "!dm @other:ewintr.nl hi",
+			exp:    "Sorry, only admins can use !dm.",
+		},
+		{
+			name:   "schedule list",
+			sender: "@someone:ewintr.nl",
+			body:   "!schedule list",
+			exp:    "No schedules in this room.",
+		},
+		{
+			name:   "schedule add by non admin",
+			sender: "@someone:ewintr.nl",
+			body:   "!schedule add 0 9 * * 1-5 Standup!",
+			exp:    "only admins can change the schedules of this room",
+		},
+		{
+			name:   "schedule add by admin",
+			sender: "@admin:ewintr.nl",
+			body:   "!schedule add 0 9 * * 1-5 Standup!",
+			exp:    "Added schedule 1.",
+		},
+		{
+			name:   "feed add by non admin",
+			sender: "@someone:ewintr.nl",
+			body:   "!feed add https://example.com/feed.xml",
+			exp:    "only admins can change the feeds of this room",
+		},
+		{
+			name:   "feed add of local address",
+			sender: "@admin:ewintr.nl",
+			body:   "!feed add http://127.0.0.1:8080/feed.xml",
+			exp:    "address is not public",
+		},
+		{
+			name:   "calendar add by non admin",
+			sender: "@someone:ewintr.nl",
+			body:   "!calendar add https://example.com/team.ics",
+			exp:    "Sorry, only admins can use !calendar.",
+		},
+		{
+			name:   "calendar add of local address",
+			sender: "@admin:ewintr.nl",
+			body:   "!calendar add http://192.168.1.10/team.ics",
+			exp:    "address is not public",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := bot.NewFakeMatrix()
+			b := newTestBot(t, bot.ConfigBot{
+				UserID: "@bot:ewintr.nl",
+				Admins: []string{"@admin:ewintr.nl"},
+			}, bot.WithMatrix(fake))
+			_, h := b.ResponseHandler()
+			h(mautrix.EventSourceTimeline, &event.Event{
+				ID:     "$event",
+				RoomID: "!room:ewintr.nl",
+				Sender: tc.sender,
+				Type:   event.EventMessage,
+				Content: event.Content{Parsed: &event.MessageEventContent{
+					MsgType: event.MsgText,
+					Body:    tc.body,
+				}},
+			})
+
+			msgs := fake.Messages()
+			if len(msgs) != 1 {
+				t.Fatalf("exp 1, got %v", len(msgs))
+			}
+			content, ok := msgs[0].Content.(*event.MessageEventContent)
+			if !ok {
+				t.Fatalf("exp message content, got %T", msgs[0].Content)
+			}
+			if !strings.Contains(content.Body, tc.exp) {
+				t.Errorf("exp %v, got %v", tc.exp, content.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/exp/slog"
+)
+
+// Option changes how a bot is built. Options are given to New.
+type Option func(*Bot)
+
+// WithLogger sets the logger of the bot itself. Without it, the default
+// slog logger is used.
+func WithLogger(l *slog.Logger) Option {
+	return func(m *Bot) {
+		m.logger = l
+	}
+}
+
+// WithClientLogger sets the logger for the Matrix client and the crypto
+// machinery, which are silent by default.
+func WithClientLogger(l zerolog.Logger) Option {
+	return func(m *Bot) {
+		m.clientLog = &l
+	}
+}
+
+// WithOpenAIKey sets the API key for the default provider. It is not needed
+// with WithProvider.
+func WithOpenAIKey(key string) Option {
+	return func(m *Bot) {
+		m.openaiKey = key
+	}
+}
+
+// WithProvider replaces the OpenAI provider.
+func WithProvider(p Provider) Option {
+	return func(m *Bot) {
+		m.gptClient = p
+	}
+}
+
+// WithFallbackProvider sets the provider that answers once the monthly
+// budget is used up, like a local model. It is called with the Fallback
+// model of the budget.
+func WithFallbackProvider(p Provider) Option {
+	return func(m *Bot) {
+		m.fallback = p
+	}
+}
+
+// WithStore makes the bot keep its state in s, instead of in the database at
+// DBPath. The crypto store stays at DBPath.
+func WithStore(s *Store) Option {
+	return func(m *Bot) {
+		m.store = s
+	}
+}
+
+// WithMatrix makes the bot do everything in rooms through mx, like a
+// FakeMatrix, instead of connecting to a homeserver. Init then only sets up
+// the handling of messages, which is meant for testing handlers, see
+// ResponseHandler. The bot needs WithStore as well.
+func WithMatrix(mx Matrix) Option {
+	return func(m *Bot) {
+		m.matrix = mx
+	}
+}
+
+// WithHTTPClient sets the HTTP client for the homeserver and the default
+// provider, for example to go through a proxy. The timeouts from the config
+// are applied to copies of it.
+func WithHTTPClient(c *http.Client) Option {
+	return func(m *Bot) {
+		m.httpClient = c
+	}
+}
@@ -0,0 +1,69 @@
+package bot_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestOptions(t *testing.T) {
+	t.Parallel()
+
+	fp := bot.NewFakeProvider()
+	fp.Default("from the provider option")
+	var viaClient []string
+	hc := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		viaClient = append(viaClient, req.URL.Host)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"choices":[{"message":{"role":"assistant","content":"from the http client option"}}]}`)),
+		}, nil
+	})}
+
+	for _, tc := range []struct {
+		name       string
+		opts       []bot.Option
+		exp        string
+		expViaHTTP int
+	}{
+		{name: "provider", opts: []bot.Option{bot.WithProvider(fp)}, exp: "from the provider option"},
+		{name: "http client", opts: []bot.Option{bot.WithOpenAIKey("key"), bot.WithHTTPClient(hc)}, exp: "from the http client option", expViaHTTP: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			viaClient = nil
+			fm := bot.NewFakeMatrix()
+			// without the FakeProvider of newTestBot
+			b := bot.New(bot.ConfigBot{
+				UserID:            "@bot:ewintr.nl",
+				AnswerUnaddressed: true,
+			}, append([]bot.Option{bot.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))), bot.WithStore(newTestStore(t)), bot.WithMatrix(fm)}, tc.opts...)...)
+			if err := b.Init(false); err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			_, h := b.ResponseHandler()
+			h(mautrix.EventSourceTimeline, testMessage("$q1", "Hello?", ""))
+
+			msgs := fm.Messages()
+			if len(msgs) != 1 {
+				t.Fatalf("exp 1, got %v", len(msgs))
+			}
+			if act := msgs[0].Content.(*event.MessageEventContent).Body; act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+			if act := len(viaClient); act != tc.expViaHTTP {
+				t.Errorf("exp %v, got %v", tc.expViaHTTP, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	outboxInterval = 10 * time.Second
+	// outboxClaim keeps the queue from sending a message that is still
+	// being sent directly. After a crash it is picked up when this passed.
+	outboxClaim = 5 * time.Minute
+	outboxKeep  = 24 * time.Hour
+)
+
+// outboxBackoff is used for messages in the queue.
+var outboxBackoff = Backoff{Base: 10 * time.Second, Max: 10 * time.Minute}
+
+// ErrQueued means a message could not be sent now, but is in the queue and
+// will be sent later.
+var ErrQueued = errors.New("message queued")
+
+// Outgoing is a message in the queue. It keeps its transaction ID, so the
+// homeserver ignores it if it arrived before after all.
+//
+// Content is the message before it is encrypted, so messages for encrypted
+// rooms are in the store as plain text until they are sent or given up on.
+type Outgoing struct {
+	ID        int64
+	RoomID    id.RoomID
+	TxnID     string
+	Content   json.RawMessage
+	CreatedAt time.Time
+	Attempts  int
+}
+
+// runOutbox sends the queued messages that are due. When the bot stops, it
+// makes one last attempt.
+func (m *Bot) runOutbox() {
+	for running := true; ; running = m.wait(outboxInterval) {
+		m.flushOutbox(time.Now())
+		if !running {
+			return
+		}
+	}
+}
+
+func (m *Bot) flushOutbox(now time.Time) {
+	queued, err := m.store.DueOutgoing(now)
+	if err != nil {
+		m.logger.Error("failed to get queued messages", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		return
+	}
+	for _, o := range queued {
+		_, err := m.matrix.SendMessage(o.RoomID, o.Content, o.TxnID)
+		if err == nil {
+			if err := m.store.DeleteOutgoing(o.ID); err != nil {
+				m.logger.Error("failed to remove sent message from queue", slog.String("err", err.Error()), slog.Int64("outgoing", o.ID), slog.String("bot", m.config.UserDisplayName))
+			}
+			continue
+		}
+		attempts := o.Attempts + 1
+		wait, retry := SendRetryDelay(err, outboxBackoff, attempts)
+		if !retry || now.Sub(o.CreatedAt) > outboxKeep {
+			m.logger.Error("giving up on queued message", slog.String("err", err.Error()), slog.String("room_id", o.RoomID.String()), slog.Int("attempts", attempts), slog.String("bot", m.config.UserDisplayName))
+			if err := m.store.DeleteOutgoing(o.ID); err != nil {
+				m.logger.Error("failed to remove message from queue", slog.String("err", err.Error()), slog.Int64("outgoing", o.ID), slog.String("bot", m.config.UserDisplayName))
+			}
+			continue
+		}
+		if err := m.store.RescheduleOutgoing(o.ID, attempts, now.Add(wait)); err != nil {
+			m.logger.Error("failed to reschedule queued message", slog.String("err", err.Error()), slog.Int64("outgoing", o.ID), slog.String("bot", m.config.UserDisplayName))
+		}
+	}
+}
+
+// AddOutgoing queues a message, to be sent from nextAt.
+func (s *Store) AddOutgoing(o Outgoing, nextAt time.Time) (int64, error) {
+	var outgoingID int64
+	err := s.db.QueryRowContext(s.context(), `INSERT INTO bot_outbox (room_id, txn_id, content, created_at, attempts, next_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		o.RoomID, o.TxnID, string(o.Content), o.CreatedAt.Unix(), o.Attempts, nextAt.Unix()).Scan(&outgoingID)
+
+	return outgoingID, err
+}
+
+// DueOutgoing returns the queued messages that should be sent at now, oldest
+// first.
+func (s *Store) DueOutgoing(now time.Time) ([]Outgoing, error) {
+	rows, err := s.db.QueryContext(s.context(), `SELECT id, room_id, txn_id, content, created_at, attempts FROM bot_outbox WHERE next_at <= $1 ORDER BY id`, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var queued []Outgoing
+	for rows.Next() {
+		var o Outgoing
+		var content string
+		var createdAt int64
+		if err := rows.Scan(&o.ID, &o.RoomID, &o.TxnID, &content, &createdAt, &o.Attempts); err != nil {
+			return nil, err
+		}
+		o.Content = json.RawMessage(content)
+		o.CreatedAt = time.Unix(createdAt, 0)
+		queued = append(queued, o)
+	}
+
+	return queued, rows.Err()
+}
+
+func (s *Store) RescheduleOutgoing(outgoingID int64, attempts int, nextAt time.Time) error {
+	_, err := s.db.ExecContext(s.context(), `UPDATE bot_outbox SET attempts = $2, next_at = $3 WHERE id = $1`, outgoingID, attempts, nextAt.Unix())
+	return err
+}
+
+func (s *Store) DeleteOutgoing(outgoingID int64) error {
+	_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_outbox WHERE id = $1`, outgoingID)
+	return err
+}
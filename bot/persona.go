@@ -0,0 +1,362 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Persona is a character the bot can play, with its own system prompt and
+// model. A persona answers in the Rooms it is assigned to, and anywhere when
+// a message starts with its Trigger, like "helpdesk: my printer is on fire".
+// Tools lists the names of the tools the persona is allowed to use.
+// Temperature and MaxTokens are passed to the model when they are set.
+// Answers of a persona show its DisplayName, or else its Name, so several
+// personas can share one account. Examples are put between the system
+// prompt and the question of every new conversation, to show the model how
+// to answer. With CodeBlocks, the persona also answers anywhere when the bot
+// is addressed or mentioned in a message with a fenced code block. With
+// Thread, it answers in a thread on the question.
+type Persona struct {
+	Name         string
+	DisplayName  string
+	Description  string
+	SystemPrompt string
+	Examples     []Example
+	Model        string
+	Temperature  float32
+	MaxTokens    int
+	Tools        []string
+	Rooms        []string
+	Trigger      string
+	CodeBlocks   bool
+	Thread       bool
+}
+
+// Example is a question and the answer the model should give to questions
+// like it.
+type Example struct {
+	User      string
+	Assistant string
+}
+
+// exampleMessages are the examples as messages of a conversation.
+func (p Persona) exampleMessages() []Message {
+	msgs := make([]Message, 0, 2*len(p.Examples))
+	for _, e := range p.Examples {
+		msgs = append(msgs,
+			Message{Role: openai.ChatMessageRoleUser, Content: e.User},
+			Message{Role: openai.ChatMessageRoleAssistant, Content: e.Assistant},
+		)
+	}
+
+	return msgs
+}
+
+func (p Persona) params() CompletionParams {
+	return CompletionParams{Temperature: p.Temperature, MaxTokens: p.MaxTokens}
+}
+
+// perMessageProfile is the profile of MSC4144, that clients show instead of
+// the display name of the sender.
+type perMessageProfile struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayname"`
+}
+
+type personaMessageContent struct {
+	*event.MessageEventContent
+	Profile perMessageProfile `json:"com.beeper.per_message_profile"`
+}
+
+// personaReply labels the reply with the name of the persona, as per-message
+// profile and, for clients that do not know those, in front of the text.
+func personaReply(p Persona, content *event.MessageEventContent) any {
+	name := p.DisplayName
+	if name == "" {
+		name = p.Name
+	}
+	content.EnsureHasHTML()
+	content.Body = name + ": " + content.Body
+	content.FormattedBody = "<strong data-mx-profile-fallback>" + html.EscapeString(name) + ": </strong>" + content.FormattedBody
+
+	return &personaMessageContent{
+		MessageEventContent: content,
+		Profile:             perMessageProfile{ID: p.Name, DisplayName: name},
+	}
+}
+
+func (p Persona) inRoom(roomID id.RoomID) bool {
+	return contains(p.Rooms, roomID.String())
+}
+
+// allowedIn tells whether the persona may be picked for the room. A persona
+// with Rooms is limited to those.
+func (p Persona) allowedIn(roomID id.RoomID) bool {
+	return len(p.Rooms) == 0 || p.inRoom(roomID)
+}
+
+// RegisterPersona adds a persona as a message handler with the persona name,
+// so it can be switched on and off per room like any other plugin. Without a
+// system prompt, the persona uses that of the bot.
+func (m *Bot) RegisterPersona(p Persona) error {
+	m.personaMu.Lock()
+	defer m.personaMu.Unlock()
+
+	if _, ok := m.personas[p.Name]; ok {
+		return fmt.Errorf("duplicate persona %q", p.Name)
+	}
+
+	return m.addPersona(p)
+}
+
+// addPersona adds or replaces the persona. The handler is added once per
+// name and answers as the persona that has the name at that moment, so
+// personas can be replaced while the bot runs. The caller holds personaMu.
+func (m *Bot) addPersona(p Persona) error {
+	if p.Name == "" {
+		return fmt.Errorf("persona without name")
+	}
+	if p.SystemPrompt == "" {
+		p.SystemPrompt = m.config.SystemPrompt
+	}
+	if err := validatePrompt(p.SystemPrompt); err != nil {
+		return fmt.Errorf("persona %q: %w", p.Name, err)
+	}
+	if m.personaDir.handlers[p.Name] {
+		m.personas[p.Name] = p
+		return nil
+	}
+	if _, ok := m.dispatcher.Handler(p.Name); ok {
+		return fmt.Errorf("persona %q has the name of a plugin", p.Name)
+	}
+	m.personas[p.Name] = p
+	if m.personaDir.handlers == nil {
+		m.personaDir.handlers = make(map[string]bool)
+	}
+	m.personaDir.handlers[p.Name] = true
+	name := p.Name
+	m.AddMessageHandler(NewMessageHandler(name, PriorityPersona, func(evt *event.Event) bool {
+		p, ok := m.lookupPersona(name)
+		if !ok {
+			return false
+		}
+		return m.personaHandler(p, PriorityPersona, false).HandleMessage(evt)
+	}))
+
+	return nil
+}
+
+// lookupPersona returns the persona with the name, as it is now.
+func (m *Bot) lookupPersona(name string) (Persona, bool) {
+	m.personaMu.RLock()
+	defer m.personaMu.RUnlock()
+
+	p, ok := m.personas[name]
+	return p, ok
+}
+
+// roomPersona returns the persona with the name, if it may be picked for the
+// room.
+func (m *Bot) roomPersona(roomID id.RoomID, name string) (Persona, bool) {
+	p, ok := m.lookupPersona(name)
+	if !ok || !p.allowedIn(roomID) {
+		return Persona{}, false
+	}
+
+	return p, true
+}
+
+// listPersonas returns the personas, sorted by name.
+func (m *Bot) listPersonas() []Persona {
+	m.personaMu.RLock()
+	defer m.personaMu.RUnlock()
+
+	personas := make([]Persona, 0, len(m.personas))
+	for _, p := range m.personas {
+		personas = append(personas, p)
+	}
+	sort.Slice(personas, func(i, j int) bool { return personas[i].Name < personas[j].Name })
+
+	return personas
+}
+
+// personaHandler answers as the persona when the message continues one of its
+// conversations, starts with its trigger or, if the persona is the default or
+// assigned to the room, when the message is addressed to the bot.
+func (m *Bot) personaHandler(p Persona, priority int, isDefault bool) MessageHandler {
+	return NewMessageHandler(p.Name, priority, func(evt *event.Event) bool {
+		content := evt.Content.AsMessage()
+		eventID := evt.ID
+
+		// find out if it is a reply to a known conversation, or a follow-up
+		// in the thread of one
+		var hasParent bool
+		if relatesTo := content.GetRelatesTo(); relatesTo != nil {
+			parentID := relatesTo.GetReplyTo()
+			var c *Conversation
+			if parentID != "" {
+				hasParent = true
+				m.logger.Info("message is a reply", slog.String("parent_id", parentID.String()))
+				c = m.findConversation(parentID)
+			}
+			// in a thread, the reply can be to a message that is not part of
+			// the conversation, like one of another user
+			if threadID := relatesTo.GetThreadParent(); c == nil && threadID != "" {
+				if c = m.findConversation(threadID); c != nil {
+					parentID = threadID
+				}
+			}
+			if c != nil {
+				if c.Persona != p.Name {
+					return false
+				}
+				m.logger.Info("found parent, appending message to conversation", slog.String("event_id", eventID.String()), slog.String("persona", p.Name), slog.String("bot", m.config.UserDisplayName))
+				// the prompt may have changed since the conversation was stored
+				c.SetSystemPrompt(m.systemPrompt(evt, p))
+				c.Add(Message{
+					EventID:  eventID,
+					ParentID: parentID,
+					Role:     openai.ChatMessageRoleUser,
+					Content:  m.guardMessage(evt, content.Body),
+				})
+				return m.respond(evt, p, c)
+			}
+		}
+
+		addressedTo, _, isAddressed := strings.Cut(content.Body, ": ")
+		addressedTo = strings.TrimSpace(strings.ToLower(addressedTo))
+		if strings.Contains(addressedTo, " ") {
+			isAddressed = false // only display names without spaces, otherwise no way to know if it's a name or not
+		}
+
+		var conv *Conversation
+		switch {
+		// a new question with the trigger of the persona
+		case p.Trigger != "" && isAddressed && addressedTo == strings.ToLower(p.Trigger):
+			m.logger.Info("message has persona trigger", slog.String("event_id", eventID.String()), slog.String("persona", p.Name), slog.String("bot", m.config.UserDisplayName))
+			conv = m.newConversation(evt, p, content.Body)
+		// code to look at, for the persona that does that
+		case p.CodeBlocks && hasCodeBlock(content.Body) && ((isAddressed && addressedTo == m.config.UserDisplayName) || m.mentioned(content)):
+			m.logger.Info("message has code for persona", slog.String("event_id", eventID.String()), slog.String("persona", p.Name), slog.String("bot", m.config.UserDisplayName))
+			conv = m.newConversation(evt, p, content.Body)
+		// other personas only answer in their own rooms
+		case !isDefault && !p.inRoom(evt.RoomID):
+		// a new question addressed to the bot
+		case isAddressed && addressedTo == m.config.UserDisplayName:
+			m.logger.Info("message is addressed to bot", slog.String("event_id", eventID.String()), slog.String("persona", p.Name), slog.String("bot", m.config.UserDisplayName))
+			conv = m.newConversation(evt, p, content.Body)
+		// a message addressed to no-one and this bot answers those
+		case !isAddressed && !hasParent && m.answerUnaddressed(evt.RoomID):
+			m.logger.Info("message is addressed to no-one", slog.String("event_id", eventID.String()), slog.String("persona", p.Name), slog.String("bot", m.config.UserDisplayName))
+			conv = m.newConversation(evt, p, content.Body)
+		}
+
+		if conv == nil {
+			m.logger.Info("apparently not for us, ignoring", slog.String("event_id", eventID.String()), slog.String("persona", p.Name), slog.String("bot", m.config.UserDisplayName))
+			return false
+		}
+		if urls := m.linkedURLs(evt, p, content.Body); len(urls) > 0 {
+			// fetching the pages takes a while, the sync loop does not wait for it
+			m.goLoop(func() {
+				conv.InsertBefore(evt.ID, m.pageMessages(urls)...)
+				m.respond(evt, p, conv)
+			})
+			return true
+		}
+
+		return m.respond(evt, p, conv)
+	})
+}
+
+func (m *Bot) newConversation(evt *event.Event, p Persona, question string) *Conversation {
+	conv := NewConversation(evt.ID, m.systemPrompt(evt, p), m.guardMessage(evt, question))
+	conv.RoomID = evt.RoomID
+	conv.Persona = p.Name
+	extra := m.guardExamples(p.exampleMessages())
+	if msg, ok := m.calendarMessage(evt.RoomID, time.Now().In(m.userLocation(evt.Sender))); ok {
+		extra = append(extra, msg)
+	}
+	if len(extra) > 0 {
+		// after the system prompt, before the question
+		conv.Messages = append(conv.Messages[:1], append(extra, conv.Messages[1:]...)...)
+	}
+
+	if err := m.conversations.Add(conv); err != nil {
+		m.logger.Error("failed to store conversation", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+	}
+
+	return conv
+}
+
+func (m *Bot) findConversation(eventID id.EventID) *Conversation {
+	conv, err := m.conversations.Find(eventID)
+	if err != nil {
+		m.logger.Error("failed to find conversation", slog.String("err", err.Error()), slog.String("event_id", eventID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+
+	return conv
+}
+
+func (m *Bot) personaCommand() Command {
+	return Command{
+		Name:      "persona",
+		Usage:     "list|<name>|default",
+		Help:      "show or pick the persona that answers in this room",
+		AdminOnly: true,
+		Run: func(evt *event.Event, args []string) (string, error) {
+			rc := m.roomConfig(evt.RoomID)
+			if len(args) == 0 || args[0] == "list" {
+				personas := m.listPersonas()
+				if len(personas) == 0 {
+					return "There are no personas.", nil
+				}
+				var lines []string
+				for _, p := range personas {
+					if !p.allowedIn(evt.RoomID) {
+						continue
+					}
+					line := fmt.Sprintf("- %s (%s)", p.Name, modelOrDefault(p.Model))
+					if p.Description != "" {
+						line += ": " + p.Description
+					}
+					if p.Name == rc.Persona {
+						line += ", active"
+					}
+					lines = append(lines, line)
+				}
+				return strings.Join(lines, "\n"), nil
+			}
+			if len(args) != 1 {
+				return "", errors.New("usage: !persona list|<name>|default")
+			}
+
+			name := args[0]
+			if name == "default" {
+				name = ""
+			}
+			if p, ok := m.lookupPersona(name); name != "" && !ok {
+				return "", fmt.Errorf("unknown persona %q", name)
+			} else if name != "" && !p.allowedIn(evt.RoomID) {
+				return "", fmt.Errorf("persona %q is not available in this room", name)
+			}
+			rc.Persona = name
+			if err := m.store.SetRoomConfig(evt.RoomID, rc); err != nil {
+				return "", err
+			}
+			if name == "" {
+				return "The bot answers as itself in this room again.", nil
+			}
+
+			return fmt.Sprintf("Persona %s now answers in this room.", name), nil
+		},
+	}
+}
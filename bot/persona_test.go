@@ -0,0 +1,317 @@
+package bot_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestPersonaCommand(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name      string
+		command   string
+		expReply  string
+		expPrompt string
+		expParams bot.CompletionParams
+	}{
+		{
+			name:      "list",
+			command:   "!persona list",
+			expReply:  "* helpdesk (gpt-3.5-turbo)",
+			expPrompt: "You are the bot.",
+		},
+		{
+			name:      "switch",
+			command:   "!persona helpdesk",
+			expReply:  "Persona helpdesk now answers in this room.",
+			expPrompt: "You are a patient helpdesk employee.",
+			expParams: bot.CompletionParams{Temperature: 0.2, MaxTokens: 100},
+		},
+		{
+			name:      "default",
+			command:   "!persona default",
+			expReply:  "The bot answers as itself in this room again.",
+			expPrompt: "You are the bot.",
+		},
+		{
+			name:      "unknown",
+			command:   "!persona pirate",
+			expReply:  `unknown persona "pirate"`,
+			expPrompt: "You are the bot.",
+		},
+		{
+			name:      "other room",
+			command:   "!persona ops",
+			expReply:  `persona "ops" is not available in this room`,
+			expPrompt: "You are the bot.",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fm := bot.NewFakeMatrix()
+			fp := bot.NewFakeProvider()
+			b := newTestBot(t, bot.ConfigBot{
+				UserID:            "@bot:ewintr.nl",
+				SystemPrompt:      "You are the bot.",
+				AnswerUnaddressed: true,
+				Admins:            []string{"@admin:ewintr.nl"},
+				Personas: []bot.Persona{{
+					Name:         "helpdesk",
+					SystemPrompt: "You are a patient helpdesk employee.",
+					Model:        "gpt-3.5-turbo",
+					Temperature:  0.2,
+					MaxTokens:    100,
+				}, {
+					Name:         "ops",
+					SystemPrompt: "You run the servers.",
+					Rooms:        []string{"!ops:ewintr.nl"},
+				}},
+			}, bot.WithMatrix(fm), bot.WithProvider(fp))
+			_, h := b.ResponseHandler()
+
+			cmd := testMessage("$cmd", tc.command, "")
+			cmd.Sender = id.UserID("@admin:ewintr.nl")
+			h(mautrix.EventSourceTimeline, cmd)
+			h(mautrix.EventSourceTimeline, testMessage("$q1", "Hello", ""))
+
+			msgs := fm.Messages()
+			if len(msgs) != 2 {
+				t.Fatalf("exp 2, got %v", len(msgs))
+			}
+			if act := msgs[0].Content.(*event.MessageEventContent).Body; !strings.Contains(act, tc.expReply) {
+				t.Errorf("exp %q in %v", tc.expReply, act)
+			}
+			reqs := fp.Requests()
+			if len(reqs) != 1 {
+				t.Fatalf("exp 1, got %v", len(reqs))
+			}
+			if act := reqs[0].Messages[0].Content; act != tc.expPrompt {
+				t.Errorf("exp %v, got %v", tc.expPrompt, act)
+			}
+			if reqs[0].Params != tc.expParams {
+				t.Errorf("exp %v, got %v", tc.expParams, reqs[0].Params)
+			}
+		})
+	}
+}
+
+func TestChatPersona(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name         string
+		topicPersona bool
+		topic        string
+		rc           bot.RoomConfigEventContent
+		expPrompt    string
+	}{
+		{
+			name:      "default",
+			topic:     "Support | persona: helpdesk",
+			expPrompt: "You are the bot.",
+		},
+		{
+			name:         "topic",
+			topicPersona: true,
+			topic:        "Support | persona: helpdesk",
+			expPrompt:    "You are a patient helpdesk employee.",
+		},
+		{
+			name:         "unknown persona in topic",
+			topicPersona: true,
+			topic:        "persona: pirate",
+			expPrompt:    "You are the bot.",
+		},
+		{
+			name:         "room config first",
+			topicPersona: true,
+			topic:        "persona: helpdesk",
+			rc:           bot.RoomConfigEventContent{Persona: "poet"},
+			expPrompt:    "You are a poet.",
+		},
+		{
+			name:         "topic persona of other room",
+			topicPersona: true,
+			topic:        "persona: ops",
+			expPrompt:    "You are the bot.",
+		},
+		{
+			name:      "room config persona of other room",
+			rc:        bot.RoomConfigEventContent{Persona: "ops"},
+			expPrompt: "You are the bot.",
+		},
+		{
+			name:      "room prompt",
+			rc:        bot.RoomConfigEventContent{Persona: "poet", Prompt: "Answer in Dutch."},
+			expPrompt: "Answer in Dutch.",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newTestStore(t)
+			if err := store.SaveRoom(bot.Room{ID: "!room:ewintr.nl", Topic: tc.topic}, time.Now()); err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			if err := store.SetRoomConfig("!room:ewintr.nl", tc.rc); err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			fp := bot.NewFakeProvider()
+			b := newTestBot(t, bot.ConfigBot{
+				UserID:            "@bot:ewintr.nl",
+				SystemPrompt:      "You are the bot.",
+				AnswerUnaddressed: true,
+				TopicPersona:      tc.topicPersona,
+				RoomPrompts:       true,
+				Personas: []bot.Persona{
+					{Name: "helpdesk", SystemPrompt: "You are a patient helpdesk employee."},
+					{Name: "poet", SystemPrompt: "You are a poet."},
+					{Name: "ops", SystemPrompt: "You run the servers.", Rooms: []string{"!ops:ewintr.nl"}},
+				},
+			}, bot.WithStore(store), bot.WithProvider(fp))
+			_, h := b.ResponseHandler()
+
+			h(mautrix.EventSourceTimeline, testMessage("$q1", "Hello", ""))
+
+			reqs := fp.Requests()
+			if len(reqs) != 1 {
+				t.Fatalf("exp 1, got %v", len(reqs))
+			}
+			if act := reqs[0].Messages[0].Content; act != tc.expPrompt {
+				t.Errorf("exp %v, got %v", tc.expPrompt, act)
+			}
+		})
+	}
+}
+
+func TestPersonaTriggers(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	fp := bot.NewFakeProvider()
+	b := newTestBot(t, bot.ConfigBot{
+		UserID:          "@bot:ewintr.nl",
+		UserDisplayName: "bot",
+		SystemPrompt:    "You are the bot.",
+		Personas: []bot.Persona{
+			{Name: "coach", DisplayName: "Coach", Trigger: "coach", SystemPrompt: "You are a coach."},
+			{Name: "reviewer", Trigger: "reviewer", SystemPrompt: "You review code."},
+		},
+	}, bot.WithMatrix(fm), bot.WithProvider(fp))
+	_, h := b.ResponseHandler()
+
+	for _, tc := range []struct {
+		name       string
+		body       string
+		replyTo    int
+		expPrompt  string
+		expHistory int
+		expLabel   string
+	}{
+		{name: "coach", body: "coach: how do I start?", replyTo: -1, expPrompt: "You are a coach.", expHistory: 2, expLabel: "Coach"},
+		{name: "reviewer", body: "reviewer: look at this diff", replyTo: -1, expPrompt: "You review code.", expHistory: 2, expLabel: "reviewer"},
+		{name: "continue with coach", body: "and then?", replyTo: 0, expPrompt: "You are a coach.", expHistory: 4, expLabel: "Coach"},
+		{name: "bot itself", body: "bot: hello", replyTo: -1, expPrompt: "You are the bot.", expHistory: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var replyTo id.EventID
+			if tc.replyTo >= 0 {
+				replyTo = fm.Messages()[tc.replyTo].EventID
+			}
+			h(mautrix.EventSourceTimeline, testMessage(id.EventID("$"+strings.ReplaceAll(tc.name, " ", "-")), tc.body, replyTo))
+
+			reqs := fp.Requests()
+			last := reqs[len(reqs)-1]
+			if act := last.Messages[0].Content; act != tc.expPrompt {
+				t.Errorf("exp %v, got %v", tc.expPrompt, act)
+			}
+			if act := len(last.Messages); act != tc.expHistory {
+				t.Errorf("exp %v, got %v", tc.expHistory, act)
+			}
+
+			msgs := fm.Messages()
+			raw, err := json.Marshal(msgs[len(msgs)-1].Content)
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			var content struct {
+				Body    string `json:"body"`
+				Profile *struct {
+					DisplayName string `json:"displayname"`
+				} `json:"com.beeper.per_message_profile"`
+			}
+			if err := json.Unmarshal(raw, &content); err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			if tc.expLabel == "" {
+				if content.Profile != nil {
+					t.Errorf("exp no profile, got %v", content.Profile)
+				}
+				return
+			}
+			if content.Profile == nil || content.Profile.DisplayName != tc.expLabel {
+				t.Errorf("exp profile %v, got %v", tc.expLabel, content.Profile)
+			}
+			if exp := tc.expLabel + ": OK."; content.Body != exp {
+				t.Errorf("exp %v, got %v", exp, content.Body)
+			}
+		})
+	}
+}
+
+func TestPersonaExamples(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	fp := bot.NewFakeProvider()
+	b := newTestBot(t, bot.ConfigBot{
+		UserID:            "@bot:ewintr.nl",
+		SystemPrompt:      "You are the bot.",
+		AnswerUnaddressed: true,
+		Examples:          []bot.Example{{User: "Is it open?", Assistant: "Yes, until five."}},
+		Personas: []bot.Persona{{
+			Name:         "haiku",
+			Trigger:      "haiku",
+			SystemPrompt: "You answer in haiku.",
+			Examples: []bot.Example{
+				{User: "What is Go?", Assistant: "Gophers in the field"},
+				{User: "What is Rust?", Assistant: "Crabs on the shore"},
+			},
+		}},
+	}, bot.WithMatrix(fm), bot.WithProvider(fp))
+	_, h := b.ResponseHandler()
+
+	for _, tc := range []struct {
+		name    string
+		body    string
+		replyTo bool
+		exp     []string
+	}{
+		{name: "chat", body: "Is it closed?", exp: []string{"You are the bot.", "Is it open?", "Yes, until five.", "Is it closed?"}},
+		{name: "persona", body: "haiku: what is Zig?", exp: []string{"You answer in haiku.", "What is Go?", "Gophers in the field", "What is Rust?", "Crabs on the shore", "haiku: what is Zig?"}},
+		{name: "reply", body: "and C?", replyTo: true, exp: []string{"You answer in haiku.", "What is Go?", "Gophers in the field", "What is Rust?", "Crabs on the shore", "haiku: what is Zig?", "OK.", "and C?"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var replyTo id.EventID
+			if tc.replyTo {
+				msgs := fm.Messages()
+				replyTo = msgs[len(msgs)-1].EventID
+			}
+			h(mautrix.EventSourceTimeline, testMessage(id.EventID("$"+tc.name), tc.body, replyTo))
+
+			reqs := fp.Requests()
+			var act []string
+			for _, msg := range reqs[len(reqs)-1].Messages {
+				act = append(act, msg.Content)
+			}
+			if strings.Join(act, "|") != strings.Join(tc.exp, "|") {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"gopkg.in/yaml.v3"
+)
+
+const personaDirInterval = 10 * time.Second
+
+// personaDir keeps track of the personas that were loaded from
+// ConfigBot.PersonaDir, so that changes to the files can be applied.
+type personaDir struct {
+	// handlers are the names of all personas that have a handler
+	handlers map[string]bool
+	// loaded are the names of the personas from the directory
+	loaded map[string]bool
+	// state identifies the files as they were at the last load
+	state string
+}
+
+// personaFile is a persona in a YAML file, or in the front matter of a
+// Markdown file.
+type personaFile struct {
+	Name        string    `yaml:"name"`
+	DisplayName string    `yaml:"display_name"`
+	Description string    `yaml:"description"`
+	Prompt      string    `yaml:"prompt"`
+	Examples    []Example `yaml:"examples"`
+	Model       string    `yaml:"model"`
+	Temperature float32   `yaml:"temperature"`
+	MaxTokens   int       `yaml:"max_tokens"`
+	Tools       []string  `yaml:"tools"`
+	Rooms       []string  `yaml:"rooms"`
+	Trigger     string    `yaml:"trigger"`
+	CodeBlocks  bool      `yaml:"code_blocks"`
+	Thread      bool      `yaml:"thread"`
+}
+
+// LoadPersonaDir reads the personas in the .yaml, .yml and .md files of the
+// directory. A Markdown file has the fields of the persona in YAML front
+// matter and the prompt as text. Without a name, the persona is named after
+// the file.
+func LoadPersonaDir(dir string) ([]Persona, error) {
+	paths, err := personaPaths(dir)
+	if err != nil {
+		return nil, err
+	}
+	var personas []Persona
+	names := make(map[string]string)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		p, err := parsePersonaFile(path, data)
+		if err != nil {
+			return nil, fmt.Errorf("persona %s: %w", filepath.Base(path), err)
+		}
+		if other, ok := names[p.Name]; ok {
+			return nil, fmt.Errorf("persona %q is in both %s and %s", p.Name, filepath.Base(other), filepath.Base(path))
+		}
+		names[p.Name] = path
+		personas = append(personas, p)
+	}
+
+	return personas, nil
+}
+
+func personaPaths(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".yaml", ".yml", ".md":
+			if !e.IsDir() {
+				paths = append(paths, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+func parsePersonaFile(path string, data []byte) (Persona, error) {
+	var pf personaFile
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".md" {
+		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+		if !bytes.HasPrefix(data, []byte("---\n")) {
+			return Persona{}, fmt.Errorf("no front matter")
+		}
+		front, body, ok := bytes.Cut(data[4:], []byte("\n---"))
+		if !ok {
+			return Persona{}, fmt.Errorf("front matter is not closed")
+		}
+		if err := yaml.Unmarshal(front, &pf); err != nil {
+			return Persona{}, err
+		}
+		if prompt := strings.TrimSpace(string(body)); prompt != "" {
+			pf.Prompt = prompt
+		}
+	} else if err := yaml.Unmarshal(data, &pf); err != nil {
+		return Persona{}, err
+	}
+	if pf.Name == "" {
+		pf.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	return Persona{
+		Name:         pf.Name,
+		DisplayName:  pf.DisplayName,
+		Description:  pf.Description,
+		SystemPrompt: strings.TrimSpace(pf.Prompt),
+		Examples:     pf.Examples,
+		Model:        pf.Model,
+		Temperature:  pf.Temperature,
+		MaxTokens:    pf.MaxTokens,
+		Tools:        pf.Tools,
+		Rooms:        pf.Rooms,
+		Trigger:      pf.Trigger,
+		CodeBlocks:   pf.CodeBlocks,
+		Thread:       pf.Thread,
+	}, nil
+}
+
+// personaDirState identifies the persona files by their names, sizes and
+// modification times.
+func personaDirState(dir string) (string, error) {
+	paths, err := personaPaths(dir)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+
+	return b.String(), nil
+}
+
+// ReloadPersonas reads the persona directory again. Personas that were added
+// or changed are available for the next message, those that were removed no
+// longer answer. Personas in the config keep their name. When a file can not
+// be read, nothing changes.
+func (m *Bot) ReloadPersonas() error {
+	state, err := personaDirState(m.config.PersonaDir)
+	if err != nil {
+		return err
+	}
+	personas, err := LoadPersonaDir(m.config.PersonaDir)
+	if err != nil {
+		return err
+	}
+
+	m.personaMu.Lock()
+	defer m.personaMu.Unlock()
+
+	loaded := make(map[string]bool)
+	for _, p := range personas {
+		if _, ok := m.personas[p.Name]; ok && !m.personaDir.loaded[p.Name] {
+			m.logger.Warn("persona from directory has the name of a configured persona", slog.String("persona", p.Name), slog.String("bot", m.config.UserDisplayName))
+			continue
+		}
+		if err := m.addPersona(p); err != nil {
+			m.logger.Warn("failed to add persona from directory", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+			continue
+		}
+		loaded[p.Name] = true
+	}
+	for name := range m.personaDir.loaded {
+		if !loaded[name] {
+			delete(m.personas, name)
+		}
+	}
+	m.personaDir.loaded = loaded
+	m.personaDir.state = state
+
+	return nil
+}
+
+// runPersonaDir reloads the personas when the files in the directory
+// change.
+func (m *Bot) runPersonaDir() {
+	if m.config.PersonaDir == "" {
+		return
+	}
+	for m.wait(personaDirInterval) {
+		state, err := personaDirState(m.config.PersonaDir)
+		if err != nil {
+			m.logger.Error("failed to read persona directory", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+			continue
+		}
+		m.personaMu.RLock()
+		changed := state != m.personaDir.state
+		m.personaMu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := m.ReloadPersonas(); err != nil {
+			// report it once, until the files change again
+			m.personaMu.Lock()
+			m.personaDir.state = state
+			m.personaMu.Unlock()
+			m.logger.Error("failed to reload personas", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+			continue
+		}
+		m.logger.Info("reloaded personas", slog.String("dir", m.config.PersonaDir), slog.String("bot", m.config.UserDisplayName))
+	}
+}
@@ -0,0 +1,173 @@
+package bot_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestLoadPersonaDir(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		files  map[string]string
+		expErr bool
+		exp    []bot.Persona
+	}{
+		{
+			name: "yaml",
+			files: map[string]string{
+				"helpdesk.yaml": "description: Answers support questions\nprompt: You are a patient helpdesk employee.\nmodel: gpt-3.5-turbo\ntemperature: 0.2\nmax_tokens: 500\ntools: [fetch_url]\nexamples:\n  - user: Is it open?\n    assistant: Yes, until five.\n",
+				"notes.txt":     "not a persona",
+			},
+			exp: []bot.Persona{{Name: "helpdesk", Description: "Answers support questions", SystemPrompt: "You are a patient helpdesk employee.", Model: "gpt-3.5-turbo", Temperature: 0.2, MaxTokens: 500, Tools: []string{"fetch_url"}, Examples: []bot.Example{{User: "Is it open?", Assistant: "Yes, until five."}}}},
+		},
+		{
+			name: "markdown",
+			files: map[string]string{
+				"pirate.md": "---\nname: captain\ntrigger: captain\n---\n\nYou are a pirate.\nAnswer like one.\n",
+			},
+			exp: []bot.Persona{{Name: "captain", SystemPrompt: "You are a pirate.\nAnswer like one.", Trigger: "captain"}},
+		},
+		{
+			name:   "no front matter",
+			files:  map[string]string{"pirate.md": "You are a pirate."},
+			expErr: true,
+		},
+		{
+			name: "duplicate",
+			files: map[string]string{
+				"a.yaml": "name: same\n",
+				"b.yaml": "name: same\n",
+			},
+			expErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatalf("exp nil, got %v", err)
+				}
+			}
+
+			act, err := bot.LoadPersonaDir(dir)
+			if tc.expErr {
+				if err == nil {
+					t.Errorf("exp error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			if len(act) != len(tc.exp) {
+				t.Fatalf("exp %v, got %v", tc.exp, act)
+			}
+			for i := range tc.exp {
+				if act[i].Name != tc.exp[i].Name || act[i].Description != tc.exp[i].Description || act[i].SystemPrompt != tc.exp[i].SystemPrompt ||
+					act[i].Model != tc.exp[i].Model || act[i].Temperature != tc.exp[i].Temperature || act[i].MaxTokens != tc.exp[i].MaxTokens ||
+					act[i].Trigger != tc.exp[i].Trigger || strings.Join(act[i].Tools, ",") != strings.Join(tc.exp[i].Tools, ",") ||
+					fmt.Sprint(act[i].Examples) != fmt.Sprint(tc.exp[i].Examples) {
+					t.Errorf("exp %v, got %v", tc.exp[i], act[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReloadPersonas(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+	}
+	write("pirate.yaml", "trigger: pirate\nprompt: You are a pirate.\n")
+
+	fm := bot.NewFakeMatrix()
+	fp := bot.NewFakeProvider()
+	b := newTestBot(t, bot.ConfigBot{
+		UserID:     "@bot:ewintr.nl",
+		Admins:     []string{"@admin:ewintr.nl"},
+		PersonaDir: dir,
+	}, bot.WithMatrix(fm), bot.WithProvider(fp))
+	_, h := b.ResponseHandler()
+
+	for _, tc := range []struct {
+		name      string
+		files     map[string]string
+		remove    string
+		expPrompt string
+		expList   []string
+	}{
+		{
+			name:      "loaded",
+			expPrompt: "You are a pirate.",
+			expList:   []string{"pirate"},
+		},
+		{
+			name:      "changed and added",
+			files:     map[string]string{"pirate.yaml": "trigger: pirate\nprompt: You are a polite pirate.\n", "poet.md": "---\ntrigger: poet\n---\nYou are a poet.\n"},
+			expPrompt: "You are a polite pirate.",
+			expList:   []string{"pirate", "poet"},
+		},
+		{
+			name:    "removed",
+			remove:  "pirate.yaml",
+			expList: []string{"poet"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for name, content := range tc.files {
+				write(name, content)
+			}
+			if tc.remove != "" {
+				if err := os.Remove(filepath.Join(dir, tc.remove)); err != nil {
+					t.Fatalf("exp nil, got %v", err)
+				}
+			}
+			if err := b.ReloadPersonas(); err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+
+			before := len(fp.Requests())
+			h(mautrix.EventSourceTimeline, testMessage(id.EventID("$q-"+tc.name), "pirate: ahoy", ""))
+			reqs := fp.Requests()
+			switch {
+			case tc.expPrompt == "" && len(reqs) != before:
+				t.Errorf("exp no request, got %v", reqs[len(reqs)-1])
+			case tc.expPrompt != "" && len(reqs) != before+1:
+				t.Fatalf("exp %v, got %v", before+1, len(reqs))
+			case tc.expPrompt != "":
+				if act := reqs[len(reqs)-1].Messages[0].Content; act != tc.expPrompt {
+					t.Errorf("exp %v, got %v", tc.expPrompt, act)
+				}
+			}
+
+			cmd := testMessage(id.EventID("$list-"+tc.name), "!persona list", "")
+			cmd.Sender = "@admin:ewintr.nl"
+			h(mautrix.EventSourceTimeline, cmd)
+			msgs := fm.Messages()
+			list := msgs[len(msgs)-1].Content.(*event.MessageEventContent).Body
+			if act := strings.Count(list, "* "); act != len(tc.expList) {
+				t.Errorf("exp %v, got %v", tc.expList, list)
+			}
+			for _, name := range tc.expList {
+				if !strings.Contains(list, "* "+name+" ") {
+					t.Errorf("exp %v in %v", name, list)
+				}
+			}
+		})
+	}
+}
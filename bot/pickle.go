@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/crypto/olm"
+	"maunium.net/go/mautrix/util/dbutil"
+)
+
+type pickler interface {
+	Pickle(key []byte) []byte
+}
+
+// pickledTables lists the columns of the crypto store that are encrypted
+// with the pickle key, with the columns that identify a row.
+var pickledTables = []struct {
+	table    string
+	keys     []string
+	column   string
+	unpickle func(pickled, key []byte) (pickler, error)
+}{
+	{"crypto_account", []string{"account_id"}, "account", func(p, k []byte) (pickler, error) { return olm.AccountFromPickled(p, k) }},
+	{"crypto_olm_session", []string{"account_id", "session_id"}, "session", func(p, k []byte) (pickler, error) { return olm.SessionFromPickled(p, k) }},
+	{"crypto_megolm_inbound_session", []string{"account_id", "session_id"}, "session", func(p, k []byte) (pickler, error) { return olm.InboundGroupSessionFromPickled(p, k) }},
+	{"crypto_megolm_outbound_session", []string{"account_id", "room_id"}, "session", func(p, k []byte) (pickler, error) { return olm.OutboundGroupSessionFromPickled(p, k) }},
+}
+
+type pickledRow struct {
+	keys    []any
+	pickled []byte
+}
+
+// RotatePickleKey encrypts the account and sessions in the crypto store at
+// dbPath with newKey instead of oldKey. Nothing is changed if one of them
+// cannot be decrypted with oldKey. The bot must not be running.
+func RotatePickleKey(dbPath string, oldKey, newKey []byte) (int, error) {
+	if len(newKey) == 0 {
+		return 0, fmt.Errorf("new pickle key is empty")
+	}
+	db, err := dbutil.NewWithDialect(dbPath, DBDialect(dbPath))
+	if err != nil {
+		return 0, err
+	}
+	defer db.RawDB.Close()
+
+	tx, err := db.RawDB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var count int
+	for _, pt := range pickledTables {
+		rows, err := tx.Query(fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s IS NOT NULL`, strings.Join(pt.keys, ", "), pt.column, pt.table, pt.column))
+		if err != nil {
+			return 0, err
+		}
+		var pickled []pickledRow
+		for rows.Next() {
+			r := pickledRow{keys: make([]any, len(pt.keys))}
+			dest := make([]any, 0, len(pt.keys)+1)
+			for i := range r.keys {
+				var s string
+				r.keys[i] = &s
+				dest = append(dest, &s)
+			}
+			if err := rows.Scan(append(dest, &r.pickled)...); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			pickled = append(pickled, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+
+		where := make([]string, 0, len(pt.keys))
+		for i, k := range pt.keys {
+			where = append(where, fmt.Sprintf("%s = $%d", k, i+2))
+		}
+		update := fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE %s`, pt.table, pt.column, strings.Join(where, " AND "))
+		for _, r := range pickled {
+			p, err := pt.unpickle(r.pickled, oldKey)
+			if err != nil {
+				return 0, fmt.Errorf("%w: could not unpickle %s, is the old key correct?: %w", ErrDecryptFailed, pt.table, err)
+			}
+			if _, err := tx.Exec(update, append([]any{p.Pickle(newKey)}, r.keys...)...); err != nil {
+				return 0, err
+			}
+			count++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
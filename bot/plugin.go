@@ -0,0 +1,136 @@
+package bot
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// StatePlugins is a room state event that room admins can use to switch
+// plugins off for their room, for example:
+//
+//	{"disabled": ["chat"]}
+var StatePlugins = event.Type{Type: "org.ewintr.bot.plugins", Class: event.StateEventType}
+
+type PluginsEventContent struct {
+	Disabled []string `json:"disabled"`
+}
+
+// PluginEnabled reports whether the plugin is enabled in the room. Plugins
+// are enabled unless explicitly disabled.
+func (s *Store) PluginEnabled(roomID id.RoomID, plugin string) (bool, error) {
+	var enabled bool
+	err := s.db.QueryRowContext(s.context(), `SELECT enabled FROM bot_room_plugin WHERE room_id = $1 AND plugin = $2`, roomID, plugin).Scan(&enabled)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+
+	return enabled, nil
+}
+
+func (s *Store) SetPluginEnabled(roomID id.RoomID, plugin string, enabled bool) error {
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_room_plugin (room_id, plugin, enabled) VALUES ($1, $2, $3)
+		ON CONFLICT (room_id, plugin) DO UPDATE SET enabled = excluded.enabled`, roomID, plugin, enabled)
+	return err
+}
+
+// pluginNames returns the names of the handlers that can be switched on and
+// off. The command handler is always on, otherwise there would be no way to
+// switch it back.
+func (m *Bot) pluginNames() []string {
+	var names []string
+	for _, h := range m.dispatcher.Handlers() {
+		if h.Name() == "command" {
+			continue
+		}
+		names = append(names, h.Name())
+	}
+
+	return names
+}
+
+func (m *Bot) pluginEnabled(evt *event.Event, name string) bool {
+	if name == "command" {
+		return true
+	}
+	enabled, err := m.store.PluginEnabled(evt.RoomID, name)
+	if err != nil {
+		m.logger.Error("failed to get plugin state", slog.String("err", err.Error()), slog.String("plugin", name), slog.String("bot", m.config.UserDisplayName))
+		return true
+	}
+
+	return enabled
+}
+
+func (m *Bot) pluginCommand() Command {
+	return Command{
+		Name:      "plugin",
+		Usage:     "list|enable <name>|disable <name>",
+		Help:      "show or change which plugins are active in this room",
+		AdminOnly: true,
+		Run: func(evt *event.Event, args []string) (string, error) {
+			if len(args) == 0 || args[0] == "list" {
+				var lines []string
+				for _, name := range m.pluginNames() {
+					state := "enabled"
+					if !m.pluginEnabled(evt, name) {
+						state = "disabled"
+					}
+					lines = append(lines, fmt.Sprintf("- %s: %s", name, state))
+				}
+				return strings.Join(lines, "\n"), nil
+			}
+			if len(args) != 2 || (args[0] != "enable" && args[0] != "disable") {
+				return "", fmt.Errorf("usage: !plugin list|enable <name>|disable <name>")
+			}
+
+			name := args[1]
+			var known bool
+			for _, n := range m.pluginNames() {
+				if n == name {
+					known = true
+				}
+			}
+			if !known {
+				return "", fmt.Errorf("unknown plugin %q", name)
+			}
+			if err := m.store.SetPluginEnabled(evt.RoomID, name, args[0] == "enable"); err != nil {
+				return "", err
+			}
+
+			return fmt.Sprintf("Plugin %s is now %sd in this room.", name, args[0]), nil
+		},
+	}
+}
+
+// PluginStateHandler applies the StatePlugins state event of a room. The
+// event replaces whatever was set before with the command.
+func (m *Bot) PluginStateHandler() (event.Type, mautrix.EventHandler) {
+	return StatePlugins, func(source mautrix.EventSource, evt *event.Event) {
+		var content PluginsEventContent
+		if err := json.Unmarshal(evt.Content.VeryRaw, &content); err != nil {
+			m.logger.Error("failed to parse plugin state", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+			return
+		}
+		disabled := make(map[string]bool)
+		for _, name := range content.Disabled {
+			disabled[name] = true
+		}
+		for _, name := range m.pluginNames() {
+			if err := m.store.SetPluginEnabled(evt.RoomID, name, !disabled[name]); err != nil {
+				m.logger.Error("failed to store plugin state", slog.String("err", err.Error()), slog.String("plugin", name), slog.String("bot", m.config.UserDisplayName))
+			}
+		}
+		m.logger.Info("updated plugins from room state", slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+}
@@ -0,0 +1,420 @@
+package bot
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// The poll events of MSC3381, with the unstable names that clients use.
+var (
+	EventPollStart    = event.Type{Type: "org.matrix.msc3381.poll.start", Class: event.MessageEventType}
+	EventPollResponse = event.Type{Type: "org.matrix.msc3381.poll.response", Class: event.MessageEventType}
+	EventPollEnd      = event.Type{Type: "org.matrix.msc3381.poll.end", Class: event.MessageEventType}
+)
+
+const (
+	pollKindDisclosed = "org.matrix.msc3381.poll.disclosed"
+	maxPollAnswers    = 20
+)
+
+// PollText is the text of an extensible event, MSC1767.
+type PollText struct {
+	Text string `json:"org.matrix.msc1767.text"`
+}
+
+type PollAnswer struct {
+	ID   string `json:"id"`
+	Text string `json:"org.matrix.msc1767.text"`
+}
+
+type PollStart struct {
+	Question      PollText     `json:"question"`
+	Kind          string       `json:"kind"`
+	MaxSelections int          `json:"max_selections"`
+	Answers       []PollAnswer `json:"answers"`
+}
+
+// PollStartEventContent starts a poll. Text is the fallback for clients that
+// do not know polls.
+type PollStartEventContent struct {
+	PollStart PollStart `json:"org.matrix.msc3381.poll.start"`
+	Text      string    `json:"org.matrix.msc1767.text"`
+}
+
+type PollResponse struct {
+	Answers []string `json:"answers"`
+}
+
+// PollResponseEventContent is a vote. A later vote of the same user replaces
+// the earlier one.
+type PollResponseEventContent struct {
+	RelatesTo event.RelatesTo `json:"m.relates_to"`
+	Response  PollResponse    `json:"org.matrix.msc3381.poll.response"`
+}
+
+// PollEndEventContent closes a poll, Text says what the outcome was.
+type PollEndEventContent struct {
+	RelatesTo event.RelatesTo `json:"m.relates_to"`
+	End       struct{}        `json:"org.matrix.msc3381.poll.end"`
+	Text      string          `json:"org.matrix.msc1767.text"`
+}
+
+// Poll is a poll that the bot started for a user.
+type Poll struct {
+	EventID  id.EventID
+	RoomID   id.RoomID
+	Creator  id.UserID
+	Question string
+	Answers  []PollAnswer
+	Closed   bool
+}
+
+// PollResult is the number of votes for an answer.
+type PollResult struct {
+	Answer PollAnswer
+	Votes  int
+}
+
+// ParsePollArgs splits `"Question" "Option A" "Option B"` in its quoted
+// parts. Both straight and curly quotes work, phones tend to type the
+// latter.
+func ParsePollArgs(s string) ([]string, error) {
+	var (
+		parts   []string
+		current strings.Builder
+		quoted  bool
+	)
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			if quoted {
+				parts = append(parts, strings.TrimSpace(current.String()))
+				current.Reset()
+			}
+			quoted = !quoted
+		case quoted:
+			current.WriteRune(r)
+		case r != ' ' && r != '\t' && r != '\n':
+			return nil, fmt.Errorf("put the question and each option between quotes")
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("missing closing quote")
+	}
+	for _, p := range parts {
+		if p == "" {
+			return nil, fmt.Errorf("empty question or option")
+		}
+	}
+
+	return parts, nil
+}
+
+func (m *Bot) pollCommand() Command {
+	return Command{
+		Name:  "poll",
+		Usage: `"<question>" "<option>" "<option>"...|end`,
+		Help:  "start a poll, or end the last one of the room and show the results, like `!poll \"Lunch?\" \"Pizza\" \"Sushi\"`",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			if len(args) == 1 && args[0] == "end" {
+				return "", m.endPollCommand(evt)
+			}
+			// the arguments are split on spaces, the quotes need the text
+			body := strings.TrimSpace(event.TrimReplyFallbackText(evt.Content.AsMessage().Body))
+			parts, err := ParsePollArgs(strings.TrimPrefix(body, commandPrefix)[len("poll"):])
+			if err != nil {
+				return "", err
+			}
+			if len(parts) < 3 || len(parts) > maxPollAnswers+1 {
+				return "", fmt.Errorf("a poll needs a question and 2 to %d options", maxPollAnswers)
+			}
+
+			return "", m.startPoll(evt, parts[0], parts[1:])
+		},
+	}
+}
+
+func (m *Bot) startPoll(evt *event.Event, question string, options []string) error {
+	p := Poll{RoomID: evt.RoomID, Creator: evt.Sender, Question: question}
+	fallback := []string{question}
+	for i, o := range options {
+		p.Answers = append(p.Answers, PollAnswer{ID: strconv.Itoa(i + 1), Text: o})
+		fallback = append(fallback, fmt.Sprintf("%d. %s", i+1, o))
+	}
+	content := &PollStartEventContent{
+		PollStart: PollStart{
+			Question:      PollText{Text: question},
+			Kind:          pollKindDisclosed,
+			MaxSelections: 1,
+			Answers:       p.Answers,
+		},
+		Text: strings.Join(fallback, "\n"),
+	}
+	var err error
+	p.EventID, err = m.matrix.SendEvent(evt.RoomID, EventPollStart, content, newTxnID())
+	if err != nil {
+		return err
+	}
+
+	return m.store.AddPoll(p)
+}
+
+// endPollCommand ends the poll that is replied to, or else the last open
+// poll of the room. Only the one who asked for it and admins can do that.
+func (m *Bot) endPollCommand(evt *event.Event) error {
+	var (
+		p   Poll
+		ok  bool
+		err error
+	)
+	if replyTo := evt.Content.AsMessage().RelatesTo.GetReplyTo(); replyTo != "" {
+		p, ok, err = m.store.Poll(replyTo)
+	} else {
+		p, ok, err = m.store.LastOpenPoll(evt.RoomID)
+	}
+	switch {
+	case err != nil:
+		return err
+	case !ok || p.RoomID != evt.RoomID:
+		return fmt.Errorf("no poll to end in this room")
+	case p.Closed:
+		return fmt.Errorf("this poll has already ended")
+	case p.Creator != evt.Sender && !m.isAdmin(evt.Sender):
+		return fmt.Errorf("only %s and admins can end this poll", p.Creator)
+	}
+
+	return m.closePoll(p, true)
+}
+
+// closePoll counts the votes and posts the results as a reply to the poll.
+// With sendEnd, the poll is also ended for the clients in the room.
+func (m *Bot) closePoll(p Poll, sendEnd bool) error {
+	closed, err := m.store.ClosePoll(p.EventID)
+	if err != nil || !closed {
+		return err
+	}
+	votes, err := m.store.PollVotes(p.EventID)
+	if err != nil {
+		return err
+	}
+	results, total := CountPoll(p, votes)
+
+	top := "no votes"
+	if total > 0 {
+		var winners []string
+		for _, r := range results {
+			if r.Votes == results[0].Votes {
+				winners = append(winners, r.Answer.Text)
+			}
+		}
+		top = strings.Join(winners, ", ")
+	}
+	if sendEnd {
+		content := &PollEndEventContent{
+			RelatesTo: event.RelatesTo{Type: event.RelReference, EventID: p.EventID},
+			Text:      fmt.Sprintf("The poll has ended. Top answer: %s", top),
+		}
+		if _, err := m.matrix.SendEvent(p.RoomID, EventPollEnd, content, newTxnID()); err != nil {
+			return err
+		}
+	}
+
+	lines := []string{fmt.Sprintf("**Poll ended: %s**", p.Question), ""}
+	for _, r := range results {
+		share := 0
+		if total > 0 {
+			share = r.Votes * 100 / total
+		}
+		line := fmt.Sprintf("- %s: %s (%d%%)", r.Answer.Text, votesText(r.Votes), share)
+		if total > 0 && r.Votes == results[0].Votes {
+			line += " 🏆"
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", fmt.Sprintf("%s in total.", votesText(total)))
+	m.sendNotice(p.RoomID, p.EventID, strings.Join(lines, "\n"))
+
+	return nil
+}
+
+func votesText(n int) string {
+	if n == 1 {
+		return "1 vote"
+	}
+
+	return fmt.Sprintf("%d votes", n)
+}
+
+// CountPoll counts the votes per answer, most votes first. Votes for unknown
+// answers and votes for more answers than allowed are spoiled and do not
+// count.
+func CountPoll(p Poll, votes map[id.UserID][]string) ([]PollResult, int) {
+	results := make([]PollResult, len(p.Answers))
+	index := make(map[string]int)
+	for i, a := range p.Answers {
+		results[i].Answer = a
+		index[a.ID] = i
+	}
+	var total int
+	for _, answers := range votes {
+		if len(answers) != 1 {
+			continue
+		}
+		i, ok := index[answers[0]]
+		if !ok {
+			continue
+		}
+		results[i].Votes++
+		total++
+	}
+	// equal votes keep the order of the poll
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Votes > results[j].Votes })
+
+	return results, total
+}
+
+// PollHandler records the votes on the polls of the bot, and posts the
+// results when a poll is ended in a client by the one who asked for it or
+// an admin.
+func (m *Bot) PollHandler() mautrix.EventHandler {
+	return func(source mautrix.EventSource, evt *event.Event) {
+		if evt.Sender == id.UserID(m.config.UserID) {
+			return
+		}
+		var rel struct {
+			RelatesTo event.RelatesTo `json:"m.relates_to"`
+		}
+		if err := json.Unmarshal(evt.Content.VeryRaw, &rel); err != nil || rel.RelatesTo.Type != event.RelReference {
+			return
+		}
+		p, ok, err := m.store.Poll(rel.RelatesTo.EventID)
+		if err != nil {
+			m.logger.Error("failed to get poll", slog.String("err", err.Error()), slog.String("event_id", rel.RelatesTo.EventID.String()), slog.String("bot", m.config.UserDisplayName))
+			return
+		}
+		if !ok || p.Closed || p.RoomID != evt.RoomID {
+			return
+		}
+
+		switch evt.Type.Type {
+		case EventPollResponse.Type:
+			var content PollResponseEventContent
+			if err := json.Unmarshal(evt.Content.VeryRaw, &content); err != nil {
+				m.logger.Error("failed to parse poll response", slog.String("err", err.Error()), slog.String("event_id", evt.ID.String()), slog.String("bot", m.config.UserDisplayName))
+				return
+			}
+			if err := m.store.SetPollVote(p.EventID, evt.Sender, content.Response.Answers, time.UnixMilli(evt.Timestamp)); err != nil {
+				m.logger.Error("failed to store poll vote", slog.String("err", err.Error()), slog.String("event_id", evt.ID.String()), slog.String("bot", m.config.UserDisplayName))
+			}
+		case EventPollEnd.Type:
+			if evt.Sender != p.Creator && !m.isAdmin(evt.Sender) {
+				return
+			}
+			if err := m.closePoll(p, false); err != nil {
+				m.logger.Error("failed to end poll", slog.String("err", err.Error()), slog.String("event_id", p.EventID.String()), slog.String("bot", m.config.UserDisplayName))
+			}
+		}
+	}
+}
+
+func (s *Store) AddPoll(p Poll) error {
+	answers, err := json.Marshal(p.Answers)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(s.context(), `INSERT INTO bot_poll (event_id, room_id, creator, question, answers, created_at, closed) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		p.EventID, p.RoomID, p.Creator, p.Question, string(answers), time.Now().Unix(), p.Closed)
+
+	return err
+}
+
+// Poll returns the poll that was started with the event.
+func (s *Store) Poll(eventID id.EventID) (Poll, bool, error) {
+	return s.scanPoll(s.db.QueryRowContext(s.context(), `SELECT event_id, room_id, creator, question, answers, closed FROM bot_poll WHERE event_id = $1`, eventID))
+}
+
+// LastOpenPoll returns the poll of the room that was started last and did not
+// end yet.
+func (s *Store) LastOpenPoll(roomID id.RoomID) (Poll, bool, error) {
+	return s.scanPoll(s.db.QueryRowContext(s.context(), `SELECT event_id, room_id, creator, question, answers, closed FROM bot_poll
+		WHERE room_id = $1 AND closed = false ORDER BY created_at DESC LIMIT 1`, roomID))
+}
+
+func (s *Store) scanPoll(row *sql.Row) (Poll, bool, error) {
+	var p Poll
+	var answers string
+	err := row.Scan(&p.EventID, &p.RoomID, &p.Creator, &p.Question, &answers, &p.Closed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Poll{}, false, nil
+	}
+	if err != nil {
+		return Poll{}, false, err
+	}
+	if err := json.Unmarshal([]byte(answers), &p.Answers); err != nil {
+		return Poll{}, false, err
+	}
+
+	return p, true, nil
+}
+
+// ClosePoll marks the poll as ended. It returns false when it already was,
+// so the results are posted once.
+func (s *Store) ClosePoll(eventID id.EventID) (bool, error) {
+	res, err := s.db.ExecContext(s.context(), `UPDATE bot_poll SET closed = true WHERE event_id = $1 AND closed = false`, eventID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// SetPollVote stores the vote of the user, unless a later one is stored
+// already. Events can arrive out of order.
+func (s *Store) SetPollVote(eventID id.EventID, userID id.UserID, answers []string, votedAt time.Time) error {
+	raw, err := json.Marshal(answers)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(s.context(), `INSERT INTO bot_poll_vote (poll_event_id, user_id, answers, voted_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (poll_event_id, user_id) DO UPDATE SET answers = excluded.answers, voted_at = excluded.voted_at
+		WHERE bot_poll_vote.voted_at <= excluded.voted_at`, eventID, userID, string(raw), votedAt.UnixMilli())
+
+	return err
+}
+
+// PollVotes returns the last vote of each user on the poll.
+func (s *Store) PollVotes(eventID id.EventID) (map[id.UserID][]string, error) {
+	rows, err := s.db.QueryContext(s.context(), `SELECT user_id, answers FROM bot_poll_vote WHERE poll_event_id = $1`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	votes := make(map[id.UserID][]string)
+	for rows.Next() {
+		var userID id.UserID
+		var raw string
+		if err := rows.Scan(&userID, &raw); err != nil {
+			return nil, err
+		}
+		var answers []string
+		if err := json.Unmarshal([]byte(raw), &answers); err != nil {
+			return nil, err
+		}
+		votes[userID] = answers
+	}
+
+	return votes, rows.Err()
+}
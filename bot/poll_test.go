@@ -0,0 +1,117 @@
+package bot_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestParsePollArgs(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		args   string
+		exp    []string
+		expErr bool
+	}{
+		{name: "straight", args: ` "Lunch?" "Pizza" "Sushi"`, exp: []string{"Lunch?", "Pizza", "Sushi"}},
+		{name: "curly", args: ` “Where to?” “The beach” “Home”`, exp: []string{"Where to?", "The beach", "Home"}},
+		{name: "unquoted", args: ` Lunch? Pizza Sushi`, expErr: true},
+		{name: "open quote", args: ` "Lunch?" "Pizza`, expErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := bot.ParsePollArgs(tc.args)
+			if (err != nil) != tc.expErr {
+				t.Fatalf("exp %v, got %v", tc.expErr, err)
+			}
+			if strings.Join(act, "|") != strings.Join(tc.exp, "|") {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestPoll(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	b := newTestBot(t, bot.ConfigBot{
+		UserID: "@bot:ewintr.nl",
+	}, bot.WithMatrix(fm))
+	_, h := b.ResponseHandler()
+
+	h(mautrix.EventSourceTimeline, testMessage("$start", `!poll "Lunch?" "Pizza" "Sushi"`, ""))
+	msgs := fm.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("exp 1, got %v", len(msgs))
+	}
+	if msgs[0].Type != bot.EventPollStart {
+		t.Errorf("exp %v, got %v", bot.EventPollStart, msgs[0].Type)
+	}
+	start := msgs[0].Content.(*bot.PollStartEventContent)
+	if act := start.PollStart.Question.Text; act != "Lunch?" {
+		t.Errorf("exp Lunch?, got %v", act)
+	}
+	if len(start.PollStart.Answers) != 2 || start.PollStart.MaxSelections != 1 {
+		t.Errorf("exp two answers and one selection, got %v", start.PollStart)
+	}
+	pollID := msgs[0].EventID
+
+	vote := b.PollHandler()
+	for i, v := range []struct {
+		sender id.UserID
+		answer string
+		ts     int64
+	}{
+		{sender: "@a:ewintr.nl", answer: "1", ts: 1000},
+		{sender: "@b:ewintr.nl", answer: "2", ts: 2000},
+		{sender: "@c:ewintr.nl", answer: "2", ts: 3000},
+		// changed their mind
+		{sender: "@b:ewintr.nl", answer: "1", ts: 4000},
+		// arrived late, but older than the vote above
+		{sender: "@b:ewintr.nl", answer: "2", ts: 2500},
+		{sender: "@d:ewintr.nl", answer: "7", ts: 5000},
+	} {
+		vote(mautrix.EventSourceTimeline, &event.Event{
+			ID:        id.EventID(fmt.Sprintf("$vote%d", i)),
+			RoomID:    "!room:ewintr.nl",
+			Sender:    v.sender,
+			Type:      bot.EventPollResponse,
+			Timestamp: v.ts,
+			Content: event.Content{VeryRaw: []byte(fmt.Sprintf(`{"m.relates_to": {"rel_type": "m.reference", "event_id": %q}, "org.matrix.msc3381.poll.response": {"answers": [%q]}}`,
+				pollID, v.answer))},
+		})
+	}
+
+	h(mautrix.EventSourceTimeline, testMessage("$end", "!poll end", ""))
+	msgs = fm.Messages()
+	if len(msgs) != 3 {
+		t.Fatalf("exp 3, got %v", len(msgs))
+	}
+	if msgs[1].Type != bot.EventPollEnd {
+		t.Errorf("exp %v, got %v", bot.EventPollEnd, msgs[1].Type)
+	}
+	if act := msgs[1].Content.(*bot.PollEndEventContent).RelatesTo.EventID; act != pollID {
+		t.Errorf("exp %v, got %v", pollID, act)
+	}
+	results := msgs[2].Content.(*event.MessageEventContent)
+	exp := "**Poll ended: Lunch?**\n\n* Pizza: 2 votes (66%) 🏆\n* Sushi: 1 vote (33%)\n\n3 votes in total."
+	if results.Body != exp {
+		t.Errorf("exp %q, got %q", exp, results.Body)
+	}
+	if act := results.RelatesTo.GetReplyTo(); act != pollID {
+		t.Errorf("exp %v, got %v", pollID, act)
+	}
+
+	h(mautrix.EventSourceTimeline, testMessage("$again", "!poll end", ""))
+	msgs = fm.Messages()
+	if act := msgs[len(msgs)-1].Content.(*event.MessageEventContent).Body; act != "Error: no poll to end in this room" {
+		t.Errorf("exp no poll error, got %q", act)
+	}
+}
@@ -0,0 +1,149 @@
+package bot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ConfigProfile is how the bot presents itself. Avatar is either an mxc://
+// URI or the path of an image file, which is uploaded once. Rooms sets a
+// different display name in some rooms.
+type ConfigProfile struct {
+	DisplayName string
+	Avatar      string
+	Rooms       []ConfigRoomProfile
+}
+
+type ConfigRoomProfile struct {
+	Room        string
+	DisplayName string
+}
+
+// applyProfile sets the display name and avatar from the config, if they are
+// not set already. The global display name goes first, because changing it
+// also changes the name in every room.
+func (m *Bot) applyProfile() error {
+	cfg := m.config.Profile
+	if cfg.DisplayName != "" {
+		resp, err := m.client.GetOwnDisplayName()
+		if err != nil || resp.DisplayName != cfg.DisplayName {
+			if err := m.client.SetDisplayName(cfg.DisplayName); err != nil {
+				return fmt.Errorf("could not set display name: %w", err)
+			}
+			m.logger.Info("set display name", slog.String("display_name", cfg.DisplayName), slog.String("bot", m.config.UserDisplayName))
+		}
+	}
+	if cfg.Avatar != "" {
+		uri, err := m.avatarURI(cfg.Avatar)
+		if err != nil {
+			return fmt.Errorf("could not upload avatar: %w", err)
+		}
+		current, err := m.client.GetOwnAvatarURL()
+		if err != nil || current != uri {
+			if err := m.client.SetAvatarURL(uri); err != nil {
+				return fmt.Errorf("could not set avatar: %w", err)
+			}
+			m.logger.Info("set avatar", slog.String("avatar", uri.String()), slog.String("bot", m.config.UserDisplayName))
+		}
+	}
+	for _, rp := range cfg.Rooms {
+		if err := m.setRoomDisplayName(rp); err != nil {
+			return fmt.Errorf("could not set display name in %s: %w", rp.Room, err)
+		}
+	}
+
+	return nil
+}
+
+// avatarURI returns the content URI for the avatar in the config. Files are
+// only uploaded when the store has no URI for their contents yet.
+func (m *Bot) avatarURI(avatar string) (id.ContentURI, error) {
+	if strings.HasPrefix(avatar, "mxc://") {
+		return id.ParseContentURI(avatar)
+	}
+	data, err := os.ReadFile(avatar)
+	if err != nil {
+		return id.ContentURI{}, err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	uri, err := m.store.Avatar(hash)
+	if err != nil {
+		return id.ContentURI{}, err
+	}
+	if !uri.IsEmpty() {
+		return uri, nil
+	}
+
+	resp, err := m.client.UploadBytesWithName(data, http.DetectContentType(data), filepath.Base(avatar))
+	if err != nil {
+		return id.ContentURI{}, err
+	}
+	if err := m.store.SaveAvatar(hash, resp.ContentURI); err != nil {
+		return id.ContentURI{}, err
+	}
+
+	return resp.ContentURI, nil
+}
+
+// setRoomDisplayName changes the display name in the member event of the bot
+// in a single room, keeping the rest of the event as it is.
+func (m *Bot) setRoomDisplayName(rp ConfigRoomProfile) error {
+	roomID, err := m.ResolveRoom(rp.Room)
+	if err != nil {
+		return err
+	}
+	var member event.MemberEventContent
+	if err := m.matrix.StateEvent(roomID, event.StateMember, m.matrix.UserID().String(), &member); err != nil {
+		return err
+	}
+	if member.Membership != event.MembershipJoin || member.Displayname == rp.DisplayName {
+		return nil
+	}
+	member.Displayname = rp.DisplayName
+	if _, err := m.client.SendStateEvent(roomID, event.StateMember, m.matrix.UserID().String(), &member); err != nil {
+		return err
+	}
+	m.logger.Info("set room display name", slog.String("room_id", roomID.String()), slog.String("display_name", rp.DisplayName), slog.String("bot", m.config.UserDisplayName))
+
+	return nil
+}
+
+// Avatar returns the content URI of an uploaded avatar by the SHA-256 of the
+// image, or an empty URI.
+func (s *Store) Avatar(hash string) (id.ContentURI, error) {
+	rows, err := s.db.QueryContext(s.context(), `SELECT content_uri FROM bot_avatar WHERE hash = $1`, hash)
+	if err != nil {
+		return id.ContentURI{}, err
+	}
+	defer rows.Close()
+
+	var uri string
+	if rows.Next() {
+		if err := rows.Scan(&uri); err != nil {
+			return id.ContentURI{}, err
+		}
+	}
+	if err := rows.Err(); err != nil || uri == "" {
+		return id.ContentURI{}, err
+	}
+
+	return id.ParseContentURI(uri)
+}
+
+// SaveAvatar stores the content URI of an uploaded avatar.
+func (s *Store) SaveAvatar(hash string, uri id.ContentURI) error {
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_avatar (hash, content_uri) VALUES ($1, $2)
+		ON CONFLICT (hash) DO UPDATE SET content_uri = excluded.content_uri`, hash, uri.String())
+
+	return err
+}
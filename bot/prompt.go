@@ -0,0 +1,121 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// promptTime finds the use of the time of day in a prompt template.
+var promptTime = regexp.MustCompile(`\{\{[^}]*\.(Time|Now)\b`)
+
+// PromptData is what a system prompt can use when it is a Go template, like
+// "You talk with {{.Sender}} in {{.Room}}. Today is {{.Date}}." The prompt
+// is rendered for every question, with the date and time in the time zone
+// of the sender. Language is the name of the language the sender is
+// answered in, if one is set. Sender, Room and Topic are chosen by the
+// people in the room, so they are quoted, like "Alice".
+type PromptData struct {
+	Bot      string
+	Room     string
+	Topic    string
+	Sender   string
+	SenderID id.UserID
+	Date     string
+	Time     string
+	Timezone string
+	Language string
+	Now      time.Time
+}
+
+// RenderPrompt executes a system prompt template.
+func RenderPrompt(prompt string, data PromptData) (string, error) {
+	if !strings.Contains(prompt, "{{") {
+		return prompt, nil
+	}
+	tmpl, err := template.New("prompt").Parse(prompt)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func validatePrompt(prompt string) error {
+	if _, err := template.New("prompt").Parse(prompt); err != nil {
+		return fmt.Errorf("invalid prompt template: %w", err)
+	}
+
+	return nil
+}
+
+// systemPrompt renders the system prompt of the persona for the message. If
+// that fails, the prompt is used as it is. When a language is set for the
+// sender, the prompt asks for answers in it. With a defence against prompt
+// injection, the prompt explains it.
+func (m *Bot) systemPrompt(evt *event.Event, p Persona) string {
+	loc := m.userLocation(evt.Sender)
+	now := time.Now().In(loc)
+	data := PromptData{
+		Bot:      m.config.Profile.DisplayName,
+		Sender:   strconv.Quote(m.guardValue(m.displayName(evt.RoomID, evt.Sender), "sender")),
+		SenderID: evt.Sender,
+		Date:     now.Format("Monday 2 January 2006"),
+		Time:     now.Format("15:04"),
+		Timezone: loc.String(),
+		Now:      now,
+	}
+	lang := m.replyLanguage(evt.RoomID, evt.Sender)
+	if lang != "" {
+		data.Language = languageNames[lang]
+	}
+	if data.Bot == "" {
+		data.Bot = m.config.UserDisplayName
+	}
+	if room, ok, err := m.store.Room(evt.RoomID); err == nil && ok {
+		data.Room, data.Topic = strconv.Quote(m.guardValue(room.Name, "room")), strconv.Quote(m.guardValue(room.Topic, "topic"))
+	}
+	prompt, err := RenderPrompt(p.SystemPrompt, data)
+	if err != nil {
+		m.logger.Error("failed to render prompt", slog.String("err", err.Error()), slog.String("persona", p.Name), slog.String("bot", m.config.UserDisplayName))
+		prompt = p.SystemPrompt
+	}
+	if note := languagePrompt(lang); note != "" {
+		prompt += "\n\n" + note
+	}
+	if note := m.injectionPrompt(); note != "" {
+		prompt += "\n\n" + note
+	}
+
+	return prompt
+}
+
+// displayName is the display name of the user in the room, or the user ID
+// when it is not known. It comes from the state the sync has seen, so it does
+// not wait for the homeserver.
+func (m *Bot) displayName(roomID id.RoomID, userID id.UserID) string {
+	member, ok := m.matrix.Member(roomID, userID)
+	if !ok || member.Displayname == "" {
+		return userID.String()
+	}
+
+	return member.Displayname
+}
+
+// promptUsesTime tells whether the prompt template shows the time of day. It
+// is different every minute, and so are the answers.
+func promptUsesTime(prompt string) bool {
+	return promptTime.MatchString(prompt)
+}
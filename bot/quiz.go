@@ -0,0 +1,464 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	defaultQuizRounds     = 5
+	maxQuizRounds         = 20
+	defaultQuizAnswerTime = time.Minute
+	quizPrompt            = `You are the quiz master of a trivia quiz. Write one new question that has a short, unambiguous answer, about the topic in the message, or about general knowledge if there is none. Do not repeat the questions that were already asked. Answer with JSON only, like {"question": "What is the capital of Australia?", "answer": "Canberra", "alternatives": []}, where alternatives are other correct ways to write the answer.`
+)
+
+// ConfigQuiz sets up !quiz. Questions is a question bank that is used for
+// quizzes without a topic. When it runs out, or a topic is given, the model
+// with Model writes the questions. Rounds is the number of questions of a
+// quiz, five when not set. AnswerTime is how long players get for a
+// question, a minute when not set.
+type ConfigQuiz struct {
+	Model      string
+	Rounds     int
+	AnswerTime time.Duration
+	Questions  []QuizQuestion
+}
+
+func (c ConfigQuiz) rounds() int {
+	if c.Rounds <= 0 {
+		return defaultQuizRounds
+	}
+
+	return c.Rounds
+}
+
+func (c ConfigQuiz) answerTime() time.Duration {
+	if c.AnswerTime <= 0 {
+		return defaultQuizAnswerTime
+	}
+
+	return c.AnswerTime
+}
+
+// QuizQuestion is a question with its answer. Alternatives are other ways to
+// write the answer that are also accepted.
+type QuizQuestion struct {
+	Question     string   `json:"question"`
+	Answer       string   `json:"answer"`
+	Alternatives []string `json:"alternatives"`
+}
+
+// Matches tells whether the message is the answer, or one of the
+// alternatives. Case, punctuation and a leading article do not matter, and
+// for longer answers a typo is allowed: one for every five letters.
+func (q QuizQuestion) Matches(message string) bool {
+	msg := normalizeAnswer(message)
+	for _, a := range append([]string{q.Answer}, q.Alternatives...) {
+		a := normalizeAnswer(a)
+		if a != "" && editDistance(msg, a) <= len([]rune(a))/5 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if d := prev[j] + 1; d < cur[j] {
+				cur[j] = d
+			}
+			if d := cur[j-1] + 1; d < cur[j] {
+				cur[j] = d
+			}
+		}
+		prev = cur
+	}
+
+	return prev[len(rb)]
+}
+
+func normalizeAnswer(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, s)
+	fields := strings.Fields(s)
+	if len(fields) > 1 && (fields[0] == "the" || fields[0] == "a" || fields[0] == "an") {
+		fields = fields[1:]
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// quizGame is the quiz that is running in a room. While the question is
+// open, messages in the room are taken as answers.
+type quizGame struct {
+	// evt is the !quiz command, the usage of the model is recorded for it
+	evt      *event.Event
+	topic    string
+	rounds   int
+	round    int
+	open     bool
+	question QuizQuestion
+	asked    []string
+	scores   map[id.UserID]int
+	timer    *time.Timer
+}
+
+type quizGames struct {
+	games map[id.RoomID]*quizGame
+	mu    sync.Mutex
+}
+
+func (m *Bot) quizCommand() Command {
+	return Command{
+		Name:  "quiz",
+		Usage: "[<rounds>] [<topic>]|skip|stop|scores",
+		Help:  "start a quiz in the room, the first to give the right answer gets the point, like `!quiz 10 space travel`",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			if len(args) == 1 {
+				switch strings.ToLower(args[0]) {
+				case "skip":
+					return "", m.skipQuestion(evt)
+				case "stop":
+					return m.stopQuiz(evt.RoomID)
+				case "scores":
+					return m.quizScores(evt.RoomID)
+				}
+			}
+			rounds := m.config.Quiz.rounds()
+			if len(args) > 0 {
+				if n, err := strconv.Atoi(args[0]); err == nil {
+					if n < 1 || n > maxQuizRounds {
+						return "", fmt.Errorf("a quiz has 1 to %d questions", maxQuizRounds)
+					}
+					rounds, args = n, args[1:]
+				}
+			}
+
+			return m.startQuiz(evt, rounds, strings.Join(args, " "))
+		},
+	}
+}
+
+func (m *Bot) startQuiz(evt *event.Event, rounds int, topic string) (string, error) {
+	g := &quizGame{evt: evt, topic: topic, rounds: rounds, scores: make(map[id.UserID]int)}
+	m.quizzes.mu.Lock()
+	if m.quizzes.games == nil {
+		m.quizzes.games = make(map[id.RoomID]*quizGame)
+	}
+	if _, ok := m.quizzes.games[evt.RoomID]; ok {
+		m.quizzes.mu.Unlock()
+		return "", fmt.Errorf("a quiz is already running in this room, end it with !quiz stop")
+	}
+	m.quizzes.games[evt.RoomID] = g
+	m.quizzes.mu.Unlock()
+
+	q, err := m.quizQuestion(evt, topic, nil)
+	if err != nil {
+		m.quizzes.mu.Lock()
+		delete(m.quizzes.games, evt.RoomID)
+		m.quizzes.mu.Unlock()
+		return "", err
+	}
+	about := ""
+	if topic != "" {
+		about = fmt.Sprintf(" about %s", topic)
+	}
+	intro := fmt.Sprintf("A quiz of %d questions%s! The first to give the right answer gets the point, you have %d seconds for each question.", rounds, about, int(m.config.Quiz.answerTime().Seconds()))
+
+	m.quizzes.mu.Lock()
+	defer m.quizzes.mu.Unlock()
+	if m.quizzes.games[evt.RoomID] != g {
+		return "", nil
+	}
+
+	return intro + "\n\n" + m.askQuestion(evt.RoomID, g, q), nil
+}
+
+// askQuestion opens the next question of the game and returns the text to
+// post. m.quizzes.mu must be held.
+func (m *Bot) askQuestion(roomID id.RoomID, g *quizGame, q QuizQuestion) string {
+	g.round++
+	g.question, g.open = q, true
+	g.asked = append(g.asked, q.Question)
+	round := g.round
+	g.timer = time.AfterFunc(m.config.Quiz.answerTime(), func() {
+		m.closeQuestion(roomID, round, "", func(q QuizQuestion) string {
+			return fmt.Sprintf("Time is up! The answer was **%s**.", q.Answer)
+		})
+	})
+
+	return fmt.Sprintf("**Question %d/%d:** %s", g.round, g.rounds, q.Question)
+}
+
+// closeQuestion ends the question of the round, if it is still open, and
+// posts the text for it together with the next question, or the results
+// when it was the last. The user in userID gets a point. The next question
+// is written in the background, so a model call does not hold up the
+// handling of other messages.
+func (m *Bot) closeQuestion(roomID id.RoomID, round int, userID id.UserID, text func(q QuizQuestion) string) {
+	m.quizzes.mu.Lock()
+	g, ok := m.quizzes.games[roomID]
+	if !ok || !g.open || g.round != round || m.ctx.Err() != nil {
+		m.quizzes.mu.Unlock()
+		return
+	}
+	g.open = false
+	g.timer.Stop()
+	if userID != "" {
+		g.scores[userID]++
+	}
+	out := text(g.question)
+	last := g.round >= g.rounds
+	if last {
+		delete(m.quizzes.games, roomID)
+	}
+	evt, topic, asked := g.evt, g.topic, append([]string(nil), g.asked...)
+	m.quizzes.mu.Unlock()
+
+	if last {
+		m.sendNotice(roomID, "", out+"\n\n"+m.quizResults(roomID, g.scores))
+		return
+	}
+
+	m.goLoop(func() {
+		q, err := m.quizQuestion(evt, topic, asked)
+		m.quizzes.mu.Lock()
+		current := m.quizzes.games[roomID] == g
+		switch {
+		case !current:
+		case err != nil:
+			delete(m.quizzes.games, roomID)
+		default:
+			out += "\n\n" + m.askQuestion(roomID, g, q)
+		}
+		m.quizzes.mu.Unlock()
+		if !current {
+			// stopped while the question was written
+			return
+		}
+		if err != nil {
+			m.logger.Error("failed to write quiz question", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+			out += "\n\nI could not come up with another question, so the quiz ends here.\n\n" + m.quizResults(roomID, g.scores)
+		}
+		m.sendNotice(roomID, "", out)
+	})
+}
+
+func (m *Bot) skipQuestion(evt *event.Event) error {
+	m.quizzes.mu.Lock()
+	g, ok := m.quizzes.games[evt.RoomID]
+	var round int
+	if ok {
+		round = g.round
+	}
+	m.quizzes.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no quiz is running in this room")
+	}
+	m.closeQuestion(evt.RoomID, round, "", func(q QuizQuestion) string {
+		return fmt.Sprintf("Skipped. The answer was **%s**.", q.Answer)
+	})
+
+	return nil
+}
+
+func (m *Bot) stopQuiz(roomID id.RoomID) (string, error) {
+	g, ok := m.endQuiz(roomID)
+	if !ok {
+		return "", fmt.Errorf("no quiz is running in this room")
+	}
+
+	return "The quiz is stopped.\n\n" + m.quizResults(roomID, g.scores), nil
+}
+
+// endQuiz removes the quiz of the room, if there is one.
+func (m *Bot) endQuiz(roomID id.RoomID) (*quizGame, bool) {
+	m.quizzes.mu.Lock()
+	defer m.quizzes.mu.Unlock()
+
+	g, ok := m.quizzes.games[roomID]
+	if !ok {
+		return nil, false
+	}
+	g.open = false
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	delete(m.quizzes.games, roomID)
+
+	return g, true
+}
+
+func (m *Bot) quizScores(roomID id.RoomID) (string, error) {
+	m.quizzes.mu.Lock()
+	g, ok := m.quizzes.games[roomID]
+	scores := make(map[id.UserID]int)
+	if ok {
+		for u, n := range g.scores {
+			scores[u] = n
+		}
+	}
+	m.quizzes.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("no quiz is running in this room")
+	}
+
+	return m.quizRanking(roomID, scores), nil
+}
+
+// quizResults announces the winners.
+func (m *Bot) quizResults(roomID id.RoomID, scores map[id.UserID]int) string {
+	if len(scores) == 0 {
+		return "Nobody scored a point this time."
+	}
+	best := 0
+	for _, n := range scores {
+		if n > best {
+			best = n
+		}
+	}
+	var winners []string
+	for u, n := range scores {
+		if n == best {
+			winners = append(winners, m.displayName(roomID, u))
+		}
+	}
+	sort.Strings(winners)
+	text := fmt.Sprintf("🏆 %s wins with %s!", winners[0], points(best))
+	if len(winners) > 1 {
+		text = fmt.Sprintf("🏆 It's a tie between %s, with %s each!", strings.Join(winners, " and "), points(best))
+	}
+
+	return text + "\n\n" + m.quizRanking(roomID, scores)
+}
+
+func (m *Bot) quizRanking(roomID id.RoomID, scores map[id.UserID]int) string {
+	if len(scores) == 0 {
+		return "No points yet."
+	}
+	type score struct {
+		name   string
+		points int
+	}
+	var ranking []score
+	for u, n := range scores {
+		ranking = append(ranking, score{name: m.displayName(roomID, u), points: n})
+	}
+	sort.Slice(ranking, func(i, j int) bool {
+		if ranking[i].points != ranking[j].points {
+			return ranking[i].points > ranking[j].points
+		}
+		return ranking[i].name < ranking[j].name
+	})
+	lines := make([]string, 0, len(ranking))
+	for i, s := range ranking {
+		lines = append(lines, fmt.Sprintf("%d. %s: %d", i+1, s.name, s.points))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func points(n int) string {
+	if n == 1 {
+		return "1 point"
+	}
+
+	return fmt.Sprintf("%d points", n)
+}
+
+// quizQuestion picks a question from the bank that was not asked yet, or has
+// the model write one.
+func (m *Bot) quizQuestion(evt *event.Event, topic string, asked []string) (QuizQuestion, error) {
+	if topic == "" {
+		var fresh []QuizQuestion
+		for _, q := range m.config.Quiz.Questions {
+			if !contains(asked, q.Question) {
+				fresh = append(fresh, q)
+			}
+		}
+		if len(fresh) > 0 {
+			return fresh[rand.Intn(len(fresh))], nil
+		}
+	}
+
+	text := "Topic: " + topic
+	if topic == "" {
+		text = "Topic: general knowledge"
+	}
+	if len(asked) > 0 {
+		text += "\n\nAlready asked:\n- " + strings.Join(asked, "\n- ")
+	}
+	answer, err := m.completeFor(evt, m.config.Quiz.Model, quizPrompt, text)
+	if err != nil {
+		return QuizQuestion{}, err
+	}
+	answer = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(answer, "```json"), "```"), "```")
+	var q QuizQuestion
+	if err := json.Unmarshal([]byte(answer), &q); err != nil {
+		return QuizQuestion{}, fmt.Errorf("invalid question from model: %w", err)
+	}
+	if q.Question == "" || q.Answer == "" {
+		return QuizQuestion{}, fmt.Errorf("question from model has no question or answer")
+	}
+
+	return q, nil
+}
+
+// QuizHandler takes the messages in rooms with an open quiz question as
+// answers. The first right answer gets the point and brings up the next
+// question.
+func (m *Bot) QuizHandler() MessageHandler {
+	return NewMessageHandler("quiz", PriorityQuiz, func(evt *event.Event) bool {
+		content := evt.Content.AsMessage()
+		body := strings.TrimSpace(event.TrimReplyFallbackText(content.Body))
+		if content.MsgType != event.MsgText || body == "" || strings.HasPrefix(body, commandPrefix) {
+			return false
+		}
+		m.quizzes.mu.Lock()
+		g, ok := m.quizzes.games[evt.RoomID]
+		if !ok || !g.open {
+			m.quizzes.mu.Unlock()
+			return false
+		}
+		round, right := g.round, g.question.Matches(body)
+		m.quizzes.mu.Unlock()
+		if right {
+			name := m.displayName(evt.RoomID, evt.Sender)
+			m.closeQuestion(evt.RoomID, round, evt.Sender, func(q QuizQuestion) string {
+				return fmt.Sprintf("✅ %s got it, the answer was **%s**.", name, q.Answer)
+			})
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,107 @@
+package bot_test
+
+import (
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestQuizQuestionMatches(t *testing.T) {
+	t.Parallel()
+
+	q := bot.QuizQuestion{Question: "Which planet is known as the red planet?", Answer: "Mars", Alternatives: []string{"planet Mars"}}
+	for _, tc := range []struct {
+		message string
+		exp     bool
+	}{
+		{message: "mars", exp: true},
+		{message: "The planet Mars!", exp: true},
+		{message: "planet Marz", exp: true},
+		{message: "Mats", exp: false},
+		{message: "Marsupial", exp: false},
+		{message: "Not Venus, but Mars", exp: false},
+		{message: "Venus", exp: false},
+	} {
+		t.Run(tc.message, func(t *testing.T) {
+			if act := q.Matches(tc.message); act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestQuiz(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	fp := bot.NewFakeProvider()
+	fp.Script(
+		`{"question": "What is the closest star to Earth?", "answer": "Sun", "alternatives": ["Sol"]}`,
+		"```json\n{\"question\": \"Which planet is known as the red planet?\", \"answer\": \"Mars\"}\n```",
+	)
+	b := newTestBot(t, bot.ConfigBot{
+		UserID: "@bot:ewintr.nl",
+	}, bot.WithMatrix(fm), bot.WithProvider(fp))
+	_, h := b.ResponseHandler()
+
+	for _, tc := range []struct {
+		name    string
+		body    string
+		expBody string
+		// the next question is written in the background
+		async bool
+	}{
+		{name: "start", body: "!quiz 2 space", expBody: "A quiz of 2 questions about space! The first to give the right answer gets the point, you have 60 seconds for each question.\n\n**Question 1/2:** What is the closest star to Earth?"},
+		{name: "running", body: "!quiz", expBody: "Error: a quiz is already running in this room, end it with !quiz stop"},
+		{name: "wrong", body: "Is it the moon?"},
+		{name: "right", body: "The sun!", expBody: "✅ @someone:ewintr.nl got it, the answer was **Sun**.\n\n**Question 2/2:** Which planet is known as the red planet?", async: true},
+		{name: "skip", body: "!quiz skip", expBody: "Skipped. The answer was **Mars**.\n\n🏆 @someone:ewintr.nl wins with 1 point!\n\n1. @someone:ewintr.nl: 1"},
+		{name: "ended", body: "!quiz stop", expBody: "Error: no quiz is running in this room"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := len(fm.Messages())
+			h(mautrix.EventSourceTimeline, testMessage(id.EventID("$"+tc.name), tc.body, ""))
+			if tc.async {
+				waitForMessages(t, fm, before+1)
+			}
+
+			msgs := fm.Messages()
+			if tc.expBody == "" {
+				if len(msgs) != before {
+					t.Errorf("exp %v, got %v", before, len(msgs))
+				}
+				return
+			}
+			if len(msgs) != before+1 {
+				t.Fatalf("exp %v, got %v", before+1, len(msgs))
+			}
+			if act := msgs[len(msgs)-1].Content.(*event.MessageEventContent).Body; act != tc.expBody {
+				t.Errorf("exp %q, got %q", tc.expBody, act)
+			}
+		})
+	}
+
+	reqs := fp.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("exp 2, got %v", len(reqs))
+	}
+	if exp, act := "Topic: space\n\nAlready asked:\n- What is the closest star to Earth?", reqs[1].Messages[len(reqs[1].Messages)-1].Content; act != exp {
+		t.Errorf("exp %q, got %q", exp, act)
+	}
+}
+
+func waitForMessages(t *testing.T, fm *bot.FakeMatrix, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for len(fm.Messages()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("exp %v messages, got %v", n, len(fm.Messages()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/id"
+)
+
+// ErrQuotaExceeded is returned for what a user asks after using up the
+// quota for today.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ConfigQuota limits what a single user can ask per day, in the time zone
+// of the bot: the number of answers, and the tokens they used. Zero means
+// no limit. Admins have no quota.
+type ConfigQuota struct {
+	Answers int
+	Tokens  int
+}
+
+// todayUsage adds up what the user used since the start of the day. The day
+// is that of the bot, not of the user, who could otherwise get a new quota by
+// moving to a time zone where the day just started.
+func (m *Bot) todayUsage(userID id.UserID, now time.Time) (UsageTotal, error) {
+	now = now.In(m.location())
+	perModel, err := m.store.ModelUsage(UsageFilter{
+		UserID: userID,
+		Since:  time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()),
+	})
+	if err != nil {
+		return UsageTotal{}, err
+	}
+	total := UsageTotal{Key: userID.String()}
+	for _, ut := range perModel {
+		total.Answers += ut.Answers
+		total.PromptTokens += ut.PromptTokens
+		total.CompletionTokens += ut.CompletionTokens
+	}
+
+	return total, nil
+}
+
+// quotaExceeded returns the reply for a user that used up the quota for
+// today. If the usage can not be read, the quota does not apply.
+func (m *Bot) quotaExceeded(userID id.UserID, lang string) (string, bool) {
+	quota := m.config.Quota
+	if (quota.Answers <= 0 && quota.Tokens <= 0) || m.isAdmin(userID) {
+		return "", false
+	}
+	today, err := m.todayUsage(userID, time.Now())
+	if err != nil {
+		m.logger.Error("failed to get usage", slog.String("err", err.Error()), slog.String("user_id", userID.String()), slog.String("bot", m.config.UserDisplayName))
+		return "", false
+	}
+	switch {
+	case quota.Answers > 0 && today.Answers >= quota.Answers:
+		return m.text(lang, msgQuotaAnswers, quota.Answers), true
+	case quota.Tokens > 0 && today.TotalTokens() >= quota.Tokens:
+		return m.text(lang, msgQuotaTokens, quota.Tokens), true
+	default:
+		return "", false
+	}
+}
+
+// quotaLine describes how much of the quota for today the user has left,
+// or nothing when there is no quota.
+func (m *Bot) quotaLine(userID id.UserID) (string, error) {
+	quota := m.config.Quota
+	if (quota.Answers <= 0 && quota.Tokens <= 0) || m.isAdmin(userID) {
+		return "", nil
+	}
+	today, err := m.todayUsage(userID, time.Now())
+	if err != nil {
+		return "", err
+	}
+	var line string
+	if quota.Answers > 0 {
+		line = fmt.Sprintf("%d of %d answers", today.Answers, quota.Answers)
+	}
+	if quota.Tokens > 0 {
+		if line != "" {
+			line += ", "
+		}
+		line += fmt.Sprintf("%d of %d tokens", today.TotalTokens(), quota.Tokens)
+	}
+
+	return fmt.Sprintf("Used today: %s.", line), nil
+}
@@ -0,0 +1,184 @@
+package bot_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestQuota(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name        string
+		quota       bot.ConfigQuota
+		sender      id.UserID
+		expRequests int
+		expLast     string
+		expUsage    string
+	}{
+		{
+			name:        "no quota",
+			sender:      "@someone:ewintr.nl",
+			expRequests: 3,
+			expLast:     "OK.",
+		},
+		{
+			name:        "answers",
+			quota:       bot.ConfigQuota{Answers: 2},
+			sender:      "@someone:ewintr.nl",
+			expRequests: 2,
+			expLast:     "Sorry, you have had your 2 answers for today.",
+			expUsage:    "Used today: 2 of 2 answers.",
+		},
+		{
+			name:        "tokens",
+			quota:       bot.ConfigQuota{Answers: 10, Tokens: 5},
+			sender:      "@someone:ewintr.nl",
+			expRequests: 2,
+			expLast:     "Sorry, you have used your 5 tokens for today.",
+			expUsage:    "Used today: 2 of 10 answers, 6 of 5 tokens.",
+		},
+		{
+			name:        "admin",
+			quota:       bot.ConfigQuota{Answers: 1},
+			sender:      "@admin:ewintr.nl",
+			expRequests: 3,
+			expLast:     "OK.",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fm := bot.NewFakeMatrix()
+			fp := bot.NewFakeProvider()
+			b := newTestBot(t, bot.ConfigBot{
+				UserID:            "@bot:ewintr.nl",
+				SystemPrompt:      "Help.",
+				AnswerUnaddressed: true,
+				Admins:            []string{"@admin:ewintr.nl"},
+				Quota:             tc.quota,
+			}, bot.WithMatrix(fm), bot.WithProvider(fp))
+			_, h := b.ResponseHandler()
+
+			for i, body := range []string{"Hello", "Hello", "Hello", "!usage"} {
+				evt := testMessage(id.EventID(fmt.Sprintf("$q%d", i)), body, "")
+				evt.Sender = tc.sender
+				h(mautrix.EventSourceTimeline, evt)
+			}
+
+			if act := len(fp.Requests()); act != tc.expRequests {
+				t.Errorf("exp %v, got %v", tc.expRequests, act)
+			}
+			msgs := fm.Messages()
+			if len(msgs) != 4 {
+				t.Fatalf("exp 4, got %v", len(msgs))
+			}
+			if act := msgs[2].Content.(*event.MessageEventContent).Body; !strings.HasPrefix(act, tc.expLast) {
+				t.Errorf("exp %v, got %v", tc.expLast, act)
+			}
+			usage := msgs[3].Content.(*event.MessageEventContent).Body
+			if tc.expUsage == "" {
+				if strings.Contains(usage, "Used today") {
+					t.Errorf("exp no quota, got %v", usage)
+				}
+				return
+			}
+			if !strings.Contains(usage, tc.expUsage) {
+				t.Errorf("exp %q in %v", tc.expUsage, usage)
+			}
+		})
+	}
+}
+
+func TestQuotaTimezone(t *testing.T) {
+	t.Parallel()
+
+	// the day of the bot is halfway, while that of the zone the user moves to
+	// started this hour, after the answers were given
+	now := time.Now().UTC()
+	botZone := etcZone(12 - now.Hour())
+	userOffset := -now.Hour()
+	if userOffset < -12 {
+		userOffset += 24
+	}
+	userZone := etcZone(userOffset)
+
+	fm := bot.NewFakeMatrix()
+	fp := bot.NewFakeProvider()
+	store := newTestStore(t)
+	b := newTestBot(t, bot.ConfigBot{
+		UserID:            "@bot:ewintr.nl",
+		SystemPrompt:      "Help.",
+		AnswerUnaddressed: true,
+		Timezone:          botZone,
+		Quota:             bot.ConfigQuota{Answers: 2},
+	}, bot.WithStore(store), bot.WithMatrix(fm), bot.WithProvider(fp))
+	for i := 0; i < 2; i++ {
+		if err := store.AddUsage(bot.UsageRecord{
+			EventID:   id.EventID(fmt.Sprintf("$old%d", i)),
+			RoomID:    "!room:ewintr.nl",
+			UserID:    "@someone:ewintr.nl",
+			Model:     "gpt-4o",
+			CreatedAt: now.Add(-time.Duration(now.Minute()+1) * time.Minute),
+		}); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+	}
+	_, h := b.ResponseHandler()
+
+	h(mautrix.EventSourceTimeline, testMessage("$tz", "!tz "+userZone, ""))
+	h(mautrix.EventSourceTimeline, testMessage("$q", "Hello", ""))
+
+	if act := len(fp.Requests()); act != 0 {
+		t.Errorf("exp 0, got %v", act)
+	}
+	msgs := fm.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("exp 2, got %v", len(msgs))
+	}
+	exp := "Sorry, you have had your 2 answers for today."
+	if act := msgs[1].Content.(*event.MessageEventContent).Body; !strings.HasPrefix(act, exp) {
+		t.Errorf("exp %v, got %v", exp, act)
+	}
+}
+
+// etcZone is the name of the zone that is offset hours ahead of UTC.
+func etcZone(offset int) string {
+	if offset == 0 {
+		return "Etc/GMT"
+	}
+
+	return fmt.Sprintf("Etc/GMT%+d", -offset)
+}
+
+func TestQuotaCommands(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	fp := bot.NewFakeProvider()
+	b := newTestBot(t, bot.ConfigBot{
+		UserID: "@bot:ewintr.nl",
+		Quota:  bot.ConfigQuota{Answers: 1},
+	}, bot.WithMatrix(fm), bot.WithProvider(fp))
+	_, h := b.ResponseHandler()
+
+	for i := 0; i < 2; i++ {
+		h(mautrix.EventSourceTimeline, testMessage(id.EventID(fmt.Sprintf("$t%d", i)), "!translate en Goedemorgen", ""))
+	}
+
+	if act := len(fp.Requests()); act != 1 {
+		t.Errorf("exp 1, got %v", act)
+	}
+	msgs := fm.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("exp 2, got %v", len(msgs))
+	}
+	if exp, act := "Sorry, you have had your 1 answers for today.", msgs[1].Content.(*event.MessageEventContent).Body; !strings.Contains(act, exp) {
+		t.Errorf("exp %q in %q", exp, act)
+	}
+}
@@ -0,0 +1,492 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/util/dbutil"
+)
+
+const (
+	reminderInterval    = 30 * time.Second
+	reminderDefaultHour = 9
+	reminderTimeFormat  = "Mon 2 Jan 15:04"
+	reminderSnooze      = 30 * time.Minute
+	reminderKeep        = 24 * time.Hour
+	reminderMaxAhead    = 366 * 24 * time.Hour
+	reminderMaxPending  = 25
+	reactionSnooze      = "💤"
+	reactionCancel      = "❌"
+)
+
+// reminderBackoff is used for reminders that could not be sent. After the
+// last attempt the reminder is marked as failed.
+var reminderBackoff = Backoff{Attempts: 5, Base: time.Minute, Max: time.Hour}
+
+// Reminder is a mention to send at Due. EventID is the request for it and
+// NoticeEventID the last message of the bot about it, the confirmation or the
+// reminder itself. Sent reminders are kept for a day, so they can still be
+// snoozed. A reminder that could not be sent is tried again later, Attempts
+// counts the failures.
+type Reminder struct {
+	ID            int64
+	RoomID        id.RoomID
+	UserID        id.UserID
+	EventID       id.EventID
+	NoticeEventID id.EventID
+	Due           time.Time
+	Message       string
+	Sent          bool
+	Attempts      int
+}
+
+var errReminderTooFar = errors.New("that is more than a year ahead")
+
+var reminderUnits = map[string]time.Duration{
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+// ParseReminderTime understands the times people usually write when asking
+// for a reminder, relative to now and in its time zone:
+//
+//	in 2 hours, in an hour, in 10 min
+//	at 15:30
+//	tomorrow, tomorrow at 8:00
+//	on friday, on 2023-06-01 at 14:00
+//
+// A day without a time means nine in the morning. Times more than a year
+// ahead are refused.
+func ParseReminderTime(when string, now time.Time) (time.Time, error) {
+	fields := strings.Fields(strings.ToLower(when))
+	if len(fields) == 0 {
+		return time.Time{}, fmt.Errorf("no time given")
+	}
+
+	if fields[0] == "in" {
+		if len(fields) != 3 {
+			return time.Time{}, fmt.Errorf("could not understand %q, try \"in 2 hours\"", when)
+		}
+		n, err := strconv.Atoi(fields[1])
+		if fields[1] == "a" || fields[1] == "an" {
+			n, err = 1, nil
+		}
+		if err != nil || n <= 0 {
+			return time.Time{}, fmt.Errorf("not a number: %s", fields[1])
+		}
+		unit, ok := reminderUnits[fields[2]]
+		if !ok {
+			return time.Time{}, fmt.Errorf("unknown unit %q", fields[2])
+		}
+		// compare before multiplying, a large n would overflow
+		if n > int(reminderMaxAhead/unit) {
+			return time.Time{}, errReminderTooFar
+		}
+		return now.Add(time.Duration(n) * unit), nil
+	}
+
+	// a day, optionally followed by a time
+	day := now
+	hour, minute, hasTime := reminderDefaultHour, 0, false
+	rest := fields
+	switch {
+	case rest[0] == "today":
+		rest = rest[1:]
+	case rest[0] == "tomorrow":
+		day = now.AddDate(0, 0, 1)
+		rest = rest[1:]
+	case rest[0] == "on" && len(rest) > 1:
+		d, err := parseReminderDay(rest[1], now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		day = d
+		rest = rest[2:]
+	}
+	if len(rest) > 0 {
+		if rest[0] != "at" || len(rest) != 2 {
+			return time.Time{}, fmt.Errorf("could not understand %q, try \"at 15:30\"", when)
+		}
+		var err error
+		if hour, minute, err = parseClock(rest[1]); err != nil {
+			return time.Time{}, err
+		}
+		hasTime = true
+	}
+	due := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location())
+	if !due.After(now) {
+		if !hasTime || fields[0] != "at" {
+			return time.Time{}, fmt.Errorf("%s is in the past", due.Format(reminderTimeFormat))
+		}
+		// "at 9:00" when it is already later means tomorrow
+		due = due.AddDate(0, 0, 1)
+	}
+	if due.Sub(now) > reminderMaxAhead {
+		return time.Time{}, errReminderTooFar
+	}
+
+	return due, nil
+}
+
+func parseReminderDay(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseInLocation("2006-01-02", s, now.Location()); err == nil {
+		return d, nil
+	}
+	for i := 1; i <= 7; i++ {
+		d := now.AddDate(0, 0, i)
+		if strings.ToLower(d.Weekday().String()) == s {
+			return d, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unknown day %q", s)
+}
+
+func parseClock(s string) (int, int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("not a time: %s", s)
+	}
+
+	return t.Hour(), t.Minute(), nil
+}
+
+func (m *Bot) remindCommand() Command {
+	return Command{
+		Name:  "remind",
+		Usage: "me <when> to <what>",
+		Help:  "get a mention at the given time, like `!remind me in 2 hours to check the build`",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			if len(args) > 0 && args[0] == "me" {
+				args = args[1:]
+			}
+			line := strings.Join(args, " ")
+			when, what, ok := strings.Cut(line, " to ")
+			if !ok || strings.TrimSpace(what) == "" {
+				return "", fmt.Errorf("usage: !remind me <when> to <what>")
+			}
+			due, err := ParseReminderTime(when, time.Now().In(m.userLocation(evt.Sender)))
+			if err != nil {
+				return "", err
+			}
+			pending, err := m.store.PendingReminders(evt.Sender)
+			if err != nil {
+				return "", err
+			}
+			if pending >= reminderMaxPending {
+				return "", fmt.Errorf("you already have %d reminders waiting, cancel one first", pending)
+			}
+			reminderID, err := m.store.AddReminder(Reminder{
+				RoomID:  evt.RoomID,
+				UserID:  evt.Sender,
+				EventID: evt.ID,
+				Due:     due,
+				Message: strings.TrimSpace(what),
+			})
+			if err != nil {
+				return "", err
+			}
+
+			noticeID := m.sendNotice(evt.RoomID, evt.ID, fmt.Sprintf("I'll remind you on %s. Reply `!cancel` to drop it.", due.Format(reminderTimeFormat)))
+			if noticeID != "" {
+				if err := m.store.SetReminderNotice(reminderID, noticeID, false); err != nil {
+					return "", err
+				}
+			}
+			return "", nil
+		},
+	}
+}
+
+func (m *Bot) snoozeCommand() Command {
+	return Command{
+		Name:  "snooze",
+		Usage: "[duration]",
+		Help:  "in reply to a reminder, send it again later, like `!snooze 30m` or `!snooze tomorrow`",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			r, err := m.repliedReminder(evt)
+			if err != nil {
+				return "", err
+			}
+			due, err := ParseSnooze(strings.Join(args, " "), time.Now().In(m.userLocation(evt.Sender)))
+			if err != nil {
+				return "", err
+			}
+			if err := m.store.SnoozeReminder(r.ID, due); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Snoozed until %s.", due.Format(reminderTimeFormat)), nil
+		},
+	}
+}
+
+func (m *Bot) cancelCommand() Command {
+	return Command{
+		Name: "cancel",
+		Help: "in reply to a reminder, drop it",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			r, err := m.repliedReminder(evt)
+			if err != nil {
+				return "", err
+			}
+			if err := m.store.DeleteReminder(r.ID); err != nil {
+				return "", err
+			}
+			return "Reminder cancelled.", nil
+		},
+	}
+}
+
+// repliedReminder returns the reminder the command is a reply to. Only the
+// user it is for, or an admin, may change it.
+func (m *Bot) repliedReminder(evt *event.Event) (Reminder, error) {
+	replyTo := evt.Content.AsMessage().RelatesTo.GetReplyTo()
+	if replyTo == "" {
+		return Reminder{}, fmt.Errorf("reply to a reminder to change it")
+	}
+	r, ok, err := m.store.ReminderByEvent(evt.RoomID, replyTo)
+	if err != nil {
+		return Reminder{}, err
+	}
+	if !ok {
+		return Reminder{}, fmt.Errorf("that is not a reminder, or it is too old")
+	}
+	if r.UserID != evt.Sender && !m.isAdmin(evt.Sender) {
+		return Reminder{}, fmt.Errorf("only %s can change this reminder", r.UserID)
+	}
+
+	return r, nil
+}
+
+// ParseSnooze reads the argument of !snooze: a duration like 30m or 2h, a
+// time that ParseReminderTime understands, or "2 hours" for "in 2 hours".
+// Without an argument it snoozes for 30 minutes.
+func ParseSnooze(arg string, now time.Time) (time.Time, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return now.Add(reminderSnooze), nil
+	}
+	if d, err := time.ParseDuration(arg); err == nil && d > 0 {
+		if d > reminderMaxAhead {
+			return time.Time{}, errReminderTooFar
+		}
+		return now.Add(d), nil
+	}
+	if due, err := ParseReminderTime(arg, now); err == nil {
+		return due, nil
+	}
+
+	return ParseReminderTime("in "+arg, now)
+}
+
+// ReminderReactionHandler snoozes a reminder for 30 minutes when its user
+// reacts with 💤 and drops it on ❌.
+func (m *Bot) ReminderReactionHandler() (event.Type, mautrix.EventHandler) {
+	return event.EventReaction, func(source mautrix.EventSource, evt *event.Event) {
+		if evt.Sender == m.matrix.UserID() {
+			return
+		}
+		rel := evt.Content.AsReaction().GetRelatesTo()
+		if rel.Type != event.RelAnnotation {
+			return
+		}
+		key := strings.TrimSuffix(rel.Key, "\ufe0f")
+		if key != reactionSnooze && key != reactionCancel {
+			return
+		}
+		r, ok, err := m.store.ReminderByEvent(evt.RoomID, rel.EventID)
+		if err != nil || !ok || r.UserID != evt.Sender {
+			return
+		}
+
+		var text string
+		switch key {
+		case reactionSnooze:
+			due := time.Now().In(m.userLocation(r.UserID)).Add(reminderSnooze)
+			err = m.store.SnoozeReminder(r.ID, due)
+			text = fmt.Sprintf("Snoozed until %s.", due.Format(reminderTimeFormat))
+		case reactionCancel:
+			err = m.store.DeleteReminder(r.ID)
+			text = "Reminder cancelled."
+		}
+		if err != nil {
+			m.logger.Error("failed to update reminder", slog.String("err", err.Error()), slog.Int64("reminder", r.ID), slog.String("bot", m.config.UserDisplayName))
+			return
+		}
+		m.sendNotice(evt.RoomID, rel.EventID, text)
+	}
+}
+
+// runReminders sends the reminders that are due. As they are stored, the ones
+// that became due while the bot was down are sent right after the start.
+func (m *Bot) runReminders() {
+	for {
+		reminders, err := m.store.DueReminders(time.Now())
+		if err != nil {
+			m.logger.Error("failed to get reminders", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		}
+		for _, r := range reminders {
+			// a queued reminder will be sent, but can not be snoozed with a reaction
+			eventID, err := m.sendReminder(r)
+			if err != nil && !errors.Is(err, ErrQueued) {
+				m.logger.Error("failed to send reminder", slog.String("err", err.Error()), slog.Int64("reminder", r.ID), slog.String("bot", m.config.UserDisplayName))
+				m.retryReminder(r, time.Now())
+				continue
+			}
+			if err := m.store.SetReminderNotice(r.ID, eventID, true); err != nil {
+				m.logger.Error("failed to update reminder", slog.String("err", err.Error()), slog.Int64("reminder", r.ID), slog.String("bot", m.config.UserDisplayName))
+			}
+		}
+		if err := m.store.PruneReminders(time.Now().Add(-reminderKeep)); err != nil {
+			m.logger.Error("failed to prune reminders", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		}
+		if !m.wait(reminderInterval) {
+			return
+		}
+	}
+}
+
+// retryReminder schedules the next attempt for a reminder that could not be
+// sent, or gives up on it.
+func (m *Bot) retryReminder(r Reminder, now time.Time) {
+	attempts := r.Attempts + 1
+	if attempts >= reminderBackoff.Attempts {
+		m.logger.Error("giving up on reminder", slog.Int64("reminder", r.ID), slog.Int("attempts", attempts), slog.String("bot", m.config.UserDisplayName))
+		if err := m.store.FailReminder(r.ID); err != nil {
+			m.logger.Error("failed to update reminder", slog.String("err", err.Error()), slog.Int64("reminder", r.ID), slog.String("bot", m.config.UserDisplayName))
+		}
+		return
+	}
+	if err := m.store.RetryReminder(r.ID, attempts, now.Add(reminderBackoff.Delay(attempts))); err != nil {
+		m.logger.Error("failed to update reminder", slog.String("err", err.Error()), slog.Int64("reminder", r.ID), slog.String("bot", m.config.UserDisplayName))
+	}
+}
+
+func (m *Bot) sendReminder(r Reminder) (id.EventID, error) {
+	content := event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          fmt.Sprintf("%s: reminder: %s (react %s or reply `!snooze 30m` to snooze)", r.UserID, r.Message, reactionSnooze),
+		Format:        event.FormatHTML,
+		FormattedBody: fmt.Sprintf(`<a href="https://matrix.to/#/%s">%s</a>: reminder: %s <i>(react %s or reply <code>!snooze 30m</code> to snooze)</i>`, r.UserID, r.UserID, html.EscapeString(r.Message), reactionSnooze),
+		Mentions:      &event.Mentions{UserIDs: []id.UserID{r.UserID}},
+	}
+	if r.EventID != "" {
+		content.RelatesTo = &event.RelatesTo{InReplyTo: &event.InReplyTo{EventID: r.EventID}}
+	}
+	eventID, err := m.sendMessage(r.RoomID, &content)
+	if err != nil {
+		return "", err
+	}
+
+	return eventID, nil
+}
+
+func (s *Store) AddReminder(r Reminder) (int64, error) {
+	var reminderID int64
+	err := s.db.QueryRowContext(s.context(), `INSERT INTO bot_reminder (room_id, user_id, event_id, due_at, message) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		r.RoomID, r.UserID, r.EventID, r.Due.Unix(), r.Message).Scan(&reminderID)
+
+	return reminderID, err
+}
+
+// PendingReminders counts the reminders of the user that are not sent or
+// failed yet.
+func (s *Store) PendingReminders(userID id.UserID) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(s.context(), `SELECT COUNT(*) FROM bot_reminder WHERE user_id = $1 AND NOT sent AND NOT failed`, userID).Scan(&n)
+
+	return n, err
+}
+
+const reminderColumns = `id, room_id, user_id, event_id, notice_event_id, due_at, message, sent, attempts`
+
+// DueReminders returns the reminders that are due at now and not sent or
+// failed yet, oldest first.
+func (s *Store) DueReminders(now time.Time) ([]Reminder, error) {
+	rows, err := s.db.QueryContext(s.context(), `SELECT `+reminderColumns+` FROM bot_reminder WHERE due_at <= $1 AND NOT sent AND NOT failed ORDER BY due_at`, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	return scanReminders(rows)
+}
+
+// ReminderByEvent finds the reminder that was requested with, or announced in,
+// the event.
+func (s *Store) ReminderByEvent(roomID id.RoomID, eventID id.EventID) (Reminder, bool, error) {
+	rows, err := s.db.QueryContext(s.context(), `SELECT `+reminderColumns+` FROM bot_reminder WHERE room_id = $1 AND (event_id = $2 OR notice_event_id = $2)`, roomID, eventID)
+	if err != nil {
+		return Reminder{}, false, err
+	}
+	reminders, err := scanReminders(rows)
+	if err != nil || len(reminders) == 0 {
+		return Reminder{}, false, err
+	}
+
+	return reminders[0], true, nil
+}
+
+// SetReminderNotice records the last message about the reminder and whether
+// it was the reminder itself.
+func (s *Store) SetReminderNotice(reminderID int64, eventID id.EventID, sent bool) error {
+	_, err := s.db.ExecContext(s.context(), `UPDATE bot_reminder SET notice_event_id = $2, sent = $3 WHERE id = $1`, reminderID, eventID, sent)
+	return err
+}
+
+// SnoozeReminder makes the reminder due again at the given time.
+func (s *Store) SnoozeReminder(reminderID int64, due time.Time) error {
+	_, err := s.db.ExecContext(s.context(), `UPDATE bot_reminder SET due_at = $2, sent = false, attempts = 0, failed = false WHERE id = $1`, reminderID, due.Unix())
+	return err
+}
+
+// RetryReminder moves a reminder that could not be sent to a later due time.
+func (s *Store) RetryReminder(reminderID int64, attempts int, due time.Time) error {
+	_, err := s.db.ExecContext(s.context(), `UPDATE bot_reminder SET attempts = $2, due_at = $3 WHERE id = $1`, reminderID, attempts, due.Unix())
+	return err
+}
+
+// FailReminder marks a reminder that could not be sent at all. It is pruned
+// like a sent one.
+func (s *Store) FailReminder(reminderID int64) error {
+	_, err := s.db.ExecContext(s.context(), `UPDATE bot_reminder SET failed = true WHERE id = $1`, reminderID)
+	return err
+}
+
+func (s *Store) DeleteReminder(reminderID int64) error {
+	_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_reminder WHERE id = $1`, reminderID)
+	return err
+}
+
+// PruneReminders removes the sent and failed reminders that were due before
+// the given time.
+func (s *Store) PruneReminders(before time.Time) error {
+	_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_reminder WHERE (sent OR failed) AND due_at < $1`, before.Unix())
+	return err
+}
+
+func scanReminders(rows dbutil.Rows) ([]Reminder, error) {
+	defer rows.Close()
+
+	var reminders []Reminder
+	for rows.Next() {
+		var r Reminder
+		var due int64
+		if err := rows.Scan(&r.ID, &r.RoomID, &r.UserID, &r.EventID, &r.NoticeEventID, &due, &r.Message, &r.Sent, &r.Attempts); err != nil {
+			return nil, err
+		}
+		r.Due = time.Unix(due, 0)
+		reminders = append(reminders, r)
+	}
+
+	return reminders, rows.Err()
+}
@@ -0,0 +1,80 @@
+package bot_test
+
+import (
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestParseReminderTime(t *testing.T) {
+	t.Parallel()
+
+	// a wednesday
+	now := time.Date(2023, 6, 7, 14, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		when   string
+		exp    time.Time
+		expErr bool
+	}{
+		{name: "in hours", when: "in 2 hours", exp: now.Add(2 * time.Hour)},
+		{name: "in an hour", when: "in an hour", exp: now.Add(time.Hour)},
+		{name: "in short unit", when: "in 10 min", exp: now.Add(10 * time.Minute)},
+		{name: "at later today", when: "at 15:30", exp: time.Date(2023, 6, 7, 15, 30, 0, 0, time.UTC)},
+		{name: "at earlier means tomorrow", when: "at 9:00", exp: time.Date(2023, 6, 8, 9, 0, 0, 0, time.UTC)},
+		{name: "tomorrow", when: "tomorrow", exp: time.Date(2023, 6, 8, 9, 0, 0, 0, time.UTC)},
+		{name: "tomorrow at", when: "tomorrow at 8:15", exp: time.Date(2023, 6, 8, 8, 15, 0, 0, time.UTC)},
+		{name: "weekday", when: "on Friday", exp: time.Date(2023, 6, 9, 9, 0, 0, 0, time.UTC)},
+		{name: "same weekday is next week", when: "on wednesday at 10:00", exp: time.Date(2023, 6, 14, 10, 0, 0, 0, time.UTC)},
+		{name: "date", when: "on 2023-07-01 at 14:00", exp: time.Date(2023, 7, 1, 14, 0, 0, 0, time.UTC)},
+		{name: "date in past", when: "on 2023-01-01", expErr: true},
+		{name: "today in past", when: "today at 13:00", expErr: true},
+		{name: "unknown unit", when: "in 3 fortnights", expErr: true},
+		{name: "more than a year", when: "in 53 weeks", expErr: true},
+		{name: "overflow", when: "in 9223372036854775807 weeks", expErr: true},
+		{name: "date more than a year", when: "on 2025-01-01", expErr: true},
+		{name: "nonsense", when: "whenever", expErr: true},
+		{name: "empty", when: "", expErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := bot.ParseReminderTime(tc.when, now)
+			if (err != nil) != tc.expErr {
+				t.Fatalf("exp error %v, got %v", tc.expErr, err)
+			}
+			if !act.Equal(tc.exp) {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestParseSnooze(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 6, 7, 14, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		arg    string
+		exp    time.Time
+		expErr bool
+	}{
+		{name: "default", arg: "", exp: now.Add(30 * time.Minute)},
+		{name: "duration", arg: "1h30m", exp: now.Add(90 * time.Minute)},
+		{name: "without in", arg: "2 days", exp: now.Add(48 * time.Hour)},
+		{name: "reminder time", arg: "tomorrow at 8:00", exp: time.Date(2023, 6, 8, 8, 0, 0, 0, time.UTC)},
+		{name: "negative", arg: "-5m", expErr: true},
+		{name: "more than a year", arg: "9000h", expErr: true},
+		{name: "nonsense", arg: "later", expErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := bot.ParseSnooze(tc.arg, now)
+			if (err != nil) != tc.expErr {
+				t.Fatalf("exp error %v, got %v", tc.expErr, err)
+			}
+			if !act.Equal(tc.exp) {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Backoff is a jittered exponential backoff: the wait before the next
+// attempt doubles each time, up to Max, and a random part of it is skipped so
+// clients that failed together do not retry together.
+type Backoff struct {
+	Attempts int
+	Base     time.Duration
+	Max      time.Duration
+}
+
+// providerBackoff is used for calls to the LLM provider.
+var providerBackoff = Backoff{Attempts: 5, Base: time.Second, Max: 30 * time.Second}
+
+// Delay returns the wait after the given failed attempt, starting at 1.
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := b.Base
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// RetryAfter parses a Retry-After header, which is either a number of
+// seconds or a date.
+func RetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// sleepContext waits for d, or until the context is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// RetryTransport retries requests that were rate limited or hit a server
+// error, and requests that failed to connect. A Retry-After header from the
+// server is honored, unless it asks to wait longer than Max.
+type RetryTransport struct {
+	Base    http.RoundTripper
+	Backoff Backoff
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	for attempt := 1; ; attempt++ {
+		res, err := base.RoundTrip(req)
+		if attempt >= t.Backoff.Attempts || req.Context().Err() != nil || !retryable(res, err) {
+			return res, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			// the body is gone and can not be sent again
+			return res, err
+		}
+
+		wait := t.Backoff.Delay(attempt)
+		if res != nil {
+			if d, ok := RetryAfter(res.Header, time.Now()); ok {
+				if d > t.Backoff.Max {
+					return res, err
+				}
+				wait = d
+			}
+			res.Body.Close()
+		}
+		if err := sleepContext(req.Context(), wait); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+func retryable(res *http.Response, err error) bool {
+	if errors.Is(err, ErrNotRecorded) {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+package bot_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 6, 7, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name  string
+		value string
+		exp   time.Duration
+		expOK bool
+	}{
+		{name: "none"},
+		{name: "seconds", value: "3", exp: 3 * time.Second, expOK: true},
+		{name: "date", value: "Wed, 07 Jun 2023 12:00:10 GMT", exp: 10 * time.Second, expOK: true},
+		{name: "past date", value: "Wed, 07 Jun 2023 11:00:00 GMT", expOK: true},
+		{name: "invalid", value: "soon"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := http.Header{}
+			if tc.value != "" {
+				h.Set("Retry-After", tc.value)
+			}
+			act, ok := bot.RetryAfter(h, now)
+			if ok != tc.expOK {
+				t.Errorf("exp %v, got %v", tc.expOK, ok)
+			}
+			if act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name       string
+		statuses   []int
+		retryAfter string
+		expStatus  int
+		expCalls   int
+	}{
+		{name: "ok", statuses: []int{200}, expStatus: 200, expCalls: 1},
+		{name: "rate limited", statuses: []int{429, 429, 200}, expStatus: 200, expCalls: 3},
+		{name: "server error", statuses: []int{503, 200}, expStatus: 200, expCalls: 2},
+		{name: "client error", statuses: []int{400, 200}, expStatus: 400, expCalls: 1},
+		{name: "out of attempts", statuses: []int{500, 500, 500, 200}, expStatus: 500, expCalls: 3},
+		{name: "wait too long", statuses: []int{429, 200}, retryAfter: "3600", expStatus: 429, expCalls: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if body, err := io.ReadAll(r.Body); err != nil || string(body) != "body" {
+					t.Errorf("exp body, got %v", string(body))
+				}
+				if tc.retryAfter != "" {
+					w.Header().Set("Retry-After", tc.retryAfter)
+				}
+				w.WriteHeader(tc.statuses[calls])
+				calls++
+			}))
+			defer srv.Close()
+
+			client := &http.Client{Transport: &bot.RetryTransport{
+				Backoff: bot.Backoff{Attempts: 3, Base: time.Millisecond, Max: 10 * time.Millisecond},
+			}}
+			res, err := client.Post(srv.URL, "text/plain", strings.NewReader("body"))
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tc.expStatus {
+				t.Errorf("exp %v, got %v", tc.expStatus, res.StatusCode)
+			}
+			if calls != tc.expCalls {
+				t.Errorf("exp %v, got %v", tc.expCalls, calls)
+			}
+		})
+	}
+}
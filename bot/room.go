@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"strings"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Room is what the bot knows about a room it is in. The name is the room
+// name, or the canonical alias for rooms without one.
+type Room struct {
+	ID        id.RoomID
+	Name      string
+	Topic     string
+	Encrypted bool
+	Members   int
+}
+
+// JoinedRooms lists the rooms the bot is in, from the room cache.
+func (m *Bot) JoinedRooms() ([]Room, error) {
+	return m.store.Rooms()
+}
+
+// RoomName returns the name of the room, the canonical alias if it has no
+// name, or an empty string.
+func (m *Bot) RoomName(roomID id.RoomID) string {
+	if room, ok, err := m.store.Room(roomID); err == nil && ok {
+		return room.Name
+	}
+
+	return m.stateRoomName(roomID)
+}
+
+func (m *Bot) stateRoomName(roomID id.RoomID) string {
+	var name event.RoomNameEventContent
+	if err := m.matrix.StateEvent(roomID, event.StateRoomName, "", &name); err == nil && name.Name != "" {
+		return name.Name
+	}
+	var alias event.CanonicalAliasEventContent
+	if err := m.matrix.StateEvent(roomID, event.StateCanonicalAlias, "", &alias); err == nil && alias.Alias != "" {
+		return alias.Alias.String()
+	}
+
+	return ""
+}
+
+// JoinRoom joins a room by ID or alias and returns the room ID.
+func (m *Bot) JoinRoom(roomIDOrAlias string) (id.RoomID, error) {
+	roomID, err := m.matrix.JoinRoom(roomIDOrAlias)
+	if err != nil {
+		return "", err
+	}
+	m.joined(roomID)
+
+	return roomID, nil
+}
+
+// autoJoin joins the rooms in AutoJoinRooms that the bot is not in yet.
+func (m *Bot) autoJoin() {
+	if len(m.config.AutoJoinRooms) == 0 {
+		return
+	}
+	resp, err := m.client.JoinedRooms()
+	if err != nil {
+		m.logger.Error("failed to get joined rooms", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		return
+	}
+	for _, room := range m.config.AutoJoinRooms {
+		roomID, err := m.ResolveRoom(room)
+		if err != nil {
+			m.logger.Error("failed to resolve room to join", slog.String("err", err.Error()), slog.String("room", room), slog.String("bot", m.config.UserDisplayName))
+			continue
+		}
+		if containsRoom(resp.JoinedRooms, roomID) {
+			continue
+		}
+		if _, err := m.JoinRoom(room); err != nil {
+			m.logger.Error("failed to join room", slog.String("err", err.Error()), slog.String("room", room), slog.String("bot", m.config.UserDisplayName))
+			continue
+		}
+		m.logger.Info("joined room on startup", slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+}
+
+func containsRoom(rooms []id.RoomID, roomID id.RoomID) bool {
+	for _, r := range rooms {
+		if r == roomID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// joined caches the room, reads the room config and posts the greeting in a
+// room the bot just joined.
+func (m *Bot) joined(roomID id.RoomID) {
+	if _, err := m.refreshRoom(roomID); err != nil {
+		m.logger.Error("failed to refresh room", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+	if err := m.loadRoomConfig(roomID); err != nil {
+		m.logger.Error("failed to load room config", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+	m.greet(roomID)
+}
+
+func (m *Bot) JoinedMembers(roomID id.RoomID) ([]id.UserID, error) {
+	return m.matrix.JoinedMembers(roomID)
+}
+
+// ResolveRoom returns the room ID for a room ID or alias.
+func (m *Bot) ResolveRoom(roomIDOrAlias string) (id.RoomID, error) {
+	if !strings.HasPrefix(roomIDOrAlias, "#") {
+		return id.RoomID(roomIDOrAlias), nil
+	}
+	resp, err := m.client.ResolveAlias(id.RoomAlias(roomIDOrAlias))
+	if err != nil {
+		return "", err
+	}
+
+	return resp.RoomID, nil
+}
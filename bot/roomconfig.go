@@ -0,0 +1,203 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// StateRoomConfig is a room state event that room admins can use to change
+// how the bot behaves in their room, for example:
+//
+//	{"persona": "helpdesk", "model": "gpt-4", "mode": "all", "prompt": "Answer in Dutch."}
+//
+// With an empty state key it applies to all bots in the room, with the user
+// ID of a bot as state key only to that bot.
+var StateRoomConfig = event.Type{Type: "org.ewintr.bot.config", Class: event.StateEventType}
+
+const (
+	// ModeAddressed only answers messages that are addressed to the bot.
+	ModeAddressed = "addressed"
+	// ModeAll also answers messages that are addressed to no-one.
+	ModeAll = "all"
+)
+
+// RoomConfigEventContent replaces the default persona, the model, the system
+// prompt, the language and whether unaddressed messages are answered in a
+// room. Empty fields keep the setting from the bot config.
+type RoomConfigEventContent struct {
+	Persona  string `json:"persona,omitempty"`
+	Model    string `json:"model,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+	Prompt   string `json:"prompt,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+// topicPersonaPattern finds a persona in a room topic, like
+// "Support for the office | persona: helpdesk".
+var topicPersonaPattern = regexp.MustCompile(`(?i)\bpersona:\s*([\w.-]+)`)
+
+// validateRoomConfig checks the room config against what the bot offers.
+// Room admins can only pick personas that exist and are not limited to other
+// rooms, and models that are in RoomModels, and only set a prompt when
+// RoomPrompts allows it.
+func (m *Bot) validateRoomConfig(roomID id.RoomID, rc RoomConfigEventContent) error {
+	if rc.Prompt != "" {
+		if !m.config.RoomPrompts {
+			return fmt.Errorf("room prompts are not allowed")
+		}
+		if err := validatePrompt(rc.Prompt); err != nil {
+			return err
+		}
+	}
+	if p, ok := m.lookupPersona(rc.Persona); rc.Persona != "" && !ok {
+		return fmt.Errorf("unknown persona %q", rc.Persona)
+	} else if rc.Persona != "" && !p.allowedIn(roomID) {
+		return fmt.Errorf("persona %q is not available in this room", rc.Persona)
+	}
+	if rc.Model != "" && !contains(m.config.RoomModels, rc.Model) {
+		return fmt.Errorf("model %q is not allowed", rc.Model)
+	}
+	if rc.Mode != "" && rc.Mode != ModeAddressed && rc.Mode != ModeAll {
+		return fmt.Errorf("unknown mode %q", rc.Mode)
+	}
+	if rc.Language != "" {
+		if err := validLanguage(rc.Language); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadRoomConfig reads the StateRoomConfig event of the room and stores it.
+// The event for this bot goes before the one for all bots.
+func (m *Bot) loadRoomConfig(roomID id.RoomID) error {
+	var rc RoomConfigEventContent
+	err := m.matrix.StateEvent(roomID, StateRoomConfig, m.matrix.UserID().String(), &rc)
+	if errors.Is(err, mautrix.MNotFound) {
+		err = m.matrix.StateEvent(roomID, StateRoomConfig, "", &rc)
+	}
+	switch {
+	case errors.Is(err, mautrix.MNotFound):
+		rc = RoomConfigEventContent{}
+	case err != nil:
+		return err
+	}
+	if err := m.validateRoomConfig(roomID, rc); err != nil {
+		m.sendNotice(roomID, "", fmt.Sprintf("Ignoring the room configuration: %s.", err))
+		rc = RoomConfigEventContent{}
+	}
+
+	return m.store.SetRoomConfig(roomID, rc)
+}
+
+// RoomConfigHandler reloads the room config when a StateRoomConfig event for
+// this bot or for all bots changes.
+func (m *Bot) RoomConfigHandler() (event.Type, mautrix.EventHandler) {
+	return StateRoomConfig, func(source mautrix.EventSource, evt *event.Event) {
+		if key := evt.GetStateKey(); key != "" && key != m.matrix.UserID().String() {
+			return
+		}
+		if err := m.loadRoomConfig(evt.RoomID); err != nil {
+			m.logger.Error("failed to load room config", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+			return
+		}
+		m.logger.Info("updated room config from room state", slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+}
+
+func (m *Bot) roomConfig(roomID id.RoomID) RoomConfigEventContent {
+	rc, err := m.store.RoomConfig(roomID)
+	if err != nil {
+		m.logger.Error("failed to get room config", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+
+	return rc
+}
+
+// chatPersona is the persona that answers in the room when no other persona
+// does. That is the persona of the room config or, with TopicPersona, the one
+// named in the room topic, with the model and prompt from the room config.
+func (m *Bot) chatPersona(roomID id.RoomID) Persona {
+	p := Persona{
+		Name:         "chat",
+		SystemPrompt: m.config.SystemPrompt,
+		Examples:     m.config.Examples,
+		Model:        m.config.Model,
+		Tools:        m.config.Tools,
+	}
+	rc := m.roomConfig(roomID)
+	if rp, ok := m.roomPersona(roomID, rc.Persona); ok {
+		p = rp
+	} else if rp, ok := m.topicPersona(roomID); ok {
+		p = rp
+	}
+	if rc.Model != "" {
+		p.Model = rc.Model
+	}
+	if rc.Prompt != "" {
+		p.SystemPrompt = rc.Prompt
+	}
+
+	return p
+}
+
+// topicPersona is the persona named in the topic of the room, if the bot
+// looks there and the persona is not limited to other rooms.
+func (m *Bot) topicPersona(roomID id.RoomID) (Persona, bool) {
+	if !m.config.TopicPersona {
+		return Persona{}, false
+	}
+	room, ok, err := m.store.Room(roomID)
+	if err != nil || !ok {
+		return Persona{}, false
+	}
+	match := topicPersonaPattern.FindStringSubmatch(room.Topic)
+	if match == nil {
+		return Persona{}, false
+	}
+
+	return m.roomPersona(roomID, match[1])
+}
+
+func (m *Bot) answerUnaddressed(roomID id.RoomID) bool {
+	switch m.roomConfig(roomID).Mode {
+	case ModeAll:
+		return true
+	case ModeAddressed:
+		return false
+	default:
+		return m.config.AnswerUnaddressed
+	}
+}
+
+// RoomConfig returns the stored room config, or an empty one.
+func (s *Store) RoomConfig(roomID id.RoomID) (RoomConfigEventContent, error) {
+	var rc RoomConfigEventContent
+	err := s.db.QueryRowContext(s.context(), `SELECT persona, model, mode, prompt, language FROM bot_room_config WHERE room_id = $1`, roomID).Scan(&rc.Persona, &rc.Model, &rc.Mode, &rc.Prompt, &rc.Language)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RoomConfigEventContent{}, nil
+	}
+
+	return rc, err
+}
+
+// SetRoomConfig stores the room config. An empty config removes it.
+func (s *Store) SetRoomConfig(roomID id.RoomID, rc RoomConfigEventContent) error {
+	if rc == (RoomConfigEventContent{}) {
+		_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_room_config WHERE room_id = $1`, roomID)
+		return err
+	}
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_room_config (room_id, persona, model, mode, prompt, language) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (room_id) DO UPDATE SET persona = excluded.persona, model = excluded.model, mode = excluded.mode, prompt = excluded.prompt, language = excluded.language`,
+		roomID, rc.Persona, rc.Model, rc.Mode, rc.Prompt, rc.Language)
+
+	return err
+}
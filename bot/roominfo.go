@@ -0,0 +1,233 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// refreshRoom reads the name, topic, encryption and members of a room from
+// its state and updates the room cache.
+func (m *Bot) refreshRoom(roomID id.RoomID) (Room, error) {
+	room := Room{
+		ID:   roomID,
+		Name: m.stateRoomName(roomID),
+	}
+	var topic event.TopicEventContent
+	if err := m.matrix.StateEvent(roomID, event.StateTopic, "", &topic); err == nil {
+		room.Topic = topic.Topic
+	}
+	var encryption event.EncryptionEventContent
+	if err := m.matrix.StateEvent(roomID, event.StateEncryption, "", &encryption); err == nil {
+		room.Encrypted = encryption.Algorithm != ""
+	}
+	members, err := m.matrix.JoinedMembers(roomID)
+	if err != nil {
+		return Room{}, err
+	}
+	room.Members = len(members)
+
+	return room, m.store.SaveRoom(room, time.Now())
+}
+
+// refreshRooms rebuilds the room cache from the rooms the bot is in, and
+// removes the rooms it left while it was not running. The rooms are listed
+// first, so they show up right away, the details follow one by one.
+func (m *Bot) refreshRooms() {
+	resp, err := m.client.JoinedRooms()
+	if err != nil {
+		m.logger.Error("failed to get joined rooms", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		return
+	}
+	cached, err := m.store.Rooms()
+	if err != nil {
+		m.logger.Error("failed to get cached rooms", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+		return
+	}
+	known := make(map[id.RoomID]bool)
+	for _, room := range cached {
+		known[room.ID] = true
+		if containsRoom(resp.JoinedRooms, room.ID) {
+			continue
+		}
+		if err := m.store.DeleteRoomInfo(room.ID); err != nil {
+			m.logger.Error("failed to remove cached room", slog.String("err", err.Error()), slog.String("room_id", room.ID.String()), slog.String("bot", m.config.UserDisplayName))
+		}
+	}
+	for _, roomID := range resp.JoinedRooms {
+		if known[roomID] {
+			continue
+		}
+		if err := m.store.SaveRoom(Room{ID: roomID}, time.Now()); err != nil {
+			m.logger.Error("failed to cache room", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+		}
+	}
+	for _, roomID := range resp.JoinedRooms {
+		if m.stopping() {
+			return
+		}
+		if _, err := m.refreshRoom(roomID); err != nil {
+			m.logger.Error("failed to refresh room", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+		}
+	}
+}
+
+// RoomInfoHandler keeps the room cache up to date when the name, alias,
+// topic, encryption or members of a room change. The change is read from the
+// event, so the homeserver is not asked for the whole state every time.
+func (m *Bot) RoomInfoHandler() mautrix.EventHandler {
+	return func(source mautrix.EventSource, evt *event.Event) {
+		if source&mautrix.EventSourceJoin == 0 {
+			return
+		}
+		if evt.Type == event.StateMember && evt.GetStateKey() == m.config.UserID && evt.Content.AsMember().Membership != event.MembershipJoin {
+			return
+		}
+		room, _, err := m.store.Room(evt.RoomID)
+		if err != nil {
+			m.logger.Error("failed to get cached room", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+			return
+		}
+		room.ID = evt.RoomID
+		m.applyRoomState(&room, evt)
+		if err := m.store.SaveRoom(room, time.Now()); err != nil {
+			m.logger.Error("failed to cache room", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+		}
+	}
+}
+
+// applyRoomState changes the cached room as the state event says.
+func (m *Bot) applyRoomState(room *Room, evt *event.Event) {
+	switch evt.Type {
+	case event.StateRoomName:
+		room.Name = evt.Content.AsRoomName().Name
+		if room.Name == "" {
+			// the name is removed, fall back to the alias
+			room.Name = m.stateRoomName(evt.RoomID)
+		}
+	case event.StateCanonicalAlias:
+		// the alias is only the name of rooms without one
+		if room.Name == "" || strings.HasPrefix(room.Name, "#") {
+			room.Name = evt.Content.AsCanonicalAlias().Alias.String()
+		}
+	case event.StateTopic:
+		room.Topic = evt.Content.AsTopic().Topic
+	case event.StateEncryption:
+		room.Encrypted = evt.Content.AsEncryption().Algorithm != ""
+	case event.StateMember:
+		was := prevMembership(evt) == event.MembershipJoin
+		is := evt.Content.AsMember().Membership == event.MembershipJoin
+		switch {
+		case is && !was:
+			room.Members++
+		case was && !is && room.Members > 0:
+			room.Members--
+		}
+	}
+}
+
+// prevMembership is the membership of the user before the member event.
+func prevMembership(evt *event.Event) event.Membership {
+	prev := evt.Unsigned.PrevContent
+	if prev == nil {
+		return ""
+	}
+	if prev.Parsed == nil {
+		_ = prev.ParseRaw(event.StateMember)
+	}
+	if member, ok := prev.Parsed.(*event.MemberEventContent); ok {
+		return member.Membership
+	}
+
+	return ""
+}
+
+func (m *Bot) roomCommand() Command {
+	return Command{
+		Name: "room",
+		Help: "show what the bot knows about this room",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			room, ok, err := m.store.Room(evt.RoomID)
+			if err != nil {
+				return "", err
+			}
+			if !ok {
+				if room, err = m.refreshRoom(evt.RoomID); err != nil {
+					return "", err
+				}
+			}
+			name := room.Name
+			if name == "" {
+				name = "(unnamed)"
+			}
+			encryption := "not encrypted"
+			if room.Encrypted {
+				encryption = "encrypted"
+			}
+			lines := fmt.Sprintf("- name: %s\n- id: %s\n- members: %d\n- %s", name, room.ID, room.Members, encryption)
+			if room.Topic != "" {
+				lines += fmt.Sprintf("\n- topic: %s", room.Topic)
+			}
+
+			return lines, nil
+		},
+	}
+}
+
+// SaveRoom adds the room to the room cache, or updates it.
+func (s *Store) SaveRoom(room Room, now time.Time) error {
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_room (room_id, name, topic, encrypted, members, updated_at) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (room_id) DO UPDATE SET name = excluded.name, topic = excluded.topic, encrypted = excluded.encrypted,
+		members = excluded.members, updated_at = excluded.updated_at`,
+		room.ID, room.Name, room.Topic, room.Encrypted, room.Members, now.Unix())
+
+	return err
+}
+
+// Room returns the cached room, if there is one.
+func (s *Store) Room(roomID id.RoomID) (Room, bool, error) {
+	room := Room{ID: roomID}
+	err := s.db.QueryRowContext(s.context(), `SELECT name, topic, encrypted, members FROM bot_room WHERE room_id = $1`, roomID).
+		Scan(&room.Name, &room.Topic, &room.Encrypted, &room.Members)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return Room{}, false, nil
+	case err != nil:
+		return Room{}, false, err
+	}
+
+	return room, true, nil
+}
+
+// Rooms returns all cached rooms, ordered by ID.
+func (s *Store) Rooms() ([]Room, error) {
+	rows, err := s.db.QueryContext(s.context(), `SELECT room_id, name, topic, encrypted, members FROM bot_room ORDER BY room_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []Room
+	for rows.Next() {
+		var room Room
+		if err := rows.Scan(&room.ID, &room.Name, &room.Topic, &room.Encrypted, &room.Members); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	return rooms, rows.Err()
+}
+
+func (s *Store) DeleteRoomInfo(roomID id.RoomID) error {
+	_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_room WHERE room_id = $1`, roomID)
+
+	return err
+}
@@ -0,0 +1,56 @@
+package bot_test
+
+import (
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestRoomInfoHandler(t *testing.T) {
+	t.Parallel()
+
+	b := newTestBot(t, bot.ConfigBot{
+		UserID: "@bot:ewintr.nl",
+	})
+	h := b.RoomInfoHandler()
+	state := func(evtType event.Type, stateKey string, content any, prev any) {
+		evt := &event.Event{
+			RoomID:   "!room:ewintr.nl",
+			Type:     evtType,
+			StateKey: &stateKey,
+			Content:  event.Content{Parsed: content},
+		}
+		if prev != nil {
+			evt.Unsigned.PrevContent = &event.Content{Parsed: prev}
+		}
+		h(mautrix.EventSourceJoin|mautrix.EventSourceState, evt)
+	}
+
+	state(event.StateCanonicalAlias, "", &event.CanonicalAliasEventContent{Alias: "#team:ewintr.nl"}, nil)
+	state(event.StateTopic, "", &event.TopicEventContent{Topic: "All things team"}, nil)
+	state(event.StateEncryption, "", &event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}, nil)
+	for _, user := range []string{"@bot:ewintr.nl", "@alice:ewintr.nl", "@bob:ewintr.nl"} {
+		state(event.StateMember, user, &event.MemberEventContent{Membership: event.MembershipJoin}, nil)
+	}
+	state(event.StateMember, "@bob:ewintr.nl", &event.MemberEventContent{Membership: event.MembershipLeave}, &event.MemberEventContent{Membership: event.MembershipJoin})
+	// a profile change is no new member
+	state(event.StateMember, "@alice:ewintr.nl", &event.MemberEventContent{Membership: event.MembershipJoin, Displayname: "Alice"}, &event.MemberEventContent{Membership: event.MembershipJoin})
+
+	rooms, err := b.JoinedRooms()
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	exp := []bot.Room{{ID: "!room:ewintr.nl", Name: "#team:ewintr.nl", Topic: "All things team", Encrypted: true, Members: 2}}
+	if len(rooms) != 1 || rooms[0] != exp[0] {
+		t.Errorf("exp %v, got %v", exp, rooms)
+	}
+
+	state(event.StateRoomName, "", &event.RoomNameEventContent{Name: "Team"}, nil)
+	state(event.StateCanonicalAlias, "", &event.CanonicalAliasEventContent{Alias: "#other:ewintr.nl"}, nil)
+	if rooms, _ := b.JoinedRooms(); len(rooms) != 1 || rooms[0].Name != "Team" {
+		t.Errorf("exp Team, got %v", rooms)
+	}
+}
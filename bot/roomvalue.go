@@ -0,0 +1,74 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// RoomValue returns the value of the key in the room, and whether it was
+// set. Room values are a small key/value store per room, for plugins that
+// need to remember something about a room without a table of their own. The
+// namespace keeps the keys of plugins apart, by convention it is the name of
+// the plugin. The values are removed when the bot leaves the room.
+func (s *Store) RoomValue(roomID id.RoomID, namespace, key string) (string, bool, error) {
+	var value string
+	err := s.db.QueryRowContext(s.context(), `SELECT value FROM bot_room_value WHERE room_id = $1 AND namespace = $2 AND key = $3`, roomID, namespace, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return value, true, nil
+}
+
+func (s *Store) SetRoomValue(roomID id.RoomID, namespace, key, value string) error {
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_room_value (room_id, namespace, key, value) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (room_id, namespace, key) DO UPDATE SET value = excluded.value`, roomID, namespace, key, value)
+
+	return err
+}
+
+func (s *Store) DeleteRoomValue(roomID id.RoomID, namespace, key string) error {
+	_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_room_value WHERE room_id = $1 AND namespace = $2 AND key = $3`, roomID, namespace, key)
+	return err
+}
+
+// RoomValues returns all keys of the namespace in the room with their
+// values.
+func (s *Store) RoomValues(roomID id.RoomID, namespace string) (map[string]string, error) {
+	rows, err := s.db.QueryContext(s.context(), `SELECT key, value FROM bot_room_value WHERE room_id = $1 AND namespace = $2`, roomID, namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+
+	return values, rows.Err()
+}
+
+// AddRoomCounter adds delta to the number stored under the key, that starts
+// at zero, and returns the new number. It is done in one statement, so
+// concurrent additions are not lost.
+func (s *Store) AddRoomCounter(roomID id.RoomID, namespace, key string, delta int64) (int64, error) {
+	var value string
+	if err := s.db.QueryRowContext(s.context(), `INSERT INTO bot_room_value (room_id, namespace, key, value) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (room_id, namespace, key) DO UPDATE SET value = CAST(CAST(bot_room_value.value AS BIGINT) + CAST(excluded.value AS BIGINT) AS TEXT)
+		RETURNING value`, roomID, namespace, key, strconv.FormatInt(delta, 10)).Scan(&value); err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/sashabaranov/go-openai"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+)
+
+// ConfigRoute sends questions that match to another model than the default
+// one, usually a cheaper one. A route matches on the room, the length of the
+// question in characters, the number of messages in the conversation so far
+// and a regular expression on the question. Empty fields match everything.
+// The first route that matches wins, so a route for a priority room with the
+// expensive model can go before a route for all rooms with a cheap one.
+type ConfigRoute struct {
+	Room        string
+	MaxLength   int
+	MaxMessages int
+	Body        string
+	Model       string
+}
+
+type route struct {
+	config ConfigRoute
+	body   *regexp.Regexp
+}
+
+func newRoute(cfg ConfigRoute) (*route, error) {
+	if cfg.Model == "" {
+		return nil, fmt.Errorf("route needs a model")
+	}
+	r := &route{config: cfg}
+	if cfg.Body != "" {
+		body, err := regexp.Compile(cfg.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body pattern %q: %w", cfg.Body, err)
+		}
+		r.body = body
+	}
+
+	return r, nil
+}
+
+func (r *route) match(evt *event.Event, question string, messages int) bool {
+	switch {
+	case r.config.Room != "" && r.config.Room != evt.RoomID.String():
+		return false
+	case r.config.MaxLength > 0 && len([]rune(question)) > r.config.MaxLength:
+		return false
+	case r.config.MaxMessages > 0 && messages > r.config.MaxMessages:
+		return false
+	case r.body != nil && !r.body.MatchString(question):
+		return false
+	}
+
+	return true
+}
+
+// routeModel returns the model that answers the conversation. Routes only
+// replace the default model of the bot. A model picked for the room, or by
+// a persona, is kept.
+func (m *Bot) routeModel(evt *event.Event, model string, conv *Conversation) string {
+	if model != m.config.Model || len(m.routes) == 0 {
+		return model
+	}
+	var question string
+	var messages int
+	for _, msg := range conv.History() {
+		// skip the prompt, examples and other messages that were not sent in the room
+		if msg.Role == openai.ChatMessageRoleSystem || msg.EventID == "" {
+			continue
+		}
+		question = msg.Content
+		messages++
+	}
+	for _, r := range m.routes {
+		if !r.match(evt, question, messages) {
+			continue
+		}
+		m.logger.Info("question routed", slog.String("event_id", evt.ID.String()), slog.String("model", r.config.Model), slog.String("bot", m.config.UserDisplayName))
+		return r.config.Model
+	}
+
+	return model
+}
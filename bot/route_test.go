@@ -0,0 +1,105 @@
+package bot_test
+
+import (
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+)
+
+func TestRoutes(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name      string
+		routes    []bot.ConfigRoute
+		question  string
+		expErr    bool
+		expModels []string
+	}{
+		{
+			name:      "no routes",
+			question:  "Hi",
+			expModels: []string{"", ""},
+		},
+		{
+			name:      "short question",
+			routes:    []bot.ConfigRoute{{MaxLength: 10, Model: "gpt-3.5-turbo"}},
+			question:  "Hi",
+			expModels: []string{"gpt-3.5-turbo", ""},
+		},
+		{
+			name:      "long question",
+			routes:    []bot.ConfigRoute{{MaxLength: 10, Model: "gpt-3.5-turbo"}},
+			question:  "Can you explain how the garbage collector works?",
+			expModels: []string{"", ""},
+		},
+		{
+			name:      "long thread",
+			routes:    []bot.ConfigRoute{{MaxMessages: 1, Model: "gpt-3.5-turbo"}},
+			question:  "Hi",
+			expModels: []string{"gpt-3.5-turbo", ""},
+		},
+		{
+			name: "priority room first",
+			routes: []bot.ConfigRoute{
+				{Room: "!room:ewintr.nl", Model: "gpt-4o"},
+				{Model: "gpt-3.5-turbo"},
+			},
+			question:  "Hi",
+			expModels: []string{"gpt-4o", "gpt-4o"},
+		},
+		{
+			name:      "pattern",
+			routes:    []bot.ConfigRoute{{Body: `(?i)^(hi|thanks)`, Model: "gpt-3.5-turbo"}},
+			question:  "Thanks!",
+			expModels: []string{"gpt-3.5-turbo", ""},
+		},
+		{
+			name:     "invalid pattern",
+			routes:   []bot.ConfigRoute{{Body: `(`, Model: "gpt-3.5-turbo"}},
+			question: "Hi",
+			expErr:   true,
+		},
+		{
+			name:     "no model",
+			routes:   []bot.ConfigRoute{{MaxLength: 10}},
+			question: "Hi",
+			expErr:   true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fm := bot.NewFakeMatrix()
+			fp := bot.NewFakeProvider()
+			b, err := initTestBot(t, bot.ConfigBot{
+				UserID:            "@bot:ewintr.nl",
+				AnswerUnaddressed: true,
+				Routes:            tc.routes,
+			}, bot.WithMatrix(fm), bot.WithProvider(fp))
+			if tc.expErr {
+				if err == nil {
+					t.Errorf("exp error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			_, h := b.ResponseHandler()
+
+			// a question, and a reply to the answer of the bot
+			h(mautrix.EventSourceTimeline, testMessage("$q1", tc.question, ""))
+			h(mautrix.EventSourceTimeline, testMessage("$q2", "And why is that the case?", fm.Messages()[0].EventID))
+
+			reqs := fp.Requests()
+			if len(reqs) != len(tc.expModels) {
+				t.Fatalf("exp %v, got %v", len(tc.expModels), len(reqs))
+			}
+			for i, exp := range tc.expModels {
+				if reqs[i].Model != exp {
+					t.Errorf("exp %v, got %v", exp, reqs[i].Model)
+				}
+			}
+		})
+	}
+}
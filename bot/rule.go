@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	RuleActionIgnore = "ignore"
+	RuleActionRoute  = "route"
+	RuleActionReact  = "react"
+)
+
+// ConfigRule matches incoming messages on room, sender, message type and a
+// regular expression on the body. Empty fields match everything.
+type ConfigRule struct {
+	Room     string
+	Sender   string
+	MsgType  string
+	Body     string
+	Action   string
+	Plugin   string
+	Reaction string
+}
+
+type Rule struct {
+	config ConfigRule
+	body   *regexp.Regexp
+}
+
+func NewRule(cfg ConfigRule) (*Rule, error) {
+	r := &Rule{config: cfg}
+	if cfg.Body != "" {
+		body, err := regexp.Compile(cfg.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body pattern %q: %w", cfg.Body, err)
+		}
+		r.body = body
+	}
+	switch cfg.Action {
+	case RuleActionIgnore:
+	case RuleActionRoute:
+		if cfg.Plugin == "" {
+			return nil, fmt.Errorf("rule with action %s needs a plugin", cfg.Action)
+		}
+	case RuleActionReact:
+		if cfg.Reaction == "" {
+			return nil, fmt.Errorf("rule with action %s needs a reaction", cfg.Action)
+		}
+	default:
+		return nil, fmt.Errorf("unknown rule action %q", cfg.Action)
+	}
+
+	return r, nil
+}
+
+func (r *Rule) Match(evt *event.Event) bool {
+	content := evt.Content.AsMessage()
+	switch {
+	case r.config.Room != "" && r.config.Room != evt.RoomID.String():
+		return false
+	case r.config.Sender != "" && r.config.Sender != evt.Sender.String():
+		return false
+	case r.config.MsgType != "" && r.config.MsgType != string(content.MsgType):
+		return false
+	case r.body != nil && !r.body.MatchString(content.Body):
+		return false
+	}
+
+	return true
+}
+
+func (r *Rule) Action() string { return r.config.Action }
+
+// RuleHandler applies the first configured rule that matches the message.
+// Ignored and routed messages are consumed, reactions are added before the
+// message continues to the other handlers.
+func (m *Bot) RuleHandler() MessageHandler {
+	return NewMessageHandler("rule", PriorityRule, func(evt *event.Event) bool {
+		for _, r := range m.rules {
+			if !r.Match(evt) {
+				continue
+			}
+			m.logger.Info("message matched rule", slog.String("event_id", evt.ID.String()), slog.String("action", r.config.Action), slog.String("bot", m.config.UserDisplayName))
+
+			switch r.config.Action {
+			case RuleActionIgnore:
+				return true
+			case RuleActionReact:
+				if _, err := m.matrix.React(evt.RoomID, evt.ID, r.config.Reaction); err != nil {
+					m.logger.Error("failed to send reaction", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+				}
+				return false
+			case RuleActionRoute:
+				h, ok := m.dispatcher.Handler(r.config.Plugin)
+				if !ok {
+					m.logger.Error("rule routes to unknown plugin", slog.String("plugin", r.config.Plugin), slog.String("bot", m.config.UserDisplayName))
+					return true
+				}
+				// a plugin that is switched off in the room does not get the message
+				// through a rule either
+				if !m.pluginEnabled(evt, h.Name()) {
+					m.logger.Info("rule routes to disabled plugin", slog.String("plugin", h.Name()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+					continue
+				}
+				h.HandleMessage(evt)
+				return true
+			}
+		}
+
+		return false
+	})
+}
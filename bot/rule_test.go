@@ -0,0 +1,115 @@
+package bot_test
+
+import (
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestRule_Match(t *testing.T) {
+	t.Parallel()
+
+	evt := &event.Event{
+		RoomID: "!room:server",
+		Sender: "@user:server",
+		Content: event.Content{Parsed: &event.MessageEventContent{
+			MsgType: event.MsgText,
+			Body:    "ping",
+		}},
+	}
+	for _, tc := range []struct {
+		name string
+		cfg  bot.ConfigRule
+		exp  bool
+	}{
+		{
+			name: "empty matches all",
+			cfg:  bot.ConfigRule{Action: bot.RuleActionIgnore},
+			exp:  true,
+		},
+		{
+			name: "all fields",
+			cfg: bot.ConfigRule{
+				Room:    "!room:server",
+				Sender:  "@user:server",
+				MsgType: "m.text",
+				Body:    "^pi",
+				Action:  bot.RuleActionIgnore,
+			},
+			exp: true,
+		},
+		{
+			name: "other room",
+			cfg:  bot.ConfigRule{Room: "!other:server", Action: bot.RuleActionIgnore},
+		},
+		{
+			name: "other msgtype",
+			cfg:  bot.ConfigRule{MsgType: "m.notice", Action: bot.RuleActionIgnore},
+		},
+		{
+			name: "body mismatch",
+			cfg:  bot.ConfigRule{Body: "pong", Action: bot.RuleActionIgnore},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := bot.NewRule(tc.cfg)
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			if act := r.Match(evt); act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestNewRule_Invalid(t *testing.T) {
+	t.Parallel()
+
+	for _, cfg := range []bot.ConfigRule{
+		{Action: "unknown"},
+		{Action: bot.RuleActionRoute},
+		{Action: bot.RuleActionReact},
+		{Body: "(", Action: bot.RuleActionIgnore},
+	} {
+		if _, err := bot.NewRule(cfg); err == nil {
+			t.Errorf("exp error for %+v", cfg)
+		}
+	}
+}
+
+func TestRuleHandler_RouteDisabledPlugin(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	store := newTestStore(t)
+	b := newTestBot(t, bot.ConfigBot{
+		UserID:            "@bot:ewintr.nl",
+		AnswerUnaddressed: true,
+		Rules:             []bot.ConfigRule{{Body: "^ping", Action: bot.RuleActionRoute, Plugin: "chat"}},
+	}, bot.WithStore(store), bot.WithMatrix(fm))
+	_, h := b.ResponseHandler()
+
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+		exp     int
+	}{
+		{name: "enabled", enabled: true, exp: 1},
+		{name: "disabled", enabled: false, exp: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := store.SetPluginEnabled("!room:ewintr.nl", "chat", tc.enabled); err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			before := len(fm.Messages())
+			h(mautrix.EventSourceTimeline, testMessage(id.EventID("$"+tc.name), "ping", ""))
+			if act := len(fm.Messages()) - before; act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	SandboxProcess = "process"
+	SandboxDocker  = "docker"
+
+	sandboxTimeout      = 15 * time.Second
+	sandboxBuildTimeout = time.Minute
+	sandboxCPU          = 10      // seconds
+	sandboxMemory       = 1 << 19 // KiB
+	sandboxGoMemory     = 1 << 21 // KiB, the go runtime reserves more address space than it uses
+	sandboxFileSize     = 10240   // KiB
+	sandboxMaxOutput    = 4000
+	sandboxMaxCode      = 20000
+)
+
+var sandboxImages = map[string]string{
+	"python": "python:3-alpine",
+	"go":     "golang:1-alpine",
+}
+
+// ConfigSandbox enables the run_code tool, which runs Python and Go snippets.
+// With Mode "process" the code runs as a child process of the bot, with
+// limits on CPU time, memory and file size, in an empty directory and
+// without the environment of the bot. With Mode "docker" every run gets a
+// container without network instead, which is the safer choice.
+type ConfigSandbox struct {
+	Mode string
+}
+
+// SandboxTool runs code for the model, so it can compute answers instead of
+// guessing them.
+type SandboxTool struct {
+	mode string
+}
+
+func NewSandboxTool(cfg ConfigSandbox) (*SandboxTool, error) {
+	if cfg.Mode != SandboxProcess && cfg.Mode != SandboxDocker {
+		return nil, fmt.Errorf("unknown sandbox mode %q", cfg.Mode)
+	}
+
+	return &SandboxTool{mode: cfg.Mode}, nil
+}
+
+func (s *SandboxTool) Name() string { return "run_code" }
+
+// Description only promises no network access in a container, a process runs
+// with the network of the bot.
+func (s *SandboxTool) Description() string {
+	limits := "The program is stopped after 10 seconds."
+	if s.mode == SandboxDocker {
+		limits = "There is no network access and the program is stopped after 10 seconds."
+	}
+
+	return "Run a short Python or Go program and get its output. Use it to calculate, convert or check things instead of guessing. " + limits + " Print the results."
+}
+
+func (s *SandboxTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"language":{"type":"string","enum":["python","go"]},"code":{"type":"string","description":"the complete program, for Go including package main"}},"required":["language","code"]}`)
+}
+
+func (s *SandboxTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	var a struct {
+		Language string `json:"language"`
+		Code     string `json:"code"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return "", err
+	}
+
+	return s.Run(ctx, a.Language, a.Code)
+}
+
+// Run runs the code and returns the combined output and the exit status.
+func (s *SandboxTool) Run(ctx context.Context, language, code string) (string, error) {
+	if _, ok := sandboxImages[language]; !ok {
+		return "", fmt.Errorf("unsupported language %q", language)
+	}
+	if len(code) > sandboxMaxCode {
+		return "", fmt.Errorf("program is too long")
+	}
+	dir, err := os.MkdirTemp("", "sandbox-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	file := "main.py"
+	if language == "go" {
+		file = "main.go"
+	}
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(code), 0o644); err != nil {
+		return "", err
+	}
+
+	if s.mode == SandboxProcess && language == "go" {
+		// compiling takes longer than running, it gets its own timeout
+		if out, err := buildGo(ctx, dir, file); err != nil {
+			return formatSandboxOutput(out, err), nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sandboxTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	switch s.mode {
+	case SandboxDocker:
+		cmd = dockerCommand(ctx, dir, language, file)
+	default:
+		cmd = processCommand(ctx, dir, language, file)
+	}
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("stopped after %s", sandboxTimeout)
+	}
+
+	return formatSandboxOutput(out.Bytes(), err), nil
+}
+
+func buildGo(ctx context.Context, dir, file string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, sandboxBuildTimeout)
+	defer cancel()
+	build := exec.CommandContext(ctx, "go", "build", "-o", "main", file)
+	build.Dir = dir
+	build.Env = sandboxEnv(dir, true)
+	build.WaitDelay = time.Second
+	out, err := build.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("build stopped after %s", sandboxBuildTimeout)
+	}
+
+	return out, err
+}
+
+func processCommand(ctx context.Context, dir, language, file string) *exec.Cmd {
+	limits := fmt.Sprintf("ulimit -t %d; ulimit -f %d; ", sandboxCPU, sandboxFileSize)
+	env := sandboxEnv(dir, false)
+	run := fmt.Sprintf("ulimit -v %d; exec python3 -I %s", sandboxMemory, file)
+	if language == "go" {
+		run = fmt.Sprintf("ulimit -v %d; exec ./main", sandboxGoMemory)
+		env = append(env, fmt.Sprintf("GOMEMLIMIT=%dKiB", sandboxMemory))
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", limits+run)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.WaitDelay = time.Second
+
+	return cmd
+}
+
+func dockerCommand(ctx context.Context, dir, language, file string) *exec.Cmd {
+	run := []string{"python3", "-I", file}
+	if language == "go" {
+		run = []string{"go", "run", file}
+	}
+	args := []string{
+		"run", "--rm", "--network", "none", "--memory", "512m", "--cpus", "1", "--pids-limit", "64",
+		"-v", dir + ":/code", "-w", "/code", "-e", "HOME=/code", "-e", "GOCACHE=/code/.cache",
+		sandboxImages[language], "timeout", fmt.Sprint(sandboxCPU),
+	}
+	cmd := exec.CommandContext(ctx, "docker", append(args, run...)...)
+	cmd.WaitDelay = time.Second
+
+	return cmd
+}
+
+// sandboxEnv is the environment for the code: only a PATH to find the
+// interpreter and a home in the temporary directory. Go gets a build cache
+// that is shared between runs.
+func sandboxEnv(dir string, build bool) []string {
+	env := []string{"PATH=" + os.Getenv("PATH"), "HOME=" + dir}
+	if build {
+		env = append(env, "GOCACHE="+filepath.Join(os.TempDir(), "sandbox-gocache"), "GOPATH="+filepath.Join(dir, "gopath"), "GOFLAGS=-mod=mod", "CGO_ENABLED=0")
+	}
+
+	return env
+}
+
+func formatSandboxOutput(out []byte, err error) string {
+	text := strings.TrimSpace(string(out))
+	if len(text) > sandboxMaxOutput {
+		text = text[:sandboxMaxOutput] + "\n[output truncated]"
+	}
+	if err == nil {
+		text += "\n[exit status 0]"
+	} else {
+		text += fmt.Sprintf("\n[%s]", err.Error())
+	}
+
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,67 @@
+package bot_test
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestSandboxToolRun(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("no python3")
+	}
+	sandbox, err := bot.NewSandboxTool(bot.ConfigSandbox{Mode: bot.SandboxProcess})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	for _, tc := range []struct {
+		name     string
+		language string
+		code     string
+		exp      string
+		expErr   bool
+	}{
+		{name: "output", language: "python", code: "print(6 * 7)", exp: "42\n[exit status 0]"},
+		{name: "exit code", language: "python", code: "import sys\nsys.exit(3)", exp: "[exit status 3]"},
+		{name: "own home", language: "python", code: "import os\nprint(os.getcwd() == os.environ['HOME'])", exp: "True"},
+		{name: "go", language: "go", code: "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tb := make([]byte, 100<<20)\n\tfmt.Println(len(b))\n}\n", exp: "104857600\n[exit status 0]"},
+		{name: "unknown language", language: "cobol", code: "DISPLAY 'HI'.", expErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := sandbox.Run(context.Background(), tc.language, tc.code)
+			if (err != nil) != tc.expErr {
+				t.Fatalf("exp error %v, got %v", tc.expErr, err)
+			}
+			if !strings.HasPrefix(act, tc.exp) {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestSandboxToolDescription(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		mode         string
+		expNoNetwork bool
+	}{
+		{mode: bot.SandboxProcess},
+		{mode: bot.SandboxDocker, expNoNetwork: true},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			sandbox, err := bot.NewSandboxTool(bot.ConfigSandbox{Mode: tc.mode})
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			if act := strings.Contains(sandbox.Description(), "no network access"); act != tc.expNoNetwork {
+				t.Errorf("exp %v, got %v", tc.expNoNetwork, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// ConfigSchedule posts Message to Room at the times in Cron, a standard five
+// field cron expression like "0 9 * * 1-5". The message is a Go template that
+// can use .Now and .RoomID, as in "Standup for {{.Now.Format \"Mon 2 Jan\"}}".
+type ConfigSchedule struct {
+	Room    string
+	Cron    string
+	Message string
+}
+
+// Schedule is a schedule added with the !schedule command. Schedules from the
+// config have no ID.
+type Schedule struct {
+	ID      int64
+	RoomID  id.RoomID
+	Cron    string
+	Message string
+}
+
+type ScheduleData struct {
+	Now    time.Time
+	RoomID id.RoomID
+}
+
+// Scheduler runs the schedules of a bot.
+type Scheduler struct {
+	cron    *cron.Cron
+	entries map[int64]cron.EntryID
+	mu      sync.Mutex
+}
+
+// NewScheduler creates a scheduler that reads cron expressions in loc. An
+// expression can use another zone with a CRON_TZ=Europe/Amsterdam prefix.
+func NewScheduler(loc *time.Location) *Scheduler {
+	return &Scheduler{
+		cron:    cron.New(cron.WithLocation(loc)),
+		entries: make(map[int64]cron.EntryID),
+	}
+}
+
+// Add runs fn at the times in spec. A schedule with an ID can be removed
+// later.
+func (s *Scheduler) Add(scheduleID int64, spec string, fn func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entryID, err := s.cron.AddFunc(spec, fn)
+	if err != nil {
+		return fmt.Errorf("invalid cron expression %q: %w", spec, err)
+	}
+	if scheduleID != 0 {
+		s.entries[scheduleID] = entryID
+	}
+
+	return nil
+}
+
+func (s *Scheduler) Remove(scheduleID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entryID, ok := s.entries[scheduleID]; ok {
+		s.cron.Remove(entryID)
+		delete(s.entries, scheduleID)
+	}
+}
+
+func (s *Scheduler) Start() { s.cron.Start() }
+
+// Stop stops the scheduler. The context is done when the running jobs are.
+func (s *Scheduler) Stop() context.Context { return s.cron.Stop() }
+
+// RenderSchedule executes the message template of a schedule.
+func RenderSchedule(message string, data ScheduleData) (string, error) {
+	tmpl, err := template.New("schedule").Parse(message)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// loadSchedules adds the schedules from the config and the store to the
+// scheduler.
+func (m *Bot) loadSchedules() error {
+	for _, sc := range m.config.Schedules {
+		if err := m.addSchedule(Schedule{RoomID: id.RoomID(sc.Room), Cron: sc.Cron, Message: sc.Message}); err != nil {
+			return err
+		}
+	}
+	stored, err := m.store.Schedules("")
+	if err != nil {
+		return err
+	}
+	for _, s := range stored {
+		if err := m.addSchedule(s); err != nil {
+			m.logger.Error("failed to load schedule", slog.String("err", err.Error()), slog.Int64("schedule", s.ID), slog.String("bot", m.config.UserDisplayName))
+		}
+	}
+
+	return nil
+}
+
+func (m *Bot) addSchedule(s Schedule) error {
+	if _, err := template.New("schedule").Parse(s.Message); err != nil {
+		return fmt.Errorf("invalid message template: %w", err)
+	}
+
+	return m.scheduler.Add(s.ID, s.Cron, func() {
+		text, err := RenderSchedule(s.Message, ScheduleData{Now: time.Now().In(m.location()), RoomID: s.RoomID})
+		if err == nil {
+			err = m.SendText(s.RoomID, text)
+		}
+		if err != nil {
+			m.logger.Error("failed to post scheduled message", slog.String("err", err.Error()), slog.String("room_id", s.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+		}
+	})
+}
+
+func (m *Bot) scheduleCommand() Command {
+	return Command{
+		Name:  "schedule",
+		Usage: "list|add <min> <hour> <day> <month> <weekday> <message>|remove <id>",
+		Help:  "post a message in this room at fixed times, like `!schedule add 0 9 * * 1-5 Time for standup!`",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			if len(args) == 0 || args[0] == "list" {
+				return m.listSchedules(evt.RoomID)
+			}
+			if !m.isAdmin(evt.Sender) {
+				return "", fmt.Errorf("only admins can change the schedules of this room")
+			}
+			switch {
+			case args[0] == "add" && len(args) > 6:
+				s := Schedule{
+					RoomID:  evt.RoomID,
+					Cron:    fmt.Sprintf("CRON_TZ=%s %s", m.userLocation(evt.Sender), strings.Join(args[1:6], " ")),
+					Message: strings.Join(args[6:], " "),
+				}
+				if _, err := cron.ParseStandard(s.Cron); err != nil {
+					return "", fmt.Errorf("invalid cron expression %q: %w", s.Cron, err)
+				}
+				scheduleID, err := m.store.AddSchedule(s)
+				if err != nil {
+					return "", err
+				}
+				s.ID = scheduleID
+				if err := m.addSchedule(s); err != nil {
+					_ = m.store.DeleteSchedule(evt.RoomID, scheduleID)
+					return "", err
+				}
+				return fmt.Sprintf("Added schedule %d.", scheduleID), nil
+			case args[0] == "remove" && len(args) == 2:
+				scheduleID, err := strconv.ParseInt(args[1], 10, 64)
+				if err != nil {
+					return "", fmt.Errorf("not a schedule id: %s", args[1])
+				}
+				if err := m.store.DeleteSchedule(evt.RoomID, scheduleID); err != nil {
+					return "", err
+				}
+				m.scheduler.Remove(scheduleID)
+				return fmt.Sprintf("Removed schedule %d.", scheduleID), nil
+			default:
+				return "", fmt.Errorf("usage: !schedule list|add <min> <hour> <day> <month> <weekday> <message>|remove <id>")
+			}
+		},
+	}
+}
+
+func (m *Bot) listSchedules(roomID id.RoomID) (string, error) {
+	var lines []string
+	for _, sc := range m.config.Schedules {
+		if id.RoomID(sc.Room) == roomID {
+			lines = append(lines, fmt.Sprintf("- config: `%s` %s", sc.Cron, sc.Message))
+		}
+	}
+	stored, err := m.store.Schedules(roomID)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range stored {
+		lines = append(lines, fmt.Sprintf("- %d: `%s` %s", s.ID, s.Cron, s.Message))
+	}
+	if len(lines) == 0 {
+		return "No schedules in this room.", nil
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func (s *Store) AddSchedule(sched Schedule) (int64, error) {
+	var scheduleID int64
+	err := s.db.QueryRowContext(s.context(), `INSERT INTO bot_schedule (room_id, cron, message) VALUES ($1, $2, $3) RETURNING id`,
+		sched.RoomID, sched.Cron, sched.Message).Scan(&scheduleID)
+
+	return scheduleID, err
+}
+
+// Schedules returns the stored schedules of a room, or of all rooms if roomID
+// is empty.
+func (s *Store) Schedules(roomID id.RoomID) ([]Schedule, error) {
+	rows, err := s.db.QueryContext(s.context(), `SELECT id, room_id, cron, message FROM bot_schedule WHERE $1 = '' OR room_id = $1 ORDER BY id`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []Schedule
+	for rows.Next() {
+		var sched Schedule
+		if err := rows.Scan(&sched.ID, &sched.RoomID, &sched.Cron, &sched.Message); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, sched)
+	}
+
+	return schedules, rows.Err()
+}
+
+// DeleteSchedule removes a schedule, which must belong to the room.
+func (s *Store) DeleteSchedule(roomID id.RoomID, scheduleID int64) error {
+	res, err := s.db.ExecContext(s.context(), `DELETE FROM bot_schedule WHERE id = $1 AND room_id = $2`, scheduleID, roomID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("no schedule %d in this room", scheduleID)
+	}
+
+	return nil
+}
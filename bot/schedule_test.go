@@ -0,0 +1,38 @@
+package bot_test
+
+import (
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestRenderSchedule(t *testing.T) {
+	t.Parallel()
+
+	data := bot.ScheduleData{
+		Now:    time.Date(2023, 6, 7, 9, 0, 0, 0, time.UTC),
+		RoomID: "!room:server",
+	}
+	for _, tc := range []struct {
+		name    string
+		message string
+		exp     string
+		expErr  bool
+	}{
+		{name: "plain", message: "Time for standup!", exp: "Time for standup!"},
+		{name: "date", message: `Standup for {{.Now.Format "Mon 2 Jan"}}`, exp: "Standup for Wed 7 Jun"},
+		{name: "room", message: "Hello {{.RoomID}}", exp: "Hello !room:server"},
+		{name: "invalid", message: "{{.Missing", expErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := bot.RenderSchedule(tc.message, data)
+			if (err != nil) != tc.expErr {
+				t.Fatalf("exp error %v, got %v", tc.expErr, err)
+			}
+			if act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
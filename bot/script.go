@@ -0,0 +1,179 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	scriptMaxSteps       = 1_000_000
+	scriptWebhookTimeout = 10 * time.Second
+	scriptLocalKey       = "script_context"
+)
+
+// Script is an operator provided Starlark hook that is run on every incoming
+// message. A script must define a function on_message(msg). Its return value
+// decides what happens next:
+//
+//   - None: the message is processed as usual
+//   - False: the message is dropped
+//   - a string: the message is processed as if this was the body
+//
+// Scripts can act on the message with the builtins send(text), reply(text),
+// react(key) and webhook(url, body). Starlark has no access to the file system
+// or network by itself, so these builtins are the only side effects possible.
+type Script struct {
+	path      string
+	onMessage starlark.Callable
+}
+
+type scriptContext struct {
+	bot *Bot
+	evt *event.Event
+}
+
+func LoadScript(path string) (*Script, error) {
+	thread := &starlark.Thread{Name: path}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	globals, err := starlark.ExecFile(thread, path, nil, scriptBuiltins())
+	if err != nil {
+		return nil, err
+	}
+	onMessage, ok := globals["on_message"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("script %s does not define on_message", path)
+	}
+
+	return &Script{
+		path:      path,
+		onMessage: onMessage,
+	}, nil
+}
+
+// OnMessage runs the hook for the event and returns the, possibly transformed,
+// body and whether processing of the message should continue.
+func (s *Script) OnMessage(b *Bot, evt *event.Event, body string) (string, bool, error) {
+	thread := &starlark.Thread{Name: s.path}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	thread.SetLocal(scriptLocalKey, &scriptContext{bot: b, evt: evt})
+
+	msg := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"event_id": starlark.String(evt.ID.String()),
+		"room_id":  starlark.String(evt.RoomID.String()),
+		"sender":   starlark.String(evt.Sender.String()),
+		"body":     starlark.String(body),
+	})
+	res, err := starlark.Call(thread, s.onMessage, starlark.Tuple{msg}, nil)
+	if err != nil {
+		return body, true, err
+	}
+
+	switch v := res.(type) {
+	case starlark.NoneType:
+		return body, true, nil
+	case starlark.Bool:
+		return body, bool(v), nil
+	case starlark.String:
+		return string(v), true, nil
+	default:
+		return body, true, fmt.Errorf("on_message returned unsupported type %s", res.Type())
+	}
+}
+
+func scriptBuiltins() starlark.StringDict {
+	return starlark.StringDict{
+		"json":    json.Module,
+		"send":    starlark.NewBuiltin("send", scriptSend),
+		"reply":   starlark.NewBuiltin("reply", scriptReply),
+		"react":   starlark.NewBuiltin("react", scriptReact),
+		"webhook": starlark.NewBuiltin("webhook", scriptWebhook),
+	}
+}
+
+func scriptContextFrom(thread *starlark.Thread, name string) (*scriptContext, error) {
+	sc, ok := thread.Local(scriptLocalKey).(*scriptContext)
+	if !ok {
+		return nil, fmt.Errorf("%s can only be called from on_message", name)
+	}
+
+	return sc, nil
+}
+
+func scriptSend(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var text string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "text", &text); err != nil {
+		return nil, err
+	}
+	sc, err := scriptContextFrom(thread, fn.Name())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sc.bot.client.SendText(sc.evt.RoomID, text); err != nil {
+		return nil, err
+	}
+
+	return starlark.None, nil
+}
+
+func scriptReply(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var text string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "text", &text); err != nil {
+		return nil, err
+	}
+	sc, err := scriptContextFrom(thread, fn.Name())
+	if err != nil {
+		return nil, err
+	}
+	content := event.MessageEventContent{
+		MsgType: event.MsgText,
+		Body:    text,
+		RelatesTo: &event.RelatesTo{
+			InReplyTo: &event.InReplyTo{
+				EventID: sc.evt.ID,
+			},
+		},
+	}
+	if _, err := sc.bot.sendMessage(sc.evt.RoomID, &content); err != nil {
+		return nil, err
+	}
+
+	return starlark.None, nil
+}
+
+func scriptReact(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "key", &key); err != nil {
+		return nil, err
+	}
+	sc, err := scriptContextFrom(thread, fn.Name())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sc.bot.client.SendReaction(sc.evt.RoomID, sc.evt.ID, key); err != nil {
+		return nil, err
+	}
+
+	return starlark.None, nil
+}
+
+func scriptWebhook(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var url, body string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "url", &url, "body", &body); err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: scriptWebhookTimeout}
+	res, err := client.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	return starlark.MakeInt(res.StatusCode), nil
+}
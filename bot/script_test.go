@@ -0,0 +1,76 @@
+package bot_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestScript_OnMessage(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		source  string
+		body    string
+		expBody string
+		expKeep bool
+	}{
+		{
+			name:    "pass through",
+			source:  "def on_message(msg):\n  return None\n",
+			body:    "hello",
+			expBody: "hello",
+			expKeep: true,
+		},
+		{
+			name:    "drop",
+			source:  "def on_message(msg):\n  if 'spam' in msg.body:\n    return False\n",
+			body:    "buy spam",
+			expBody: "buy spam",
+		},
+		{
+			name:    "transform",
+			source:  "def on_message(msg):\n  return msg.body.upper()\n",
+			body:    "hello",
+			expBody: "HELLO",
+			expKeep: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hook.star")
+			if err := os.WriteFile(path, []byte(tc.source), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			script, err := bot.LoadScript(path)
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			body, keep, err := script.OnMessage(nil, &event.Event{ID: "id", RoomID: "room", Sender: "@user:server"}, tc.body)
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			if body != tc.expBody {
+				t.Errorf("exp %q, got %q", tc.expBody, body)
+			}
+			if keep != tc.expKeep {
+				t.Errorf("exp %v, got %v", tc.expKeep, keep)
+			}
+		})
+	}
+}
+
+func TestLoadScript_MissingHook(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "hook.star")
+	if err := os.WriteFile(path, []byte("x = 1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bot.LoadScript(path); err == nil {
+		t.Error("exp error, got nil")
+	}
+}
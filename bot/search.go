@@ -0,0 +1,208 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	SearchBackendSearxNG = "searxng"
+	SearchBackendBrave   = "brave"
+	SearchBackendBing    = "bing"
+
+	searchMaxResults = 5
+	searchTimeout    = 15 * time.Second
+	searchMaxBody    = 2 << 20
+)
+
+var searchDefaultURLs = map[string]string{
+	SearchBackendBrave: "https://api.search.brave.com/res/v1/web/search",
+	SearchBackendBing:  "https://api.bing.microsoft.com/v7.0/search",
+}
+
+// ConfigSearch sets up the web_search tool. Backend is "searxng", "brave" or
+// "bing". URL is the address of the SearxNG instance, with the JSON format
+// enabled, and can be left empty for the others. Key is the API key of Brave
+// or Bing.
+type ConfigSearch struct {
+	Backend string
+	URL     string
+	Key     string
+}
+
+type SearchResult struct {
+	Title   string
+	URL     string
+	Snippet string
+}
+
+// SearchTool lets the model search the web.
+type SearchTool struct {
+	config ConfigSearch
+	client *http.Client
+}
+
+func NewSearchTool(cfg ConfigSearch) (*SearchTool, error) {
+	switch cfg.Backend {
+	case SearchBackendSearxNG:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("searxng search needs a url")
+		}
+	case SearchBackendBrave, SearchBackendBing:
+		if cfg.Key == "" {
+			return nil, fmt.Errorf("%s search needs a key", cfg.Backend)
+		}
+		if cfg.URL == "" {
+			cfg.URL = searchDefaultURLs[cfg.Backend]
+		}
+	default:
+		return nil, fmt.Errorf("unknown search backend %q", cfg.Backend)
+	}
+
+	return &SearchTool{
+		config: cfg,
+		client: &http.Client{Timeout: searchTimeout},
+	}, nil
+}
+
+func (s *SearchTool) Name() string { return "web_search" }
+
+func (s *SearchTool) Description() string {
+	return "Search the web for recent or specific information. Returns titles, links and snippets. Mention the links of the results you use in the answer."
+}
+
+func (s *SearchTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"query":{"type":"string","description":"the search query"}},"required":["query"]}`)
+}
+
+func (s *SearchTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	var a struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(a.Query) == "" {
+		return "", fmt.Errorf("empty query")
+	}
+	results, err := s.Search(ctx, a.Query)
+	if err != nil {
+		return "", err
+	}
+	if len(results) == 0 {
+		return "No results.", nil
+	}
+	var lines []string
+	for i, r := range results {
+		lines = append(lines, fmt.Sprintf("%d. %s\n%s\n%s", i+1, r.Title, r.URL, r.Snippet))
+	}
+
+	return strings.Join(lines, "\n\n"), nil
+}
+
+// Search returns the first results for the query.
+func (s *SearchTool) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	q := url.Values{"q": {query}}
+	if s.config.Backend == SearchBackendSearxNG {
+		q.Set("format", "json")
+	}
+	u := s.config.URL
+	if s.config.Backend == SearchBackendSearxNG {
+		u = strings.TrimSuffix(u, "/") + "/search"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	switch s.config.Backend {
+	case SearchBackendBrave:
+		req.Header.Set("X-Subscription-Token", s.config.Key)
+	case SearchBackendBing:
+		req.Header.Set("Ocp-Apim-Subscription-Key", s.config.Key)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("search returned status %d", res.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, searchMaxBody))
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := parseSearchResults(s.config.Backend, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) > searchMaxResults {
+		results = results[:searchMaxResults]
+	}
+
+	return results, nil
+}
+
+func parseSearchResults(backend string, body []byte) ([]SearchResult, error) {
+	var results []SearchResult
+	switch backend {
+	case SearchBackendSearxNG:
+		var res struct {
+			Results []struct {
+				Title   string `json:"title"`
+				URL     string `json:"url"`
+				Content string `json:"content"`
+			} `json:"results"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			return nil, err
+		}
+		for _, r := range res.Results {
+			results = append(results, SearchResult{Title: r.Title, URL: r.URL, Snippet: r.Content})
+		}
+	case SearchBackendBrave:
+		var res struct {
+			Web struct {
+				Results []struct {
+					Title       string `json:"title"`
+					URL         string `json:"url"`
+					Description string `json:"description"`
+				} `json:"results"`
+			} `json:"web"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			return nil, err
+		}
+		for _, r := range res.Web.Results {
+			results = append(results, SearchResult{Title: r.Title, URL: r.URL, Snippet: html.UnescapeString(htmlTag.ReplaceAllString(r.Description, ""))})
+		}
+	case SearchBackendBing:
+		var res struct {
+			WebPages struct {
+				Value []struct {
+					Name    string `json:"name"`
+					URL     string `json:"url"`
+					Snippet string `json:"snippet"`
+				} `json:"value"`
+			} `json:"webPages"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			return nil, err
+		}
+		for _, r := range res.WebPages.Value {
+			results = append(results, SearchResult{Title: r.Name, URL: r.URL, Snippet: r.Snippet})
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,76 @@
+package bot_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestSearchTool(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name      string
+		backend   string
+		body      string
+		expPath   string
+		expHeader string
+		exp       []bot.SearchResult
+	}{
+		{
+			name:    "searxng",
+			backend: bot.SearchBackendSearxNG,
+			body:    `{"results":[{"title":"Go","url":"https://go.dev","content":"The Go language"}]}`,
+			expPath: "/search",
+			exp:     []bot.SearchResult{{Title: "Go", URL: "https://go.dev", Snippet: "The Go language"}},
+		},
+		{
+			name:      "brave",
+			backend:   bot.SearchBackendBrave,
+			body:      `{"web":{"results":[{"title":"Go","url":"https://go.dev","description":"The <strong>Go</strong> language &amp; tools"}]}}`,
+			expPath:   "/",
+			expHeader: "X-Subscription-Token",
+			exp:       []bot.SearchResult{{Title: "Go", URL: "https://go.dev", Snippet: "The Go language & tools"}},
+		},
+		{
+			name:      "bing",
+			backend:   bot.SearchBackendBing,
+			body:      `{"webPages":{"value":[{"name":"Go","url":"https://go.dev","snippet":"The Go language"}]}}`,
+			expPath:   "/",
+			expHeader: "Ocp-Apim-Subscription-Key",
+			exp:       []bot.SearchResult{{Title: "Go", URL: "https://go.dev", Snippet: "The Go language"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tc.expPath {
+					t.Errorf("exp %v, got %v", tc.expPath, r.URL.Path)
+				}
+				if r.URL.Query().Get("q") != "golang" {
+					t.Errorf("exp golang, got %v", r.URL.Query().Get("q"))
+				}
+				if tc.expHeader != "" && r.Header.Get(tc.expHeader) != "key" {
+					t.Errorf("exp key, got %v", r.Header.Get(tc.expHeader))
+				}
+				_, _ = w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+
+			search, err := bot.NewSearchTool(bot.ConfigSearch{Backend: tc.backend, URL: srv.URL + "/", Key: "key"})
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			act, err := search.Search(context.Background(), "golang")
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+// sendBackoff is used for messages the homeserver did not accept. After the
+// last attempt the message goes to the queue.
+var sendBackoff = Backoff{Attempts: 3, Base: 2 * time.Second, Max: 10 * time.Second}
+
+// sendMessage sends a message event to the room. The message is stored in
+// the queue first, so it is not lost when the bot stops before it is sent.
+// When the homeserver rate limits the bot or is temporarily unavailable, the
+// message is sent again after a while, with the same transaction ID so it is
+// not posted twice. If it still fails, or the homeserver asks for a longer
+// wait than sendBackoff allows, the queue takes over and ErrQueued is
+// returned.
+func (m *Bot) sendMessage(roomID id.RoomID, content any) (id.EventID, error) {
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	o := Outgoing{RoomID: roomID, TxnID: newTxnID(), Content: raw, CreatedAt: now}
+	o.ID, err = m.store.AddOutgoing(o, now.Add(outboxClaim))
+	if err != nil {
+		m.logger.Error("failed to queue message", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+		o.ID = 0
+	}
+
+	for attempt := 1; ; attempt++ {
+		eventID, err := m.matrix.SendMessage(roomID, content, o.TxnID)
+		if err == nil {
+			if o.ID != 0 {
+				if err := m.store.DeleteOutgoing(o.ID); err != nil {
+					m.logger.Error("failed to remove sent message from queue", slog.String("err", err.Error()), slog.Int64("outgoing", o.ID), slog.String("bot", m.config.UserDisplayName))
+				}
+			}
+			return eventID, nil
+		}
+		wait, ok := SendRetryDelay(err, sendBackoff, attempt)
+		// a long retry_after or Retry-After is waited out by the queue, not here
+		queue := attempt >= sendBackoff.Attempts || wait > sendBackoff.Max
+		switch {
+		case !ok:
+			if o.ID != 0 {
+				if err := m.store.DeleteOutgoing(o.ID); err != nil {
+					m.logger.Error("failed to remove message from queue", slog.String("err", err.Error()), slog.Int64("outgoing", o.ID), slog.String("bot", m.config.UserDisplayName))
+				}
+			}
+			return "", err
+		case queue && o.ID != 0:
+			if err := m.store.RescheduleOutgoing(o.ID, attempt, time.Now().Add(wait)); err != nil {
+				return "", err
+			}
+			return "", fmt.Errorf("%w: %s", ErrQueued, err.Error())
+		case queue:
+			return "", err
+		}
+		m.logger.Warn("failed to send message, retrying", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.Duration("wait", wait), slog.String("bot", m.config.UserDisplayName))
+		time.Sleep(wait)
+	}
+}
+
+// SendRetryDelay reports whether sending can be tried again after the error,
+// and how long to wait before that. Rate limits, gateway errors and failed
+// connections are temporary, everything else is not.
+func SendRetryDelay(err error, b Backoff, attempt int) (time.Duration, bool) {
+	var httpErr mautrix.HTTPError
+	if !errors.As(err, &httpErr) {
+		return 0, false
+	}
+	if httpErr.RespError != nil && httpErr.RespError.ErrCode == mautrix.MLimitExceeded.ErrCode {
+		if ms, ok := httpErr.RespError.ExtraData["retry_after_ms"].(float64); ok && ms > 0 {
+			return time.Duration(ms) * time.Millisecond, true
+		}
+		return b.Delay(attempt), true
+	}
+	if httpErr.Response == nil {
+		return b.Delay(attempt), httpErr.WrappedError != nil
+	}
+	switch httpErr.Response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if d, ok := RetryAfter(httpErr.Response.Header, time.Now()); ok {
+			return d, true
+		}
+		return b.Delay(attempt), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,127 @@
+package bot_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+)
+
+func TestSendRetryDelay(t *testing.T) {
+	t.Parallel()
+
+	b := bot.Backoff{Attempts: 3, Base: 0, Max: 0}
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expWait  time.Duration
+		expRetry bool
+	}{
+		{
+			name: "rate limited",
+			err: fmt.Errorf("failed: %w", mautrix.HTTPError{
+				Response:  &http.Response{StatusCode: http.StatusTooManyRequests},
+				RespError: &mautrix.RespError{ErrCode: "M_LIMIT_EXCEEDED", ExtraData: map[string]interface{}{"retry_after_ms": float64(1500)}},
+			}),
+			expWait:  1500 * time.Millisecond,
+			expRetry: true,
+		},
+		{
+			name:     "gateway",
+			err:      mautrix.HTTPError{Response: &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{"Retry-After": {"2"}}}},
+			expWait:  2 * time.Second,
+			expRetry: true,
+		},
+		{
+			name:     "connection",
+			err:      mautrix.HTTPError{WrappedError: errors.New("connection refused")},
+			expRetry: true,
+		},
+		{
+			name: "forbidden",
+			err: mautrix.HTTPError{
+				Response:  &http.Response{StatusCode: http.StatusForbidden},
+				RespError: &mautrix.RespError{ErrCode: "M_FORBIDDEN"},
+			},
+		},
+		{name: "encryption", err: errors.New("failed to encrypt event")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			wait, retry := bot.SendRetryDelay(tc.err, b, 1)
+			if retry != tc.expRetry {
+				t.Errorf("exp %v, got %v", tc.expRetry, retry)
+			}
+			if wait != tc.expWait {
+				t.Errorf("exp %v, got %v", tc.expWait, wait)
+			}
+		})
+	}
+}
+
+func TestSendMessageLongRateLimit(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	store := newTestStore(t)
+	b := newTestBot(t, bot.ConfigBot{
+		UserID: "@bot:ewintr.nl",
+	}, bot.WithStore(store), bot.WithMatrix(fm))
+	fm.FailWith(mautrix.HTTPError{
+		Request:   httptest.NewRequest(http.MethodPut, "/_matrix/client/v3/rooms/!room:ewintr.nl/send", nil),
+		Response:  &http.Response{StatusCode: http.StatusTooManyRequests},
+		RespError: &mautrix.RespError{ErrCode: "M_LIMIT_EXCEEDED", ExtraData: map[string]interface{}{"retry_after_ms": float64(60000)}},
+	})
+	_, h := b.ResponseHandler()
+	start := time.Now()
+	h(mautrix.EventSourceTimeline, testMessage("$help", "!help", ""))
+	if act := time.Since(start); act > 5*time.Second {
+		t.Errorf("exp the wait to be left to the queue, got %v", act)
+	}
+	queued, err := store.DueOutgoing(time.Now().Add(2 * time.Minute))
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if len(queued) != 1 {
+		t.Errorf("exp 1, got %v", len(queued))
+	}
+}
+
+func TestQueuedReplyInConversation(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	fp := bot.NewFakeProvider()
+	fp.Script("Queued answer.", "Second answer.")
+	b := newTestBot(t, bot.ConfigBot{
+		UserID:            "@bot:ewintr.nl",
+		AnswerUnaddressed: true,
+	}, bot.WithMatrix(fm), bot.WithProvider(fp))
+	_, h := b.ResponseHandler()
+	fm.FailWith(mautrix.HTTPError{
+		Request:   httptest.NewRequest(http.MethodPut, "/_matrix/client/v3/rooms/!room:ewintr.nl/send", nil),
+		Response:  &http.Response{StatusCode: http.StatusTooManyRequests},
+		RespError: &mautrix.RespError{ErrCode: "M_LIMIT_EXCEEDED", ExtraData: map[string]interface{}{"retry_after_ms": float64(60000)}},
+	})
+	h(mautrix.EventSourceTimeline, testMessage("$first", "What is Go?", ""))
+	fm.FailWith(nil)
+	h(mautrix.EventSourceTimeline, testMessage("$second", "And Rust?", "$first"))
+
+	reqs := fp.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("exp 2, got %v", len(reqs))
+	}
+	var found bool
+	for _, msg := range reqs[1].Messages {
+		if msg.Role == "assistant" && msg.Content == "Queued answer." {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("exp queued answer in conversation, got %v", reqs[1].Messages)
+	}
+}
@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// shutdownTimeout is how long Close waits for work that is still running.
+	shutdownTimeout = 30 * time.Second
+	// shutdownCancelWait is how long cancelled work gets to stop before the
+	// crypto store is closed
+	shutdownCancelWait = 5 * time.Second
+)
+
+// goLoop runs fn in the background. Shutdown waits for it to return.
+func (m *Bot) goLoop(fn func()) {
+	m.running.Add(1)
+	go func() {
+		defer m.running.Done()
+		fn()
+	}()
+}
+
+// wait sleeps for d and reports whether the bot is still running after it.
+// It returns early when the bot stops.
+func (m *Bot) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-m.stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// stopping reports whether the bot is shutting down.
+func (m *Bot) stopping() bool {
+	select {
+	case <-m.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown stops the bot. It stops syncing and the background loops, waits
+// for answers that are being written and sent, and for scheduled jobs, then
+// closes the database. Work that is not done when ctx ends is cancelled:
+// requests to the provider, tools, webhooks and the store all stop. The
+// crypto store is only closed once that work has stopped.
+func (m *Bot) Shutdown(ctx context.Context) error {
+	m.stopOnce.Do(func() { close(m.stop) })
+	if m.client != nil {
+		m.client.StopSync()
+	}
+	cronDone := m.scheduler.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		<-cronDone.Done()
+		close(done)
+	}()
+	var err error
+	finished := true
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("stopped before running work was done: %w", ctx.Err())
+		m.logger.Warn("shutdown deadline passed", slog.String("bot", m.config.UserDisplayName))
+		finished = false
+	}
+	m.cancel()
+	if !finished {
+		// the cancelled work may still use the crypto store
+		select {
+		case <-done:
+			finished = true
+		case <-time.After(shutdownCancelWait):
+		}
+	}
+	if m.cryptoHelper == nil {
+		return err
+	}
+	if !finished {
+		m.logger.Warn("work did not stop after cancel, leaving the crypto store open", slog.String("bot", m.config.UserDisplayName))
+		return err
+	}
+	if cerr := m.cryptoHelper.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,75 @@
+package bot_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+)
+
+// blockingProvider waits for the context of the request to end.
+type blockingProvider struct {
+	started chan struct{}
+	err     chan error
+}
+
+func (bp *blockingProvider) CompleteContext(ctx context.Context, _ string, _ *bot.Conversation, _ ...bot.Tool) (string, error) {
+	close(bp.started)
+	<-ctx.Done()
+	bp.err <- ctx.Err()
+	return "", ctx.Err()
+}
+
+func (bp *blockingProvider) Usage() openai.Usage { return openai.Usage{} }
+func (bp *blockingProvider) Available() bool     { return true }
+
+func TestContextPropagation(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name       string
+		completion time.Duration
+		shutdown   bool
+		exp        error
+	}{
+		{name: "completion deadline", completion: 10 * time.Millisecond, exp: context.DeadlineExceeded},
+		{name: "shutdown", completion: time.Minute, shutdown: true, exp: context.Canceled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := &blockingProvider{started: make(chan struct{}), err: make(chan error, 1)}
+			fm := bot.NewFakeMatrix()
+			b := newTestBot(t, bot.ConfigBot{
+				UserID:            "@bot:ewintr.nl",
+				AnswerUnaddressed: true,
+				Timeouts:          bot.ConfigTimeouts{Completion: tc.completion},
+			}, bot.WithMatrix(fm), bot.WithProvider(bp))
+			_, h := b.ResponseHandler()
+			go h(mautrix.EventSourceTimeline, testMessage("$q1", "Hello?", ""))
+			<-bp.started
+
+			if tc.shutdown {
+				// a deadline that already passed abandons the running answer
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				if err := b.Shutdown(ctx); err == nil {
+					t.Errorf("exp error, got nil")
+				}
+			}
+			select {
+			case act := <-bp.err:
+				if act != tc.exp {
+					t.Errorf("exp %v, got %v", tc.exp, act)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("exp %v, got no cancellation", tc.exp)
+			}
+			// the store stops with the bot
+			if _, err := b.JoinedRooms(); tc.shutdown && err == nil {
+				t.Errorf("exp error after shutdown, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/util/dbutil"
+)
+
+const (
+	standupInterval = time.Minute
+	// standupAskWindow is how late the questions are still sent, for when
+	// the bot was not running at the time.
+	standupAskWindow = time.Hour
+	standupPrompt    = "You write the summary of a team standup. Below are the answers of the team members. Write a short overview per person, and list what blocks people and where they could help each other at the end. Do not add anything that is not in the answers."
+)
+
+var defaultStandupQuestions = []string{
+	"What did you do since the last standup?",
+	"What will you do today?",
+	"Is anything blocking you?",
+}
+
+// ConfigStandup asks the Members in a direct chat for their standup at Ask,
+// like "09:30", in the time zone each of them set with !tz. At Post, in the
+// time zone of the bot, the answers are posted in Room. Days are the days of
+// the week in cron notation, Monday to Friday when empty. Without
+// Questions, the usual three are asked. With Summarize, the model writes an
+// overview instead of posting the answers as they are.
+type ConfigStandup struct {
+	Room      string
+	Members   []string
+	Ask       string
+	Post      string
+	Days      string
+	Questions []string
+	Summarize bool
+}
+
+func (c ConfigStandup) days() string {
+	if c.Days == "" {
+		return "1-5"
+	}
+
+	return c.Days
+}
+
+func (c ConfigStandup) questions() []string {
+	if len(c.Questions) == 0 {
+		return defaultStandupQuestions
+	}
+
+	return c.Questions
+}
+
+// cronAt is the cron expression for the time of day, like "09:30", on the
+// days of the standup.
+func (c ConfigStandup) cronAt(clock string) (string, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return "", fmt.Errorf("invalid standup time %q, use a time like 09:30", clock)
+	}
+
+	return fmt.Sprintf("%d %d * * %s", t.Minute(), t.Hour(), c.days()), nil
+}
+
+// StandupAnswer is what a member answered for one standup. Day is the date
+// in the time zone of the member.
+type StandupAnswer struct {
+	ID       int64
+	Room     string
+	UserID   id.UserID
+	Day      string
+	DMRoomID id.RoomID
+	Answer   string
+	AskedAt  time.Time
+}
+
+// standup is a configured standup with its parsed schedule.
+type standup struct {
+	config ConfigStandup
+	ask    cron.Schedule
+	post   cron.Schedule
+}
+
+// loadStandups checks the standups and schedules the posting of the
+// answers.
+func (m *Bot) loadStandups() error {
+	for _, sc := range m.config.Standups {
+		if sc.Room == "" || len(sc.Members) == 0 {
+			return fmt.Errorf("standup needs a room and members")
+		}
+		askSpec, err := sc.cronAt(sc.Ask)
+		if err != nil {
+			return err
+		}
+		ask, err := cron.ParseStandard(askSpec)
+		if err != nil {
+			return fmt.Errorf("invalid standup days %q: %w", sc.Days, err)
+		}
+		postSpec, err := sc.cronAt(sc.Post)
+		if err != nil {
+			return err
+		}
+		post, err := cron.ParseStandard(postSpec)
+		if err != nil {
+			return fmt.Errorf("invalid standup days %q: %w", sc.Days, err)
+		}
+		room := sc.Room
+		if err := m.scheduler.Add(0, postSpec, func() {
+			if err := m.PostStandup(room); err != nil {
+				m.logger.Error("failed to post standup", slog.String("err", err.Error()), slog.String("room", room), slog.String("bot", m.config.UserDisplayName))
+			}
+		}); err != nil {
+			return err
+		}
+		m.standups = append(m.standups, standup{config: sc, ask: ask, post: post})
+	}
+
+	return nil
+}
+
+// runStandups asks the members for their standup when it is time for them.
+func (m *Bot) runStandups() {
+	if len(m.standups) == 0 {
+		return
+	}
+	for m.wait(standupInterval) {
+		for _, s := range m.standups {
+			for _, member := range s.config.Members {
+				if err := m.askStandup(s, id.UserID(member), time.Now()); err != nil {
+					m.logger.Error("failed to ask for standup", slog.String("err", err.Error()), slog.String("user_id", member), slog.String("bot", m.config.UserDisplayName))
+				}
+			}
+		}
+	}
+}
+
+// askStandup sends the questions to the member, if it is time for that in
+// the time zone of the member and they were not sent yet today.
+func (m *Bot) askStandup(s standup, userID id.UserID, now time.Time) error {
+	now = now.In(m.userLocation(userID))
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	due := s.ask.Next(startOfDay.Add(-time.Second))
+	if now.Before(due) || now.Sub(due) > standupAskWindow {
+		return nil
+	}
+	day := now.Format(time.DateOnly)
+	asked, err := m.store.StandupAsked(s.config.Room, userID, day)
+	if err != nil || asked {
+		return err
+	}
+
+	lines := []string{fmt.Sprintf("Time for the standup of %s. Please answer here:", s.config.Room), ""}
+	for i, q := range s.config.questions() {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, q))
+	}
+	dmRoomID, err := m.directRoom(userID)
+	if err != nil {
+		return err
+	}
+	if err := m.SendMarkdown(dmRoomID, strings.Join(lines, "\n")); err != nil {
+		return err
+	}
+
+	return m.store.AddStandupAnswer(StandupAnswer{Room: s.config.Room, UserID: userID, Day: day, DMRoomID: dmRoomID, AskedAt: now})
+}
+
+// StandupHandler collects the answers to the standup questions in the
+// direct chats. Each message is added to the answer and acknowledged with a
+// reaction.
+func (m *Bot) StandupHandler() MessageHandler {
+	return NewMessageHandler("standup", PriorityStandup, func(evt *event.Event) bool {
+		if len(m.standups) == 0 {
+			return false
+		}
+		content := evt.Content.AsMessage()
+		body := strings.TrimSpace(event.TrimReplyFallbackText(content.Body))
+		if content.MsgType != event.MsgText || body == "" {
+			return false
+		}
+		a, ok, err := m.store.OpenStandupAnswer(evt.Sender, evt.RoomID)
+		if err != nil {
+			m.logger.Error("failed to get standup", slog.String("err", err.Error()), slog.String("user_id", evt.Sender.String()), slog.String("bot", m.config.UserDisplayName))
+			return false
+		}
+		if !ok || !m.standupOpen(a, evt) {
+			return false
+		}
+		answer := body
+		if a.Answer != "" {
+			answer = a.Answer + "\n" + body
+		}
+		if err := m.store.SetStandupAnswer(a.ID, answer); err != nil {
+			m.logger.Error("failed to store standup answer", slog.String("err", err.Error()), slog.String("user_id", evt.Sender.String()), slog.String("bot", m.config.UserDisplayName))
+			return false
+		}
+		if _, err := m.matrix.React(evt.RoomID, evt.ID, "✅"); err != nil {
+			m.logger.Error("failed to react", slog.String("err", err.Error()), slog.String("event_id", evt.ID.String()), slog.String("bot", m.config.UserDisplayName))
+		}
+
+		return true
+	})
+}
+
+// standupOpen tells whether the message still answers the standup, that is
+// whether it was sent before the answers are posted. Without it a standup
+// that was never posted would swallow every message in the DM.
+func (m *Bot) standupOpen(a StandupAnswer, evt *event.Event) bool {
+	sent := time.Now()
+	if evt.Timestamp != 0 {
+		sent = time.UnixMilli(evt.Timestamp)
+	}
+	for _, s := range m.standups {
+		if s.config.Room != a.Room {
+			continue
+		}
+		deadline := s.post.Next(a.AskedAt.In(m.location()))
+		return sent.Before(deadline)
+	}
+
+	return false
+}
+
+// PostStandup posts the answers that were given since the last time in the
+// room of the standup, and who did not answer.
+func (m *Bot) PostStandup(room string) error {
+	var sc ConfigStandup
+	for _, s := range m.standups {
+		if s.config.Room == room {
+			sc = s.config
+		}
+	}
+	if sc.Room == "" {
+		return fmt.Errorf("unknown standup %q", room)
+	}
+	roomID, err := m.ResolveRoom(room)
+	if err != nil {
+		return err
+	}
+	answers, err := m.store.StandupAnswers(room)
+	if err != nil {
+		return err
+	}
+	if len(answers) == 0 {
+		return nil
+	}
+
+	var parts, missing, transcript []string
+	for _, a := range answers {
+		name := m.displayName(roomID, a.UserID)
+		if a.Answer == "" {
+			missing = append(missing, name)
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("**%s**\n\n> %s", name, strings.ReplaceAll(a.Answer, "\n", "\n> ")))
+		transcript = append(transcript, fmt.Sprintf("%s:\n%s", name, a.Answer))
+	}
+	text := fmt.Sprintf("**Standup of %s**", time.Now().In(m.location()).Format("Monday 2 January"))
+	switch {
+	case len(parts) == 0:
+	case sc.Summarize:
+		summary, err := m.summarize(roomID, standupPrompt, strings.Join(transcript, "\n\n"))
+		if err != nil {
+			return err
+		}
+		text += "\n\n" + summary
+	default:
+		text += "\n\n" + strings.Join(parts, "\n\n")
+	}
+	if len(missing) > 0 {
+		text += fmt.Sprintf("\n\nNo answer from %s.", strings.Join(missing, ", "))
+	}
+	if err := m.SendMarkdown(roomID, text); err != nil {
+		return err
+	}
+
+	return m.store.SetStandupReported(room)
+}
+
+func (s *Store) AddStandupAnswer(a StandupAnswer) error {
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_standup (room, user_id, day, dm_room_id, answer, asked_at) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (room, user_id, day) DO NOTHING`, a.Room, a.UserID, a.Day, a.DMRoomID, a.Answer, a.AskedAt.Unix())
+
+	return err
+}
+
+// StandupAsked tells whether the member was asked for the standup of the
+// day.
+func (s *Store) StandupAsked(room string, userID id.UserID, day string) (bool, error) {
+	var n int
+	if err := s.db.QueryRowContext(s.context(), `SELECT COUNT(*) FROM bot_standup WHERE room = $1 AND user_id = $2 AND day = $3`, room, userID, day).Scan(&n); err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// OpenStandupAnswer returns the standup the member was asked for last in the
+// direct chat, if it was not posted yet.
+func (s *Store) OpenStandupAnswer(userID id.UserID, dmRoomID id.RoomID) (StandupAnswer, bool, error) {
+	rows, err := s.db.QueryContext(s.context(), `SELECT id, room, user_id, day, dm_room_id, answer, asked_at FROM bot_standup
+		WHERE user_id = $1 AND dm_room_id = $2 AND reported = false ORDER BY asked_at DESC, id DESC LIMIT 1`, userID, dmRoomID)
+	if err != nil {
+		return StandupAnswer{}, false, err
+	}
+	answers, err := scanStandupAnswers(rows)
+	if err != nil || len(answers) == 0 {
+		return StandupAnswer{}, false, err
+	}
+
+	return answers[0], true, nil
+}
+
+func (s *Store) SetStandupAnswer(answerID int64, answer string) error {
+	_, err := s.db.ExecContext(s.context(), `UPDATE bot_standup SET answer = $1 WHERE id = $2`, answer, answerID)
+	return err
+}
+
+// StandupAnswers returns the answers of the standup that were not posted
+// yet, in the order the members were asked.
+func (s *Store) StandupAnswers(room string) ([]StandupAnswer, error) {
+	rows, err := s.db.QueryContext(s.context(), `SELECT id, room, user_id, day, dm_room_id, answer, asked_at FROM bot_standup
+		WHERE room = $1 AND reported = false ORDER BY asked_at, id`, room)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanStandupAnswers(rows)
+}
+
+func (s *Store) SetStandupReported(room string) error {
+	_, err := s.db.ExecContext(s.context(), `UPDATE bot_standup SET reported = true WHERE room = $1`, room)
+	return err
+}
+
+func scanStandupAnswers(rows dbutil.Rows) ([]StandupAnswer, error) {
+	defer rows.Close()
+
+	var answers []StandupAnswer
+	for rows.Next() {
+		var a StandupAnswer
+		var askedAt int64
+		if err := rows.Scan(&a.ID, &a.Room, &a.UserID, &a.Day, &a.DMRoomID, &a.Answer, &askedAt); err != nil {
+			return nil, err
+		}
+		a.AskedAt = time.Unix(askedAt, 0)
+		answers = append(answers, a)
+	}
+
+	return answers, rows.Err()
+}
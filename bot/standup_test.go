@@ -0,0 +1,176 @@
+package bot_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestStandup(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	store := newTestStore(t)
+	b := newTestBot(t, bot.ConfigBot{
+		UserID: "@bot:ewintr.nl",
+		Standups: []bot.ConfigStandup{{
+			Room:    "!team:ewintr.nl",
+			Members: []string{"@someone:ewintr.nl", "@other:ewintr.nl"},
+			Ask:     "09:30",
+			Post:    "10:30",
+		}},
+	}, bot.WithStore(store), bot.WithMatrix(fm))
+	_, h := b.ResponseHandler()
+
+	for _, userID := range []id.UserID{"@someone:ewintr.nl", "@other:ewintr.nl"} {
+		if err := store.AddStandupAnswer(bot.StandupAnswer{
+			Room:     "!team:ewintr.nl",
+			UserID:   userID,
+			Day:      "2024-05-13",
+			DMRoomID: "!room:ewintr.nl",
+			AskedAt:  time.Now(),
+		}); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name        string
+		body        string
+		expReaction bool
+	}{
+		{name: "yesterday", body: "Fixed the login bug.", expReaction: true},
+		{name: "today", body: "Start on the export.", expReaction: true},
+		{name: "command", body: "!help"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := len(fm.Reactions())
+			h(mautrix.EventSourceTimeline, testMessage(id.EventID("$"+tc.name), tc.body, ""))
+
+			reactions := fm.Reactions()
+			if act := len(reactions) > before; act != tc.expReaction {
+				t.Fatalf("exp %v, got %v", tc.expReaction, act)
+			}
+			if tc.expReaction && reactions[len(reactions)-1].EventID != id.EventID("$"+tc.name) {
+				t.Errorf("exp %v, got %v", "$"+tc.name, reactions[len(reactions)-1].EventID)
+			}
+		})
+	}
+
+	before := len(fm.Messages())
+	if err := b.PostStandup("!team:ewintr.nl"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	msgs := fm.Messages()
+	if len(msgs) != before+1 {
+		t.Fatalf("exp %v, got %v", before+1, len(msgs))
+	}
+	if msgs[len(msgs)-1].RoomID != "!team:ewintr.nl" {
+		t.Errorf("exp !team:ewintr.nl, got %v", msgs[len(msgs)-1].RoomID)
+	}
+	body := msgs[len(msgs)-1].Content.(*event.MessageEventContent).Body
+	for _, exp := range []string{"> Fixed the login bug.\n> Start on the export.", "No answer from @other:ewintr.nl."} {
+		if !strings.Contains(body, exp) {
+			t.Errorf("exp %q in %q", exp, body)
+		}
+	}
+
+	answers, err := store.StandupAnswers("!team:ewintr.nl")
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if len(answers) != 0 {
+		t.Errorf("exp 0, got %v", len(answers))
+	}
+}
+
+func TestStandupClosed(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	store := newTestStore(t)
+	b := newTestBot(t, bot.ConfigBot{
+		UserID: "@bot:ewintr.nl",
+		Standups: []bot.ConfigStandup{{
+			Room:    "!team:ewintr.nl",
+			Members: []string{"@someone:ewintr.nl"},
+			Ask:     "09:30",
+			Post:    "10:30",
+		}},
+	}, bot.WithStore(store), bot.WithMatrix(fm))
+	_, h := b.ResponseHandler()
+
+	// the standup of last week was never posted
+	if err := store.AddStandupAnswer(bot.StandupAnswer{
+		Room:     "!team:ewintr.nl",
+		UserID:   "@someone:ewintr.nl",
+		Day:      "2024-05-13",
+		DMRoomID: "!room:ewintr.nl",
+		AskedAt:  time.Now().Add(-8 * 24 * time.Hour),
+	}); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	h(mautrix.EventSourceTimeline, testMessage("$late", "What time is it?", ""))
+
+	if reactions := fm.Reactions(); len(reactions) != 0 {
+		t.Errorf("exp 0, got %v", len(reactions))
+	}
+	answers, err := store.StandupAnswers("!team:ewintr.nl")
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if len(answers) != 1 || answers[0].Answer != "" {
+		t.Errorf("exp empty answer, got %v", answers)
+	}
+}
+
+func TestStandupSummaryUsage(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	store := newTestStore(t)
+	b := newTestBot(t, bot.ConfigBot{
+		UserID: "@bot:ewintr.nl",
+		Standups: []bot.ConfigStandup{{
+			Room:      "!team:ewintr.nl",
+			Members:   []string{"@someone:ewintr.nl"},
+			Ask:       "09:30",
+			Post:      "10:30",
+			Summarize: true,
+		}},
+	}, bot.WithStore(store), bot.WithMatrix(fm))
+	if err := store.AddStandupAnswer(bot.StandupAnswer{
+		Room:     "!team:ewintr.nl",
+		UserID:   "@someone:ewintr.nl",
+		Day:      "2024-05-13",
+		DMRoomID: "!room:ewintr.nl",
+		Answer:   "Fixed the login bug.",
+		AskedAt:  time.Now(),
+	}); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if err := b.PostStandup("!team:ewintr.nl"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+
+	// nobody asked for the summary, so it is on the room and the bot
+	users, err := b.UsageByUser(time.Time{})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if len(users) != 1 || users[0].Key != "@bot:ewintr.nl" {
+		t.Fatalf("exp usage of @bot:ewintr.nl, got %v", users)
+	}
+	rooms, err := b.UsageByRoom(time.Time{})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if len(rooms) != 1 || rooms[0].Key != "!team:ewintr.nl" {
+		t.Errorf("exp usage of !team:ewintr.nl, got %v", rooms)
+	}
+}
@@ -0,0 +1,415 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+
+	"maunium.net/go/mautrix/util/dbutil"
+)
+
+// storeUpgrades holds the schema of the tables the bot keeps next to the
+// crypto and state stores. Add new versions at the end, never change
+// existing ones.
+var storeUpgrades dbutil.UpgradeTable
+
+func init() {
+	storeUpgrades.Register(0, 1, "add room plugin table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_room_plugin (
+			room_id TEXT NOT NULL,
+			plugin  TEXT NOT NULL,
+			enabled BOOLEAN NOT NULL,
+			PRIMARY KEY (room_id, plugin)
+		)`)
+		return err
+	})
+	storeUpgrades.Register(1, 2, "add key backup table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_key_backup (
+			session_id TEXT PRIMARY KEY
+		)`)
+		return err
+	})
+	storeUpgrades.Register(2, 3, "add unencrypted notice table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_unencrypted_notice (
+			room_id TEXT PRIMARY KEY
+		)`)
+		return err
+	})
+	storeUpgrades.Register(3, 4, "add reminder table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE bot_reminder (
+			id       %s,
+			room_id  TEXT   NOT NULL,
+			user_id  TEXT   NOT NULL,
+			event_id TEXT   NOT NULL,
+			due_at   BIGINT NOT NULL,
+			message  TEXT   NOT NULL
+		)`, serialPrimaryKey(db)))
+		return err
+	})
+	storeUpgrades.Register(4, 5, "add schedule table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE bot_schedule (
+			id      %s,
+			room_id TEXT NOT NULL,
+			cron    TEXT NOT NULL,
+			message TEXT NOT NULL
+		)`, serialPrimaryKey(db)))
+		return err
+	})
+	storeUpgrades.Register(5, 6, "add feed tables", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		if _, err := tx.Exec(fmt.Sprintf(`CREATE TABLE bot_feed (
+			id        %s,
+			room_id   TEXT    NOT NULL,
+			url       TEXT    NOT NULL,
+			summarize BOOLEAN NOT NULL
+		)`, serialPrimaryKey(db))); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`CREATE TABLE bot_feed_item (
+			feed_id BIGINT NOT NULL,
+			item_id TEXT   NOT NULL,
+			PRIMARY KEY (feed_id, item_id)
+		)`)
+		return err
+	})
+	storeUpgrades.Register(6, 7, "add calendar tables", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		if _, err := tx.Exec(fmt.Sprintf(`CREATE TABLE bot_calendar (
+			id      %s,
+			room_id TEXT NOT NULL,
+			url     TEXT NOT NULL
+		)`, serialPrimaryKey(db))); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`CREATE TABLE bot_calendar_announced (
+			calendar_id BIGINT NOT NULL,
+			event_key   TEXT   NOT NULL,
+			PRIMARY KEY (calendar_id, event_key)
+		)`)
+		return err
+	})
+	storeUpgrades.Register(7, 8, "add reminder notice and sent columns", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		if _, err := tx.Exec(`ALTER TABLE bot_reminder ADD COLUMN notice_event_id TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`ALTER TABLE bot_reminder ADD COLUMN sent BOOLEAN NOT NULL DEFAULT false`)
+		return err
+	})
+	storeUpgrades.Register(8, 9, "add user time zone table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_user_timezone (
+			user_id  TEXT PRIMARY KEY,
+			timezone TEXT NOT NULL
+		)`)
+		return err
+	})
+	storeUpgrades.Register(9, 10, "add user location table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_user_location (
+			user_id  TEXT PRIMARY KEY,
+			location TEXT NOT NULL
+		)`)
+		return err
+	})
+	storeUpgrades.Register(10, 11, "add room tool table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_room_tool (
+			room_id TEXT NOT NULL,
+			tool    TEXT NOT NULL,
+			PRIMARY KEY (room_id, tool)
+		)`)
+		return err
+	})
+	storeUpgrades.Register(11, 12, "add outbox table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE bot_outbox (
+			id         %s,
+			room_id    TEXT    NOT NULL,
+			txn_id     TEXT    NOT NULL,
+			content    TEXT    NOT NULL,
+			created_at BIGINT  NOT NULL,
+			attempts   INTEGER NOT NULL,
+			next_at    BIGINT  NOT NULL
+		)`, serialPrimaryKey(db)))
+		return err
+	})
+	storeUpgrades.Register(12, 13, "add processed event table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_processed_event (
+			event_id     TEXT PRIMARY KEY,
+			processed_at BIGINT NOT NULL
+		)`)
+		return err
+	})
+	storeUpgrades.Register(13, 14, "add conversation tables", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		if _, err := tx.Exec(`CREATE TABLE bot_conversation (
+			root_id    TEXT PRIMARY KEY,
+			room_id    TEXT   NOT NULL,
+			persona    TEXT   NOT NULL,
+			messages   TEXT   NOT NULL,
+			updated_at BIGINT NOT NULL
+		)`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`CREATE TABLE bot_conversation_event (
+			event_id TEXT PRIMARY KEY,
+			root_id  TEXT NOT NULL
+		)`)
+		return err
+	})
+	storeUpgrades.Register(14, 15, "add avatar table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_avatar (
+			hash        TEXT PRIMARY KEY,
+			content_uri TEXT NOT NULL
+		)`)
+		return err
+	})
+	storeUpgrades.Register(15, 16, "add room config table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_room_config (
+			room_id TEXT PRIMARY KEY,
+			persona TEXT NOT NULL,
+			model   TEXT NOT NULL,
+			mode    TEXT NOT NULL
+		)`)
+		return err
+	})
+	storeUpgrades.Register(16, 17, "add knock table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_knock (
+			room_id    TEXT PRIMARY KEY,
+			knocked_at BIGINT NOT NULL
+		)`)
+		return err
+	})
+	storeUpgrades.Register(17, 18, "add room cache table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_room (
+			room_id    TEXT PRIMARY KEY,
+			name       TEXT    NOT NULL,
+			topic      TEXT    NOT NULL,
+			encrypted  BOOLEAN NOT NULL,
+			members    INTEGER NOT NULL,
+			updated_at BIGINT  NOT NULL
+		)`)
+		return err
+	})
+	storeUpgrades.Register(18, 19, "add invite table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_invite (
+			room_id    TEXT PRIMARY KEY,
+			inviter    TEXT   NOT NULL,
+			invited_at BIGINT NOT NULL
+		)`)
+		return err
+	})
+	storeUpgrades.Register(19, 20, "add usage table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE bot_usage (
+			id                %s,
+			event_id          TEXT    NOT NULL,
+			conversation      TEXT    NOT NULL,
+			room_id           TEXT    NOT NULL,
+			user_id           TEXT    NOT NULL,
+			model             TEXT    NOT NULL,
+			prompt_tokens     INTEGER NOT NULL,
+			completion_tokens INTEGER NOT NULL,
+			created_at        BIGINT  NOT NULL
+		)`, serialPrimaryKey(db)))
+		return err
+	})
+	storeUpgrades.Register(20, 21, "add budget notice table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_budget_notice (
+			month TEXT PRIMARY KEY
+		)`)
+		return err
+	})
+	storeUpgrades.Register(21, 22, "add failed column to usage table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`ALTER TABLE bot_usage ADD COLUMN failed BOOLEAN NOT NULL DEFAULT false`)
+		return err
+	})
+	storeUpgrades.Register(22, 23, "add answer cache table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_answer_cache (
+			key        TEXT PRIMARY KEY,
+			answer     TEXT   NOT NULL,
+			created_at BIGINT NOT NULL
+		)`)
+		return err
+	})
+	storeUpgrades.Register(23, 24, "add day, cost and indices to usage table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		day := `strftime('%Y-%m-%d', created_at, 'unixepoch')`
+		if db.Dialect == dbutil.Postgres {
+			day = `to_char(to_timestamp(created_at) AT TIME ZONE 'UTC', 'YYYY-MM-DD')`
+		}
+		for _, q := range []string{
+			`ALTER TABLE bot_usage ADD COLUMN day TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE bot_usage ADD COLUMN cost DOUBLE PRECISION NOT NULL DEFAULT 0`,
+			`UPDATE bot_usage SET day = ` + day,
+			`CREATE INDEX bot_usage_user_idx ON bot_usage (user_id, created_at)`,
+			`CREATE INDEX bot_usage_room_idx ON bot_usage (room_id, created_at)`,
+			`CREATE INDEX bot_usage_model_idx ON bot_usage (model, created_at)`,
+			`CREATE INDEX bot_usage_day_idx ON bot_usage (day)`,
+			`CREATE INDEX bot_usage_conversation_idx ON bot_usage (conversation)`,
+		} {
+			if _, err := tx.Exec(q); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	storeUpgrades.Register(24, 25, "replace budget notice table with budget alert table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		for _, q := range []string{
+			`CREATE TABLE bot_budget_alert (
+				month     TEXT    NOT NULL,
+				threshold INTEGER NOT NULL,
+				PRIMARY KEY (month, threshold)
+			)`,
+			`INSERT INTO bot_budget_alert (month, threshold) SELECT month, 100 FROM bot_budget_notice`,
+			`DROP TABLE bot_budget_notice`,
+		} {
+			if _, err := tx.Exec(q); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	storeUpgrades.Register(25, 26, "add prompt to room config table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`ALTER TABLE bot_room_config ADD COLUMN prompt TEXT NOT NULL DEFAULT ''`)
+		return err
+	})
+	storeUpgrades.Register(26, 27, "add languages of rooms and users", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		for _, q := range []string{
+			`ALTER TABLE bot_room_config ADD COLUMN language TEXT NOT NULL DEFAULT ''`,
+			`CREATE TABLE bot_user_language (
+				user_id  TEXT PRIMARY KEY,
+				language TEXT NOT NULL
+			)`,
+		} {
+			if _, err := tx.Exec(q); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	storeUpgrades.Register(27, 28, "add room translation table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_room_translate (
+			room_id  TEXT PRIMARY KEY,
+			language TEXT NOT NULL
+		)`)
+		return err
+	})
+	storeUpgrades.Register(28, 29, "add user correction table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_user_correct (
+			user_id  TEXT PRIMARY KEY,
+			language TEXT NOT NULL,
+			mode     TEXT NOT NULL
+		)`)
+		return err
+	})
+	storeUpgrades.Register(29, 30, "add standup table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE bot_standup (
+			id         %s,
+			room       TEXT NOT NULL,
+			user_id    TEXT NOT NULL,
+			day        TEXT NOT NULL,
+			dm_room_id TEXT NOT NULL,
+			answer     TEXT NOT NULL,
+			asked_at   BIGINT NOT NULL,
+			reported   BOOLEAN NOT NULL DEFAULT false,
+			UNIQUE (room, user_id, day)
+		)`, serialPrimaryKey(db)))
+		return err
+	})
+	storeUpgrades.Register(30, 31, "add room value table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_room_value (
+			room_id   TEXT NOT NULL,
+			namespace TEXT NOT NULL,
+			key       TEXT NOT NULL,
+			value     TEXT NOT NULL,
+			PRIMARY KEY (room_id, namespace, key)
+		)`)
+		return err
+	})
+	storeUpgrades.Register(31, 32, "add poll tables", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		for _, q := range []string{
+			`CREATE TABLE bot_poll (
+				event_id   TEXT PRIMARY KEY,
+				room_id    TEXT NOT NULL,
+				creator    TEXT NOT NULL,
+				question   TEXT NOT NULL,
+				answers    TEXT NOT NULL,
+				created_at BIGINT NOT NULL,
+				closed     BOOLEAN NOT NULL
+			)`,
+			`CREATE TABLE bot_poll_vote (
+				poll_event_id TEXT NOT NULL,
+				user_id       TEXT NOT NULL,
+				answers       TEXT NOT NULL,
+				voted_at      BIGINT NOT NULL,
+				PRIMARY KEY (poll_event_id, user_id)
+			)`,
+		} {
+			if _, err := tx.Exec(q); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	storeUpgrades.Register(32, 33, "add reminder attempts and failed columns", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		if _, err := tx.Exec(`ALTER TABLE bot_reminder ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`ALTER TABLE bot_reminder ADD COLUMN failed BOOLEAN NOT NULL DEFAULT false`)
+		return err
+	})
+	storeUpgrades.Register(33, 34, "add coordinates to user location", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		for _, q := range []string{
+			`ALTER TABLE bot_user_location ADD COLUMN country TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE bot_user_location ADD COLUMN latitude DOUBLE PRECISION`,
+			`ALTER TABLE bot_user_location ADD COLUMN longitude DOUBLE PRECISION`,
+		} {
+			if _, err := tx.Exec(q); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	storeUpgrades.Register(34, 35, "add karma reaction table", true, func(tx dbutil.Execable, db *dbutil.Database) error {
+		_, err := tx.Exec(`CREATE TABLE bot_karma_reaction (
+			event_id TEXT NOT NULL,
+			user_id  TEXT NOT NULL,
+			room_id  TEXT NOT NULL,
+			PRIMARY KEY (event_id, user_id)
+		)`)
+		return err
+	})
+}
+
+// serialPrimaryKey is the definition of an auto incrementing id column.
+func serialPrimaryKey(db *dbutil.Database) string {
+	if db.Dialect == dbutil.Postgres {
+		return "BIGSERIAL PRIMARY KEY"
+	}
+
+	return "INTEGER PRIMARY KEY"
+}
+
+type Store struct {
+	db  *dbutil.Database
+	ctx context.Context
+}
+
+// NewStore creates the bot tables in db, which may be shared with the crypto
+// helper. The tables are versioned separately in bot_version.
+func NewStore(db *dbutil.Database) (*Store, error) {
+	child := db.Child("bot_version", storeUpgrades, nil)
+	if err := child.Upgrade(); err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		db:  child,
+		ctx: context.Background(),
+	}, nil
+}
+
+// WithContext returns a store on the same database whose queries are
+// cancelled when ctx is done.
+func (s *Store) WithContext(ctx context.Context) *Store {
+	return &Store{db: s.db, ctx: ctx}
+}
+
+func (s *Store) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+
+	return s.ctx
+}
@@ -0,0 +1,667 @@
+package bot_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestStore_PluginEnabled(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	enabled, err := store.PluginEnabled("room", "chat")
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if !enabled {
+		t.Error("exp plugin to be enabled by default")
+	}
+
+	if err := store.SetPluginEnabled("room", "chat", false); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if enabled, _ := store.PluginEnabled("room", "chat"); enabled {
+		t.Error("exp plugin to be disabled")
+	}
+	if enabled, _ := store.PluginEnabled("other", "chat"); !enabled {
+		t.Error("exp plugin to be enabled in other room")
+	}
+
+	if err := store.SetPluginEnabled("room", "chat", true); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if enabled, _ := store.PluginEnabled("room", "chat"); !enabled {
+		t.Error("exp plugin to be enabled again")
+	}
+}
+
+func TestStore_KeyBackedUp(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	if done, err := store.KeyBackedUp("session"); err != nil || done {
+		t.Fatalf("exp false and nil, got %v and %v", done, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := store.SetKeyBackedUp("session"); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+	}
+	if done, _ := store.KeyBackedUp("session"); !done {
+		t.Error("exp session to be backed up")
+	}
+}
+
+func TestDBDialect(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		path string
+		exp  string
+	}{
+		{path: "gpt4-bot.db", exp: "sqlite3"},
+		{path: "postgres://bot:secret@db/bot", exp: "postgres"},
+		{path: "postgresql://bot@db/bot?sslmode=disable", exp: "postgres"},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			if act := bot.DBDialect(tc.path); act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestStore_UnencryptedNoticeSent(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	if sent, err := store.UnencryptedNoticeSent("room"); err != nil || sent {
+		t.Fatalf("exp false and nil, got %v and %v", sent, err)
+	}
+	if err := store.SetUnencryptedNoticeSent("room"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if sent, _ := store.UnencryptedNoticeSent("room"); !sent {
+		t.Error("exp notice to be sent")
+	}
+	if sent, _ := store.UnencryptedNoticeSent("other"); sent {
+		t.Error("exp no notice in other room")
+	}
+}
+
+func TestStore_Reminders(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	now := time.Now().Truncate(time.Second)
+	for _, r := range []bot.Reminder{
+		{RoomID: "room", UserID: "@later:server", Due: now.Add(time.Hour), Message: "later"},
+		{RoomID: "room", UserID: "@due:server", Due: now.Add(-time.Minute), Message: "due"},
+	} {
+		if _, err := store.AddReminder(r); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+	}
+
+	due, err := store.DueReminders(now)
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if len(due) != 1 {
+		t.Fatalf("exp 1, got %v", len(due))
+	}
+	if due[0].Message != "due" || !due[0].Due.Equal(now.Add(-time.Minute)) {
+		t.Errorf("exp due reminder, got %v", due[0])
+	}
+
+	if err := store.DeleteReminder(due[0].ID); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if due, _ := store.DueReminders(now.Add(2 * time.Hour)); len(due) != 1 || due[0].Message != "later" {
+		t.Errorf("exp only the later reminder, got %v", due)
+	}
+
+	later, _ := store.DueReminders(now.Add(2 * time.Hour))
+	if err := store.SetReminderNotice(later[0].ID, "$notice", true); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if due, _ := store.DueReminders(now.Add(2 * time.Hour)); len(due) != 0 {
+		t.Errorf("exp sent reminder not to be due, got %v", due)
+	}
+	r, ok, err := store.ReminderByEvent("room", "$notice")
+	if err != nil || !ok {
+		t.Fatalf("exp reminder, got %v %v", ok, err)
+	}
+	if !r.Sent || r.Message != "later" {
+		t.Errorf("exp sent later reminder, got %v", r)
+	}
+	if err := store.SnoozeReminder(r.ID, now.Add(3*time.Hour)); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if due, _ := store.DueReminders(now.Add(3 * time.Hour)); len(due) != 1 || due[0].Sent {
+		t.Errorf("exp snoozed reminder to be due again, got %v", due)
+	}
+	if err := store.SetReminderNotice(r.ID, "$again", true); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if err := store.PruneReminders(now.Add(4 * time.Hour)); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if _, ok, _ := store.ReminderByEvent("room", "$again"); ok {
+		t.Error("exp pruned reminder to be gone")
+	}
+
+	if n, err := store.PendingReminders("@later:server"); err != nil || n != 0 {
+		t.Errorf("exp no pending reminders, got %v %v", n, err)
+	}
+	pending, err := store.AddReminder(bot.Reminder{RoomID: "room", UserID: "@later:server", Due: now.Add(time.Hour), Message: "pending"})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if n, err := store.PendingReminders("@later:server"); err != nil || n != 1 {
+		t.Errorf("exp 1 pending reminder, got %v %v", n, err)
+	}
+	if err := store.DeleteReminder(pending); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+
+	failing, err := store.AddReminder(bot.Reminder{RoomID: "room", UserID: "@fail:server", EventID: "$fail", Due: now, Message: "fail"})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if err := store.RetryReminder(failing, 1, now.Add(time.Minute)); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if due, _ := store.DueReminders(now); len(due) != 0 {
+		t.Errorf("exp retried reminder not to be due yet, got %v", due)
+	}
+	if due, _ := store.DueReminders(now.Add(time.Minute)); len(due) != 1 || due[0].Attempts != 1 {
+		t.Errorf("exp retried reminder with one attempt, got %v", due)
+	}
+	if err := store.FailReminder(failing); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if due, _ := store.DueReminders(now.Add(time.Hour)); len(due) != 0 {
+		t.Errorf("exp failed reminder not to be due, got %v", due)
+	}
+	if err := store.PruneReminders(now.Add(2 * time.Minute)); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if _, ok, _ := store.ReminderByEvent("room", "$fail"); ok {
+		t.Error("exp pruned failed reminder to be gone")
+	}
+}
+
+func TestStore_Schedules(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	first, err := store.AddSchedule(bot.Schedule{RoomID: "room", Cron: "0 9 * * 1-5", Message: "standup"})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if _, err := store.AddSchedule(bot.Schedule{RoomID: "other", Cron: "0 10 * * 1", Message: "meeting"}); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+
+	if all, _ := store.Schedules(""); len(all) != 2 {
+		t.Errorf("exp 2, got %v", len(all))
+	}
+	inRoom, err := store.Schedules("room")
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if len(inRoom) != 1 || inRoom[0].Message != "standup" {
+		t.Errorf("exp standup schedule, got %v", inRoom)
+	}
+
+	if err := store.DeleteSchedule("other", first); err == nil {
+		t.Error("exp error removing schedule of other room")
+	}
+	if err := store.DeleteSchedule("room", first); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if inRoom, _ := store.Schedules("room"); len(inRoom) != 0 {
+		t.Errorf("exp 0, got %v", len(inRoom))
+	}
+}
+
+func TestStore_Feeds(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	feedID, err := store.AddFeed(bot.Feed{RoomID: "room", URL: "https://example.com/feed", Summarize: true})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	feeds, err := store.Feeds("room")
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if len(feeds) != 1 || !feeds[0].Summarize || feeds[0].URL != "https://example.com/feed" {
+		t.Errorf("exp the feed, got %v", feeds)
+	}
+
+	if err := store.SetFeedItemSeen(feedID, "item"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if seen, _ := store.FeedItemSeen(feedID, "item"); !seen {
+		t.Error("exp item to be seen")
+	}
+	if seen, _ := store.FeedItemSeen(feedID, "other"); seen {
+		t.Error("exp other item not to be seen")
+	}
+
+	if err := store.DeleteFeed("room", feedID); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if seen, _ := store.FeedItemSeen(feedID, "item"); seen {
+		t.Error("exp items to be removed with the feed")
+	}
+}
+
+func TestStore_Calendars(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	calendarID, err := store.AddCalendar(bot.Calendar{RoomID: "room", URL: "https://example.com/cal.ics"})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	calendars, err := store.Calendars("")
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	exp := []bot.Calendar{{ID: calendarID, RoomID: "room", URL: "https://example.com/cal.ics"}}
+	if !reflect.DeepEqual(exp, calendars) {
+		t.Errorf("exp %v, got %v", exp, calendars)
+	}
+	if err := store.SetCalendarEventAnnounced(calendarID, "uid/1"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if done, _ := store.CalendarEventAnnounced(calendarID, "uid/1"); !done {
+		t.Error("exp event to be announced")
+	}
+	if err := store.DeleteCalendar("other", calendarID); err == nil {
+		t.Error("exp error for calendar of other room")
+	}
+	if err := store.DeleteCalendar("room", calendarID); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if done, _ := store.CalendarEventAnnounced(calendarID, "uid/1"); done {
+		t.Error("exp announcements to be removed with the calendar")
+	}
+}
+
+func TestStore_UserTimezone(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	for _, tz := range []string{"Europe/Amsterdam", "Asia/Tokyo"} {
+		if err := store.SetUserTimezone("@user:server", tz); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+		if act, _ := store.UserTimezone("@user:server"); act != tz {
+			t.Errorf("exp %v, got %v", tz, act)
+		}
+	}
+	if err := store.SetUserTimezone("@user:server", ""); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if act, _ := store.UserTimezone("@user:server"); act != "" {
+		t.Errorf("exp empty, got %v", act)
+	}
+}
+
+func TestStore_UserPlace(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	if _, ok, err := store.UserPlace("@user:server"); err != nil || ok {
+		t.Fatalf("exp no place, got %v %v", ok, err)
+	}
+	for _, place := range []bot.Place{
+		{Name: "Amsterdam", Country: "Netherlands", Latitude: 52.37, Longitude: 4.89},
+		{Name: "Utrecht", Country: "Netherlands", Latitude: 52.09, Longitude: 5.12},
+	} {
+		if err := store.SetUserPlace("@user:server", place); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+		act, ok, err := store.UserPlace("@user:server")
+		if err != nil || !ok {
+			t.Fatalf("exp place, got %v %v", ok, err)
+		}
+		if act != place {
+			t.Errorf("exp %v, got %v", place, act)
+		}
+	}
+}
+
+func TestStore_RoomTools(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	for _, tool := range []string{"wikipedia", "weather", "wikipedia"} {
+		if err := store.AllowRoomTool("!room:server", tool); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+	}
+	if err := store.AllowRoomTool("!other:server", "fetch_url"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	act, ok, err := store.RoomTools("!room:server")
+	if err != nil || !ok {
+		t.Fatalf("exp allowlist, got %v %v", ok, err)
+	}
+	if exp := []string{"weather", "wikipedia"}; !reflect.DeepEqual(exp, act) {
+		t.Errorf("exp %v, got %v", exp, act)
+	}
+
+	if err := store.RemoveRoomTool("!room:server", "weather"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	act, _, _ = store.RoomTools("!room:server")
+	if exp := []string{"wikipedia"}; !reflect.DeepEqual(exp, act) {
+		t.Errorf("exp %v, got %v", exp, act)
+	}
+	// the last tool leaves an empty allowlist, not none
+	if err := store.RemoveRoomTool("!room:server", "wikipedia"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if act, ok, _ := store.RoomTools("!room:server"); !ok || len(act) != 0 {
+		t.Errorf("exp empty allowlist, got %v %v", ok, act)
+	}
+
+	if err := store.ClearRoomTools("!room:server"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if act, ok, _ := store.RoomTools("!room:server"); ok || len(act) != 0 {
+		t.Errorf("exp no allowlist, got %v %v", ok, act)
+	}
+	if act, _, _ := store.RoomTools("!other:server"); len(act) != 1 {
+		t.Errorf("exp 1, got %v", len(act))
+	}
+}
+
+func TestStore_Outbox(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	now := time.Date(2023, 6, 7, 12, 0, 0, 0, time.UTC)
+	first, err := store.AddOutgoing(bot.Outgoing{RoomID: "!room:server", TxnID: "txn-1", Content: json.RawMessage(`{"body":"one"}`), CreatedAt: now}, now)
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if _, err := store.AddOutgoing(bot.Outgoing{RoomID: "!room:server", TxnID: "txn-2", Content: json.RawMessage(`{"body":"two"}`), CreatedAt: now}, now.Add(time.Minute)); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+
+	due, err := store.DueOutgoing(now)
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if len(due) != 1 || due[0].ID != first || due[0].TxnID != "txn-1" || string(due[0].Content) != `{"body":"one"}` || !due[0].CreatedAt.Equal(now) {
+		t.Errorf("exp txn-1, got %v", due)
+	}
+
+	if err := store.RescheduleOutgoing(first, 1, now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	due, _ = store.DueOutgoing(now.Add(time.Minute))
+	if len(due) != 1 || due[0].TxnID != "txn-2" {
+		t.Errorf("exp txn-2, got %v", due)
+	}
+	due, _ = store.DueOutgoing(now.Add(2 * time.Minute))
+	if len(due) != 2 || due[0].Attempts != 1 {
+		t.Errorf("exp 2 with 1 attempt, got %v", due)
+	}
+
+	if err := store.DeleteOutgoing(first); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if due, _ := store.DueOutgoing(now.Add(time.Hour)); len(due) != 1 {
+		t.Errorf("exp 1, got %v", len(due))
+	}
+}
+
+func TestStore_Processed(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	now := time.Date(2023, 6, 7, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		eventID string
+		at      time.Time
+		exp     bool
+	}{
+		{eventID: "$one", at: now.Add(-time.Hour), exp: true},
+		{eventID: "$two", at: now, exp: true},
+		{eventID: "$one", at: now, exp: false},
+	} {
+		act, err := store.MarkProcessed(id.EventID(tc.eventID), tc.at)
+		if err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+		if act != tc.exp {
+			t.Errorf("exp %v, got %v", tc.exp, act)
+		}
+	}
+
+	if err := store.PruneProcessed(now.Add(-time.Minute)); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if act, _ := store.MarkProcessed("$one", now); !act {
+		t.Errorf("exp pruned event to be new, got %v", act)
+	}
+	if act, _ := store.MarkProcessed("$two", now); act {
+		t.Errorf("exp recent event to be known, got %v", act)
+	}
+}
+
+func TestStore_Avatar(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	if act, err := store.Avatar("hash"); err != nil || !act.IsEmpty() {
+		t.Errorf("exp empty, got %v, %v", act, err)
+	}
+	exp := id.ContentURI{Homeserver: "server", FileID: "file"}
+	if err := store.SaveAvatar("hash", exp); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if act, _ := store.Avatar("hash"); act != exp {
+		t.Errorf("exp %v, got %v", exp, act)
+	}
+}
+
+func TestStore_DeleteRoom(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	for _, roomID := range []id.RoomID{"room", "other"} {
+		if _, err := store.AddSchedule(bot.Schedule{RoomID: roomID, Cron: "0 9 * * *", Message: "hi"}); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+		feedID, err := store.AddFeed(bot.Feed{RoomID: roomID, URL: "https://example.com/feed"})
+		if err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+		if err := store.SetFeedItemSeen(feedID, "item"); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+		if err := store.SetPluginEnabled(roomID, "chat", false); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+	}
+
+	if err := store.DeleteRoom("room"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	for _, tc := range []struct {
+		roomID id.RoomID
+		exp    int
+	}{
+		{roomID: "room", exp: 0},
+		{roomID: "other", exp: 1},
+	} {
+		schedules, _ := store.Schedules(tc.roomID)
+		feeds, _ := store.Feeds(tc.roomID)
+		if len(schedules) != tc.exp || len(feeds) != tc.exp {
+			t.Errorf("exp %v, got %v schedules and %v feeds in %v", tc.exp, len(schedules), len(feeds), tc.roomID)
+		}
+		enabled, _ := store.PluginEnabled(tc.roomID, "chat")
+		if enabled != (tc.exp == 0) {
+			t.Errorf("exp plugin setting of %v to be kept: %v", tc.roomID, tc.exp == 1)
+		}
+	}
+}
+
+func TestStore_RoomConfig(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	for _, tc := range []struct {
+		name string
+		rc   bot.RoomConfigEventContent
+	}{
+		{name: "set", rc: bot.RoomConfigEventContent{Persona: "helpdesk", Mode: bot.ModeAll}},
+		{name: "replace", rc: bot.RoomConfigEventContent{Model: "gpt-4", Prompt: "Answer in Dutch.", Language: "nl"}},
+		{name: "remove"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := store.SetRoomConfig("room", tc.rc); err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			act, err := store.RoomConfig("room")
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			if act != tc.rc {
+				t.Errorf("exp %v, got %v", tc.rc, act)
+			}
+		})
+	}
+}
+
+func TestStore_Knocks(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	if knocked, _ := store.Knocked("room"); knocked {
+		t.Error("exp no knock")
+	}
+	if err := store.AddKnock("room", time.Now()); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if knocked, _ := store.Knocked("room"); !knocked {
+		t.Error("exp knock")
+	}
+	if err := store.DeleteKnock("room"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if knocked, _ := store.Knocked("room"); knocked {
+		t.Error("exp knock to be removed")
+	}
+}
+
+func TestStore_Rooms(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	exp := bot.Room{ID: "!b:server", Name: "general", Topic: "everything", Encrypted: true, Members: 3}
+	for _, room := range []bot.Room{{ID: "!b:server", Name: "old"}, exp, {ID: "!a:server"}} {
+		if err := store.SaveRoom(room, time.Now()); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+	}
+	if act, ok, err := store.Room("!b:server"); err != nil || !ok || act != exp {
+		t.Errorf("exp %v, got %v", exp, act)
+	}
+	if _, ok, _ := store.Room("!c:server"); ok {
+		t.Error("exp unknown room not to be found")
+	}
+
+	if err := store.DeleteRoomInfo("!a:server"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	rooms, err := store.Rooms()
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if !reflect.DeepEqual(rooms, []bot.Room{exp}) {
+		t.Errorf("exp %v, got %v", []bot.Room{exp}, rooms)
+	}
+}
+
+func TestStore_Invites(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	now := time.Date(2023, 6, 7, 9, 0, 0, 0, time.UTC)
+	for i, roomID := range []id.RoomID{"!b:server", "!a:server"} {
+		if err := store.AddInvite(bot.Invite{RoomID: roomID, Inviter: "@stranger:server", InvitedAt: now.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+	}
+	invites, err := store.Invites()
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if len(invites) != 2 || invites[0].RoomID != "!b:server" || !invites[0].InvitedAt.Equal(now) {
+		t.Errorf("exp oldest invite first, got %v", invites)
+	}
+
+	if err := store.DeleteInvite("!b:server"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if _, ok, _ := store.Invite("!b:server"); ok {
+		t.Error("exp invite to be removed")
+	}
+	if inv, ok, _ := store.Invite("!a:server"); !ok || inv.Inviter != "@stranger:server" {
+		t.Errorf("exp invite, got %v", inv)
+	}
+}
+
+func TestStore_RoomValues(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	if err := store.SetRoomValue("!a:server", "plugin", "key", "one"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if err := store.SetRoomValue("!a:server", "plugin", "key", "two"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if value, ok, err := store.RoomValue("!a:server", "plugin", "key"); err != nil || !ok || value != "two" {
+		t.Errorf("exp two, got %q %v %v", value, ok, err)
+	}
+	if _, ok, _ := store.RoomValue("!b:server", "plugin", "key"); ok {
+		t.Error("exp no value in other room")
+	}
+
+	for _, delta := range []int64{3, -1} {
+		if _, err := store.AddRoomCounter("!a:server", "counter", "key", delta); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+	}
+	values, err := store.RoomValues("!a:server", "counter")
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if len(values) != 1 || values["key"] != "2" {
+		t.Errorf("exp map[key:2], got %v", values)
+	}
+
+	if err := store.DeleteRoomValue("!a:server", "plugin", "key"); err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if _, ok, _ := store.RoomValue("!a:server", "plugin", "key"); ok {
+		t.Error("exp value to be removed")
+	}
+}
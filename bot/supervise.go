@@ -0,0 +1,140 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+)
+
+// syncBackoff is used when syncing with the homeserver fails.
+var syncBackoff = Backoff{Base: time.Second, Max: 5 * time.Minute}
+
+// Health is the state of a bot, for monitoring.
+type Health struct {
+	Syncing           bool
+	SyncFailures      int
+	LastSync          time.Time
+	LastSyncError     string
+	ProviderAvailable bool
+}
+
+type syncHealth struct {
+	syncing   bool
+	failures  int
+	lastSync  time.Time
+	lastError string
+	mu        sync.Mutex
+}
+
+func (h *syncHealth) succeeded(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.failures = 0
+	h.lastSync = now
+	h.lastError = ""
+}
+
+// failed records the error and returns the number of failures in a row.
+func (h *syncHealth) failed(err error) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.failures++
+	h.lastError = err.Error()
+
+	return h.failures
+}
+
+func (h *syncHealth) setSyncing(syncing bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.syncing = syncing
+}
+
+// supervisedSyncer waits longer after each failed sync, instead of the
+// fixed ten seconds of the default syncer, and keeps count of the failures.
+type supervisedSyncer struct {
+	*mautrix.DefaultSyncer
+	health *syncHealth
+}
+
+// OnFailedSync counts the failures it retries. A fatal error ends the sync
+// and is counted by superviseSync, that gets it back.
+func (s *supervisedSyncer) OnFailedSync(_ *mautrix.RespSync, err error) (time.Duration, error) {
+	if FatalSyncError(err) {
+		return 0, err
+	}
+
+	return syncBackoff.Delay(s.health.failed(err)), nil
+}
+
+// FatalSyncError reports whether syncing can not work again without a
+// change, like a new access token.
+func FatalSyncError(err error) bool {
+	if errors.Is(err, mautrix.MUnknownToken) || errors.Is(err, mautrix.MMissingToken) || errors.Is(err, mautrix.MForbidden) {
+		return true
+	}
+	var httpErr mautrix.HTTPError
+	if errors.As(err, &httpErr) && httpErr.Response != nil {
+		return httpErr.Response.StatusCode == http.StatusUnauthorized
+	}
+
+	return false
+}
+
+// superviseSync syncs until the bot stops. When syncing ends with an error
+// that is not fatal, it starts again after a backoff.
+func (m *Bot) superviseSync(parent context.Context) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	go func() {
+		select {
+		case <-m.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	m.syncHealth.setSyncing(true)
+	defer m.syncHealth.setSyncing(false)
+	for {
+		err := m.client.SyncWithContext(ctx)
+		if m.stopping() {
+			return nil
+		}
+		if err == nil {
+			// stopped by another sync
+			return nil
+		}
+		failures := m.syncHealth.failed(err)
+		if FatalSyncError(err) {
+			return err
+		}
+		wait := syncBackoff.Delay(failures)
+		m.logger.Error("sync failed, restarting", slog.String("err", err.Error()), slog.Int("failures", failures), slog.Duration("wait", wait), slog.String("bot", m.config.UserDisplayName))
+		if !m.wait(wait) {
+			return nil
+		}
+	}
+}
+
+// Health returns the state of syncing and of the provider.
+func (m *Bot) Health() Health {
+	m.syncHealth.mu.Lock()
+	defer m.syncHealth.mu.Unlock()
+
+	return Health{
+		Syncing:           m.syncHealth.syncing,
+		SyncFailures:      m.syncHealth.failures,
+		LastSync:          m.syncHealth.lastSync,
+		LastSyncError:     m.syncHealth.lastError,
+		ProviderAvailable: m.gptClient.Available(),
+	}
+}
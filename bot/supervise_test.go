@@ -0,0 +1,44 @@
+package bot_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+)
+
+func TestFatalSyncError(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		exp  bool
+	}{
+		{
+			name: "unknown token",
+			err:  fmt.Errorf("sync: %w", mautrix.HTTPError{Response: &http.Response{StatusCode: http.StatusUnauthorized}, RespError: &mautrix.RespError{ErrCode: "M_UNKNOWN_TOKEN"}}),
+			exp:  true,
+		},
+		{
+			name: "unauthorized",
+			err:  mautrix.HTTPError{Response: &http.Response{StatusCode: http.StatusUnauthorized}},
+			exp:  true,
+		},
+		{
+			name: "gateway",
+			err:  mautrix.HTTPError{Response: &http.Response{StatusCode: http.StatusBadGateway}},
+		},
+		{name: "connection", err: mautrix.HTTPError{WrappedError: errors.New("connection reset")}},
+		{name: "processing", err: errors.New("panic in sync handler")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if act := bot.FatalSyncError(tc.err); act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
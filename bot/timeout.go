@@ -0,0 +1,64 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultCompletionTimeout = 2 * time.Minute
+	defaultToolTimeout       = 30 * time.Second
+	// minMatrixTimeout leaves room for a sync, that the homeserver holds
+	// for 30 seconds when there is nothing new.
+	minMatrixTimeout = 45 * time.Second
+)
+
+// ConfigTimeouts limits how long the bot waits, like "90s" or "2m".
+// Completion covers a whole answer of the model, including the tools it
+// calls. Tool is for a single tool call and Matrix for a single request to
+// the homeserver.
+type ConfigTimeouts struct {
+	Completion time.Duration
+	Tool       time.Duration
+	Matrix     time.Duration
+}
+
+func (c ConfigTimeouts) validate() error {
+	for name, d := range map[string]time.Duration{"completion": c.Completion, "tool": c.Tool, "matrix": c.Matrix} {
+		if d < 0 {
+			return fmt.Errorf("negative %s timeout", name)
+		}
+	}
+	if c.Matrix != 0 && c.Matrix < minMatrixTimeout {
+		return fmt.Errorf("matrix timeout must be at least %s", minMatrixTimeout)
+	}
+
+	return nil
+}
+
+func (c ConfigTimeouts) completion() time.Duration {
+	if c.Completion == 0 {
+		return defaultCompletionTimeout
+	}
+
+	return c.Completion
+}
+
+func (c ConfigTimeouts) tool() time.Duration {
+	if c.Tool == 0 {
+		return defaultToolTimeout
+	}
+
+	return c.Tool
+}
+
+// completionContext returns a context for getting an answer of the model.
+func (m *Bot) completionContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, m.config.Timeouts.completion())
+}
+
+// toolContext returns a context for using a tool directly, like in a command.
+func (m *Bot) toolContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(m.ctx, m.config.Timeouts.tool())
+}
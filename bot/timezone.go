@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// location is the time zone of the bot, from Timezone in the config. Without
+// it, the local time of the server is used.
+func (m *Bot) location() *time.Location {
+	if m.loc == nil {
+		return time.Local
+	}
+
+	return m.loc
+}
+
+// userLocation is the time zone a user set with !tz, or that of the bot.
+func (m *Bot) userLocation(userID id.UserID) *time.Location {
+	name, err := m.store.UserTimezone(userID)
+	if err != nil {
+		m.logger.Error("failed to get time zone", slog.String("err", err.Error()), slog.String("user_id", userID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+	if name == "" {
+		return m.location()
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return m.location()
+	}
+
+	return loc
+}
+
+func (m *Bot) tzCommand() Command {
+	return Command{
+		Name:  "tz",
+		Usage: "[zone|reset]",
+		Help:  "show or set your time zone for reminders and times, like `!tz Europe/Amsterdam`",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			switch {
+			case len(args) == 0:
+				loc := m.userLocation(evt.Sender)
+				return fmt.Sprintf("Your time zone is %s, it is now %s.", loc, time.Now().In(loc).Format(reminderTimeFormat)), nil
+			case len(args) == 1 && strings.EqualFold(args[0], "reset"):
+				if err := m.store.SetUserTimezone(evt.Sender, ""); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Your time zone is %s again.", m.location()), nil
+			case len(args) == 1:
+				loc, err := time.LoadLocation(args[0])
+				if err != nil || args[0] == "" || strings.EqualFold(args[0], "local") {
+					return "", fmt.Errorf("unknown time zone %q, use a name like Europe/Amsterdam", args[0])
+				}
+				if err := m.store.SetUserTimezone(evt.Sender, loc.String()); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Your time zone is now %s, it is %s.", loc, time.Now().In(loc).Format(reminderTimeFormat)), nil
+			default:
+				return "", fmt.Errorf("usage: !tz [zone|reset]")
+			}
+		},
+	}
+}
+
+// UserTimezone returns the time zone a user set, or nothing.
+func (s *Store) UserTimezone(userID id.UserID) (string, error) {
+	var name string
+	err := s.db.QueryRowContext(s.context(), `SELECT timezone FROM bot_user_timezone WHERE user_id = $1`, userID).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	return name, err
+}
+
+// SetUserTimezone stores the time zone of a user. An empty name removes it.
+func (s *Store) SetUserTimezone(userID id.UserID, name string) error {
+	if name == "" {
+		_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_user_timezone WHERE user_id = $1`, userID)
+		return err
+	}
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_user_timezone (user_id, timezone) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET timezone = excluded.timezone`, userID, name)
+
+	return err
+}
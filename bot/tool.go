@@ -0,0 +1,282 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// maxToolRounds is the number of times the model may call tools before it
+// has to answer.
+const maxToolRounds = 5
+
+// toolName is what OpenAI accepts as the name of a function.
+var toolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Tool is something the model can use while answering, like a search or a
+// lookup in another system. Parameters is the JSON schema of the arguments
+// that Execute gets. The result of Execute is passed to the model as is.
+type Tool interface {
+	Name() string
+	Description() string
+	Parameters() json.RawMessage
+	Execute(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+type callerKey struct{}
+
+// Caller is the user whose message the model is answering.
+type Caller struct {
+	UserID id.UserID
+	RoomID id.RoomID
+}
+
+// WithCaller adds the caller to the context, so tools can check what the
+// user is allowed to do.
+func WithCaller(ctx context.Context, c Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, c)
+}
+
+// CallerFromContext returns the caller, if it is known.
+func CallerFromContext(ctx context.Context) (Caller, bool) {
+	c, ok := ctx.Value(callerKey{}).(Caller)
+	return c, ok
+}
+
+// RegisterTool makes a tool of the embedding program, like a lookup in an
+// internal system, available to the bot and the personas that list it in
+// their Tools. It must be called after Init.
+func (m *Bot) RegisterTool(t Tool) error {
+	if !toolName.MatchString(t.Name()) {
+		return fmt.Errorf("invalid tool name %q", t.Name())
+	}
+	if _, ok := m.tools[t.Name()]; ok {
+		return fmt.Errorf("duplicate tool %q", t.Name())
+	}
+	m.registerTool(t)
+
+	return nil
+}
+
+func (m *Bot) registerTool(t Tool) {
+	m.tools[t.Name()] = t
+}
+
+// personaTools returns the registered tools the persona may use. Unknown
+// names are logged and skipped.
+func (m *Bot) personaTools(p Persona) []Tool {
+	var tools []Tool
+	for _, name := range p.Tools {
+		t, ok := m.tools[name]
+		if !ok {
+			m.logger.Warn("unknown tool", slog.String("tool", name), slog.String("persona", p.Name), slog.String("bot", m.config.UserDisplayName))
+			continue
+		}
+		tools = append(tools, t)
+	}
+
+	return tools
+}
+
+// roomTools returns the tools that are allowed in the room. Without an
+// allowlist for the room, all are. An empty allowlist allows none.
+func (m *Bot) roomTools(roomID id.RoomID, tools []Tool) []Tool {
+	allowed, ok, err := m.store.RoomTools(roomID)
+	if err != nil {
+		m.logger.Error("failed to get room tools", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+		return nil
+	}
+	if !ok {
+		return tools
+	}
+	var res []Tool
+	for _, t := range tools {
+		if contains(allowed, t.Name()) {
+			res = append(res, t)
+		}
+	}
+
+	return res
+}
+
+func (m *Bot) toolCommand() Command {
+	return Command{
+		Name:      "tool",
+		Usage:     "list|allow <name>|remove <name>|none|all",
+		Help:      "show or change which tools the model may use in this room",
+		AdminOnly: true,
+		Run: func(evt *event.Event, args []string) (string, error) {
+			usage := "usage: !tool list|allow <name>|remove <name>|none|all"
+			if len(args) == 0 || args[0] == "list" {
+				allowed, ok, err := m.store.RoomTools(evt.RoomID)
+				if err != nil {
+					return "", err
+				}
+				names := make([]string, 0, len(m.tools))
+				for name := range m.tools {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				var lines []string
+				for _, name := range names {
+					state := "allowed"
+					if ok && !contains(allowed, name) {
+						state = "not allowed"
+					}
+					lines = append(lines, fmt.Sprintf("- %s: %s", name, state))
+				}
+				switch {
+				case !ok:
+					lines = append(lines, "", "There is no allowlist for this room, so all tools are allowed.")
+				case len(allowed) == 0:
+					lines = append(lines, "", "The allowlist of this room is empty, so no tools are allowed.")
+				}
+				return strings.Join(lines, "\n"), nil
+			}
+
+			switch {
+			case len(args) == 1 && args[0] == "all":
+				if err := m.store.ClearRoomTools(evt.RoomID); err != nil {
+					return "", err
+				}
+				return "All tools are allowed in this room again.", nil
+			case len(args) == 1 && args[0] == "none":
+				if err := m.store.ClearRoomTools(evt.RoomID); err != nil {
+					return "", err
+				}
+				if err := m.store.EmptyRoomTools(evt.RoomID); err != nil {
+					return "", err
+				}
+				return "No tools are allowed in this room.", nil
+			case len(args) == 2 && args[0] == "allow":
+				if _, ok := m.tools[args[1]]; !ok {
+					return "", fmt.Errorf("unknown tool %q", args[1])
+				}
+				if err := m.store.AllowRoomTool(evt.RoomID, args[1]); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Tool %s is on the allowlist of this room.", args[1]), nil
+			case len(args) == 2 && args[0] == "remove":
+				if err := m.store.RemoveRoomTool(evt.RoomID, args[1]); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Tool %s is no longer on the allowlist of this room.", args[1]), nil
+			default:
+				return "", errors.New(usage)
+			}
+		},
+	}
+}
+
+// RoomTools returns the allowlist of tools of the room and whether it has
+// one. The allowlist can be empty, then no tool is allowed.
+func (s *Store) RoomTools(roomID id.RoomID) ([]string, bool, error) {
+	rows, err := s.db.QueryContext(s.context(), `SELECT tool FROM bot_room_tool WHERE room_id = $1 ORDER BY tool`, roomID)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var tools []string
+	var ok bool
+	for rows.Next() {
+		var tool string
+		if err := rows.Scan(&tool); err != nil {
+			return nil, false, err
+		}
+		ok = true
+		if tool != roomToolsMarker {
+			tools = append(tools, tool)
+		}
+	}
+
+	return tools, ok, rows.Err()
+}
+
+// roomToolsMarker is kept with every allowlist, so it still exists when the
+// last tool is removed from it. It is not a valid tool name.
+const roomToolsMarker = ""
+
+func (s *Store) AllowRoomTool(roomID id.RoomID, tool string) error {
+	if err := s.EmptyRoomTools(roomID); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_room_tool (room_id, tool) VALUES ($1, $2)
+		ON CONFLICT (room_id, tool) DO NOTHING`, roomID, tool)
+	return err
+}
+
+// EmptyRoomTools gives the room an allowlist without tools, if it has none.
+func (s *Store) EmptyRoomTools(roomID id.RoomID) error {
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_room_tool (room_id, tool) VALUES ($1, $2)
+		ON CONFLICT (room_id, tool) DO NOTHING`, roomID, roomToolsMarker)
+	return err
+}
+
+func (s *Store) RemoveRoomTool(roomID id.RoomID, tool string) error {
+	_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_room_tool WHERE room_id = $1 AND tool = $2`, roomID, tool)
+	return err
+}
+
+// ClearRoomTools removes the allowlist of the room.
+func (s *Store) ClearRoomTools(roomID id.RoomID) error {
+	_, err := s.db.ExecContext(s.context(), `DELETE FROM bot_room_tool WHERE room_id = $1`, roomID)
+	return err
+}
+
+func toolDefinitions(tools []Tool) []openai.Tool {
+	defs := make([]openai.Tool, 0, len(tools))
+	for _, t := range tools {
+		defs = append(defs, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        t.Name(),
+				Description: t.Description(),
+				Parameters:  t.Parameters(),
+			},
+		})
+	}
+
+	return defs
+}
+
+// executeToolCall runs the call and returns the message with the result for
+// the model. Failures are reported to the model, so it can try something else.
+func executeToolCall(ctx context.Context, tools []Tool, call openai.ToolCall, timeout time.Duration) openai.ChatCompletionMessage {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	result := fmt.Sprintf("error: unknown tool %s", call.Function.Name)
+	for _, t := range tools {
+		if t.Name() != call.Function.Name {
+			continue
+		}
+		out, err := t.Execute(ctx, json.RawMessage(call.Function.Arguments))
+		recordToolUse(ctx, ToolUse{Name: t.Name(), Arguments: call.Function.Arguments, Failed: err != nil})
+		if err != nil {
+			result = fmt.Sprintf("error: %s", err.Error())
+			break
+		}
+		result = out
+		break
+	}
+
+	return openai.ChatCompletionMessage{
+		Role:       openai.ChatMessageRoleTool,
+		Content:    result,
+		Name:       call.Function.Name,
+		ToolCallID: call.ID,
+	}
+}
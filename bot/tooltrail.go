@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+)
+
+const redacted = "[redacted]"
+
+// secretKey matches the names of arguments that are never shown.
+// auth only as a word of its own, so author and authenticated_user are shown
+var secretKey = regexp.MustCompile(`(?i)(token|secret|password|passwd|api_?key|\bauth(orization|_token)?\b)`)
+
+// ToolUse is a call of a tool by the model while answering.
+type ToolUse struct {
+	Name      string
+	Arguments string
+	Failed    bool
+}
+
+// ToolTrail collects the tool calls made for one answer.
+type ToolTrail struct {
+	uses []ToolUse
+	mu   sync.Mutex
+}
+
+type toolTrailKey struct{}
+
+// WithToolTrail adds the trail to the context, the tools that are called
+// with it are recorded there.
+func WithToolTrail(ctx context.Context, t *ToolTrail) context.Context {
+	return context.WithValue(ctx, toolTrailKey{}, t)
+}
+
+func recordToolUse(ctx context.Context, use ToolUse) {
+	t, ok := ctx.Value(toolTrailKey{}).(*ToolTrail)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.uses = append(t.uses, use)
+}
+
+func (t *ToolTrail) Uses() []ToolUse {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]ToolUse(nil), t.uses...)
+}
+
+// RedactArguments hides the values of arguments that look like secrets, and
+// every occurrence of the given secrets.
+func RedactArguments(args string, secrets []string) string {
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(args), &obj); err == nil {
+		var changed bool
+		for k := range obj {
+			if secretKey.MatchString(k) {
+				obj[k] = redacted
+				changed = true
+			}
+		}
+		if b, err := json.Marshal(obj); changed && err == nil {
+			args = string(b)
+		}
+	}
+	for _, s := range secrets {
+		// short values would hide too much that is not secret
+		if len(s) < 4 {
+			continue
+		}
+		args = strings.ReplaceAll(args, s, redacted)
+	}
+
+	return args
+}
+
+// FormatToolTrail renders the calls as a collapsed list.
+func FormatToolTrail(uses []ToolUse, secrets []string) string {
+	var b strings.Builder
+	summary := "Used 1 tool"
+	if len(uses) != 1 {
+		summary = fmt.Sprintf("Used %d tools", len(uses))
+	}
+	fmt.Fprintf(&b, "<details><summary>%s</summary><ol>", summary)
+	for _, u := range uses {
+		fmt.Fprintf(&b, "<li><code>%s</code> <code>%s</code>", html.EscapeString(u.Name), html.EscapeString(RedactArguments(u.Arguments, secrets)))
+		if u.Failed {
+			b.WriteString(" (failed)")
+		}
+		b.WriteString("</li>")
+	}
+	b.WriteString("</ol></details>")
+
+	return b.String()
+}
+
+// secrets are the values from the configuration that must not end up in a
+// room.
+func (m *Bot) secrets() []string {
+	secrets := []string{m.openaiKey, m.config.Pickle, m.config.UserAccessKey, m.config.UserPassword, m.config.RecoveryKey, m.config.Search.Key, m.config.HomeAssistant.Token}
+	for _, f := range m.config.Forges {
+		secrets = append(secrets, f.Token)
+	}
+	for _, h := range m.config.Hooks {
+		secrets = append(secrets, h.Token)
+	}
+	for _, w := range m.config.Webhooks {
+		secrets = append(secrets, w.Secret)
+	}
+
+	return secrets
+}
+
+// sendToolTrail posts the tools that were used for the reply in a thread on
+// it, so anyone can check how the answer came about.
+func (m *Bot) sendToolTrail(roomID id.RoomID, replyID id.EventID, trail *ToolTrail) {
+	uses := trail.Uses()
+	if len(uses) == 0 {
+		return
+	}
+	content := format.HTMLToContent(FormatToolTrail(uses, m.secrets()))
+	content.MsgType = event.MsgNotice
+	content.RelatesTo = (&event.RelatesTo{}).SetThread(replyID, replyID)
+	if _, err := m.sendMessage(roomID, &content); err != nil {
+		m.logger.Error("failed to send tool trail", slog.String("err", err.Error()), slog.String("room_id", roomID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+}
@@ -0,0 +1,51 @@
+package bot_test
+
+import (
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestFormatToolTrail(t *testing.T) {
+	t.Parallel()
+
+	secrets := []string{"", "abc", "hunter22"}
+	for _, tc := range []struct {
+		name string
+		uses []bot.ToolUse
+		exp  string
+	}{
+		{
+			name: "one",
+			uses: []bot.ToolUse{{Name: "wikipedia", Arguments: `{"query":"<b>utrecht</b>"}`}},
+			exp:  `<details><summary>Used 1 tool</summary><ol><li><code>wikipedia</code> <code>{&#34;query&#34;:&#34;&lt;b&gt;utrecht&lt;/b&gt;&#34;}</code></li></ol></details>`,
+		},
+		{
+			name: "secret argument",
+			uses: []bot.ToolUse{
+				{Name: "api", Arguments: `{"api_key":"x","q":"abc"}`, Failed: true},
+				{Name: "fetch_url", Arguments: `{"url":"https://example.com/?pw=hunter22"}`},
+			},
+			exp: `<details><summary>Used 2 tools</summary><ol>` +
+				`<li><code>api</code> <code>{&#34;api_key&#34;:&#34;[redacted]&#34;,&#34;q&#34;:&#34;abc&#34;}</code> (failed)</li>` +
+				`<li><code>fetch_url</code> <code>{&#34;url&#34;:&#34;https://example.com/?pw=[redacted]&#34;}</code></li>` +
+				`</ol></details>`,
+		},
+		{
+			name: "auth",
+			uses: []bot.ToolUse{{Name: "repository", Arguments: `{"Authorization":"Bearer x","auth":"y","author":"ewintr"}`}},
+			exp:  `<details><summary>Used 1 tool</summary><ol><li><code>repository</code> <code>{&#34;Authorization&#34;:&#34;[redacted]&#34;,&#34;auth&#34;:&#34;[redacted]&#34;,&#34;author&#34;:&#34;ewintr&#34;}</code></li></ol></details>`,
+		},
+		{
+			name: "not json",
+			uses: []bot.ToolUse{{Name: "broken", Arguments: `token hunter22`}},
+			exp:  `<details><summary>Used 1 tool</summary><ol><li><code>broken</code> <code>token [redacted]</code></li></ol></details>`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if act := bot.FormatToolTrail(tc.uses, secrets); act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package bot_test
+
+import (
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestTranslate(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	fp := bot.NewFakeProvider()
+	fp.On("Goedemorgen", "Good morning, everyone.")
+	b := newTestBot(t, bot.ConfigBot{
+		UserID:    "@bot:ewintr.nl",
+		Admins:    []string{"@someone:ewintr.nl"},
+		Translate: bot.ConfigTranslate{Model: "gpt-3.5-turbo"},
+	}, bot.WithMatrix(fm), bot.WithProvider(fp))
+	_, h := b.ResponseHandler()
+
+	for _, tc := range []struct {
+		name      string
+		body      string
+		replyTo   id.EventID
+		expBody   string
+		expThread bool
+	}{
+		{name: "auto", body: "!translate auto en", expBody: "Messages in this room are now translated into English."},
+		{name: "translated", body: "Goedemorgen allemaal", expBody: "en: Good morning, everyone.", expThread: true},
+		{name: "same language", body: "What is the time in Amsterdam?"},
+		{name: "off", body: "!translate off", expBody: "Messages in this room are no longer translated."},
+		{name: "not translated", body: "Goedemorgen allemaal"},
+		{name: "on demand", body: "> <@other:ewintr.nl> Goedemorgen allemaal\n\n!translate en", replyTo: "$other", expBody: "Good morning, everyone."},
+		{name: "unknown language", body: "!translate xx Goedemorgen", expBody: `Error: unknown language "xx", use a code like en or nl`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := len(fm.Messages())
+			evt := testMessage(id.EventID("$"+tc.name), tc.body, tc.replyTo)
+			h(mautrix.EventSourceTimeline, evt)
+
+			msgs := fm.Messages()
+			if tc.expBody == "" {
+				if len(msgs) != before {
+					t.Errorf("exp %v, got %v", before, len(msgs))
+				}
+				return
+			}
+			if len(msgs) != before+1 {
+				t.Fatalf("exp %v, got %v", before+1, len(msgs))
+			}
+			content := msgs[len(msgs)-1].Content.(*event.MessageEventContent)
+			if act := content.Body; act != tc.expBody {
+				t.Errorf("exp %q, got %q", tc.expBody, act)
+			}
+			if act := content.RelatesTo.GetThreadParent() == evt.ID; act != tc.expThread {
+				t.Errorf("exp %v, got %v", tc.expThread, act)
+			}
+		})
+	}
+
+	reqs := fp.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("exp 2, got %v", len(reqs))
+	}
+	if reqs[0].Model != "gpt-3.5-turbo" {
+		t.Errorf("exp gpt-3.5-turbo, got %v", reqs[0].Model)
+	}
+
+	// the automatic translation is on the bot, the one on demand on the
+	// sender
+	users, err := b.UsageByUser(time.Time{})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	act := make(map[string]int)
+	for _, u := range users {
+		act[u.Key] = u.Answers
+	}
+	if act["@bot:ewintr.nl"] != 1 || act["@someone:ewintr.nl"] != 1 {
+		t.Errorf("exp one translation each, got %v", act)
+	}
+}
@@ -0,0 +1,45 @@
+package bot
+
+import (
+	"fmt"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	TrustPolicyAll         = "trust-all"
+	TrustPolicyCrossSigned = "cross-signed-only"
+	TrustPolicyVerified    = "verified-only"
+)
+
+// TrustPolicyState returns the minimum trust state a device must have for the
+// bot to share its session keys with it. An empty policy trusts all devices.
+func TrustPolicyState(policy string) (id.TrustState, error) {
+	switch policy {
+	case "", TrustPolicyAll:
+		return id.TrustStateUnset, nil
+	case TrustPolicyCrossSigned:
+		return id.TrustStateCrossSignedTOFU, nil
+	case TrustPolicyVerified:
+		return id.TrustStateCrossSignedVerified, nil
+	default:
+		return id.TrustStateInvalid, fmt.Errorf("unknown trust policy %q", policy)
+	}
+}
+
+// applyTrustPolicy limits the devices that can read the messages of the bot.
+// Key requests from other devices are never answered below the policy
+// either.
+func (m *Bot) applyTrustPolicy() error {
+	minTrust, err := TrustPolicyState(m.config.TrustPolicy)
+	if err != nil {
+		return err
+	}
+	mach := m.cryptoHelper.Machine()
+	mach.SendKeysMinTrust = minTrust
+	if minTrust > mach.ShareKeysMinTrust {
+		mach.ShareKeysMinTrust = minTrust
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package bot_test
+
+import (
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestTrustPolicyState(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		policy string
+		exp    id.TrustState
+		expErr bool
+	}{
+		{policy: "", exp: id.TrustStateUnset},
+		{policy: bot.TrustPolicyAll, exp: id.TrustStateUnset},
+		{policy: bot.TrustPolicyCrossSigned, exp: id.TrustStateCrossSignedTOFU},
+		{policy: bot.TrustPolicyVerified, exp: id.TrustStateCrossSignedVerified},
+		{policy: "paranoid", exp: id.TrustStateInvalid, expErr: true},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			act, err := bot.TrustPolicyState(tc.policy)
+			if (err != nil) != tc.expErr {
+				t.Fatalf("exp error %v, got %v", tc.expErr, err)
+			}
+			if act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	undecryptableTimeout = 5 * time.Minute
+	undecryptableNotice  = "I couldn't read that, please try again."
+)
+
+// ErrDecryptFailed means something could not be decrypted with the key that
+// was given, like a key backup, a key export or the crypto store.
+var ErrDecryptFailed = errors.New("decryption failed")
+
+// decryptError is called when an event could not be decrypted, after the
+// crypto helper waited a short while for the keys. If the keys are missing,
+// they are requested once more from the sender and the event is handled as
+// usual if they arrive in time. Otherwise, the sender is told, when
+// ReplyUndecryptable is set.
+func (m *Bot) decryptError(evt *event.Event, err error) {
+	m.logger.Warn("failed to decrypt event", slog.String("err", err.Error()), slog.String("event_id", evt.ID.String()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+	if evt.Sender == m.matrix.UserID() {
+		return
+	}
+	if !errors.Is(err, crypto.NoSessionFound) {
+		m.replyUndecryptable(evt)
+		return
+	}
+
+	go func() {
+		content := evt.Content.AsEncrypted()
+		m.cryptoHelper.RequestSession(evt.RoomID, content.SenderKey, content.SessionID, evt.Sender, content.DeviceID)
+		if !m.cryptoHelper.WaitForSession(evt.RoomID, content.SenderKey, content.SessionID, undecryptableTimeout) {
+			m.logger.Warn("keys did not arrive, giving up", slog.String("event_id", evt.ID.String()), slog.String("bot", m.config.UserDisplayName))
+			m.replyUndecryptable(evt)
+			return
+		}
+		decrypted, err := m.cryptoHelper.Decrypt(evt)
+		if err != nil {
+			m.logger.Error("failed to decrypt event after receiving keys", slog.String("err", err.Error()), slog.String("event_id", evt.ID.String()), slog.String("bot", m.config.UserDisplayName))
+			m.replyUndecryptable(evt)
+			return
+		}
+		m.logger.Info("decrypted event after receiving keys", slog.String("event_id", evt.ID.String()), slog.String("bot", m.config.UserDisplayName))
+		m.client.Syncer.(mautrix.DispatchableSyncer).Dispatch(mautrix.EventSourceTimeline|mautrix.EventSourceDecrypted, decrypted)
+	}()
+}
+
+func (m *Bot) replyUndecryptable(evt *event.Event) {
+	if !m.config.ReplyUndecryptable {
+		return
+	}
+	m.sendNotice(evt.RoomID, evt.ID, undecryptableNotice)
+}
@@ -0,0 +1,292 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/util/dbutil"
+)
+
+// UsageMeter adds up the tokens the provider reports for one answer, over
+// all rounds of tool calls.
+type UsageMeter struct {
+	usage openai.Usage
+	mu    sync.Mutex
+}
+
+type usageMeterKey struct{}
+
+// WithUsageMeter adds the meter to the context. Providers add the usage of
+// every request made with it.
+func WithUsageMeter(ctx context.Context, um *UsageMeter) context.Context {
+	return context.WithValue(ctx, usageMeterKey{}, um)
+}
+
+func recordUsage(ctx context.Context, u openai.Usage) {
+	um, ok := ctx.Value(usageMeterKey{}).(*UsageMeter)
+	if !ok {
+		return
+	}
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	um.usage.PromptTokens += u.PromptTokens
+	um.usage.CompletionTokens += u.CompletionTokens
+	um.usage.TotalTokens += u.TotalTokens
+}
+
+func (um *UsageMeter) Usage() openai.Usage {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	return um.usage
+}
+
+// UsageRecord is the usage for answering one message.
+type UsageRecord struct {
+	EventID          id.EventID
+	Conversation     id.EventID
+	RoomID           id.RoomID
+	UserID           id.UserID
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Failed           bool
+	Cost             float64
+	CreatedAt        time.Time
+}
+
+// UsageTotal is the usage of a room, a user or a conversation added up.
+// Answers counts the answers the provider gave, Failed the ones it failed
+// to give. Cost is in dollars, estimated with the prices at the time.
+type UsageTotal struct {
+	Key              string
+	Answers          int
+	Failed           int
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+}
+
+func (ut UsageTotal) TotalTokens() int {
+	return ut.PromptTokens + ut.CompletionTokens
+}
+
+// UsageFilter selects the usage of a room or a user, or both, since a
+// time. The zero filter selects everything.
+type UsageFilter struct {
+	RoomID id.RoomID
+	UserID id.UserID
+	Since  time.Time
+}
+
+// usagePeriod is a period !usage reports on, from its start until now.
+type usagePeriod struct {
+	name  string
+	start time.Time
+}
+
+// usagePeriods returns today, this week and this month, in the time zone
+// of the location.
+func usagePeriods(now time.Time, loc *time.Location) []usagePeriod {
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	// weeks start on monday
+	week := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+
+	return []usagePeriod{{"today", today}, {"this week", week}, {"this month", month}}
+}
+
+// usageScope is whose usage !usage reports on.
+type usageScope struct {
+	title  string
+	filter UsageFilter
+}
+
+func (m *Bot) usageCommand() Command {
+	return Command{
+		Name: "usage",
+		Help: "show the tokens you used and what they cost, and for admins those of this room and all rooms",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			scopes := []usageScope{{"Your usage", UsageFilter{UserID: evt.Sender}}}
+			if m.isAdmin(evt.Sender) {
+				scopes = append(scopes, usageScope{"This room", UsageFilter{RoomID: evt.RoomID}}, usageScope{"All rooms", UsageFilter{}})
+			}
+
+			periods := usagePeriods(time.Now(), m.userLocation(evt.Sender))
+			var sections []string
+			unknown := map[string]bool{}
+			for _, scope := range scopes {
+				lines := []string{scope.title + ":"}
+				for _, period := range periods {
+					f := scope.filter
+					f.Since = period.start
+					perModel, err := m.store.ModelUsage(f)
+					if err != nil {
+						return "", err
+					}
+					var tokens int
+					for _, ut := range perModel {
+						tokens += ut.TotalTokens()
+					}
+					dollars, missing := m.cost(perModel)
+					for _, model := range missing {
+						unknown[model] = true
+					}
+					lines = append(lines, fmt.Sprintf("- %s: %d tokens, about $%.2f", period.name, tokens, dollars))
+				}
+				sections = append(sections, strings.Join(lines, "\n"))
+			}
+			quota, err := m.quotaLine(evt.Sender)
+			if err != nil {
+				return "", err
+			}
+			if quota != "" {
+				sections[0] += "\n\n" + quota
+			}
+			if len(unknown) > 0 {
+				models := make([]string, 0, len(unknown))
+				for model := range unknown {
+					models = append(models, model)
+				}
+				sort.Strings(models)
+				sections = append(sections, fmt.Sprintf("No price is known for %s, so it is not in the costs.", strings.Join(models, ", ")))
+			}
+
+			return strings.Join(sections, "\n\n"), nil
+		},
+	}
+}
+
+// saveUsage stores what answering the event used, also when the provider
+// failed to answer.
+func (m *Bot) saveUsage(evt *event.Event, model string, conv *Conversation, um *UsageMeter, failed bool) {
+	u := um.Usage()
+	if u.PromptTokens == 0 && u.CompletionTokens == 0 && !failed {
+		return
+	}
+	model = modelOrDefault(model)
+	if err := m.store.AddUsage(UsageRecord{
+		EventID:          evt.ID,
+		Conversation:     conv.Root(),
+		RoomID:           evt.RoomID,
+		UserID:           evt.Sender,
+		Model:            model,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		Failed:           failed,
+		Cost:             m.estimate(model, u.PromptTokens, u.CompletionTokens),
+		CreatedAt:        time.Now(),
+	}); err != nil {
+		m.logger.Error("failed to store usage", slog.String("err", err.Error()), slog.String("event_id", evt.ID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+}
+
+// botEvent stands in for the event that caused usage nobody asked for, like
+// digests, feed summaries and automatic translations. It is recorded for the
+// room and the bot itself.
+func (m *Bot) botEvent(roomID id.RoomID) *event.Event {
+	return &event.Event{RoomID: roomID, Sender: id.UserID(m.config.UserID)}
+}
+
+// UsageByRoom returns the usage per room since the given time, the room that
+// used most first.
+func (m *Bot) UsageByRoom(since time.Time) ([]UsageTotal, error) {
+	return m.store.UsageBy("room_id", since)
+}
+
+// UsageByUser returns the usage per user since the given time, the user that
+// used most first.
+func (m *Bot) UsageByUser(since time.Time) ([]UsageTotal, error) {
+	return m.store.UsageBy("user_id", since)
+}
+
+// ConversationUsage returns the usage of the conversation that started with
+// the root event.
+func (m *Bot) ConversationUsage(root id.EventID) (UsageTotal, error) {
+	return m.store.ConversationUsage(root)
+}
+
+func (s *Store) AddUsage(r UsageRecord) error {
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_usage (event_id, conversation, room_id, user_id, model, prompt_tokens, completion_tokens, failed, cost, day, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		r.EventID, r.Conversation, r.RoomID, r.UserID, r.Model, r.PromptTokens, r.CompletionTokens, r.Failed, r.Cost,
+		r.CreatedAt.UTC().Format(time.DateOnly), r.CreatedAt.Unix())
+
+	return err
+}
+
+// usageSums are the columns that add up usage records to a UsageTotal.
+const usageSums = `COALESCE(SUM(CASE WHEN failed THEN 0 ELSE 1 END), 0), COALESCE(SUM(CASE WHEN failed THEN 1 ELSE 0 END), 0),
+	COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0), COALESCE(SUM(cost), 0)`
+
+// UsageBy adds up the usage since the given time per value of the column,
+// which is room_id, user_id or model.
+func (s *Store) UsageBy(column string, since time.Time) ([]UsageTotal, error) {
+	switch column {
+	case "room_id", "user_id", "model":
+	default:
+		return nil, fmt.Errorf("can not group usage by %q", column)
+	}
+	rows, err := s.db.QueryContext(s.context(), fmt.Sprintf(`SELECT %[1]s, %[2]s
+		FROM bot_usage WHERE created_at >= $1
+		GROUP BY %[1]s ORDER BY SUM(prompt_tokens) + SUM(completion_tokens) DESC, %[1]s`, column, usageSums), since.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	return scanUsageTotals(rows)
+}
+
+// ModelUsage adds up the usage that matches the filter per model.
+func (s *Store) ModelUsage(f UsageFilter) ([]UsageTotal, error) {
+	where := []string{"created_at >= $1"}
+	params := []any{f.Since.Unix()}
+	if f.RoomID != "" {
+		params = append(params, f.RoomID)
+		where = append(where, fmt.Sprintf("room_id = $%d", len(params)))
+	}
+	if f.UserID != "" {
+		params = append(params, f.UserID)
+		where = append(where, fmt.Sprintf("user_id = $%d", len(params)))
+	}
+	rows, err := s.db.QueryContext(s.context(), `SELECT model, `+usageSums+`
+		FROM bot_usage WHERE `+strings.Join(where, " AND ")+`
+		GROUP BY model ORDER BY model`, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanUsageTotals(rows)
+}
+
+func (s *Store) ConversationUsage(root id.EventID) (UsageTotal, error) {
+	ut := UsageTotal{Key: root.String()}
+	err := s.db.QueryRowContext(s.context(), `SELECT `+usageSums+`
+		FROM bot_usage WHERE conversation = $1`, root).Scan(&ut.Answers, &ut.Failed, &ut.PromptTokens, &ut.CompletionTokens, &ut.Cost)
+
+	return ut, err
+}
+
+func scanUsageTotals(rows dbutil.Rows) ([]UsageTotal, error) {
+	defer rows.Close()
+
+	var totals []UsageTotal
+	for rows.Next() {
+		var ut UsageTotal
+		if err := rows.Scan(&ut.Key, &ut.Answers, &ut.Failed, &ut.PromptTokens, &ut.CompletionTokens, &ut.Cost); err != nil {
+			return nil, err
+		}
+		totals = append(totals, ut)
+	}
+
+	return totals, rows.Err()
+}
@@ -0,0 +1,207 @@
+package bot_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestUsage(t *testing.T) {
+	t.Parallel()
+
+	fm := bot.NewFakeMatrix()
+	fp := bot.NewFakeProvider()
+	b := newTestBot(t, bot.ConfigBot{
+		UserID:            "@bot:ewintr.nl",
+		AnswerUnaddressed: true,
+	}, bot.WithMatrix(fm), bot.WithProvider(fp))
+	_, h := b.ResponseHandler()
+
+	// a conversation of two users in one room, and a question in another
+	h(mautrix.EventSourceTimeline, testMessage("$q1", "What is the weather?", ""))
+	reply := testMessage("$q2", "And tomorrow?", fm.Messages()[0].EventID)
+	reply.Sender = "@other:ewintr.nl"
+	h(mautrix.EventSourceTimeline, reply)
+	other := testMessage("$q3", "Hello", "")
+	other.Sender, other.RoomID = "@other:ewintr.nl", "!other:ewintr.nl"
+	h(mautrix.EventSourceTimeline, other)
+
+	rooms, err := b.UsageByRoom(time.Time{})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if len(rooms) != 2 {
+		t.Fatalf("exp 2, got %v", len(rooms))
+	}
+	if rooms[0].Key != "!room:ewintr.nl" {
+		t.Errorf("exp !room:ewintr.nl, got %v", rooms[0].Key)
+	}
+	if exp, act := fp.Usage().TotalTokens, rooms[0].TotalTokens()+rooms[1].TotalTokens(); exp != act {
+		t.Errorf("exp %v, got %v", exp, act)
+	}
+
+	users, err := b.UsageByUser(time.Time{})
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	exp := map[string]int{"@someone:ewintr.nl": 1, "@other:ewintr.nl": 2}
+	if len(users) != len(exp) {
+		t.Fatalf("exp %v, got %v", len(exp), len(users))
+	}
+	for _, ut := range users {
+		if ut.Answers != exp[ut.Key] {
+			t.Errorf("exp %v, got %v", exp[ut.Key], ut.Answers)
+		}
+	}
+
+	conv, err := b.ConversationUsage("$q1")
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if conv.Answers != 2 {
+		t.Errorf("exp 2, got %v", conv.Answers)
+	}
+	if conv.TotalTokens() != rooms[0].TotalTokens() {
+		t.Errorf("exp %v, got %v", rooms[0].TotalTokens(), conv.TotalTokens())
+	}
+
+	future, err := b.UsageByRoom(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("exp nil, got %v", err)
+	}
+	if len(future) != 0 {
+		t.Errorf("exp 0, got %v", len(future))
+	}
+}
+
+func TestUsageCommand(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name     string
+		model    string
+		sender   id.UserID
+		exp      []string
+		expNotIn []string
+	}{
+		{
+			name:     "user",
+			sender:   "@someone:ewintr.nl",
+			exp:      []string{"Your usage:", "today: 3 tokens, about $0.03", "this month: 3 tokens, about $0.03"},
+			expNotIn: []string{"This room:", "All rooms:"},
+		},
+		{
+			name:   "admin",
+			sender: "@admin:ewintr.nl",
+			exp:    []string{"today: 0 tokens, about $0.00", "This room:", "today: 3 tokens, about $0.03", "All rooms:"},
+		},
+		{
+			name:   "unknown model",
+			model:  "local-llama",
+			sender: "@someone:ewintr.nl",
+			exp:    []string{"today: 3 tokens, about $0.00", "No price is known for local-llama"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fm := bot.NewFakeMatrix()
+			b := newTestBot(t, bot.ConfigBot{
+				UserID:            "@bot:ewintr.nl",
+				SystemPrompt:      "Help.",
+				Model:             tc.model,
+				AnswerUnaddressed: true,
+				Admins:            []string{"@admin:ewintr.nl"},
+				Prices:            []bot.ConfigPrice{{Model: "gpt-4", Prompt: 10000, Completion: 10000}},
+			}, bot.WithMatrix(fm))
+			_, h := b.ResponseHandler()
+
+			h(mautrix.EventSourceTimeline, testMessage("$q1", "Hello", ""))
+			cmd := testMessage("$q2", "!usage", "")
+			cmd.Sender = tc.sender
+			h(mautrix.EventSourceTimeline, cmd)
+
+			msgs := fm.Messages()
+			if len(msgs) != 2 {
+				t.Fatalf("exp 2, got %v", len(msgs))
+			}
+			act := msgs[1].Content.(*event.MessageEventContent).Body
+			for _, exp := range tc.exp {
+				if !strings.Contains(act, exp) {
+					t.Errorf("exp %q in %v", exp, act)
+				}
+			}
+			for _, exp := range tc.expNotIn {
+				if strings.Contains(act, exp) {
+					t.Errorf("exp no %q in %v", exp, act)
+				}
+			}
+		})
+	}
+}
+
+func TestStore_ModelUsage(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	now := time.Now()
+	for _, r := range []bot.UsageRecord{
+		{EventID: "$1", RoomID: "!a:ewintr.nl", UserID: "@one:ewintr.nl", Model: "gpt-4", PromptTokens: 10, CompletionTokens: 5, Cost: 0.5, CreatedAt: now},
+		{EventID: "$2", RoomID: "!b:ewintr.nl", UserID: "@one:ewintr.nl", Model: "gpt-4", PromptTokens: 20, CompletionTokens: 5, Cost: 1, CreatedAt: now},
+		{EventID: "$3", RoomID: "!a:ewintr.nl", UserID: "@two:ewintr.nl", Model: "gpt-3.5-turbo", Failed: true, CreatedAt: now},
+		{EventID: "$4", RoomID: "!a:ewintr.nl", UserID: "@two:ewintr.nl", Model: "gpt-4", PromptTokens: 100, Cost: 3, CreatedAt: now.Add(-48 * time.Hour)},
+	} {
+		if err := store.AddUsage(r); err != nil {
+			t.Fatalf("exp nil, got %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		filter bot.UsageFilter
+		exp    []bot.UsageTotal
+	}{
+		{
+			name:   "all",
+			filter: bot.UsageFilter{Since: now.Add(-time.Hour)},
+			exp: []bot.UsageTotal{
+				{Key: "gpt-3.5-turbo", Failed: 1},
+				{Key: "gpt-4", Answers: 2, PromptTokens: 30, CompletionTokens: 10, Cost: 1.5},
+			},
+		},
+		{
+			name:   "room",
+			filter: bot.UsageFilter{RoomID: "!a:ewintr.nl", Since: now.Add(-time.Hour)},
+			exp: []bot.UsageTotal{
+				{Key: "gpt-3.5-turbo", Failed: 1},
+				{Key: "gpt-4", Answers: 1, PromptTokens: 10, CompletionTokens: 5, Cost: 0.5},
+			},
+		},
+		{
+			name:   "user, all time",
+			filter: bot.UsageFilter{UserID: "@two:ewintr.nl"},
+			exp: []bot.UsageTotal{
+				{Key: "gpt-3.5-turbo", Failed: 1},
+				{Key: "gpt-4", Answers: 1, PromptTokens: 100, Cost: 3},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := store.ModelUsage(tc.filter)
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			if len(act) != len(tc.exp) {
+				t.Fatalf("exp %v, got %v", tc.exp, act)
+			}
+			for i := range tc.exp {
+				if act[i] != tc.exp[i] {
+					t.Errorf("exp %v, got %v", tc.exp[i], act[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package bot
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const inboundUsagePath = "/usage"
+
+var usageCSVHeader = []string{"bot", "day", "created_at", "room_id", "user_id", "conversation", "event_id", "model", "prompt_tokens", "completion_tokens", "failed", "cost"}
+
+// UsageRecords returns the usage of the days from and to, in UTC, oldest
+// first.
+func (m *Bot) UsageRecords(from, to time.Time) ([]UsageRecord, error) {
+	return m.store.UsageRecords(from, to)
+}
+
+// EnableUsageExport makes the usage of all bots available as CSV with a GET
+// on /usage, for the days from and to in the query, like
+// /usage?from=2024-05-01&to=2024-05-31. Without them, it is the current
+// month. The token must be passed in the Authorization header as
+// "Bearer <token>".
+func (s *InboundServer) EnableUsageExport(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.usageToken = token
+}
+
+func (s *InboundServer) serveUsage(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	token, bots := s.usageToken, append([]*Bot(nil), s.bots...)
+	s.mu.RUnlock()
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	from, to, err := usageRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var rows [][]string
+	for _, b := range bots {
+		records, err := b.UsageRecords(from, to)
+		if err != nil {
+			http.Error(w, "failed to read usage", http.StatusInternalServerError)
+			return
+		}
+		for _, rec := range records {
+			rows = append(rows, []string{
+				b.config.UserID,
+				rec.CreatedAt.UTC().Format(time.DateOnly),
+				rec.CreatedAt.UTC().Format(time.RFC3339),
+				rec.RoomID.String(),
+				rec.UserID.String(),
+				rec.Conversation.String(),
+				rec.EventID.String(),
+				rec.Model,
+				strconv.Itoa(rec.PromptTokens),
+				strconv.Itoa(rec.CompletionTokens),
+				strconv.FormatBool(rec.Failed),
+				strconv.FormatFloat(rec.Cost, 'f', 6, 64),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s-%s.csv"`, from.Format(time.DateOnly), to.Format(time.DateOnly)))
+	cw := csv.NewWriter(w)
+	cw.Write(usageCSVHeader)
+	cw.WriteAll(rows)
+}
+
+// usageRange parses the days of the export. Without them, it is the current
+// month until today.
+func usageRange(from, to string, now time.Time) (time.Time, time.Time, error) {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	var err error
+	if from != "" {
+		if start, err = time.Parse(time.DateOnly, from); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from day %q, use a day like 2024-05-01", from)
+		}
+	}
+	if to != "" {
+		if end, err = time.Parse(time.DateOnly, to); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to day %q, use a day like 2024-05-31", to)
+		}
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to is before from")
+	}
+
+	return start, end, nil
+}
+
+// UsageRecords returns the records of the days from and to, in UTC, oldest
+// first.
+func (s *Store) UsageRecords(from, to time.Time) ([]UsageRecord, error) {
+	rows, err := s.db.QueryContext(s.context(), `SELECT event_id, conversation, room_id, user_id, model, prompt_tokens, completion_tokens, failed, cost, created_at
+		FROM bot_usage WHERE day >= $1 AND day <= $2 ORDER BY created_at, id`, from.UTC().Format(time.DateOnly), to.UTC().Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []UsageRecord
+	for rows.Next() {
+		var (
+			r                             UsageRecord
+			eventID, conversation, roomID string
+			userID                        string
+			createdAt                     int64
+		)
+		if err := rows.Scan(&eventID, &conversation, &roomID, &userID, &r.Model, &r.PromptTokens, &r.CompletionTokens, &r.Failed, &r.Cost, &createdAt); err != nil {
+			return nil, err
+		}
+		r.EventID, r.Conversation, r.RoomID, r.UserID = id.EventID(eventID), id.EventID(conversation), id.RoomID(roomID), id.UserID(userID)
+		r.CreatedAt = time.Unix(createdAt, 0)
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
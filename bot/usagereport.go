@@ -0,0 +1,157 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/id"
+)
+
+const usageReportTop = 5
+
+// ConfigUsageReport posts a report of the usage of the last Period, "day"
+// or "week", in the admin room at the times in Cron, like "0 9 * * 1" for
+// every Monday morning. Without Period, it is a day.
+type ConfigUsageReport struct {
+	Cron   string
+	Period string
+}
+
+func usageReportPeriod(period string) (time.Duration, error) {
+	switch period {
+	case "", "day":
+		return 24 * time.Hour, nil
+	case "week":
+		return 7 * 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("unknown usage report period %q, use day or week", period)
+	}
+}
+
+func (m *Bot) loadUsageReports() error {
+	for _, rc := range m.config.UsageReports {
+		period := rc.Period
+		if _, err := usageReportPeriod(period); err != nil {
+			return err
+		}
+		if m.config.AdminRoom == "" {
+			return fmt.Errorf("usage reports need an admin room")
+		}
+		if err := m.scheduler.Add(0, rc.Cron, func() {
+			if err := m.PostUsageReport(period); err != nil {
+				m.logger.Error("failed to post usage report", slog.String("err", err.Error()), slog.String("bot", m.config.UserDisplayName))
+			}
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PostUsageReport posts the report of the last day or week in the admin
+// room, like the scheduled reports.
+func (m *Bot) PostUsageReport(period string) error {
+	adminRoom, err := m.ResolveRoom(m.config.AdminRoom)
+	if err != nil {
+		return err
+	}
+	report, err := m.usageReport(period, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return m.SendMarkdown(adminRoom, report)
+}
+
+// usageReport lists the totals, the rooms and users that used most, and the
+// costs per model.
+func (m *Bot) usageReport(period string, now time.Time) (string, error) {
+	d, err := usageReportPeriod(period)
+	if err != nil {
+		return "", err
+	}
+	if period == "" {
+		period = "day"
+	}
+	since := now.Add(-d)
+	perModel, err := m.store.UsageBy("model", since)
+	if err != nil {
+		return "", err
+	}
+	rooms, err := m.store.UsageBy("room_id", since)
+	if err != nil {
+		return "", err
+	}
+	users, err := m.store.UsageBy("user_id", since)
+	if err != nil {
+		return "", err
+	}
+
+	var total UsageTotal
+	for _, ut := range perModel {
+		total.Answers += ut.Answers
+		total.Failed += ut.Failed
+		total.PromptTokens += ut.PromptTokens
+		total.CompletionTokens += ut.CompletionTokens
+	}
+	dollars, _ := m.cost(perModel)
+	lines := []string{
+		fmt.Sprintf("**Usage of the last %s**", period),
+		"",
+		fmt.Sprintf("- %d answers, %d failed (%s)", total.Answers, total.Failed, failureRate(total)),
+		fmt.Sprintf("- %d tokens, %d in prompts and %d in completions", total.TotalTokens(), total.PromptTokens, total.CompletionTokens),
+		fmt.Sprintf("- about $%.2f", dollars),
+	}
+	if len(perModel) == 0 {
+		return strings.Join(lines, "\n"), nil
+	}
+
+	lines = append(lines, "", "**Rooms**", "")
+	for _, ut := range topUsage(rooms) {
+		lines = append(lines, fmt.Sprintf("- %s: %d tokens, %d answers", m.roomName(id.RoomID(ut.Key)), ut.TotalTokens(), ut.Answers))
+	}
+	lines = append(lines, "", "**Users**", "")
+	for _, ut := range topUsage(users) {
+		lines = append(lines, fmt.Sprintf("- %s: %d tokens, %d answers", ut.Key, ut.TotalTokens(), ut.Answers))
+	}
+	lines = append(lines, "", "**Models**", "")
+	for _, ut := range perModel {
+		cost := "no price"
+		if dollars, unknown := m.cost([]UsageTotal{ut}); len(unknown) == 0 {
+			cost = fmt.Sprintf("about $%.2f", dollars)
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %d tokens, %s, %d failed (%s)", ut.Key, ut.TotalTokens(), cost, ut.Failed, failureRate(ut)))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// topUsage returns the rooms or users that used most, which come first.
+func topUsage(totals []UsageTotal) []UsageTotal {
+	if len(totals) > usageReportTop {
+		return totals[:usageReportTop]
+	}
+
+	return totals
+}
+
+func failureRate(ut UsageTotal) string {
+	if ut.Answers+ut.Failed == 0 {
+		return "0%"
+	}
+
+	return fmt.Sprintf("%.0f%%", 100*float64(ut.Failed)/float64(ut.Answers+ut.Failed))
+}
+
+// roomName is the name of the room from the room cache, with its ID.
+func (m *Bot) roomName(roomID id.RoomID) string {
+	room, ok, err := m.store.Room(roomID)
+	if err != nil || !ok || room.Name == "" {
+		return roomID.String()
+	}
+
+	return fmt.Sprintf("%s (%s)", room.Name, roomID)
+}
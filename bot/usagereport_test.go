@@ -0,0 +1,85 @@
+package bot_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestPostUsageReport(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name      string
+		period    string
+		expErr    bool
+		expReport []string
+	}{
+		{
+			name:   "day",
+			period: "day",
+			expReport: []string{
+				"Usage of the last day",
+				"1 answers, 1 failed (50%)",
+				"about $0.03",
+				"!room:ewintr.nl: 3 tokens, 1 answers",
+				"@someone:ewintr.nl: 3 tokens, 1 answers",
+				"gpt-4: 3 tokens, about $0.03, 1 failed (50%)",
+			},
+		},
+		{
+			name:      "week",
+			period:    "week",
+			expReport: []string{"Usage of the last week"},
+		},
+		{
+			name:   "unknown period",
+			period: "year",
+			expErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fm := bot.NewFakeMatrix()
+			fp := bot.NewFakeProvider()
+			b := newTestBot(t, bot.ConfigBot{
+				UserID:            "@bot:ewintr.nl",
+				SystemPrompt:      "Help.",
+				AnswerUnaddressed: true,
+				AdminRoom:         "!admin:ewintr.nl",
+				Prices:            []bot.ConfigPrice{{Model: "gpt-4", Prompt: 10000, Completion: 10000}},
+			}, bot.WithMatrix(fm), bot.WithProvider(fp))
+			_, h := b.ResponseHandler()
+
+			// one answer and one failure
+			h(mautrix.EventSourceTimeline, testMessage("$q1", "Hello", ""))
+			fp.FailWith(fmt.Errorf("down"))
+			h(mautrix.EventSourceTimeline, testMessage("$q2", "Hello?", ""))
+
+			err := b.PostUsageReport(tc.period)
+			if tc.expErr {
+				if err == nil {
+					t.Errorf("exp error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			msgs := fm.Messages()
+			last := msgs[len(msgs)-1]
+			if last.RoomID != "!admin:ewintr.nl" {
+				t.Fatalf("exp !admin:ewintr.nl, got %v", last.RoomID)
+			}
+			report := last.Content.(*event.MessageEventContent).Body
+			for _, exp := range tc.expReport {
+				if !strings.Contains(report, exp) {
+					t.Errorf("exp %q in %v", exp, report)
+				}
+			}
+		})
+	}
+}
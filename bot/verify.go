@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Verifier confirms the short authentication string (SAS) of an interactive
+// device verification. It is asked whether the emoji or numbers shown match
+// the ones on the other device.
+type Verifier interface {
+	ConfirmSAS(b *Bot, device *id.Device, sas string) bool
+}
+
+// SetVerifier lets v confirm verification requests from anyone. Without a
+// verifier, only requests from the users in VerifyFrom are accepted.
+func (m *Bot) SetVerifier(v Verifier) {
+	m.verifyMu.Lock()
+	defer m.verifyMu.Unlock()
+
+	m.verifier = v
+}
+
+// acceptVerification decides on incoming verification requests. Requests
+// from VerifyFrom are confirmed automatically, other requests are handed to
+// the verifier.
+func (m *Bot) acceptVerification(txnID string, device *id.Device, roomID id.RoomID) (crypto.VerificationRequestResponse, crypto.VerificationHooks) {
+	m.verifyMu.Lock()
+	v := m.verifier
+	m.verifyMu.Unlock()
+
+	auto := contains(m.config.VerifyFrom, device.UserID.String())
+	if !auto && v == nil {
+		m.logger.Info("rejected verification request", slog.String("user_id", device.UserID.String()), slog.String("device_id", device.DeviceID.String()), slog.String("bot", m.config.UserDisplayName))
+		return crypto.RejectRequest, nil
+	}
+	m.logger.Info("accepted verification request", slog.String("user_id", device.UserID.String()), slog.String("device_id", device.DeviceID.String()), slog.String("bot", m.config.UserDisplayName))
+
+	return crypto.AcceptRequest, &verificationHooks{bot: m, verifier: v, auto: auto}
+}
+
+type verificationHooks struct {
+	bot      *Bot
+	verifier Verifier
+	auto     bool
+}
+
+func (h *verificationHooks) VerifySASMatch(device *id.Device, data crypto.SASData) bool {
+	sas := FormatSAS(data)
+	h.bot.logger.Info("verification code", slog.String("sas", sas), slog.String("user_id", device.UserID.String()), slog.String("device_id", device.DeviceID.String()), slog.String("bot", h.bot.config.UserDisplayName))
+	if h.auto {
+		return true
+	}
+
+	return h.verifier.ConfirmSAS(h.bot, device, sas)
+}
+
+func (h *verificationHooks) VerificationMethods() []crypto.VerificationMethod {
+	return []crypto.VerificationMethod{
+		crypto.VerificationMethodEmoji{},
+		crypto.VerificationMethodDecimal{},
+	}
+}
+
+func (h *verificationHooks) OnCancel(cancelledByUs bool, reason string, code event.VerificationCancelCode) {
+	h.bot.logger.Info("verification cancelled", slog.String("reason", reason), slog.Bool("by_us", cancelledByUs), slog.String("bot", h.bot.config.UserDisplayName))
+}
+
+func (h *verificationHooks) OnSuccess() {
+	h.bot.logger.Info("verification succeeded", slog.String("bot", h.bot.config.UserDisplayName))
+}
+
+// FormatSAS renders the emoji or numbers of a SAS for display.
+func FormatSAS(data crypto.SASData) string {
+	switch sas := data.(type) {
+	case crypto.EmojiSASData:
+		parts := make([]string, 0, len(sas))
+		for _, e := range sas {
+			parts = append(parts, fmt.Sprintf("%c %s", e.Emoji, e.Description))
+		}
+		return strings.Join(parts, ", ")
+	case crypto.DecimalSASData:
+		return fmt.Sprintf("%d %d %d", sas[0], sas[1], sas[2])
+	default:
+		return fmt.Sprintf("%v", data)
+	}
+}
+
+// InRoomVerificationHandler passes verifications that are done in a room,
+// instead of with to-device events, to the crypto machine. Other clients use
+// these when verifying a user instead of one of their own devices.
+func (m *Bot) InRoomVerificationHandler() mautrix.EventHandler {
+	return func(source mautrix.EventSource, evt *event.Event) {
+		if evt.Type == event.EventMessage && evt.Content.AsMessage().MsgType != event.MsgVerificationRequest {
+			return
+		}
+		if err := m.cryptoHelper.Machine().ProcessInRoomVerification(evt); err != nil {
+			m.logger.Error("failed to process verification", slog.String("err", err.Error()), slog.String("room_id", evt.RoomID.String()), slog.String("bot", m.config.UserDisplayName))
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package bot_test
+
+import (
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix/crypto"
+)
+
+func TestFormatSAS(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		data crypto.SASData
+		exp  string
+	}{
+		{
+			name: "decimal",
+			data: crypto.DecimalSASData{1234, 5678, 9012},
+			exp:  "1234 5678 9012",
+		},
+		{
+			name: "emoji",
+			data: crypto.EmojiSASData{
+				{Emoji: '🐶', Description: "Dog"},
+				{Emoji: '🐱', Description: "Cat"},
+				{Emoji: '🦁', Description: "Lion"},
+				{Emoji: '🐎', Description: "Horse"},
+				{Emoji: '🦄', Description: "Unicorn"},
+				{Emoji: '🐷', Description: "Pig"},
+				{Emoji: '🐘', Description: "Elephant"},
+			},
+			exp: "🐶 Dog, 🐱 Cat, 🦁 Lion, 🐎 Horse, 🦄 Unicorn, 🐷 Pig, 🐘 Elephant",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if act := bot.FormatSAS(tc.data); act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	weatherGeocodeURL  = "https://geocoding-api.open-meteo.com/v1/search"
+	weatherForecastURL = "https://api.open-meteo.com/v1/forecast"
+	weatherTimeout     = 15 * time.Second
+	weatherMaxBody     = 1 << 20
+)
+
+// weatherCodes describe the WMO weather codes that Open-Meteo uses.
+var weatherCodes = map[int]string{
+	0: "clear sky", 1: "mainly clear", 2: "partly cloudy", 3: "overcast",
+	45: "fog", 48: "freezing fog",
+	51: "light drizzle", 53: "drizzle", 55: "dense drizzle", 56: "freezing drizzle", 57: "dense freezing drizzle",
+	61: "light rain", 63: "rain", 65: "heavy rain", 66: "freezing rain", 67: "heavy freezing rain",
+	71: "light snow", 73: "snow", 75: "heavy snow", 77: "snow grains",
+	80: "light showers", 81: "showers", 82: "violent showers", 85: "snow showers", 86: "heavy snow showers",
+	95: "thunderstorm", 96: "thunderstorm with hail", 99: "thunderstorm with heavy hail",
+}
+
+type Place struct {
+	Name      string  `json:"name"`
+	Country   string  `json:"country"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type Forecast struct {
+	Current struct {
+		Temperature float64 `json:"temperature_2m"`
+		Humidity    float64 `json:"relative_humidity_2m"`
+		WindSpeed   float64 `json:"wind_speed_10m"`
+		WeatherCode int     `json:"weather_code"`
+	} `json:"current"`
+	Daily struct {
+		Time          []string  `json:"time"`
+		WeatherCode   []int     `json:"weather_code"`
+		TempMax       []float64 `json:"temperature_2m_max"`
+		TempMin       []float64 `json:"temperature_2m_min"`
+		Precipitation []float64 `json:"precipitation_sum"`
+	} `json:"daily"`
+}
+
+// WeatherTool looks up the weather with Open-Meteo, which needs no key.
+// Without a location it uses the home place of the user, when home is set.
+type WeatherTool struct {
+	geocodeURL  string
+	forecastURL string
+	client      *http.Client
+	home        func(userID id.UserID) (Place, bool)
+}
+
+// NewWeatherTool creates the tool. Empty URLs mean the public Open-Meteo
+// APIs.
+func NewWeatherTool(geocodeURL, forecastURL string) *WeatherTool {
+	if geocodeURL == "" {
+		geocodeURL = weatherGeocodeURL
+	}
+	if forecastURL == "" {
+		forecastURL = weatherForecastURL
+	}
+
+	return &WeatherTool{
+		geocodeURL:  geocodeURL,
+		forecastURL: forecastURL,
+		client:      &http.Client{Timeout: weatherTimeout},
+	}
+}
+
+func (w *WeatherTool) Name() string { return "weather" }
+
+func (w *WeatherTool) Description() string {
+	return "Get the current weather and the forecast for the next days in a place. Leave out the location for the home place of the user. Ask the user where, if it is not clear."
+}
+
+func (w *WeatherTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"location":{"type":"string","description":"the name of a city or town, like Amsterdam"}}}`)
+}
+
+func (w *WeatherTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	var a struct {
+		Location string `json:"location"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return "", err
+	}
+	if caller, ok := CallerFromContext(ctx); ok && strings.TrimSpace(a.Location) == "" && w.home != nil {
+		if place, ok := w.home(caller.UserID); ok {
+			return w.WeatherAt(ctx, place)
+		}
+	}
+
+	return w.Weather(ctx, a.Location)
+}
+
+// Weather returns the current weather and a three day forecast for the place
+// as text.
+func (w *WeatherTool) Weather(ctx context.Context, location string) (string, error) {
+	place, err := w.Geocode(ctx, location)
+	if err != nil {
+		return "", err
+	}
+
+	return w.WeatherAt(ctx, place)
+}
+
+// WeatherAt is Weather for a place that is already known.
+func (w *WeatherTool) WeatherAt(ctx context.Context, place Place) (string, error) {
+	forecast, err := w.Forecast(ctx, place)
+	if err != nil {
+		return "", err
+	}
+
+	return FormatWeather(place, forecast), nil
+}
+
+// Geocode finds the place with the given name.
+func (w *WeatherTool) Geocode(ctx context.Context, name string) (Place, error) {
+	if strings.TrimSpace(name) == "" {
+		return Place{}, fmt.Errorf("no location given")
+	}
+	q := url.Values{"name": {name}, "count": {"1"}, "format": {"json"}}
+	var res struct {
+		Results []Place `json:"results"`
+	}
+	if err := w.get(ctx, w.geocodeURL+"?"+q.Encode(), &res); err != nil {
+		return Place{}, err
+	}
+	if len(res.Results) == 0 {
+		return Place{}, fmt.Errorf("could not find %s", name)
+	}
+
+	return res.Results[0], nil
+}
+
+func (w *WeatherTool) Forecast(ctx context.Context, place Place) (Forecast, error) {
+	q := url.Values{
+		"latitude":      {fmt.Sprint(place.Latitude)},
+		"longitude":     {fmt.Sprint(place.Longitude)},
+		"current":       {"temperature_2m,relative_humidity_2m,wind_speed_10m,weather_code"},
+		"daily":         {"weather_code,temperature_2m_max,temperature_2m_min,precipitation_sum"},
+		"timezone":      {"auto"},
+		"forecast_days": {"3"},
+	}
+	var forecast Forecast
+	err := w.get(ctx, w.forecastURL+"?"+q.Encode(), &forecast)
+
+	return forecast, err
+}
+
+func (w *WeatherTool) get(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("weather service returned status %d", res.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, weatherMaxBody)).Decode(v)
+}
+
+// FormatWeather renders the forecast for the place, a line for now and one
+// per day.
+func FormatWeather(place Place, f Forecast) string {
+	name := place.Name
+	if place.Country != "" {
+		name += ", " + place.Country
+	}
+	lines := []string{fmt.Sprintf("**%s**: %.0f°C, %s, wind %.0f km/h, humidity %.0f%%",
+		name, f.Current.Temperature, weatherCodes[f.Current.WeatherCode], f.Current.WindSpeed, f.Current.Humidity)}
+	d := f.Daily
+	for i := range d.Time {
+		if i >= len(d.WeatherCode) || i >= len(d.TempMin) || i >= len(d.TempMax) || i >= len(d.Precipitation) {
+			break
+		}
+		day := d.Time[i]
+		if t, err := time.Parse("2006-01-02", day); err == nil {
+			day = t.Format("Mon 2 Jan")
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %.0f to %.0f°C, %s, %.1f mm", day, d.TempMin[i], d.TempMax[i], weatherCodes[d.WeatherCode[i]], d.Precipitation[i]))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (m *Bot) weatherCommand() Command {
+	return Command{
+		Name:  "weather",
+		Usage: "[place]|home <place>",
+		Help:  "show the weather in a place, or in your home place set with `!weather home <place>`",
+		Run: func(evt *event.Event, args []string) (string, error) {
+			weather, ok := m.tools["weather"].(*WeatherTool)
+			if !ok {
+				return "", fmt.Errorf("no weather tool")
+			}
+			ctx, cancel := m.toolContext()
+			defer cancel()
+			if len(args) > 1 && args[0] == "home" {
+				place, err := weather.Geocode(ctx, strings.Join(args[1:], " "))
+				if err != nil {
+					return "", err
+				}
+				if err := m.store.SetUserPlace(evt.Sender, place); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Your home place is now %s, %s.", place.Name, place.Country), nil
+			}
+
+			if len(args) > 0 {
+				return weather.Weather(ctx, strings.Join(args, " "))
+			}
+			home, ok := m.homePlace(evt.Sender)
+			if !ok {
+				return "", fmt.Errorf("usage: !weather <place>, or set your home place with !weather home <place>")
+			}
+			return weather.WeatherAt(ctx, home)
+		},
+	}
+}
+
+// homePlace returns the home place of a user. Places that were stored
+// without coordinates are looked up by name.
+func (m *Bot) homePlace(userID id.UserID) (Place, bool) {
+	place, ok, err := m.store.UserPlace(userID)
+	if err != nil {
+		m.logger.Error("failed to get home place", slog.String("err", err.Error()), slog.String("user_id", userID.String()), slog.String("bot", m.config.UserDisplayName))
+		return Place{}, false
+	}
+	if !ok || place.Latitude != 0 || place.Longitude != 0 {
+		return place, ok
+	}
+	weather, ok := m.tools["weather"].(*WeatherTool)
+	if !ok {
+		return Place{}, false
+	}
+	ctx, cancel := m.toolContext()
+	defer cancel()
+	found, err := weather.Geocode(ctx, place.Name)
+	if err != nil {
+		m.logger.Error("failed to find home place", slog.String("err", err.Error()), slog.String("user_id", userID.String()), slog.String("bot", m.config.UserDisplayName))
+		return Place{}, false
+	}
+	if err := m.store.SetUserPlace(userID, found); err != nil {
+		m.logger.Error("failed to store home place", slog.String("err", err.Error()), slog.String("user_id", userID.String()), slog.String("bot", m.config.UserDisplayName))
+	}
+
+	return found, true
+}
+
+// UserPlace returns the home place of a user. Places that were set before
+// the coordinates were kept only have a name.
+func (s *Store) UserPlace(userID id.UserID) (Place, bool, error) {
+	var place Place
+	var lat, lon sql.NullFloat64
+	err := s.db.QueryRowContext(s.context(), `SELECT location, country, latitude, longitude FROM bot_user_location WHERE user_id = $1`, userID).
+		Scan(&place.Name, &place.Country, &lat, &lon)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Place{}, false, nil
+	}
+	if err != nil {
+		return Place{}, false, err
+	}
+	place.Latitude, place.Longitude = lat.Float64, lon.Float64
+
+	return place, true, nil
+}
+
+func (s *Store) SetUserPlace(userID id.UserID, place Place) error {
+	_, err := s.db.ExecContext(s.context(), `INSERT INTO bot_user_location (user_id, location, country, latitude, longitude) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET location = excluded.location, country = excluded.country, latitude = excluded.latitude, longitude = excluded.longitude`,
+		userID, place.Name, place.Country, place.Latitude, place.Longitude)
+	return err
+}
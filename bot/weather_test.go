@@ -0,0 +1,60 @@
+package bot_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestWeatherTool(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/geocode":
+			if r.URL.Query().Get("name") == "Nowhere" {
+				_, _ = w.Write([]byte(`{}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"results":[{"name":"Amsterdam","country":"Netherlands","latitude":52.37,"longitude":4.89}]}`))
+		case "/forecast":
+			if r.URL.Query().Get("latitude") != "52.37" {
+				t.Errorf("exp 52.37, got %v", r.URL.Query().Get("latitude"))
+			}
+			_, _ = w.Write([]byte(`{
+				"current":{"temperature_2m":14.4,"relative_humidity_2m":71,"wind_speed_10m":12.3,"weather_code":2},
+				"daily":{"time":["2023-06-07","2023-06-08"],"weather_code":[61,0],"temperature_2m_max":[16.2,20],"temperature_2m_min":[9.8,11],"precipitation_sum":[2.14,0]}
+			}`))
+		}
+	}))
+	defer srv.Close()
+
+	weather := bot.NewWeatherTool(srv.URL+"/geocode", srv.URL+"/forecast")
+	for _, tc := range []struct {
+		name     string
+		location string
+		exp      string
+		expErr   bool
+	}{
+		{
+			name:     "found",
+			location: "amsterdam",
+			exp:      "**Amsterdam, Netherlands**: 14°C, partly cloudy, wind 12 km/h, humidity 71%\n- Wed 7 Jun: 10 to 16°C, light rain, 2.1 mm\n- Thu 8 Jun: 11 to 20°C, clear sky, 0.0 mm",
+		},
+		{name: "unknown", location: "Nowhere", expErr: true},
+		{name: "empty", location: " ", expErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := weather.Weather(context.Background(), tc.location)
+			if (err != nil) != tc.expErr {
+				t.Fatalf("exp error %v, got %v", tc.expErr, err)
+			}
+			if act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
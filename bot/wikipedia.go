@@ -0,0 +1,170 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	wikipediaURL     = "https://{lang}.wikipedia.org"
+	wikipediaTimeout = 15 * time.Second
+	wikipediaMaxBody = 1 << 20
+)
+
+var wikipediaLanguage = regexp.MustCompile(`^[a-z]{2,3}(-[a-z]+)?$`)
+
+type wikipediaPage struct {
+	Key   string `json:"key"`
+	Title string `json:"title"`
+}
+
+// WikipediaSummary is the introduction of an article. The Description and
+// WikidataID come from Wikidata.
+type WikipediaSummary struct {
+	Type        string `json:"type"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Extract     string `json:"extract"`
+	WikidataID  string `json:"wikibase_item"`
+	URLs        struct {
+		Desktop struct {
+			Page string `json:"page"`
+		} `json:"desktop"`
+	} `json:"content_urls"`
+}
+
+// WikipediaTool looks up articles on Wikipedia, so answers to encyclopedic
+// questions are grounded in a source that can be linked to.
+type WikipediaTool struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewWikipediaTool creates the tool. In baseURL, {lang} is replaced by the
+// language of the wiki. Empty means the public Wikipedia.
+func NewWikipediaTool(baseURL string) *WikipediaTool {
+	if baseURL == "" {
+		baseURL = wikipediaURL
+	}
+
+	return &WikipediaTool{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: wikipediaTimeout},
+	}
+}
+
+func (w *WikipediaTool) Name() string { return "wikipedia" }
+
+func (w *WikipediaTool) Description() string {
+	return "Look up facts about people, places, things and events on Wikipedia. Use it for encyclopedic questions instead of answering from memory, and put the source link in the answer."
+}
+
+func (w *WikipediaTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{` +
+		`"query":{"type":"string","description":"the subject to look up"},` +
+		`"language":{"type":"string","description":"the language code of the wiki, like en or nl, default en"}},` +
+		`"required":["query"]}`)
+}
+
+func (w *WikipediaTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	var a struct {
+		Query    string `json:"query"`
+		Language string `json:"language"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil {
+		return "", err
+	}
+
+	return w.Lookup(ctx, a.Query, a.Language)
+}
+
+// Lookup finds the article that best matches the query and returns its
+// introduction with a link, and the titles of other matches.
+func (w *WikipediaTool) Lookup(ctx context.Context, query, language string) (string, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return "", fmt.Errorf("no query given")
+	}
+	if language == "" {
+		language = "en"
+	}
+	language = strings.ToLower(language)
+	if !wikipediaLanguage.MatchString(language) {
+		return "", fmt.Errorf("invalid language %q", language)
+	}
+	base := strings.ReplaceAll(w.baseURL, "{lang}", language)
+
+	var found struct {
+		Pages []wikipediaPage `json:"pages"`
+	}
+	q := url.Values{"q": {query}, "limit": {"5"}}
+	if err := w.get(ctx, base+"/w/rest.php/v1/search/page?"+q.Encode(), &found); err != nil {
+		return "", err
+	}
+	if len(found.Pages) == 0 {
+		return fmt.Sprintf("Nothing found on Wikipedia for %q.", query), nil
+	}
+
+	var summary WikipediaSummary
+	if err := w.get(ctx, base+"/api/rest_v1/page/summary/"+url.PathEscape(found.Pages[0].Key), &summary); err != nil {
+		return "", err
+	}
+
+	var others []string
+	for _, p := range found.Pages[1:] {
+		others = append(others, p.Title)
+	}
+
+	return FormatWikipedia(summary, others), nil
+}
+
+func (w *WikipediaTool) get(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	// Wikimedia asks clients to identify themselves
+	req.Header.Set("User-Agent", "matrix-bots (https://go-mod.ewintr.nl/matrix-bots)")
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("wikipedia returned status %d", res.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, wikipediaMaxBody)).Decode(v)
+}
+
+// FormatWikipedia renders the summary with its source, followed by the
+// titles of the other pages that matched.
+func FormatWikipedia(s WikipediaSummary, others []string) string {
+	title := "**" + s.Title + "**"
+	if s.Description != "" {
+		title += " (" + s.Description + ")"
+	}
+	parts := []string{title}
+	if s.Type == "disambiguation" {
+		parts = append(parts, "This title can refer to several subjects, see the other articles.")
+	} else if s.Extract != "" {
+		parts = append(parts, s.Extract)
+	}
+	sources := []string{"Source: " + s.URLs.Desktop.Page}
+	if s.WikidataID != "" {
+		sources = append(sources, "Wikidata: https://www.wikidata.org/wiki/"+s.WikidataID)
+	}
+	parts = append(parts, strings.Join(sources, "\n"))
+	if len(others) > 0 {
+		parts = append(parts, "Other articles: "+strings.Join(others, ", "))
+	}
+
+	return strings.Join(parts, "\n\n")
+}
@@ -0,0 +1,63 @@
+package bot_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+func TestWikipediaTool(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/nl/w/rest.php/v1/search/page":
+			if r.URL.Query().Get("q") == "nothing" {
+				_, _ = w.Write([]byte(`{"pages":[]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"pages":[{"key":"Utrecht_(stad)","title":"Utrecht (stad)"},{"key":"Utrecht_(provincie)","title":"Utrecht (provincie)"}]}`))
+		case "/nl/api/rest_v1/page/summary/Utrecht_(stad)":
+			_, _ = w.Write([]byte(`{
+				"type":"standard","title":"Utrecht (stad)","description":"stad in Nederland",
+				"extract":"Utrecht is de hoofdstad van de provincie Utrecht.","wikibase_item":"Q803",
+				"content_urls":{"desktop":{"page":"https://nl.wikipedia.org/wiki/Utrecht_(stad)"}}
+			}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	wiki := bot.NewWikipediaTool(srv.URL + "/{lang}")
+	for _, tc := range []struct {
+		name     string
+		query    string
+		language string
+		exp      string
+		expErr   bool
+	}{
+		{
+			name:     "found",
+			query:    "utrecht",
+			language: "NL",
+			exp:      "**Utrecht (stad)** (stad in Nederland)\n\nUtrecht is de hoofdstad van de provincie Utrecht.\n\nSource: https://nl.wikipedia.org/wiki/Utrecht_(stad)\nWikidata: https://www.wikidata.org/wiki/Q803\n\nOther articles: Utrecht (provincie)",
+		},
+		{name: "nothing", query: "nothing", language: "nl", exp: `Nothing found on Wikipedia for "nothing".`},
+		{name: "empty", query: " ", expErr: true},
+		{name: "invalid language", query: "utrecht", language: "evil.com/", expErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			act, err := wiki.Lookup(context.Background(), tc.query, tc.language)
+			if (err != nil) != tc.expErr {
+				t.Fatalf("exp error %v, got %v", tc.expErr, err)
+			}
+			if act != tc.exp {
+				t.Errorf("exp %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
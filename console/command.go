@@ -0,0 +1,343 @@
+package console
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+const commandPrefix = "/"
+
+var commandNames = []string{"help", "rooms", "room", "join", "filter", "convs", "expire", "config", "usage", "log", "verify", "export", "import"}
+
+type roomEntry struct {
+	bot  *bot.Bot
+	room bot.Room
+}
+
+type convEntry struct {
+	bot  *bot.Bot
+	conv *bot.Conversation
+}
+
+// runCommand executes a console command. Commands start with a slash, all
+// other input is sent as a message.
+func (c *Console) runCommand(line string) error {
+	fields := strings.Fields(strings.TrimPrefix(line, commandPrefix))
+	if len(fields) == 0 {
+		return fmt.Errorf("empty command")
+	}
+	name, args := fields[0], fields[1:]
+
+	switch name {
+	case "help":
+		c.println("/rooms            list the joined rooms of all bots")
+		c.println("/room <n>         make room n from /rooms the active room")
+		c.println("/join <alias|id>  let the active bot join a room and make it active")
+		c.println("/filter           toggle showing only the messages of the active room")
+		c.println("/convs            list the conversations the bots remember")
+		c.println("/expire <n>       forget conversation n from /convs")
+		c.println("/config           show the settings of the active bot in the active room")
+		c.println("/usage            show the tokens used by each bot, room and user")
+		c.println("/log [quiet|verbose]  hide or show the log, toggles without argument")
+		c.println("/verify yes|no    answer whether the codes of a device verification match")
+		c.println("/export <file> <passphrase>  export the encryption keys of the active bot")
+		c.println("/import <file> <passphrase>  import encryption keys into the active bot")
+		return nil
+	case "convs":
+		c.listConversations()
+		return nil
+	case "expire":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: /expire <n>")
+		}
+		return c.expireConversation(args[0])
+	case "config":
+		return c.showConfig()
+	case "usage":
+		c.showUsage()
+		return nil
+	case "verify":
+		if len(args) != 1 || (args[0] != "yes" && args[0] != "no") {
+			return fmt.Errorf("usage: /verify yes|no")
+		}
+		return c.answerVerification(args[0] == "yes")
+	case "export":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: /export <file> <passphrase>")
+		}
+		return c.exportKeys(args[0], args[1])
+	case "import":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: /import <file> <passphrase>")
+		}
+		return c.importKeys(args[0], args[1])
+	case "log":
+		return c.setLog(args)
+	case "filter":
+		c.mu.Lock()
+		c.filter = !c.filter
+		on := c.filter
+		c.mu.Unlock()
+		if on {
+			c.println("showing messages of the active room only")
+		} else {
+			c.println("showing messages of all rooms")
+		}
+		return nil
+	case "rooms":
+		return c.listRooms()
+	case "room":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: /room <n>")
+		}
+		return c.switchRoom(args[0])
+	case "join":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: /join <alias|id>")
+		}
+		return c.joinRoom(args[0])
+	default:
+		return fmt.Errorf("unknown command /%s, see /help", name)
+	}
+}
+
+func (c *Console) listRooms() error {
+	entries, err := c.refreshRooms()
+	if err != nil {
+		return err
+	}
+	for i, e := range entries {
+		name := e.room.Name
+		if name == "" {
+			name = "(unnamed)"
+		}
+		encrypted := ""
+		if e.room.Encrypted {
+			encrypted = ", encrypted"
+		}
+		c.println(fmt.Sprintf("%3d  %-12s %s (%s, %d members%s)", i+1, e.bot.Name(), name, e.room.ID, e.room.Members, encrypted))
+	}
+
+	return nil
+}
+
+func (c *Console) refreshRooms() ([]roomEntry, error) {
+	c.mu.Lock()
+	bots := append([]*bot.Bot{}, c.bots...)
+	c.mu.Unlock()
+
+	var entries []roomEntry
+	for _, b := range bots {
+		rooms, err := b.JoinedRooms()
+		if err != nil {
+			return nil, fmt.Errorf("could not list rooms of %s: %w", b.Name(), err)
+		}
+		for _, r := range rooms {
+			entries = append(entries, roomEntry{bot: b, room: r})
+		}
+	}
+
+	c.mu.Lock()
+	c.rooms = entries
+	c.mu.Unlock()
+
+	return entries, nil
+}
+
+func (c *Console) switchRoom(arg string) error {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return fmt.Errorf("not a room number: %s", arg)
+	}
+
+	c.mu.Lock()
+	entries := c.rooms
+	c.mu.Unlock()
+	if len(entries) == 0 {
+		if entries, err = c.refreshRooms(); err != nil {
+			return err
+		}
+	}
+	if n < 1 || n > len(entries) {
+		return fmt.Errorf("no room %d, see /rooms", n)
+	}
+	e := entries[n-1]
+	c.setActive(e.bot, e.room.ID)
+
+	return nil
+}
+
+// activeBot returns the active bot, or the first one if no room was active
+// yet.
+func (c *Console) activeBot() (*bot.Bot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b := c.active
+	if b == nil && len(c.bots) > 0 {
+		b = c.bots[0]
+	}
+	if b == nil {
+		return nil, fmt.Errorf("no bots available")
+	}
+
+	return b, nil
+}
+
+func (c *Console) joinRoom(roomIDOrAlias string) error {
+	b, err := c.activeBot()
+	if err != nil {
+		return err
+	}
+
+	roomID, err := b.JoinRoom(roomIDOrAlias)
+	if err != nil {
+		return err
+	}
+	c.setActive(b, roomID)
+	c.println(fmt.Sprintf("%s joined %s", b.Name(), roomID))
+
+	return nil
+}
+
+func (c *Console) println(line string) {
+	fmt.Fprintln(c.rl.Stdout(), line)
+}
+
+func (c *Console) listConversations() {
+	c.mu.Lock()
+	bots := append([]*bot.Bot{}, c.bots...)
+	c.mu.Unlock()
+
+	var entries []convEntry
+	for _, b := range bots {
+		for _, conv := range b.Conversations() {
+			entries = append(entries, convEntry{bot: b, conv: conv})
+		}
+	}
+	for i, e := range entries {
+		var tokens int
+		if ut, err := e.bot.ConversationUsage(e.conv.Root()); err == nil {
+			tokens = ut.TotalTokens()
+		}
+		c.println(fmt.Sprintf("%3d  %-12s %-10s %3d messages %7d tokens  %s %s", i+1, e.bot.Name(), e.conv.Persona, len(e.conv.History()), tokens, e.conv.RoomID, e.conv.Root()))
+	}
+
+	c.mu.Lock()
+	c.convs = entries
+	c.mu.Unlock()
+}
+
+func (c *Console) expireConversation(arg string) error {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return fmt.Errorf("not a conversation number: %s", arg)
+	}
+
+	c.mu.Lock()
+	entries := c.convs
+	c.mu.Unlock()
+	if n < 1 || n > len(entries) {
+		return fmt.Errorf("no conversation %d, see /convs", n)
+	}
+	e := entries[n-1]
+	if !e.bot.ExpireConversation(e.conv.Root()) {
+		return fmt.Errorf("conversation %d was already gone", n)
+	}
+	c.println(fmt.Sprintf("expired conversation %s", e.conv.Root()))
+
+	return nil
+}
+
+func (c *Console) showConfig() error {
+	c.mu.Lock()
+	b, room := c.active, c.room
+	c.mu.Unlock()
+	if b == nil || room == "" {
+		return fmt.Errorf("no active room, see /rooms")
+	}
+
+	rs, err := b.RoomSettings(room)
+	if err != nil {
+		return err
+	}
+	c.println(fmt.Sprintf("%s in %s", b.Name(), room))
+	names := make([]string, 0, len(rs.Plugins))
+	for name := range rs.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		state := "enabled"
+		if !rs.Plugins[name] {
+			state = "disabled"
+		}
+		c.println(fmt.Sprintf("  plugin %-12s %s", name, state))
+	}
+	sort.Strings(rs.Personas)
+	c.println(fmt.Sprintf("  room personas      %s", strings.Join(rs.Personas, ", ")))
+	c.println(fmt.Sprintf("  answer unaddressed %v", rs.AnswerUnaddressed))
+	c.println(fmt.Sprintf("  rules              %d", rs.Rules))
+
+	return nil
+}
+
+func (c *Console) showUsage() {
+	c.mu.Lock()
+	bots := append([]*bot.Bot{}, c.bots...)
+	c.mu.Unlock()
+
+	for _, b := range bots {
+		u := b.Usage()
+		c.println(fmt.Sprintf("%-12s prompt %8d  completion %8d  total %8d", b.Name(), u.PromptTokens, u.CompletionTokens, u.TotalTokens))
+		rooms, err := b.UsageByRoom(time.Time{})
+		if err != nil {
+			c.println(fmt.Sprintf("  failed to get usage per room: %v", err))
+			continue
+		}
+		users, err := b.UsageByUser(time.Time{})
+		if err != nil {
+			c.println(fmt.Sprintf("  failed to get usage per user: %v", err))
+			continue
+		}
+		for _, ut := range topUsage(rooms) {
+			c.println(fmt.Sprintf("  room %-40s %4d answers  total %8d", ut.Key, ut.Answers, ut.TotalTokens()))
+		}
+		for _, ut := range topUsage(users) {
+			c.println(fmt.Sprintf("  user %-40s %4d answers  total %8d", ut.Key, ut.Answers, ut.TotalTokens()))
+		}
+	}
+}
+
+// topUsage returns the rooms or users that used most, which come first.
+func topUsage(totals []bot.UsageTotal) []bot.UsageTotal {
+	if len(totals) > 5 {
+		return totals[:5]
+	}
+	return totals
+}
+
+func (c *Console) setLog(args []string) error {
+	switch {
+	case len(args) == 0:
+		c.quiet.Store(!c.quiet.Load())
+	case len(args) == 1 && args[0] == "quiet":
+		c.quiet.Store(true)
+	case len(args) == 1 && args[0] == "verbose":
+		c.quiet.Store(false)
+	default:
+		return fmt.Errorf("usage: /log [quiet|verbose]")
+	}
+	if c.quiet.Load() {
+		c.println("log hidden, showing messages only")
+	} else {
+		c.println("showing the full log")
+	}
+
+	return nil
+}
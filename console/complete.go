@@ -0,0 +1,72 @@
+package console
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// Do implements readline.AutoCompleter. It completes command names, room
+// aliases and IDs after /join, and user IDs of the members of the active room
+// in messages.
+func (c *Console) Do(line []rune, pos int) ([][]rune, int) {
+	head := string(line[:pos])
+	word := head[strings.LastIndex(head, " ")+1:]
+
+	var candidates []string
+	switch {
+	case strings.HasPrefix(head, commandPrefix) && !strings.Contains(head, " "):
+		for _, name := range commandNames {
+			candidates = append(candidates, commandPrefix+name)
+		}
+	case strings.HasPrefix(head, commandPrefix+"join "):
+		c.mu.Lock()
+		for _, e := range c.rooms {
+			candidates = append(candidates, e.room.ID.String())
+			if strings.HasPrefix(e.room.Name, "#") {
+				candidates = append(candidates, e.room.Name)
+			}
+		}
+		c.mu.Unlock()
+	case strings.HasPrefix(head, commandPrefix):
+	default:
+		c.mu.Lock()
+		for _, member := range c.members {
+			candidates = append(candidates, member.String())
+		}
+		c.mu.Unlock()
+	}
+	sort.Strings(candidates)
+
+	var res [][]rune
+	for _, cand := range candidates {
+		if strings.HasPrefix(cand, word) && cand != word {
+			res = append(res, []rune(strings.TrimPrefix(cand, word)+" "))
+		}
+	}
+
+	return res, len([]rune(word))
+}
+
+// refreshMembers fetches the members of the active room for completion.
+func (c *Console) refreshMembers() {
+	c.mu.Lock()
+	b, room := c.active, c.room
+	c.mu.Unlock()
+	if b == nil || room == "" {
+		return
+	}
+
+	members, err := b.JoinedMembers(room)
+	if err != nil {
+		c.logger.Error("failed to get room members", slog.String("err", err.Error()), slog.String("room_id", room.String()), slog.String("bot", b.Name()))
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.room == room {
+		c.members = members
+	}
+}
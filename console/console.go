@@ -0,0 +1,186 @@
+package console
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chzyer/readline"
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"golang.org/x/exp/slog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Console is an operator prompt on the terminal. Every line that is typed is
+// sent by the active bot to the active room. Both follow the last message
+// that was received, or can be picked with the /room command.
+type Console struct {
+	rl        *readline.Instance
+	bots      []*bot.Bot
+	rooms     []roomEntry
+	convs     []convEntry
+	roomNames map[id.RoomID]string
+	members   []id.UserID
+	filter    bool
+	quiet     atomic.Bool
+	verify    chan bool
+	active    *bot.Bot
+	room      id.RoomID
+	logger    *slog.Logger
+	mu        sync.Mutex
+}
+
+// New starts the console. When historyPath is not empty, the input history is
+// kept in that file, so it survives restarts. Ctrl-R searches the history.
+func New(historyPath string) (*Console, error) {
+	c := &Console{
+		bots:      make([]*bot.Bot, 0),
+		roomNames: make(map[id.RoomID]string),
+		verify:    make(chan bool),
+	}
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:                 "> ",
+		AutoComplete:           c,
+		HistoryFile:            historyPath,
+		HistorySearchFold:      true,
+		DisableAutoSaveHistory: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.rl = rl
+	c.logger = slog.New(slog.NewTextHandler(rl.Stdout(), nil))
+
+	return c, nil
+}
+
+// Stdout returns a writer that prints above the prompt without garbling the
+// line that is being typed.
+func (c *Console) Stdout() io.Writer {
+	return c.rl.Stdout()
+}
+
+// LogWriter returns a writer for the log that prints above the prompt, like
+// Stdout, but drops everything while the console is in quiet mode.
+func (c *Console) LogWriter() io.Writer {
+	return logWriter{c: c}
+}
+
+type logWriter struct {
+	c *Console
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	if w.c.quiet.Load() {
+		return len(p), nil
+	}
+
+	return w.c.rl.Stdout().Write(p)
+}
+
+// AddBot makes the bot available in the console. The bot must be initialized.
+func (c *Console) AddBot(b *bot.Bot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.bots = append(c.bots, b)
+	b.SetVerifier(c)
+	b.AddEventHandler(event.EventMessage, func(source mautrix.EventSource, evt *event.Event) {
+		c.printMessage(b, evt)
+		// while filtering, the active room stays the one that is watched
+		c.mu.Lock()
+		filter := c.filter
+		c.mu.Unlock()
+		if !filter {
+			c.setActive(b, evt.RoomID)
+		}
+	})
+}
+
+func (c *Console) setActive(b *bot.Bot, roomID id.RoomID) {
+	c.mu.Lock()
+	changed := c.active != b || c.room != roomID
+	if changed {
+		c.members = nil
+	}
+	c.active = b
+	c.room = roomID
+	c.rl.SetPrompt(fmt.Sprintf("%s %s> ", b.Name(), roomID))
+	c.rl.Refresh()
+	c.mu.Unlock()
+
+	if changed {
+		go c.refreshMembers()
+	}
+}
+
+// Run reads lines until the input is closed, which is returned as io.EOF.
+func (c *Console) Run() error {
+	defer c.rl.Close()
+
+	for {
+		raw, err := c.rl.Readline()
+		switch {
+		case err == readline.ErrInterrupt:
+			continue
+		case err != nil:
+			return err
+		}
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			continue
+		}
+		if !isSensitive(raw) {
+			if err := c.rl.SaveHistory(line); err != nil {
+				c.logger.Error("failed to save history", slog.String("err", err.Error()))
+			}
+		}
+		if strings.HasPrefix(line, commandPrefix) {
+			if err := c.runCommand(line); err != nil {
+				c.println(err.Error())
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		b, room := c.active, c.room
+		c.mu.Unlock()
+		if b == nil || room == "" {
+			c.println("no active room yet, see /rooms")
+			continue
+		}
+		if err := b.SendText(room, line); err != nil {
+			c.logger.Error("failed to send message", slog.String("err", err.Error()), slog.String("room_id", room.String()), slog.String("bot", b.Name()))
+		}
+	}
+}
+
+var sensitiveWords = []string{"password", "passwd", "passphrase", "secret", "token", "apikey", "api_key", "accesskey", "access_key", "keys"}
+
+// sensitiveCommands take a secret as argument.
+var sensitiveCommands = []string{"/export", "/import"}
+
+// isSensitive reports whether a line should stay out of the history file. As
+// in a shell, a leading space also keeps a line out.
+func isSensitive(line string) bool {
+	if strings.HasPrefix(line, " ") {
+		return true
+	}
+	lower := strings.ToLower(line)
+	for _, cmd := range sensitiveCommands {
+		if strings.HasPrefix(lower, cmd+" ") {
+			return true
+		}
+	}
+	for _, w := range sensitiveWords {
+		if strings.Contains(lower, w) {
+			return true
+		}
+	}
+
+	return false
+}
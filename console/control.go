@@ -0,0 +1,290 @@
+package console
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"golang.org/x/exp/slog"
+)
+
+// Control executes commands that are read line by line, from stdin or a unix
+// socket, and writes the result of each as one line of JSON. It lets shell
+// scripts and cron jobs drive the bots:
+//
+//	send <bot> <room id|alias> <text>
+//	join <bot> <room id|alias>
+//	rooms
+//	usage
+//	health
+//	export <bot> <file> <passphrase>
+//	import <bot> <file> <passphrase>
+type Control struct {
+	bots   []*bot.Bot
+	logger *slog.Logger
+}
+
+type ControlResult struct {
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+	Result any    `json:"result,omitempty"`
+}
+
+type controlRoom struct {
+	Bot       string `json:"bot"`
+	RoomID    string `json:"room_id"`
+	Name      string `json:"name"`
+	Topic     string `json:"topic"`
+	Encrypted bool   `json:"encrypted"`
+	Members   int    `json:"members"`
+}
+
+type controlHealth struct {
+	Bot               string `json:"bot"`
+	Syncing           bool   `json:"syncing"`
+	SyncFailures      int    `json:"sync_failures"`
+	LastSync          string `json:"last_sync,omitempty"`
+	LastSyncError     string `json:"last_sync_error,omitempty"`
+	ProviderAvailable bool   `json:"provider_available"`
+}
+
+type controlUsage struct {
+	Bot              string              `json:"bot"`
+	PromptTokens     int                 `json:"prompt_tokens"`
+	CompletionTokens int                 `json:"completion_tokens"`
+	TotalTokens      int                 `json:"total_tokens"`
+	Rooms            []controlUsageTotal `json:"rooms,omitempty"`
+	Users            []controlUsageTotal `json:"users,omitempty"`
+}
+
+type controlUsageTotal struct {
+	ID               string `json:"id"`
+	Answers          int    `json:"answers"`
+	Failed           int    `json:"failed"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+func NewControl(bots []*bot.Bot, logger *slog.Logger) *Control {
+	return &Control{
+		bots:   bots,
+		logger: logger,
+	}
+}
+
+// Serve handles commands from r until it is closed.
+func (c *Control) Serve(r io.Reader, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		res, err := c.run(line)
+		out := ControlResult{OK: err == nil, Result: res}
+		if err != nil {
+			out.Error = err.Error()
+		}
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// ListenUnix accepts connections on a unix socket at path and serves each of
+// them. It only returns when the listener fails.
+func (c *Control) ListenUnix(path string) error {
+	_ = os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	if err := os.Chmod(path, 0o600); err != nil {
+		return err
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := c.Serve(conn, conn); err != nil {
+				c.logger.Error("control connection failed", slog.String("err", err.Error()))
+			}
+		}()
+	}
+}
+
+func (c *Control) run(line string) (any, error) {
+	fields := strings.Fields(strings.TrimPrefix(line, commandPrefix))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("usage: <command> [<args>]")
+	}
+
+	switch fields[0] {
+	case "send":
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("usage: send <bot> <room id|alias> <text>")
+		}
+		b, err := c.bot(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		roomID, err := b.ResolveRoom(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		return nil, b.SendText(roomID, skipFields(line, 3))
+	case "join":
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("usage: join <bot> <room id|alias>")
+		}
+		b, err := c.bot(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		roomID, err := b.JoinRoom(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"room_id": roomID.String()}, nil
+	case "knock":
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("usage: knock <bot> <room id|alias> [reason]")
+		}
+		b, err := c.bot(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		roomID, err := b.KnockRoom(fields[2], skipFields(line, 3))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"room_id": roomID.String()}, nil
+	case "leave":
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("usage: leave <bot> <room id|alias>")
+		}
+		b, err := c.bot(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		roomID, err := b.ResolveRoom(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		return nil, b.LeaveRoom(roomID)
+	case "rooms":
+		var rooms []controlRoom
+		for _, b := range c.bots {
+			joined, err := b.JoinedRooms()
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range joined {
+				rooms = append(rooms, controlRoom{Bot: b.Name(), RoomID: r.ID.String(), Name: r.Name, Topic: r.Topic, Encrypted: r.Encrypted, Members: r.Members})
+			}
+		}
+		return rooms, nil
+	case "usage":
+		var usage []controlUsage
+		for _, b := range c.bots {
+			u := b.Usage()
+			cu := controlUsage{Bot: b.Name(), PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens, TotalTokens: u.TotalTokens}
+			rooms, err := b.UsageByRoom(time.Time{})
+			if err != nil {
+				return nil, err
+			}
+			users, err := b.UsageByUser(time.Time{})
+			if err != nil {
+				return nil, err
+			}
+			cu.Rooms, cu.Users = controlUsageTotals(rooms), controlUsageTotals(users)
+			usage = append(usage, cu)
+		}
+		return usage, nil
+	case "health":
+		var health []controlHealth
+		for _, b := range c.bots {
+			h := b.Health()
+			ch := controlHealth{Bot: b.Name(), Syncing: h.Syncing, SyncFailures: h.SyncFailures, LastSyncError: h.LastSyncError, ProviderAvailable: h.ProviderAvailable}
+			if !h.LastSync.IsZero() {
+				ch.LastSync = h.LastSync.UTC().Format(time.RFC3339)
+			}
+			health = append(health, ch)
+		}
+		return health, nil
+	case "export", "import":
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("usage: %s <bot> <file> <passphrase>", fields[0])
+		}
+		b, err := c.bot(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		if fields[0] == "export" {
+			data, err := b.ExportKeys(fields[3])
+			if err != nil {
+				return nil, err
+			}
+			return nil, os.WriteFile(fields[2], data, 0o600)
+		}
+		data, err := os.ReadFile(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		imported, total, err := b.ImportKeys(fields[3], data)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]int{"imported": imported, "total": total}, nil
+	default:
+		return nil, fmt.Errorf("unknown command %q", fields[0])
+	}
+}
+
+func (c *Control) bot(name string) (*bot.Bot, error) {
+	for _, b := range c.bots {
+		if strings.EqualFold(b.Name(), name) {
+			return b, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no bot with name %q", name)
+}
+
+// skipFields returns line without its first n fields, keeping the whitespace
+// in the remainder as typed.
+func skipFields(line string, n int) string {
+	rest := strings.TrimSpace(line)
+	for i := 0; i < n; i++ {
+		idx := strings.IndexFunc(rest, unicode.IsSpace)
+		if idx < 0 {
+			return ""
+		}
+		rest = strings.TrimSpace(rest[idx:])
+	}
+
+	return rest
+}
+
+func controlUsageTotals(totals []bot.UsageTotal) []controlUsageTotal {
+	var cut []controlUsageTotal
+	for _, ut := range totals {
+		cut = append(cut, controlUsageTotal{ID: ut.Key, Answers: ut.Answers, Failed: ut.Failed, PromptTokens: ut.PromptTokens, CompletionTokens: ut.CompletionTokens})
+	}
+	return cut
+}
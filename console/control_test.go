@@ -0,0 +1,42 @@
+package console_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"go-mod.ewintr.nl/matrix-bots/console"
+	"golang.org/x/exp/slog"
+)
+
+func TestControlServe(t *testing.T) {
+	t.Parallel()
+
+	c := console.NewControl(nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, tc := range []struct {
+		name     string
+		line     string
+		expError string
+	}{
+		{name: "prefix only", line: "/", expError: "usage: <command> [<args>]"},
+		{name: "prefix and space", line: "/ \t", expError: "usage: <command> [<args>]"},
+		{name: "unknown", line: "/dance", expError: `unknown command "dance"`},
+		{name: "send without text", line: "send bot !room:ewintr.nl", expError: "usage: send <bot> <room id|alias> <text>"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := c.Serve(strings.NewReader(tc.line+"\n"), &out); err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			var res console.ControlResult
+			if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+				t.Fatalf("exp nil, got %v", err)
+			}
+			if res.OK || res.Error != tc.expError {
+				t.Errorf("exp %v, got %v", tc.expError, res.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package console
+
+import (
+	"fmt"
+	"os"
+)
+
+func (c *Console) exportKeys(path, passphrase string) error {
+	b, err := c.activeBot()
+	if err != nil {
+		return err
+	}
+	data, err := b.ExportKeys(passphrase)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+	c.println(fmt.Sprintf("exported the keys of %s to %s", b.Name(), path))
+
+	return nil
+}
+
+func (c *Console) importKeys(path, passphrase string) error {
+	b, err := c.activeBot()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	imported, total, err := b.ImportKeys(passphrase, data)
+	if err != nil {
+		return err
+	}
+	c.println(fmt.Sprintf("imported %d of %d keys into %s", imported, total, b.Name()))
+
+	return nil
+}
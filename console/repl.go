@@ -0,0 +1,183 @@
+package console
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go-mod.ewintr.nl/matrix-bots/bot"
+)
+
+// REPL runs the conversation pipeline of a bot without Matrix: every line
+// read from in is a message to the active persona and the reply is written
+// to out. It is meant for trying out prompts and personas locally.
+type REPL struct {
+	gpt      *bot.GPT
+	cfg      bot.ConfigBot
+	personas []bot.Persona
+	persona  bot.Persona
+	conv     *bot.Conversation
+	in       io.Reader
+	out      io.Writer
+}
+
+// NewREPL uses the personas of the bot configuration and its persona
+// directory. The system prompt and model of the bot itself are available as
+// the persona "chat", personas without a system prompt use that of the bot.
+func NewREPL(gpt *bot.GPT, cfg bot.ConfigBot, in io.Reader, out io.Writer) *REPL {
+	if cfg.SystemPrompt == "" {
+		cfg.SystemPrompt = bot.DefaultSystemPrompt
+	}
+	r := &REPL{
+		gpt: gpt,
+		cfg: cfg,
+		in:  in,
+		out: out,
+	}
+	if err := r.loadPersonas(); err != nil {
+		fmt.Fprintf(out, "error: %s\n", err.Error())
+	}
+	r.persona = r.personas[0]
+
+	return r
+}
+
+// loadPersonas reads the personas again, so changes in the persona
+// directory can be tried without a restart.
+func (r *REPL) loadPersonas() error {
+	personas := append([]bot.Persona{{
+		Name:         "chat",
+		SystemPrompt: r.cfg.SystemPrompt,
+		Examples:     r.cfg.Examples,
+		Model:        r.cfg.Model,
+	}}, r.cfg.Personas...)
+	var err error
+	if r.cfg.PersonaDir != "" {
+		var dir []bot.Persona
+		dir, err = bot.LoadPersonaDir(r.cfg.PersonaDir)
+		personas = append(personas, dir...)
+	}
+	for i := range personas {
+		if personas[i].SystemPrompt == "" {
+			personas[i].SystemPrompt = r.cfg.SystemPrompt
+		}
+	}
+	r.personas = personas
+
+	return err
+}
+
+func (r *REPL) Run() error {
+	fmt.Fprintf(r.out, "talking to %s, /help for commands\n", r.persona.Name)
+	scanner := bufio.NewScanner(r.in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, commandPrefix) {
+			if err := r.runCommand(line); err != nil {
+				fmt.Fprintln(r.out, err.Error())
+			}
+			continue
+		}
+
+		if r.conv == nil {
+			now := time.Now()
+			prompt, err := bot.RenderPrompt(r.persona.SystemPrompt, bot.PromptData{
+				Bot:      r.cfg.UserDisplayName,
+				Date:     now.Format("Monday 2 January 2006"),
+				Time:     now.Format("15:04"),
+				Timezone: now.Location().String(),
+				Now:      now,
+			})
+			if err != nil {
+				fmt.Fprintf(r.out, "error: %s\n", err.Error())
+				continue
+			}
+			r.conv = bot.NewConversation("", prompt, "")
+			r.conv.Persona = r.persona.Name
+			r.conv.Messages = r.conv.Messages[:1]
+			for _, e := range r.persona.Examples {
+				r.conv.Messages = append(r.conv.Messages,
+					bot.Message{Role: openai.ChatMessageRoleUser, Content: e.User},
+					bot.Message{Role: openai.ChatMessageRoleAssistant, Content: e.Assistant},
+				)
+			}
+			r.conv.Messages = append(r.conv.Messages, bot.Message{Role: openai.ChatMessageRoleUser, Content: line})
+		} else {
+			r.conv.Add(bot.Message{Role: openai.ChatMessageRoleUser, Content: line})
+		}
+		ctx := bot.WithCompletionParams(context.Background(), bot.CompletionParams{Temperature: r.persona.Temperature, MaxTokens: r.persona.MaxTokens})
+		reply, err := r.gpt.CompleteContext(ctx, r.persona.Model, r.conv)
+		if err != nil {
+			fmt.Fprintf(r.out, "error: %s\n", err.Error())
+			continue
+		}
+		r.conv.Add(bot.Message{Role: openai.ChatMessageRoleAssistant, Content: reply})
+		fmt.Fprintf(r.out, "%s: %s\n", r.persona.Name, reply)
+	}
+
+	return scanner.Err()
+}
+
+func (r *REPL) runCommand(line string) error {
+	fields := strings.Fields(strings.TrimPrefix(line, commandPrefix))
+	if len(fields) == 0 {
+		return fmt.Errorf("empty command")
+	}
+
+	switch fields[0] {
+	case "help":
+		fmt.Fprintln(r.out, "/personas          list the personas")
+		fmt.Fprintln(r.out, "/persona <name>    switch persona and start a new conversation")
+		fmt.Fprintln(r.out, "/reset             start a new conversation")
+		fmt.Fprintln(r.out, "/reload            read the persona directory again")
+		fmt.Fprintln(r.out, "/history           show the messages sent to the model")
+	case "personas":
+		for _, p := range r.personas {
+			fmt.Fprintf(r.out, "- %s\n", p.Name)
+		}
+	case "persona":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: /persona <name>")
+		}
+		for _, p := range r.personas {
+			if p.Name == fields[1] {
+				r.persona = p
+				r.conv = nil
+				fmt.Fprintf(r.out, "talking to %s\n", p.Name)
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown persona %q", fields[1])
+	case "reset":
+		r.conv = nil
+		fmt.Fprintln(r.out, "started a new conversation")
+	case "reload":
+		if err := r.loadPersonas(); err != nil {
+			return err
+		}
+		for _, p := range r.personas {
+			if p.Name == r.persona.Name {
+				r.persona = p
+			}
+		}
+		fmt.Fprintf(r.out, "loaded %d personas\n", len(r.personas))
+	case "history":
+		if r.conv == nil {
+			return nil
+		}
+		for _, m := range r.conv.History() {
+			fmt.Fprintf(r.out, "[%s] %s\n", m.Role, m.Content)
+		}
+	default:
+		return fmt.Errorf("unknown command /%s, see /help", fields[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package console
+
+import (
+	"fmt"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix/id"
+)
+
+const verifyTimeout = 2 * time.Minute
+
+// ConfirmSAS shows the codes of a device verification and waits for the
+// operator to answer with /verify. No answer means no match.
+func (c *Console) ConfirmSAS(b *bot.Bot, device *id.Device, sas string) bool {
+	c.println(fmt.Sprintf("%s: %s wants to verify with device %s", b.Name(), device.UserID, device.DeviceID))
+	c.println(fmt.Sprintf("  %s", sas))
+	c.println("do these match the other device? answer with /verify yes or /verify no")
+
+	select {
+	case ok := <-c.verify:
+		return ok
+	case <-time.After(verifyTimeout):
+		c.println("no answer, verification cancelled")
+		return false
+	}
+}
+
+func (c *Console) answerVerification(ok bool) error {
+	select {
+	case c.verify <- ok:
+		return nil
+	default:
+		return fmt.Errorf("no verification waiting for an answer")
+	}
+}
@@ -0,0 +1,75 @@
+package console
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"go-mod.ewintr.nl/matrix-bots/bot"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const colorReset = "\033[0m"
+
+var palette = []string{
+	"\033[31m", // red
+	"\033[32m", // green
+	"\033[33m", // yellow
+	"\033[34m", // blue
+	"\033[35m", // magenta
+	"\033[36m", // cyan
+	"\033[91m", // bright red
+	"\033[92m", // bright green
+	"\033[93m", // bright yellow
+	"\033[94m", // bright blue
+	"\033[95m", // bright magenta
+	"\033[96m", // bright cyan
+}
+
+// colorFor picks a stable color for a room or user, so they are easy to tell
+// apart in a busy view.
+func colorFor(s string) string {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return palette[h.Sum32()%uint32(len(palette))]
+}
+
+func colored(s string) string {
+	return colorFor(s) + s + colorReset
+}
+
+// printMessage shows an incoming message as "time room sender: body". When the
+// view is filtered, only messages in the active room are shown.
+func (c *Console) printMessage(b *bot.Bot, evt *event.Event) {
+	c.mu.Lock()
+	filtered := c.filter && evt.RoomID != c.room
+	c.mu.Unlock()
+	if filtered {
+		return
+	}
+
+	ts := time.UnixMilli(evt.Timestamp).Format("15:04")
+	body := strings.ReplaceAll(evt.Content.AsMessage().Body, "\n", "\n      ")
+	c.println(fmt.Sprintf("%s %s %s: %s", ts, colored(c.roomName(b, evt.RoomID)), colored(evt.Sender.String()), body))
+}
+
+func (c *Console) roomName(b *bot.Bot, roomID id.RoomID) string {
+	c.mu.Lock()
+	name, ok := c.roomNames[roomID]
+	c.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	name = b.RoomName(roomID)
+	if name == "" {
+		name = roomID.String()
+	}
+	c.mu.Lock()
+	c.roomNames[roomID] = name
+	c.mu.Unlock()
+
+	return name
+}
@@ -3,16 +3,22 @@ module go-mod.ewintr.nl/matrix-bots
 go 1.20
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/chzyer/readline v1.5.1
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.16
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/rs/zerolog v1.29.1
-	github.com/sashabaranov/go-openai v1.9.4
+	github.com/sashabaranov/go-openai v1.24.0
+	go.starlark.net v0.0.0-20230525235612-a134d8f9ddca
+	golang.org/x/crypto v0.8.0
 	golang.org/x/exp v0.0.0-20230510235704-dd950f8aeaea
+	golang.org/x/net v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
 	maunium.net/go/mautrix v0.15.1
 )
 
 require (
-	github.com/BurntSushi/toml v1.3.2 // indirect
 	github.com/mattn/go-colorable v0.1.12 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
 	github.com/tidwall/gjson v1.14.4 // indirect
@@ -20,8 +26,6 @@ require (
 	github.com/tidwall/pretty v1.2.0 // indirect
 	github.com/tidwall/sjson v1.2.5 // indirect
 	github.com/yuin/goldmark v1.5.4 // indirect
-	golang.org/x/crypto v0.8.0 // indirect
-	golang.org/x/net v0.9.0 // indirect
 	golang.org/x/sys v0.7.0 // indirect
 	maunium.net/go/maulogger/v2 v2.4.1 // indirect
 )
@@ -1,27 +1,102 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"go-mod.ewintr.nl/matrix-bots/bot"
+	"go-mod.ewintr.nl/matrix-bots/console"
 	"github.com/BurntSushi/toml"
+	"github.com/chzyer/readline"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog"
 	"golang.org/x/exp/slog"
 )
 
+// shutdownTimeout is how long the bots get to finish their work when the
+// service is stopped.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
-	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	repl := flag.String("repl", "", "talk to the personas of the named bot on stdin and stdout, without Matrix")
+	headless := flag.Bool("headless", false, "never start the console, for running as a service")
+	logFile := flag.String("log-file", "", "append the log to this file instead of writing it to stdout")
+	rotatePickle := flag.String("rotate-pickle", "", "encrypt the crypto store of the named bot with the pickle key in MATRIX_NEW_PICKLE, then exit")
+	stdin := flag.Bool("stdin", false, "read commands from stdin and write the results as JSON, stop when the input ends")
+	socket := flag.String("socket", "", "accept the same commands as -stdin on a unix socket at this path")
+	dryRun := flag.Bool("dry-run", false, "handle messages as usual, but log the answers instead of sending them")
+	flag.Parse()
+
+	logOut := io.Writer(os.Stdout)
+	if *stdin {
+		// stdout is for the results
+		logOut = os.Stderr
+	}
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		defer f.Close()
+		logOut = f
+	}
+	logger := slog.New(slog.NewTextHandler(logOut, nil))
+
+	if *repl != "" {
+		if err := runREPL(*repl); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *rotatePickle != "" {
+		if err := runRotatePickle(*rotatePickle, logger); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	var cons *console.Console
+	if getParam("MATRIX_CONSOLE", "false") == "true" && !*headless && !*stdin {
+		if !readline.DefaultIsTerminal() {
+			logger.Error("console needs a terminal, use -headless to run without one")
+			os.Exit(1)
+		}
+		var err error
+		cons, err = console.New(getParam("MATRIX_CONSOLE_HISTORY", ".console_history"))
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		if *logFile == "" {
+			logger = slog.New(slog.NewTextHandler(cons.LogWriter(), nil))
+		}
+	}
 
 	var config bot.Config
 	if _, err := toml.DecodeFile(getParam("CONFIG_PATH", "conf.toml"), &config); err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
+	config.ApplyDefaults()
 	type Credentials struct {
-		Password  string
-		AccessKey string
+		Password    string
+		AccessKey   string
+		RecoveryKey string
 	}
 	credentials := make(map[string]Credentials)
 	for i := 0; i < len(config.Bots); i++ {
@@ -31,8 +106,9 @@ func main() {
 			os.Exit(1)
 		}
 		credentials[user] = Credentials{
-			Password:  getParam(fmt.Sprintf("MATRIX_BOT%d_PASSWORD", i), ""),
-			AccessKey: getParam(fmt.Sprintf("MATRIX_BOT%d_ACCESSKEY", i), ""),
+			Password:    getParam(fmt.Sprintf("MATRIX_BOT%d_PASSWORD", i), ""),
+			AccessKey:   getParam(fmt.Sprintf("MATRIX_BOT%d_ACCESSKEY", i), ""),
+			RecoveryKey: getParam(fmt.Sprintf("MATRIX_BOT%d_RECOVERY_KEY", i), ""),
 		}
 	}
 	for i, bc := range config.Bots {
@@ -43,6 +119,10 @@ func main() {
 		}
 		config.Bots[i].UserPassword = creds.Password
 		config.Bots[i].UserAccessKey = creds.AccessKey
+		config.Bots[i].RecoveryKey = creds.RecoveryKey
+		if *dryRun {
+			config.Bots[i].DryRun = true
+		}
 	}
 
 	config.OpenAI = bot.ConfigOpenAI{
@@ -56,23 +136,110 @@ func main() {
 
 	logger.Info("loaded config", slog.Int("bots", len(config.Bots)))
 
+	inbound := bot.NewInboundServer(logger)
+	bots := make([]*bot.Bot, 0, len(config.Bots))
 	for _, bc := range config.Bots {
-		b := bot.New(config.OpenAI.APIKey, bc, logger)
+		opts := []bot.Option{bot.WithOpenAIKey(config.OpenAI.APIKey), bot.WithLogger(logger)}
+		if cons != nil && *logFile == "" {
+			opts = append(opts, bot.WithClientLogger(zerolog.New(zerolog.ConsoleWriter{Out: cons.LogWriter(), NoColor: true}).With().Timestamp().Str("bot", bc.UserDisplayName).Logger()))
+		}
+		b := bot.New(bc, opts...)
 		if err := b.Init(acceptInvites); err != nil {
 			logger.Error(err.Error())
 			os.Exit(1)
 		}
-		go b.Run()
+		if err := inbound.Register(b); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		bots = append(bots, b)
+		if cons != nil {
+			// before the sync starts, the handlers can not be added while it
+			// reads them
+			cons.AddBot(b)
+		}
+		go func(b *bot.Bot, name string) {
+			if err := b.Run(); err != nil {
+				logger.Error("bot stopped syncing", slog.String("err", err.Error()), slog.String("name", name))
+			}
+		}(b, bc.UserDisplayName)
 		logger.Info("started bot", slog.String("name", bc.UserDisplayName))
 	}
 
-	done := make(chan os.Signal)
-	signal.Notify(done, os.Interrupt)
+	done := make(chan os.Signal, 1)
+	signal.Notify(done, os.Interrupt, syscall.SIGTERM)
+	if cons != nil {
+		go func() {
+			if err := cons.Run(); err != nil {
+				logger.Info("console closed", slog.String("reason", err.Error()))
+			}
+			done <- os.Interrupt
+		}()
+	}
+	if config.Inbound.UsageToken != "" {
+		inbound.EnableUsageExport(config.Inbound.UsageToken)
+	}
+	if config.Inbound.Addr != "" {
+		go func() {
+			if err := inbound.ListenAndServe(config.Inbound.Addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("inbound server failed", slog.String("err", err.Error()))
+			}
+		}()
+	}
+	if *socket != "" {
+		go func() {
+			if err := console.NewControl(bots, logger).ListenUnix(*socket); err != nil {
+				logger.Error("control socket failed", slog.String("err", err.Error()))
+			}
+		}()
+	}
+	if *stdin {
+		go func() {
+			if err := console.NewControl(bots, logger).Serve(os.Stdin, os.Stdout); err != nil {
+				logger.Error("reading commands failed", slog.String("err", err.Error()))
+			}
+			done <- os.Interrupt
+		}()
+	}
 	<-done
 
+	logger.Info("stopping service")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := inbound.Shutdown(ctx); err != nil {
+		logger.Error("failed to stop inbound server", slog.String("err", err.Error()))
+	}
+	var wg sync.WaitGroup
+	for _, b := range bots {
+		wg.Add(1)
+		go func(b *bot.Bot) {
+			defer wg.Done()
+			if err := b.Shutdown(ctx); err != nil {
+				logger.Error("failed to stop bot", slog.String("err", err.Error()))
+			}
+		}(b)
+	}
+	wg.Wait()
+
 	logger.Info("service stopped")
 }
 
+func runREPL(name string) error {
+	var config bot.Config
+	if _, err := toml.DecodeFile(getParam("CONFIG_PATH", "conf.toml"), &config); err != nil {
+		return err
+	}
+	config.ApplyDefaults()
+	for _, bc := range config.Bots {
+		if strings.EqualFold(bc.UserDisplayName, name) {
+			gpt := bot.NewGPT(getParam("OPENAI_API_KEY", ""))
+			return console.NewREPL(gpt, bc, os.Stdin, os.Stdout).Run()
+		}
+	}
+
+	return fmt.Errorf("no bot with name %q", name)
+}
+
 func getParam(name, def string) string {
 	val, ok := os.LookupEnv(name)
 	if !ok {
@@ -80,3 +247,22 @@ func getParam(name, def string) string {
 	}
 	return val
 }
+
+func runRotatePickle(name string, logger *slog.Logger) error {
+	var config bot.Config
+	if _, err := toml.DecodeFile(getParam("CONFIG_PATH", "conf.toml"), &config); err != nil {
+		return err
+	}
+	for _, bc := range config.Bots {
+		if strings.EqualFold(bc.UserDisplayName, name) {
+			n, err := bot.RotatePickleKey(bc.DBPath, []byte(bc.Pickle), []byte(getParam("MATRIX_NEW_PICKLE", "")))
+			if err != nil {
+				return err
+			}
+			logger.Info("rotated pickle key, update Pickle in the config", slog.String("bot", bc.UserDisplayName), slog.Int("rows", n))
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no bot with name %q", name)
+}